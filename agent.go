@@ -0,0 +1,251 @@
+package otr3
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/coyim/gotrax"
+)
+
+// The agent protocol is a simple request-response protocol. Every request and every response is one frame:
+//
+//	BYTE op or status
+//	DATA payload
+//
+// A public key request has an empty payload and is answered with the serialized public key. A sign request has
+// the hashed data as payload and is answered with the signature. Failures are answered with an error status and
+// the error message as payload.
+const (
+	agentOpPublicKey = byte(0x01)
+	agentOpSign      = byte(0x02)
+
+	agentStatusOK    = byte(0x00)
+	agentStatusError = byte(0x01)
+
+	// No sensible request or response is anywhere near this large
+	maxAgentFrameLen = 4096
+)
+
+// Agent holds a private key and serves signatures made with it, so that the process having the conversations
+// never holds the secret key material
+type Agent struct {
+	key PrivateKey
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+}
+
+// NewAgent creates an agent that signs with the given key
+func NewAgent(key PrivateKey) *Agent {
+	return &Agent{key: key}
+}
+
+// ListenAndServe creates a Unix socket at the given path, and serves signatures on it until the agent is closed.
+// The directory of the socket is created if it doesn't exist, and it must only be accessible to the current user -
+// the permissions of the socket itself can't be set until it already exists, so they don't keep others out.
+func (a *Agent) ListenAndServe(path string) error {
+	if err := ensurePrivateDirectory(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	return a.Serve(l)
+}
+
+func ensurePrivateDirectory(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return errInsecureAgentDirectory
+	}
+	return nil
+}
+
+// Serve accepts connections on the listener and serves signatures on them until the listener fails or the agent
+// is closed. Every connection can send any number of requests.
+func (a *Agent) Serve(l net.Listener) error {
+	a.lock.Lock()
+	if a.listeners == nil {
+		a.listeners = make(map[net.Listener]struct{})
+	}
+	a.listeners[l] = struct{}{}
+	a.lock.Unlock()
+
+	defer func() {
+		a.lock.Lock()
+		delete(a.listeners, l)
+		a.lock.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// Close stops the agent from accepting new connections
+func (a *Agent) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var err error
+	for l := range a.listeners {
+		err = firstError(err, l.Close())
+	}
+	return err
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		op, payload, err := readAgentFrame(conn)
+		if err != nil {
+			return
+		}
+
+		res, err := a.handle(op, payload)
+		if err != nil {
+			err = writeAgentFrame(conn, agentStatusError, []byte(err.Error()))
+		} else {
+			err = writeAgentFrame(conn, agentStatusOK, res)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) handle(op byte, payload []byte) ([]byte, error) {
+	switch op {
+	case agentOpPublicKey:
+		return a.key.PublicKey().serialize(), nil
+	case agentOpSign:
+		return a.key.Sign(rand.Reader, payload)
+	}
+	return nil, errUnknownAgentOperation
+}
+
+func readAgentFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	_, l, _ := gotrax.ExtractWord(header[1:])
+	if l > maxAgentFrameLen {
+		return 0, nil, errCorruptAgentMessage
+	}
+
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func writeAgentFrame(w io.Writer, op byte, payload []byte) error {
+	_, err := w.Write(gotrax.AppendData([]byte{op}, payload))
+	return err
+}
+
+// AgentKey is a private key whose secret part is held by an agent. It asks the agent for every signature, and can
+// be used wherever the conversation needs our private key.
+type AgentKey struct {
+	path string
+	pub  PublicKey
+}
+
+// DialAgent connects to the agent listening on the Unix socket at the given path, and returns a key that signs
+// using it
+func DialAgent(path string) (*AgentKey, error) {
+	k := &AgentKey{path: path}
+
+	res, err := k.request(agentOpPublicKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rest, ok, pub := ParsePublicKey(res)
+	if !ok || len(rest) != 0 {
+		return nil, errCorruptAgentMessage
+	}
+	k.pub = pub
+
+	return k, nil
+}
+
+func (k *AgentKey) request(op byte, payload []byte) ([]byte, error) {
+	conn, err := net.Dial("unix", k.path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := writeAgentFrame(conn, op, payload); err != nil {
+		return nil, err
+	}
+
+	status, res, err := readAgentFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case agentStatusOK:
+		return res, nil
+	case agentStatusError:
+		return nil, newOtrErrorf("agent failed: %s", res)
+	}
+	return nil, errCorruptAgentMessage
+}
+
+// PublicKey returns the public key of the agent, as it was when we connected
+func (k *AgentKey) PublicKey() PublicKey {
+	return k.pub
+}
+
+// Sign asks the agent to sign the hashed data. The agent uses its own randomness, so the reader is not used.
+func (k *AgentKey) Sign(_ io.Reader, hashed []byte) ([]byte, error) {
+	return k.request(agentOpSign, hashed)
+}
+
+// IsAvailableForVersion returns true if the key of the agent is possible to use with the given version
+func (k *AgentKey) IsAvailableForVersion(v uint16) bool {
+	if pub, ok := k.pub.(*DSAPublicKey); ok {
+		return pub.IsAvailableForVersion(v)
+	}
+	return false
+}
+
+// Parse always fails, since an agent key has no key material to parse
+func (k *AgentKey) Parse(in []byte) ([]byte, bool) {
+	return in, false
+}
+
+// Serialize returns nil, since the secret key never leaves the agent
+func (k *AgentKey) Serialize() []byte {
+	return nil
+}
+
+// Generate always fails, since the agent decides what key it holds
+func (k *AgentKey) Generate(io.Reader) error {
+	return errAgentKeyMaterialUnavailable
+}
@@ -0,0 +1,195 @@
+package otr3
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startAgent is a test utility that serves the key on a fresh Unix socket and returns its path, together with a
+// function that stops the agent and removes the socket
+func startAgent(t *testing.T, key PrivateKey) (*Agent, string, func()) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", path)
+	assertNil(t, err)
+
+	a := NewAgent(key)
+	go a.Serve(l)
+
+	return a, path, func() {
+		a.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_DialAgent_returnsTheKeyOfTheAgent(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+
+	k, err := DialAgent(path)
+	assertNil(t, err)
+	assertDeepEquals(t, k.PublicKey().Fingerprint(), alicePrivateKey.PublicKey().Fingerprint())
+	assertTrue(t, k.IsAvailableForVersion(2))
+	assertTrue(t, k.IsAvailableForVersion(3))
+	assertFalse(t, k.IsAvailableForVersion(4))
+}
+
+func Test_DialAgent_failsWhenThereIsNoAgent(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := DialAgent(filepath.Join(dir, "missing.sock"))
+	assertNotNil(t, err)
+}
+
+func Test_Agent_ListenAndServe_createsTheSocketInAPrivateDirectory(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent", "agent.sock")
+
+	a := NewAgent(alicePrivateKey)
+	defer a.Close()
+	go a.ListenAndServe(path)
+
+	var k *AgentKey
+	var err error
+	for i := 0; i < 100 && k == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		k, err = DialAgent(path)
+	}
+	assertNil(t, err)
+	assertDeepEquals(t, k.PublicKey().Fingerprint(), alicePrivateKey.PublicKey().Fingerprint())
+
+	fi, err := os.Stat(filepath.Dir(path))
+	assertNil(t, err)
+	assertEquals(t, fi.Mode().Perm(), os.FileMode(0700))
+}
+
+func Test_Agent_ListenAndServe_refusesDirectoriesOthersCanAccess(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	assertNil(t, os.Chmod(dir, 0755))
+
+	err := NewAgent(alicePrivateKey).ListenAndServe(filepath.Join(dir, "agent.sock"))
+	assertEquals(t, err, errInsecureAgentDirectory)
+}
+
+func Test_AgentKey_Sign_returnsASignatureMadeByTheAgent(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+	k, _ := DialAgent(path)
+
+	hashed := sha256.Sum256([]byte("hello"))
+	sig, err := k.Sign(nil, hashed[:])
+	assertNil(t, err)
+
+	_, ok := alicePrivateKey.PublicKey().Verify(hashed[:], sig)
+	assertTrue(t, ok)
+}
+
+func Test_AgentKey_doesNotExposeKeyMaterial(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+	k, _ := DialAgent(path)
+
+	assertNil(t, k.Serialize())
+	assertEquals(t, k.Generate(rand.Reader), errAgentKeyMaterialUnavailable)
+	_, ok := k.Parse(alicePrivateKey.Serialize())
+	assertFalse(t, ok)
+}
+
+func Test_AgentKey_Sign_failsWhenTheAgentHasGone(t *testing.T) {
+	a, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+	k, _ := DialAgent(path)
+	a.Close()
+
+	_, err := k.Sign(nil, []byte{0x01, 0x02})
+	assertNotNil(t, err)
+}
+
+func Test_Agent_answersUnknownOperationsWithAnError(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+	k := &AgentKey{path: path}
+
+	_, err := k.request(0x42, nil)
+	assertEquals(t, err, newOtrError("agent failed: otr: unknown agent operation"))
+}
+
+func Test_Agent_servesSeveralRequestsOnOneConnection(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+
+	conn, err := net.Dial("unix", path)
+	assertNil(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		assertNil(t, writeAgentFrame(conn, agentOpPublicKey, nil))
+		status, res, err := readAgentFrame(conn)
+		assertNil(t, err)
+		assertEquals(t, status, agentStatusOK)
+		assertDeepEquals(t, res, alicePrivateKey.PublicKey().serialize())
+	}
+}
+
+func Test_readAgentFrame_rejectsFramesThatAreTooLarge(t *testing.T) {
+	r, w := net.Pipe()
+	defer r.Close()
+	go func() {
+		w.Write([]byte{agentOpSign, 0x00, 0x01, 0x00, 0x00})
+		w.Close()
+	}()
+
+	_, _, err := readAgentFrame(r)
+	assertEquals(t, err, errCorruptAgentMessage)
+}
+
+func Test_AKE_succeedsWithAKeyHeldByAnAgent(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+	k, err := DialAgent(path)
+	assertNil(t, err)
+
+	alice := &Conversation{Rand: rand.Reader}
+	alice.Policies = Policy(allowV2 | allowV3)
+	alice.SetOurKeys([]PrivateKey{k})
+
+	bob := &Conversation{Rand: rand.Reader}
+	bob.Policies = Policy(allowV2 | allowV3)
+	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
+
+	runAKE(t, alice, bob)
+
+	assertDeepEquals(t, bob.GetTheirKey().Fingerprint(), alicePrivateKey.PublicKey().Fingerprint())
+	assertDeepEquals(t, alice.GetTheirKey().Fingerprint(), bobPrivateKey.PublicKey().Fingerprint())
+}
+
+func Test_AKE_succeedsWithASignerInsteadOfAPrivateKey(t *testing.T) {
+	_, path, stop := startAgent(t, alicePrivateKey)
+	defer stop()
+	k, err := DialAgent(path)
+	assertNil(t, err)
+
+	alice := &Conversation{Rand: rand.Reader}
+	alice.Policies = Policy(allowV2 | allowV3)
+	// Only the Signer part of the key is given, so the conversation has no PrivateKey at all
+	signer := struct{ Signer }{k}
+	alice.SetOurSigner(signer)
+
+	bob := &Conversation{Rand: rand.Reader}
+	bob.Policies = Policy(allowV2 | allowV3)
+	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
+
+	runAKE(t, alice, bob)
+
+	assertDeepEquals(t, bob.GetTheirKey().Fingerprint(), alicePrivateKey.PublicKey().Fingerprint())
+	assertEquals(t, alice.ourCurrentKey, Signer(signer))
+	assertNil(t, alice.GetOurCurrentKey())
+}
@@ -29,6 +29,8 @@ type ake struct {
 	revealKey akeKeys
 	sigKey    akeKeys
 
+	exporterSecret []byte
+
 	state authState
 	keys  keyManagementContext
 
@@ -49,8 +51,37 @@ func (c *Conversation) initAKE() {
 	}
 }
 
+// copy creates a copy of the AKE that doesn't share any mutable state with the original
+func (a *ake) copy() *ake {
+	ret := &ake{
+		secretExponent:   copyBigInt(a.secretExponent),
+		ourPublicValue:   copyBigInt(a.ourPublicValue),
+		theirPublicValue: copyBigInt(a.theirPublicValue),
+		r:                a.r,
+		encryptedGx:      makeCopy(a.encryptedGx),
+		xhashedGx:        makeCopy(a.xhashedGx),
+		state:            a.state,
+		lastStateChange:  a.lastStateChange,
+	}
+	ret.keys.ourKeyID = a.keys.ourKeyID
+	ret.keys.theirKeyID = a.keys.theirKeyID
+	return ret
+}
+
+func copyBigInt(v *big.Int) *big.Int {
+	if v == nil {
+		return nil
+	}
+	return new(big.Int).Set(v)
+}
+
 func (c *Conversation) calcAKEKeys(s *big.Int) {
 	c.ssid, c.ake.revealKey, c.ake.sigKey = calculateAKEKeys(s, c.version)
+
+	secbytes := gotrax.AppendMPI(nil, s)
+	defer wipeBytes(secbytes)
+	wipeBytes(c.ake.exporterSecret)
+	c.ake.exporterSecret = exporterSecretFrom(secbytes)
 }
 
 func (c *Conversation) setSecretExponent(val *big.Int) {
@@ -2,7 +2,6 @@ package otr3
 
 import (
 	"bytes"
-	"time"
 
 	"github.com/coyim/gotrax"
 )
@@ -14,12 +13,17 @@ func (c *Conversation) generateNewDHKeyPair() error {
 func (c *Conversation) akeHasFinished() error {
 	c.keys.wipe()
 	c.keys = c.ake.keys
+	c.setExporterSecret(c.ake.exporterSecret)
 	c.ake.wipe(false)
 
 	previousMsgState := c.msgState
-	c.lastMessageStateChange = time.Now()
+	c.lastMessageStateChange = c.now()
 	c.msgState = encrypted
-	defer c.signalSecurityEventIf(previousMsgState != encrypted, GoneSecure)
+	c.resetRefreshState()
+	c.resetKeyStatistics()
+	if previousMsgState != encrypted {
+		defer c.securityEvent(c.recordTheirFingerprint())
+	}
 	defer c.signalSecurityEventIf(previousMsgState == encrypted, StillSecure)
 
 	if c.ourCurrentKey.PublicKey().IsSame(c.theirKey) {
@@ -50,7 +54,7 @@ func (c *Conversation) processAKE(msgType byte, msg []byte) (toSend []messageWit
 		err = newOtrErrorf("unknown message type 0x%X", msgType)
 	}
 
-	c.ake.lastStateChange = time.Now()
+	c.ake.lastStateChange = c.now()
 
 	messages := append([]messageWithHeader{toSendSingle}, toSendExtra...)
 	toSend = compactMessagesWithHeader(messages...)
@@ -11,6 +11,7 @@ func (c *Conversation) StartAuthenticate(question string, mutualSecret []byte) (
 	if err != nil {
 		return nil, err
 	}
+	c.smp.lastProgress = c.now()
 
 	msgs, _, err := c.createSerializedDataMessage(nil, messageFlagIgnoreUnreadable, tlvs)
 	return msgs, err
@@ -23,6 +24,7 @@ func (c *Conversation) ProvideAuthenticationSecret(mutualSecret []byte) ([]Valid
 	if err != nil {
 		return nil, err
 	}
+	c.smp.lastProgress = c.now()
 
 	msgs, _, err := c.createSerializedDataMessage(nil, messageFlagIgnoreUnreadable, []tlv{*t})
 	return msgs, err
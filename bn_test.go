@@ -22,3 +22,9 @@ func Test_gt_returnsTrueIfTheLeftIsGreaterThanTheRight(t *testing.T) {
 	assertDeepEquals(t, gt(big.NewInt(4), big.NewInt(3)), true)
 	assertDeepEquals(t, gt(big.NewInt(7), big.NewInt(3)), true)
 }
+
+func Test_paddedBytes_padsTheValueWithLeadingZeros(t *testing.T) {
+	assertDeepEquals(t, paddedBytes(big.NewInt(0x0102), 4), []byte{0x00, 0x00, 0x01, 0x02})
+	assertDeepEquals(t, paddedBytes(big.NewInt(0), 2), []byte{0x00, 0x00})
+	assertDeepEquals(t, paddedBytes(big.NewInt(0x0102), 2), []byte{0x01, 0x02})
+}
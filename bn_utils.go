@@ -58,3 +58,13 @@ func gt(l, r *big.Int) bool {
 func gte(l, r *big.Int) bool {
 	return l.Cmp(r) != -1
 }
+
+// paddedBytes returns the big-endian bytes of the value, left-padded with zeros to the given size
+func paddedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	defer wipeBytes(b)
+
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
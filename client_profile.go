@@ -0,0 +1,300 @@
+package otr3
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/coyim/gotrax"
+)
+
+// The field types of a client profile
+const (
+	clientProfileFieldInstanceTag           = uint16(0x0001)
+	clientProfileFieldPublicKey             = uint16(0x0002)
+	clientProfileFieldForgingKey            = uint16(0x0003)
+	clientProfileFieldVersions              = uint16(0x0004)
+	clientProfileFieldExpiration            = uint16(0x0005)
+	clientProfileFieldDSAKey                = uint16(0x0006)
+	clientProfileFieldTransitionalSignature = uint16(0x0007)
+)
+
+var ed448ForgingKeyTypeValue = uint16(0x0012)
+
+const dsaSignatureLen = 40
+
+// The client profiles we create are valid for two weeks
+const defaultClientProfileValidity = 14 * 24 * time.Hour
+
+// ClientProfile is what an OTRv4 client publishes about itself: the instance tag the profile belongs to, the
+// long-term key, the forging key, the supported versions and when the profile expires. The profile is signed with
+// the long-term key. The secret part of the forging key is never needed, since it is only used to make the ring
+// signatures in the DAKE deniable.
+//
+// A profile can also carry an OTRv3 DSA key with a transitional signature made with it. That lets peers that already
+// trust the DSA key trust the new Ed448 key as well.
+type ClientProfile struct {
+	InstanceTag uint32
+	PublicKey   *Ed448PublicKey
+	ForgingKey  *Ed448PublicKey
+	Versions    []byte
+	Expiration  time.Time
+
+	DSAKey                *DSAPublicKey
+	TransitionalSignature []byte
+
+	Signature []byte
+}
+
+// NewClientProfile creates an unsigned client profile for the key, with a freshly generated forging key. The profile
+// allows OTRv4 and expires at the given time, which is rounded down to whole seconds.
+func NewClientProfile(rand io.Reader, key *Ed448PublicKey, instanceTag uint32, expiration time.Time) (*ClientProfile, error) {
+	forging, err := randomEd448Secret(rand)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeEd448Scalar(forging)
+
+	return &ClientProfile{
+		InstanceTag: instanceTag,
+		PublicKey:   &Ed448PublicKey{point: key.point},
+		ForgingKey:  &Ed448PublicKey{point: ed448BaseMul(forging)},
+		Versions:    []byte("4"),
+		Expiration:  time.Unix(expiration.Unix(), 0),
+	}, nil
+}
+
+// newClientProfile creates and signs a profile for the key, which is what a conversation uses when it hasn't been
+// given a profile
+func newClientProfile(r io.Reader, key *Ed448PrivateKey, instanceTag uint32, versions []byte, expiration time.Time) (*ClientProfile, error) {
+	p, err := NewClientProfile(r, &key.Ed448PublicKey, instanceTag, expiration)
+	if err != nil {
+		return nil, err
+	}
+	p.Versions = makeCopy(versions)
+
+	if err = p.Sign(r, key, nil); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Sign signs the profile with the long-term key, which has to be the key of the profile. If transitional is not nil,
+// the profile is first signed with that DSA key as well, and the DSA key is added to the profile.
+func (p *ClientProfile) Sign(rand io.Reader, key *Ed448PrivateKey, transitional *DSAPrivateKey) (err error) {
+	if p.PublicKey == nil || !p.PublicKey.IsSame(&key.Ed448PublicKey) {
+		return errClientProfileKeyMismatch
+	}
+
+	p.Expiration = time.Unix(p.Expiration.Unix(), 0)
+	p.DSAKey, p.TransitionalSignature = nil, nil
+
+	if transitional != nil {
+		p.DSAKey = &transitional.DSAPublicKey
+		if p.TransitionalSignature, err = transitional.Sign(rand, transitionalSignatureHash(p.bodyWithoutTransitionalSignature())); err != nil {
+			return err
+		}
+	}
+
+	p.Signature, err = key.Sign(rand, p.body())
+	return err
+}
+
+func transitionalSignatureHash(body []byte) []byte {
+	return kdf(usageTransitionalSignature, 32, body)
+}
+
+func appendEd448Key(out []byte, keyType uint16, p *Ed448PublicKey) []byte {
+	out = gotrax.AppendShort(out, keyType)
+	return append(out, p.point.encode()...)
+}
+
+func extractEd448Key(in []byte, keyType uint16) ([]byte, *Ed448PublicKey, bool) {
+	in, tp, ok := gotrax.ExtractShort(in)
+	if !ok || tp != keyType || len(in) < ed448PointLen {
+		return in, nil, false
+	}
+
+	p, ok := decodeEd448Point(in[:ed448PointLen])
+	if !ok {
+		return in, nil, false
+	}
+	return in[ed448PointLen:], &Ed448PublicKey{point: p}, true
+}
+
+func (p *ClientProfile) fields() (count uint32, out []byte) {
+	out = gotrax.AppendShort(out, clientProfileFieldInstanceTag)
+	out = gotrax.AppendWord(out, p.InstanceTag)
+
+	out = gotrax.AppendShort(out, clientProfileFieldPublicKey)
+	out = appendEd448Key(out, ed448KeyTypeValue, p.PublicKey)
+
+	out = gotrax.AppendShort(out, clientProfileFieldForgingKey)
+	out = appendEd448Key(out, ed448ForgingKeyTypeValue, p.ForgingKey)
+
+	out = gotrax.AppendShort(out, clientProfileFieldVersions)
+	out = gotrax.AppendData(out, p.Versions)
+
+	out = gotrax.AppendShort(out, clientProfileFieldExpiration)
+	out = gotrax.AppendLong(out, uint64(p.Expiration.Unix()))
+
+	if p.DSAKey == nil {
+		return 5, out
+	}
+
+	out = gotrax.AppendShort(out, clientProfileFieldDSAKey)
+	return 6, append(out, p.DSAKey.serialize()...)
+}
+
+// bodyWithoutTransitionalSignature returns the fields of the profile that the transitional signature covers
+func (p *ClientProfile) bodyWithoutTransitionalSignature() []byte {
+	count, fields := p.fields()
+	return append(gotrax.AppendWord(nil, count), fields...)
+}
+
+// body returns the fields of the profile, which is what the signature covers
+func (p *ClientProfile) body() []byte {
+	count, fields := p.fields()
+	if p.TransitionalSignature == nil {
+		return append(gotrax.AppendWord(nil, count), fields...)
+	}
+
+	out := append(gotrax.AppendWord(nil, count+1), fields...)
+	out = gotrax.AppendShort(out, clientProfileFieldTransitionalSignature)
+	return append(out, p.TransitionalSignature...)
+}
+
+// Serialize returns the serialization of the signed profile
+func (p *ClientProfile) Serialize() []byte {
+	return append(p.body(), p.Signature...)
+}
+
+// Parse takes the given data and tries to parse it into the profile. It will return not ok if the data is malformed,
+// or if the fields are not in the order Serialize writes them in. A parsed profile still has to be validated before
+// it can be trusted.
+func (p *ClientProfile) Parse(in []byte) (index []byte, ok bool) {
+	index, fields, ok := gotrax.ExtractWord(in)
+	if !ok {
+		return in, false
+	}
+
+	seen := make(map[uint16]bool)
+	for i := uint32(0); i < fields; i++ {
+		var tp uint16
+		if index, tp, ok = gotrax.ExtractShort(index); !ok || seen[tp] {
+			return in, false
+		}
+		seen[tp] = true
+
+		switch tp {
+		case clientProfileFieldInstanceTag:
+			index, p.InstanceTag, ok = gotrax.ExtractWord(index)
+		case clientProfileFieldPublicKey:
+			index, p.PublicKey, ok = extractEd448Key(index, ed448KeyTypeValue)
+		case clientProfileFieldForgingKey:
+			index, p.ForgingKey, ok = extractEd448Key(index, ed448ForgingKeyTypeValue)
+		case clientProfileFieldVersions:
+			index, p.Versions, ok = gotrax.ExtractData(index)
+		case clientProfileFieldExpiration:
+			var exp uint64
+			index, exp, ok = gotrax.ExtractLong(index)
+			p.Expiration = time.Unix(int64(exp), 0)
+		case clientProfileFieldDSAKey:
+			p.DSAKey = &DSAPublicKey{}
+			index, ok = p.DSAKey.Parse(index)
+		case clientProfileFieldTransitionalSignature:
+			if ok = len(index) >= dsaSignatureLen; ok {
+				p.TransitionalSignature = makeCopy(index[:dsaSignatureLen])
+				index = index[dsaSignatureLen:]
+			}
+		default:
+			ok = false
+		}
+
+		if !ok {
+			return in, false
+		}
+	}
+
+	if len(index) < ed448SignatureLen {
+		return in, false
+	}
+
+	// The signatures are checked against the body as we serialize it, so we can only accept the same serialization
+	if !bytes.Equal(p.body(), in[:len(in)-len(index)]) {
+		return in, false
+	}
+	p.Signature = makeCopy(index[:ed448SignatureLen])
+
+	return index[ed448SignatureLen:], true
+}
+
+// ParseClientProfile parses a serialized client profile. Any data after the profile is returned.
+func ParseClientProfile(in []byte) (*ClientProfile, []byte, error) {
+	p := &ClientProfile{}
+	rest, ok := p.Parse(in)
+	if !ok {
+		return nil, in, errInvalidClientProfile
+	}
+	return p, rest, nil
+}
+
+// Validate checks that the profile is signed by its long-term key, that it belongs to the given instance tag, that it
+// hasn't expired and that it allows OTRv4. If the profile carries a DSA key, the transitional signature has to be
+// valid as well.
+func (p *ClientProfile) Validate(instanceTag uint32, now time.Time) error {
+	if p.PublicKey == nil || p.ForgingKey == nil || p.Versions == nil || p.Expiration.IsZero() {
+		return errInvalidClientProfile
+	}
+
+	if !isValidEd448Point(p.PublicKey.point) || !isValidEd448Point(p.ForgingKey.point) {
+		return errInvalidClientProfile
+	}
+
+	if _, ok := p.PublicKey.Verify(p.body(), p.Signature); !ok {
+		return errInvalidClientProfile
+	}
+
+	if (p.DSAKey == nil) != (p.TransitionalSignature == nil) {
+		return errInvalidClientProfile
+	}
+
+	if p.DSAKey != nil {
+		if err := p.VerifyTransitionalSignature(p.DSAKey); err != nil {
+			return err
+		}
+	}
+
+	if p.InstanceTag != instanceTag {
+		return errInvalidClientProfile
+	}
+
+	if !p.Expiration.After(now) {
+		return errClientProfileExpired
+	}
+
+	if !bytes.Contains(p.Versions, []byte("4")) {
+		return errInvalidClientProfile
+	}
+
+	return nil
+}
+
+// VerifyTransitionalSignature checks that the profile vouches for the given DSA key, and that the key has signed
+// the profile. This is what makes it possible to move the trust in an OTRv3 identity over to an OTRv4 identity.
+func (p *ClientProfile) VerifyTransitionalSignature(key *DSAPublicKey) error {
+	if p.DSAKey == nil || p.TransitionalSignature == nil || key == nil {
+		return errInvalidTransitionalSignature
+	}
+
+	if !bytes.Equal(p.DSAKey.serialize(), key.serialize()) {
+		return errInvalidTransitionalSignature
+	}
+
+	if _, ok := key.Verify(transitionalSignatureHash(p.bodyWithoutTransitionalSignature()), p.TransitionalSignature); !ok {
+		return errInvalidTransitionalSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package otr3
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/coyim/gotrax"
+)
+
+var clientProfileTestTime = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func clientProfileFixture(t *testing.T) (*ClientProfile, *Ed448PrivateKey) {
+	key := generateEd448Key(t)
+	p, err := newClientProfile(rand.Reader, key, 0x101, []byte("34"), clientProfileTestTime.Add(defaultClientProfileValidity))
+	assertNil(t, err)
+	return p, key
+}
+
+func transitionalClientProfileFixture(t *testing.T) (*ClientProfile, *DSAPrivateKey, *Ed448PrivateKey) {
+	key := generateEd448Key(t)
+	dsaKey := alicePrivateKey.(*DSAPrivateKey)
+
+	p, err := NewClientProfile(rand.Reader, &key.Ed448PublicKey, 0x101, clientProfileTestTime.Add(time.Hour))
+	assertNil(t, err)
+	assertNil(t, p.Sign(rand.Reader, key, dsaKey))
+
+	return p, dsaKey, key
+}
+
+func Test_newClientProfile_createsAValidProfile(t *testing.T) {
+	p, key := clientProfileFixture(t)
+
+	assertNil(t, p.Validate(0x101, clientProfileTestTime))
+	assertTrue(t, p.PublicKey.IsSame(key.PublicKey()))
+	assertTrue(t, isValidEd448Point(p.ForgingKey.point))
+	assertFalse(t, p.ForgingKey.IsSame(key.PublicKey()))
+	assertNil(t, p.DSAKey)
+}
+
+func Test_NewClientProfile_createsAnUnsignedProfileForV4(t *testing.T) {
+	key := generateEd448Key(t)
+	p, err := NewClientProfile(rand.Reader, &key.Ed448PublicKey, 0x101, clientProfileTestTime.Add(1500*time.Millisecond))
+
+	assertNil(t, err)
+	assertDeepEquals(t, p.Versions, []byte("4"))
+	assertEquals(t, p.Expiration, time.Unix(clientProfileTestTime.Unix()+1, 0))
+	assertEquals(t, p.Validate(0x101, clientProfileTestTime), errInvalidClientProfile)
+}
+
+func Test_ClientProfile_Sign_failsForAnotherKey(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+
+	err := p.Sign(rand.Reader, generateEd448Key(t), nil)
+	assertEquals(t, err, errClientProfileKeyMismatch)
+}
+
+func Test_ClientProfile_Validate_failsForAChangedProfile(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+	parsed, _, _ := ParseClientProfile(p.Serialize())
+
+	parsed.Expiration = parsed.Expiration.Add(time.Hour)
+	assertEquals(t, parsed.Validate(0x101, clientProfileTestTime), errInvalidClientProfile)
+}
+
+func Test_ClientProfile_Sign_resignsAChangedProfile(t *testing.T) {
+	p, key := clientProfileFixture(t)
+	parsed, _, _ := ParseClientProfile(p.Serialize())
+
+	parsed.Versions = []byte("4")
+	assertEquals(t, parsed.Validate(0x101, clientProfileTestTime), errInvalidClientProfile)
+
+	assertNil(t, parsed.Sign(rand.Reader, key, nil))
+	assertNil(t, parsed.Validate(0x101, clientProfileTestTime))
+}
+
+func Test_ClientProfile_serializesAndParses(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+
+	res, rest, err := ParseClientProfile(append(p.Serialize(), 0x42))
+
+	assertNil(t, err)
+	assertDeepEquals(t, rest, []byte{0x42})
+	assertEquals(t, res.InstanceTag, uint32(0x101))
+	assertDeepEquals(t, res.Versions, []byte("34"))
+	assertEquals(t, res.Expiration.Unix(), p.Expiration.Unix())
+	assertDeepEquals(t, res.Serialize(), p.Serialize())
+	assertNil(t, res.Validate(0x101, clientProfileTestTime))
+}
+
+func Test_ClientProfile_Parse_failsForDuplicateOrUnknownFields(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+	ser := p.Serialize()
+
+	dup := append([]byte{0x00, 0x00, 0x00, 0x02}, ser[4:4+6]...)
+	dup = append(dup, ser[4:4+6]...)
+	_, ok := (&ClientProfile{}).Parse(dup)
+	assertFalse(t, ok)
+
+	unknown := []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x42, 0x00, 0x00, 0x00, 0x00}
+	_, ok = (&ClientProfile{}).Parse(unknown)
+	assertFalse(t, ok)
+}
+
+func Test_ParseClientProfile_failsWithoutSignature(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+	ser := p.Serialize()
+
+	_, rest, err := ParseClientProfile(ser[:len(ser)-1])
+	assertEquals(t, err, errInvalidClientProfile)
+	assertDeepEquals(t, rest, ser[:len(ser)-1])
+}
+
+func Test_ClientProfile_Validate_failsForATamperedProfile(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+	ser := p.Serialize()
+	ser[9] ^= 0x01
+
+	res, _, err := ParseClientProfile(ser)
+	assertNil(t, err)
+	assertEquals(t, res.Validate(0x101^0x01, clientProfileTestTime), errInvalidClientProfile)
+}
+
+func Test_ClientProfile_Validate_failsForAnotherInstanceTag(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+	assertEquals(t, p.Validate(0x102, clientProfileTestTime), errInvalidClientProfile)
+}
+
+func Test_ClientProfile_Validate_failsForAnExpiredProfile(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+	assertEquals(t, p.Validate(0x101, p.Expiration), errClientProfileExpired)
+}
+
+func Test_ClientProfile_Validate_failsIfV4IsNotAllowed(t *testing.T) {
+	key := generateEd448Key(t)
+	p, _ := newClientProfile(rand.Reader, key, 0x101, []byte("3"), clientProfileTestTime.Add(time.Hour))
+	assertEquals(t, p.Validate(0x101, clientProfileTestTime), errInvalidClientProfile)
+}
+
+func Test_ClientProfile_transitionalSignatureVouchesForTheProfile(t *testing.T) {
+	p, dsaKey, _ := transitionalClientProfileFixture(t)
+
+	assertNil(t, p.Validate(0x101, clientProfileTestTime))
+	assertNil(t, p.VerifyTransitionalSignature(&dsaKey.DSAPublicKey))
+	assertDeepEquals(t, p.DSAKey.Fingerprint(), dsaKey.PublicKey().Fingerprint())
+}
+
+func Test_ClientProfile_transitionalSignatureSurvivesSerialization(t *testing.T) {
+	p, dsaKey, _ := transitionalClientProfileFixture(t)
+
+	res, rest, err := ParseClientProfile(p.Serialize())
+	assertNil(t, err)
+	assertEquals(t, len(rest), 0)
+
+	assertNil(t, res.Validate(0x101, clientProfileTestTime))
+	assertNil(t, res.VerifyTransitionalSignature(&dsaKey.DSAPublicKey))
+	assertDeepEquals(t, res.Serialize(), p.Serialize())
+}
+
+func Test_ClientProfile_VerifyTransitionalSignature_failsForAnotherDSAKey(t *testing.T) {
+	p, _, _ := transitionalClientProfileFixture(t)
+
+	err := p.VerifyTransitionalSignature(&bobPrivateKey.(*DSAPrivateKey).DSAPublicKey)
+	assertEquals(t, err, errInvalidTransitionalSignature)
+}
+
+func Test_ClientProfile_VerifyTransitionalSignature_failsWithoutATransitionalSignature(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+
+	err := p.VerifyTransitionalSignature(&alicePrivateKey.(*DSAPrivateKey).DSAPublicKey)
+	assertEquals(t, err, errInvalidTransitionalSignature)
+}
+
+func Test_ClientProfile_Validate_failsForABrokenTransitionalSignature(t *testing.T) {
+	p, _, key := transitionalClientProfileFixture(t)
+	p.TransitionalSignature[3] ^= 0x01
+
+	// The profile signature covers the transitional signature
+	assertEquals(t, p.Validate(0x101, clientProfileTestTime), errInvalidClientProfile)
+
+	p.Signature, _ = key.Sign(rand.Reader, p.body())
+	assertEquals(t, p.Validate(0x101, clientProfileTestTime), errInvalidTransitionalSignature)
+}
+
+func Test_ClientProfile_Parse_failsForFieldsInAnotherOrder(t *testing.T) {
+	p, _ := clientProfileFixture(t)
+
+	// Move the instance tag field to the end
+	count, fields := p.fields()
+	reordered := gotrax.AppendWord(nil, count)
+	reordered = append(reordered, fields[6:]...)
+	reordered = append(reordered, fields[:6]...)
+	reordered = append(reordered, p.Signature...)
+
+	_, ok := (&ClientProfile{}).Parse(reordered)
+	assertFalse(t, ok)
+}
+
+func Test_ClientProfile_Validate_failsForADSAKeyWithoutTransitionalSignature(t *testing.T) {
+	p, _, _ := transitionalClientProfileFixture(t)
+	p.TransitionalSignature = nil
+
+	assertEquals(t, p.Validate(0x101, clientProfileTestTime), errInvalidClientProfile)
+}
@@ -0,0 +1,67 @@
+package otr3
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time for a conversation. It is used for heartbeats, resending of messages
+// and for deciding when to ignore repeated query messages.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock that only moves when told to, which makes it possible to test time based behavior
+// without sleeping. It is safe for concurrent use.
+type FakeClock struct {
+	t    time.Time
+	lock sync.Mutex
+}
+
+// NewFakeClock creates a FakeClock that starts at the given time
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{t: t}
+}
+
+// Now returns the current time of the fake clock
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.t
+}
+
+// Advance moves the fake clock forward with the given duration
+func (f *FakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.t = f.t.Add(d)
+}
+
+// Set moves the fake clock to the given time
+func (f *FakeClock) Set(t time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.t = t
+}
+
+// SetClock sets the clock used by this conversation. If it is never set, the system clock will be used.
+func (c *Conversation) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+func (c *Conversation) now() time.Time {
+	if c.clock != nil {
+		return c.clock.Now()
+	}
+	return systemClock{}.Now()
+}
@@ -0,0 +1,54 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_FakeClock_onlyMovesWhenTold(t *testing.T) {
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	assertEquals(t, c.Now(), start)
+
+	c.Advance(5 * time.Second)
+	assertEquals(t, c.Now(), start.Add(5*time.Second))
+
+	c.Set(start)
+	assertEquals(t, c.Now(), start)
+}
+
+func Test_Conversation_now_usesTheSystemClockByDefault(t *testing.T) {
+	c := &Conversation{}
+	before := time.Now()
+	now := c.now()
+	assertFalse(t, now.Before(before))
+}
+
+func Test_Conversation_now_usesTheClockSet(t *testing.T) {
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := &Conversation{}
+	c.SetClock(NewFakeClock(start))
+	assertEquals(t, c.now(), start)
+}
+
+func Test_Conversation_withFakeClock_sendsHeartbeatOnlyAfterTheIntervalHasPassed(t *testing.T) {
+	clock := NewFakeClock(time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC))
+	alice, bob := encryptedConversationPair(t)
+	alice.SetClock(clock)
+	bob.SetClock(clock)
+	bob.SetHeartbeatInterval(10 * time.Second)
+	bob.updateLastSent()
+
+	msgs, _ := alice.Send(ValidMessage("one"))
+	_, toSend, err := bob.Receive(msgs[0])
+	assertNil(t, err)
+	assertNil(t, toSend)
+
+	clock.Advance(11 * time.Second)
+
+	msgs, _ = alice.Send(ValidMessage("two"))
+	_, toSend, err = bob.Receive(msgs[0])
+	assertNil(t, err)
+	assertEquals(t, len(toSend), 1)
+	assertEquals(t, bob.heartbeat.lastSent, clock.Now())
+}
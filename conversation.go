@@ -29,22 +29,32 @@ type Conversation struct {
 	whitespaceState whitespaceState
 
 	lastMessageStateChange time.Time
+	clock                  Clock
 
 	ourInstanceTag   uint32
 	theirInstanceTag uint32
 
 	ssid          [8]byte
 	ourKeys       []PrivateKey
-	ourCurrentKey PrivateKey
+	ourSigner     Signer
+	ourCurrentKey Signer
 	theirKey      PublicKey
 
+	// exporterSecret is derived from the shared secret of the last AKE, for ExportKeyingMaterial
+	exporterSecret []byte
+	// stateGeneration is the generation of the last snapshot saved or restored
+	stateGeneration uint64
+
 	ake        *ake
+	dake       *dake
+	v4         *otrv4Session
 	smp        smp
 	keys       keyManagementContext
-	Policies   policies
+	Policies   Policy
 	heartbeat  heartbeatContext
 	resend     resendContext
 	injections injections
+	trust      trustContext
 
 	fragmentSize         uint16
 	fragmentationContext fragmentationContext
@@ -54,11 +64,34 @@ type Conversation struct {
 	messageEventHandler  MessageEventHandler
 	securityEventHandler SecurityEventHandler
 	receivedKeyHandler   ReceivedKeyHandler
+	customTLVHandlers    map[uint16]TLVHandler
+	unknownTLVHandler    TLVHandler
+
+	deliveryEventHandler DeliveryEventHandler
+	lastMessageID        MessageID
+	currentMessageID     MessageID
+	strictSending        bool
+
+	keyRotation keyRotationContext
+
+	refreshPolicy RefreshPolicy
+	refreshState  struct {
+		messages    int
+		lastAttempt time.Time
+	}
+
+	receiveDetails *ReceivedMessage
+
+	clientProfile      *ClientProfile
+	theirClientProfile *ClientProfile
 
 	debug         bool
 	sentRevealSig bool
 
 	friendlyQueryMessage string
+	queryIgnoreInterval  time.Duration
+	akeTimeout           time.Duration
+	smpTimeout           time.Duration
 }
 
 // NewConversationWithVersion creates a new conversation with the given version
@@ -70,6 +103,8 @@ func NewConversationWithVersion(v int) *Conversation {
 		vv = otrV2{}
 	case 3:
 		vv = otrV3{}
+	case 4:
+		vv = otrV4{}
 	}
 	return &Conversation{version: vv}
 }
@@ -119,7 +154,12 @@ func (c *Conversation) End() (toSend []ValidMessage, err error) {
 		toSend, _, err = c.createSerializedDataMessage(nil, messageFlagIgnoreUnreadable, []tlv{tlv{tlvType: tlvTypeDisconnected}})
 	}
 	c.lastMessageStateChange = time.Time{}
+	c.setExporterSecret(nil)
 	c.ake = nil
+	c.dake.wipe()
+	c.dake = nil
+	c.v4.wipe()
+	c.v4 = nil
 	c.msgState = plainText
 	defer c.signalSecurityEventIf(previousMsgState == encrypted, GoneInsecure)
 
@@ -134,14 +174,22 @@ func (c *Conversation) SetOurKeys(ourKeys []PrivateKey) {
 	c.ourKeys = ourKeys
 }
 
+// SetOurSigner makes the conversation sign with the given signer in the OTRv2 and OTRv3 AKE, instead of with one of
+// our private keys. This makes it possible to keep the private key in another process, such as an Agent.
+func (c *Conversation) SetOurSigner(s Signer) {
+	c.ourSigner = s
+}
+
 // GetOurKeys returns all our keys for the current conversation
 func (c *Conversation) GetOurKeys() []PrivateKey {
 	return c.ourKeys
 }
 
-// GetOurCurrentKey returns the currently chosen key for us
+// GetOurCurrentKey returns the currently chosen key for us. It returns nil if we sign with a signer that is not a
+// PrivateKey.
 func (c *Conversation) GetOurCurrentKey() PrivateKey {
-	return c.ourCurrentKey
+	k, _ := c.ourCurrentKey.(PrivateKey)
+	return k
 }
 
 // GetTheirKey returns the public key of the other peer in this conversation
@@ -154,6 +202,18 @@ func (c *Conversation) GetSSID() [8]byte {
 	return c.ssid
 }
 
+// SetClientProfile sets the signed client profile to use in the OTRv4 DAKE. This makes it possible to use a profile
+// with a transitional signature. A profile that doesn't match our key and instance tag, or has expired, is replaced
+// with a new profile when the DAKE starts.
+func (c *Conversation) SetClientProfile(p *ClientProfile) {
+	c.clientProfile = p
+}
+
+// GetTheirClientProfile returns the client profile the other peer used in the last OTRv4 DAKE
+func (c *Conversation) GetTheirClientProfile() *ClientProfile {
+	return c.theirClientProfile
+}
+
 // SetSMPEventHandler assigns handler for SMPEvent
 func (c *Conversation) SetSMPEventHandler(handler SMPEventHandler) {
 	c.smpEventHandler = handler
@@ -174,6 +234,11 @@ func (c *Conversation) SetSecurityEventHandler(handler SecurityEventHandler) {
 	c.securityEventHandler = handler
 }
 
+// SetReceivedKeyHandler assigns handler for extra symmetric keys the peer asks us to use
+func (c *Conversation) SetReceivedKeyHandler(handler ReceivedKeyHandler) {
+	c.receivedKeyHandler = handler
+}
+
 // InitializeInstanceTag sets our instance tag for this conversation. If the argument is zero we will create a new instance tag and return it
 // The instance tag created or set will be returned
 func (c *Conversation) InitializeInstanceTag(tag uint32) uint32 {
@@ -0,0 +1,654 @@
+package otr3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+	"time"
+
+	"github.com/coyim/gotrax"
+)
+
+// conversationStateVersion is the version of the serialization format produced by SaveState.
+// RestoreState also accepts snapshots from older versions, down to minConversationStateVersion.
+// Version 1 snapshots don't have the secret for exported keys, and version 1 and 2 snapshots don't
+// have a generation, which is the same as having generation 0.
+const (
+	conversationStateVersion    = uint16(3)
+	minConversationStateVersion = uint16(1)
+)
+
+var conversationStateMagic = []byte("OTR3STATE")
+
+const noSMPState = byte(0xFF)
+
+// ConversationState is a serialized snapshot of a Conversation, produced by SaveState.
+// It contains secret key material and should be wiped with Wipe as soon as it is no longer needed.
+type ConversationState []byte
+
+// Wipe will overwrite the content of the snapshot with zeroes
+func (s ConversationState) Wipe() {
+	wipeBytes(s)
+}
+
+// Generation returns the generation of the snapshot. Every snapshot of a conversation has a higher generation than
+// the ones saved before it. The generation should be stored together with the integrity key, and given to
+// RestoreState, so that an older snapshot can't be restored in place of the latest one.
+func (s ConversationState) Generation() uint64 {
+	if !bytes.HasPrefix(s, conversationStateMagic) {
+		return 0
+	}
+
+	in, formatVersion, ok := gotrax.ExtractShort(s[len(conversationStateMagic):])
+	if !ok || formatVersion < 3 {
+		return 0
+	}
+
+	_, generation, _ := gotrax.ExtractLong(in)
+	return generation
+}
+
+// SaveState serializes the current state of the conversation - including message state, version,
+// instance tags, SSID, the secret for exported keys, key management, pending messages to resend and
+// SMP state - into a snapshot.
+// The snapshot is authenticated with HMAC-SHA256 under the given integrity key, and RestoreState will
+// only accept it when given the same key. Long-term private keys, event handlers and an AKE in progress
+// are not part of the snapshot. Every snapshot gets a higher generation than the one saved before it.
+func (c *Conversation) SaveState(integrityKey []byte) (ConversationState, error) {
+	if len(integrityKey) == 0 {
+		return nil, newOtrError("an integrity key is needed to save the conversation state")
+	}
+
+	if c.isOTRv4() {
+		return nil, errOTRv4StateUnsupported
+	}
+
+	c.stateGeneration++
+
+	out := append([]byte{}, conversationStateMagic...)
+	out = gotrax.AppendShort(out, conversationStateVersion)
+	out = gotrax.AppendLong(out, c.stateGeneration)
+	out = c.appendState(out)
+
+	return ConversationState(append(out, stateMAC(integrityKey, out)...)), nil
+}
+
+// RestoreState restores a snapshot created by SaveState into this conversation. The conversation
+// should be fresh, and have its policies and our private keys set before calling this method.
+// Snapshots that have been tampered with, that were saved with an unknown format version or that
+// use a protocol version not allowed by the current policies will be rejected.
+//
+// Restoring an older snapshot of an encrypted conversation would make it encrypt new messages with
+// key stream that has already been used. To prevent that, lastGeneration has to be the generation of
+// the latest snapshot saved, and snapshots with a lower generation are rejected. That only protects
+// the conversation if the latest snapshot is saved after every message sent.
+func (c *Conversation) RestoreState(s ConversationState, integrityKey []byte, lastGeneration uint64) error {
+	if c.msgState != plainText || c.keys.ourKeyID != 0 || c.version != nil {
+		return errConversationStateNotFresh
+	}
+
+	if len(s) < len(conversationStateMagic)+sha256.Size || !bytes.HasPrefix(s, conversationStateMagic) {
+		return errCorruptConversationState
+	}
+
+	content := s[:len(s)-sha256.Size]
+	if !hmac.Equal(stateMAC(integrityKey, content), s[len(content):]) {
+		return errConversationStateIntegrity
+	}
+
+	in, formatVersion, ok := gotrax.ExtractShort(content[len(conversationStateMagic):])
+	if !ok {
+		return errCorruptConversationState
+	}
+
+	if formatVersion < minConversationStateVersion || formatVersion > conversationStateVersion {
+		return errConversationStateVersion
+	}
+
+	var generation uint64
+	if formatVersion >= 3 {
+		if in, generation, ok = gotrax.ExtractLong(in); !ok {
+			return errCorruptConversationState
+		}
+	}
+
+	if generation < lastGeneration {
+		return errConversationStateRolledBack
+	}
+
+	restored := &Conversation{
+		Policies:     c.Policies,
+		ourKeys:      c.ourKeys,
+		fragmentSize: c.fragmentSize,
+	}
+
+	if err := restored.extractState(in, formatVersion); err != nil {
+		restored.keys.wipe()
+		restored.smp.wipe()
+		restored.setExporterSecret(nil)
+		return err
+	}
+
+	c.stateGeneration = generation
+	c.version = restored.version
+	c.msgState = restored.msgState
+	c.whitespaceState = restored.whitespaceState
+	c.lastMessageStateChange = restored.lastMessageStateChange
+	c.ourInstanceTag = restored.ourInstanceTag
+	c.theirInstanceTag = restored.theirInstanceTag
+	c.ssid = restored.ssid
+	c.setExporterSecret(restored.exporterSecret)
+	restored.setExporterSecret(nil)
+	c.sentRevealSig = restored.sentRevealSig
+	c.ourCurrentKey = restored.ourCurrentKey
+	c.theirKey = restored.theirKey
+	c.heartbeat = restored.heartbeat
+	c.keys = restored.keys
+	c.smp = restored.smp
+	c.resend.mayRetransmit = restored.resend.mayRetransmit
+	c.resend.clear()
+	for _, m := range restored.resend.pending() {
+		c.resend.later(m.m)
+	}
+
+	return nil
+}
+
+func stateMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (c *Conversation) appendState(out []byte) []byte {
+	protocolVersion := uint16(0)
+	if c.version != nil {
+		protocolVersion = c.version.protocolVersion()
+	}
+
+	out = gotrax.AppendShort(out, protocolVersion)
+	out = append(out, byte(c.msgState), byte(c.whitespaceState), boolToByte(c.sentRevealSig))
+	out = appendTime(out, c.lastMessageStateChange)
+	out = appendTime(out, c.heartbeat.lastSent)
+	out = gotrax.AppendWord(out, c.ourInstanceTag)
+	out = gotrax.AppendWord(out, c.theirInstanceTag)
+	out = append(out, c.ssid[:]...)
+	out = gotrax.AppendData(out, c.exporterSecret)
+
+	var ourKey, theirKey []byte
+	if c.ourCurrentKey != nil {
+		ourKey = c.ourCurrentKey.PublicKey().serialize()
+	}
+	if c.theirKey != nil {
+		theirKey = c.theirKey.serialize()
+	}
+	out = gotrax.AppendData(out, ourKey)
+	out = gotrax.AppendData(out, theirKey)
+
+	out = c.keys.appendState(out)
+	out = c.resend.appendState(out)
+	return c.smp.appendState(out)
+}
+
+func (c *Conversation) extractState(in []byte, formatVersion uint16) error {
+	var ok bool
+	var protocolVersion uint16
+	if in, protocolVersion, ok = gotrax.ExtractShort(in); !ok || len(in) < 3 {
+		return errCorruptConversationState
+	}
+
+	if protocolVersion != 0 {
+		v, err := newOtrVersion(protocolVersion, c.Policies)
+		if err != nil {
+			return err
+		}
+		c.version = v
+	}
+
+	c.msgState = msgState(in[0])
+	c.whitespaceState = whitespaceState(in[1])
+	c.sentRevealSig = in[2] == 1
+	in = in[3:]
+
+	if c.msgState > finished || c.whitespaceState > whitespaceRejected {
+		return errCorruptConversationState
+	}
+
+	if c.msgState == encrypted && c.version == nil {
+		return errCorruptConversationState
+	}
+
+	if in, c.lastMessageStateChange, ok = extractTime(in); !ok {
+		return errCorruptConversationState
+	}
+	if in, c.heartbeat.lastSent, ok = extractTime(in); !ok {
+		return errCorruptConversationState
+	}
+	if in, c.ourInstanceTag, ok = gotrax.ExtractWord(in); !ok {
+		return errCorruptConversationState
+	}
+	if in, c.theirInstanceTag, ok = gotrax.ExtractWord(in); !ok {
+		return errCorruptConversationState
+	}
+	if len(in) < len(c.ssid) {
+		return errCorruptConversationState
+	}
+	copy(c.ssid[:], in)
+	in = in[len(c.ssid):]
+
+	if formatVersion >= 2 {
+		var exporterSecret []byte
+		if in, exporterSecret, ok = gotrax.ExtractData(in); !ok {
+			return errCorruptConversationState
+		}
+		if len(exporterSecret) > 0 {
+			c.exporterSecret = makeCopy(exporterSecret)
+		}
+	}
+
+	var ourKey, theirKey []byte
+	if in, ourKey, ok = gotrax.ExtractData(in); !ok {
+		return errCorruptConversationState
+	}
+	if in, theirKey, ok = gotrax.ExtractData(in); !ok {
+		return errCorruptConversationState
+	}
+
+	if err := c.restoreOurCurrentKey(ourKey); err != nil {
+		return err
+	}
+
+	if len(theirKey) > 0 {
+		if _, ok, c.theirKey = ParsePublicKey(theirKey); !ok {
+			return errCorruptConversationState
+		}
+	}
+
+	if in, ok = c.keys.extractState(in); !ok {
+		return errCorruptConversationState
+	}
+	if in, ok = c.resend.extractState(in); !ok {
+		return errCorruptConversationState
+	}
+	if in, ok = c.smp.extractState(in); !ok || len(in) > 0 {
+		return errCorruptConversationState
+	}
+
+	return nil
+}
+
+func (c *Conversation) restoreOurCurrentKey(serialized []byte) error {
+	if len(serialized) == 0 {
+		return nil
+	}
+
+	if c.ourSigner != nil && bytes.Equal(c.ourSigner.PublicKey().serialize(), serialized) {
+		c.ourCurrentKey = c.ourSigner
+		return nil
+	}
+
+	for _, k := range c.ourKeys {
+		if bytes.Equal(k.PublicKey().serialize(), serialized) {
+			c.ourCurrentKey = k
+			return nil
+		}
+	}
+
+	return newOtrError("conversation state refers to a private key that is not available")
+}
+
+func (k *keyManagementContext) appendState(out []byte) []byte {
+	out = gotrax.AppendWord(out, k.ourKeyID)
+	out = gotrax.AppendWord(out, k.theirKeyID)
+	out = appendOptionalMPIs(out,
+		k.ourCurrentDHKeys.priv, k.ourCurrentDHKeys.pub,
+		k.ourPreviousDHKeys.priv, k.ourPreviousDHKeys.pub,
+		k.theirCurrentDHPubKey, k.theirPreviousDHPubKey)
+
+	out = gotrax.AppendWord(out, uint32(len(k.counterHistory.counters)))
+	for _, ctr := range k.counterHistory.counters {
+		out = gotrax.AppendWord(out, ctr.ourKeyID)
+		out = gotrax.AppendWord(out, ctr.theirKeyID)
+		out = gotrax.AppendLong(out, ctr.ourCounter)
+		out = gotrax.AppendLong(out, ctr.theirCounter)
+	}
+
+	out = gotrax.AppendWord(out, uint32(len(k.macKeyHistory.items)))
+	for _, item := range k.macKeyHistory.items {
+		out = gotrax.AppendWord(out, item.ourKeyID)
+		out = gotrax.AppendWord(out, item.theirKeyID)
+		out = gotrax.AppendData(out, item.receivingKey)
+	}
+
+	out = gotrax.AppendWord(out, uint32(len(k.oldMACKeys)))
+	for _, mk := range k.oldMACKeys {
+		out = gotrax.AppendData(out, mk)
+	}
+
+	return out
+}
+
+func (k *keyManagementContext) extractState(in []byte) ([]byte, bool) {
+	var ok bool
+	var count uint32
+
+	if in, k.ourKeyID, ok = gotrax.ExtractWord(in); !ok {
+		return nil, false
+	}
+	if in, k.theirKeyID, ok = gotrax.ExtractWord(in); !ok {
+		return nil, false
+	}
+	if in, ok = extractOptionalMPIs(in,
+		&k.ourCurrentDHKeys.priv, &k.ourCurrentDHKeys.pub,
+		&k.ourPreviousDHKeys.priv, &k.ourPreviousDHKeys.pub,
+		&k.theirCurrentDHPubKey, &k.theirPreviousDHPubKey); !ok {
+		return nil, false
+	}
+
+	if in, count, ok = gotrax.ExtractWord(in); !ok {
+		return nil, false
+	}
+	for i := uint32(0); i < count; i++ {
+		ctr := &keyPairCounter{}
+		if in, ctr.ourKeyID, ok = gotrax.ExtractWord(in); !ok {
+			return nil, false
+		}
+		if in, ctr.theirKeyID, ok = gotrax.ExtractWord(in); !ok {
+			return nil, false
+		}
+		if in, ctr.ourCounter, ok = gotrax.ExtractLong(in); !ok {
+			return nil, false
+		}
+		if in, ctr.theirCounter, ok = gotrax.ExtractLong(in); !ok {
+			return nil, false
+		}
+		k.counterHistory.counters = append(k.counterHistory.counters, ctr)
+	}
+
+	if in, count, ok = gotrax.ExtractWord(in); !ok {
+		return nil, false
+	}
+	for i := uint32(0); i < count; i++ {
+		var item macKeyUsage
+		var key []byte
+		if in, item.ourKeyID, ok = gotrax.ExtractWord(in); !ok {
+			return nil, false
+		}
+		if in, item.theirKeyID, ok = gotrax.ExtractWord(in); !ok {
+			return nil, false
+		}
+		if in, key, ok = gotrax.ExtractData(in); !ok {
+			return nil, false
+		}
+		item.receivingKey = makeCopy(key)
+		k.macKeyHistory.items = append(k.macKeyHistory.items, item)
+	}
+
+	if in, count, ok = gotrax.ExtractWord(in); !ok {
+		return nil, false
+	}
+	for i := uint32(0); i < count; i++ {
+		var key []byte
+		if in, key, ok = gotrax.ExtractData(in); !ok {
+			return nil, false
+		}
+		k.oldMACKeys = append(k.oldMACKeys, makeCopy(key))
+	}
+
+	return in, true
+}
+
+func (r *resendContext) appendState(out []byte) []byte {
+	msgs := r.pending()
+
+	out = append(out, byte(r.mayRetransmit))
+	out = gotrax.AppendWord(out, uint32(len(msgs)))
+	for _, m := range msgs {
+		out = gotrax.AppendData(out, m.m)
+	}
+
+	return out
+}
+
+func (r *resendContext) extractState(in []byte) ([]byte, bool) {
+	var ok bool
+	var flag byte
+	var count uint32
+
+	if in, flag, ok = gotrax.ExtractByte(in); !ok || retransmitFlag(flag) > retransmitExact {
+		return nil, false
+	}
+	r.mayRetransmit = retransmitFlag(flag)
+
+	if in, count, ok = gotrax.ExtractWord(in); !ok {
+		return nil, false
+	}
+	for i := uint32(0); i < count; i++ {
+		var m []byte
+		if in, m, ok = gotrax.ExtractData(in); !ok {
+			return nil, false
+		}
+		r.later(MessagePlaintext(m))
+	}
+
+	return in, true
+}
+
+func (s *smp) appendState(out []byte) []byte {
+	if s.state == nil {
+		return append(out, noSMPState)
+	}
+
+	out = append(out, byte(s.state.identity()))
+
+	question := []byte{}
+	if s.question != nil {
+		question = []byte(*s.question)
+	}
+	out = append(out, boolToByte(s.question != nil))
+	out = gotrax.AppendData(out, question)
+	out = appendOptionalMPIs(out, s.secret)
+
+	out = append(out, boolToByte(s.s1 != nil))
+	if s.s1 != nil {
+		out = s.s1.appendState(out)
+	}
+
+	out = append(out, boolToByte(s.s2 != nil))
+	if s.s2 != nil {
+		out = appendOptionalMPIs(out, s.s2.y, s.s2.b2, s.s2.b3, s.s2.r2, s.s2.r3, s.s2.r4, s.s2.r5, s.s2.r6,
+			s.s2.g3a, s.s2.g2, s.s2.g3, s.s2.pb, s.s2.qb,
+			s.s2.msg.g2b, s.s2.msg.g3b, s.s2.msg.c2, s.s2.msg.c3, s.s2.msg.d2, s.s2.msg.d3,
+			s.s2.msg.pb, s.s2.msg.qb, s.s2.msg.cp, s.s2.msg.d5, s.s2.msg.d6)
+	}
+
+	out = append(out, boolToByte(s.s3 != nil))
+	if s.s3 != nil {
+		out = appendOptionalMPIs(out, s.s3.x, s.s3.g3b, s.s3.r4, s.s3.r5, s.s3.r6, s.s3.r7, s.s3.qaqb, s.s3.papb,
+			s.s3.msg.pa, s.s3.msg.qa, s.s3.msg.cp, s.s3.msg.d5, s.s3.msg.d6, s.s3.msg.d7, s.s3.msg.ra, s.s3.msg.cr)
+	}
+
+	if ws, ok := s.state.(smpStateWaitingForSecret); ok {
+		out = appendSMP1Message(out, ws.msg)
+	}
+
+	return out
+}
+
+func (s *smp) extractState(in []byte) ([]byte, bool) {
+	var ok bool
+	var identity, hasQuestion, has byte
+	var question []byte
+
+	if in, identity, ok = gotrax.ExtractByte(in); !ok {
+		return nil, false
+	}
+
+	if identity == noSMPState {
+		return in, true
+	}
+
+	if in, hasQuestion, ok = gotrax.ExtractByte(in); !ok {
+		return nil, false
+	}
+	if in, question, ok = gotrax.ExtractData(in); !ok {
+		return nil, false
+	}
+	if hasQuestion == 1 {
+		q := string(question)
+		s.question = &q
+	}
+
+	if in, ok = extractOptionalMPIs(in, &s.secret); !ok {
+		return nil, false
+	}
+
+	if in, has, ok = gotrax.ExtractByte(in); !ok {
+		return nil, false
+	}
+	if has == 1 {
+		s.s1 = &smp1State{}
+		if in, ok = s.s1.extractState(in); !ok {
+			return nil, false
+		}
+	}
+
+	if in, has, ok = gotrax.ExtractByte(in); !ok {
+		return nil, false
+	}
+	if has == 1 {
+		s.s2 = &smp2State{}
+		if in, ok = extractOptionalMPIs(in, &s.s2.y, &s.s2.b2, &s.s2.b3, &s.s2.r2, &s.s2.r3, &s.s2.r4, &s.s2.r5, &s.s2.r6,
+			&s.s2.g3a, &s.s2.g2, &s.s2.g3, &s.s2.pb, &s.s2.qb,
+			&s.s2.msg.g2b, &s.s2.msg.g3b, &s.s2.msg.c2, &s.s2.msg.c3, &s.s2.msg.d2, &s.s2.msg.d3,
+			&s.s2.msg.pb, &s.s2.msg.qb, &s.s2.msg.cp, &s.s2.msg.d5, &s.s2.msg.d6); !ok {
+			return nil, false
+		}
+	}
+
+	if in, has, ok = gotrax.ExtractByte(in); !ok {
+		return nil, false
+	}
+	if has == 1 {
+		s.s3 = &smp3State{}
+		if in, ok = extractOptionalMPIs(in, &s.s3.x, &s.s3.g3b, &s.s3.r4, &s.s3.r5, &s.s3.r6, &s.s3.r7, &s.s3.qaqb, &s.s3.papb,
+			&s.s3.msg.pa, &s.s3.msg.qa, &s.s3.msg.cp, &s.s3.msg.d5, &s.s3.msg.d6, &s.s3.msg.d7, &s.s3.msg.ra, &s.s3.msg.cr); !ok {
+			return nil, false
+		}
+	}
+
+	switch identity {
+	case byte(smpStateExpect1{}.identity()):
+		s.state = smpStateExpect1{}
+	case byte(smpStateExpect2{}.identity()):
+		s.state = smpStateExpect2{}
+	case byte(smpStateExpect3{}.identity()):
+		s.state = smpStateExpect3{}
+	case byte(smpStateExpect4{}.identity()):
+		s.state = smpStateExpect4{}
+	case byte(smpStateWaitingForSecret{}.identity()):
+		ws := smpStateWaitingForSecret{}
+		if in, ok = extractSMP1Message(in, &ws.msg); !ok {
+			return nil, false
+		}
+		s.state = ws
+	default:
+		return nil, false
+	}
+
+	return in, true
+}
+
+func (s *smp1State) appendState(out []byte) []byte {
+	out = appendOptionalMPIs(out, s.a2, s.a3, s.r2, s.r3)
+	return appendSMP1Message(out, s.msg)
+}
+
+func (s *smp1State) extractState(in []byte) ([]byte, bool) {
+	in, ok := extractOptionalMPIs(in, &s.a2, &s.a3, &s.r2, &s.r3)
+	if !ok {
+		return nil, false
+	}
+	return extractSMP1Message(in, &s.msg)
+}
+
+func appendSMP1Message(out []byte, m smp1Message) []byte {
+	out = appendOptionalMPIs(out, m.g2a, m.g3a, m.c2, m.c3, m.d2, m.d3)
+	out = append(out, boolToByte(m.hasQuestion))
+	return gotrax.AppendData(out, []byte(m.question))
+}
+
+func extractSMP1Message(in []byte, m *smp1Message) ([]byte, bool) {
+	var ok bool
+	var hasQuestion byte
+	var question []byte
+
+	if in, ok = extractOptionalMPIs(in, &m.g2a, &m.g3a, &m.c2, &m.c3, &m.d2, &m.d3); !ok {
+		return nil, false
+	}
+	if in, hasQuestion, ok = gotrax.ExtractByte(in); !ok {
+		return nil, false
+	}
+	if in, question, ok = gotrax.ExtractData(in); !ok {
+		return nil, false
+	}
+
+	m.hasQuestion = hasQuestion == 1
+	m.question = string(question)
+	return in, true
+}
+
+// appendOptionalMPIs serializes each MPI prefixed with a byte indicating whether it is present or nil
+func appendOptionalMPIs(out []byte, mpis ...*big.Int) []byte {
+	for _, mpi := range mpis {
+		out = append(out, boolToByte(mpi != nil))
+		if mpi != nil {
+			out = gotrax.AppendMPI(out, mpi)
+		}
+	}
+	return out
+}
+
+func extractOptionalMPIs(in []byte, mpis ...**big.Int) ([]byte, bool) {
+	for _, mpi := range mpis {
+		var ok bool
+		var present byte
+		if in, present, ok = gotrax.ExtractByte(in); !ok {
+			return nil, false
+		}
+
+		*mpi = nil
+		if present == 1 {
+			if in, *mpi, ok = gotrax.ExtractMPI(in); !ok {
+				return nil, false
+			}
+		}
+	}
+	return in, true
+}
+
+func appendTime(out []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return gotrax.AppendLong(out, 0)
+	}
+	return gotrax.AppendLong(out, uint64(t.UnixNano()))
+}
+
+func extractTime(in []byte) ([]byte, time.Time, bool) {
+	in, v, ok := gotrax.ExtractLong(in)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	if v == 0 {
+		return in, time.Time{}, true
+	}
+	return in, time.Unix(0, int64(v)), true
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
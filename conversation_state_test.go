@@ -0,0 +1,240 @@
+package otr3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/coyim/gotrax"
+)
+
+var fixtureIntegrityKey = []byte("some integrity key for the tests")
+
+func restoredConversation(t *testing.T, s ConversationState, key PrivateKey) *Conversation {
+	c := &Conversation{Rand: rand.Reader}
+	c.Policies = Policy(allowV2 | allowV3)
+	c.SetOurKeys([]PrivateKey{key})
+	err := c.RestoreState(s, fixtureIntegrityKey, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error when restoring state: %v", err)
+	}
+	return c
+}
+
+func Test_SaveState_returnsErrorWithoutIntegrityKey(t *testing.T) {
+	c := &Conversation{}
+	_, err := c.SaveState(nil)
+	assertNotNil(t, err)
+}
+
+func Test_RestoreState_restoresAnEncryptedConversationThatCanKeepTalking(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	toBob, _ := alice.Send(ValidMessage("before restart"))
+	toAlice := deliverAll(t, bob, toBob)
+	deliverAll(t, alice, toAlice)
+
+	s, err := alice.SaveState(fixtureIntegrityKey)
+	assertNil(t, err)
+
+	restored := restoredConversation(t, s, alicePrivateKey)
+
+	assertTrue(t, restored.IsEncrypted())
+	assertEquals(t, restored.version, otrV3{})
+	assertEquals(t, restored.ssid, alice.ssid)
+	assertEquals(t, restored.ourInstanceTag, alice.ourInstanceTag)
+	assertEquals(t, restored.theirInstanceTag, alice.theirInstanceTag)
+	assertEquals(t, restored.ourCurrentKey, alicePrivateKey)
+	assertDeepEquals(t, restored.theirKey.Fingerprint(), bobPrivateKey.PublicKey().Fingerprint())
+	assertDeepEquals(t, restored.keys, alice.keys)
+
+	toBob, err = restored.Send(ValidMessage("after restart"))
+	assertNil(t, err)
+	plain, _, err := bob.Receive(toBob[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("after restart"))
+
+	toAlice, _ = bob.Send(ValidMessage("welcome back"))
+	plain, _, err = restored.Receive(toAlice[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("welcome back"))
+}
+
+func Test_RestoreState_restoresPendingMessagesAndSMPState(t *testing.T) {
+	c := bobContextAfterAKE()
+	c.msgState = encrypted
+	c.ourKeys = []PrivateKey{bobPrivateKey}
+	c.ourCurrentKey = bobPrivateKey
+	c.theirKey = alicePrivateKey.PublicKey()
+	c.updateMayRetransmitTo(retransmitWithPrefix)
+	c.lastMessage(MessagePlaintext("queued"))
+	c.smp.state = smpStateExpect2{}
+	c.smp.secret = fixtureSecret()
+	c.smp.s1 = fixtureSmp1()
+
+	s, _ := c.SaveState(fixtureIntegrityKey)
+	restored := restoredConversation(t, s, bobPrivateKey)
+
+	assertEquals(t, restored.resend.mayRetransmit, retransmitWithPrefix)
+	assertEquals(t, len(restored.resend.pending()), 1)
+	assertDeepEquals(t, restored.resend.pending()[0].m, MessagePlaintext("queued"))
+	assertEquals(t, restored.smp.state, smpStateExpect2{})
+	assertDeepEquals(t, restored.smp.secret, fixtureSecret())
+	assertDeepEquals(t, restored.smp.s1.a2, c.smp.s1.a2)
+	assertDeepEquals(t, restored.smp.s1.msg.g2a, c.smp.s1.msg.g2a)
+}
+
+func Test_RestoreState_restoresSMPWaitingForSecret(t *testing.T) {
+	c := bobContextAfterAKE()
+	c.smp.state = smpStateWaitingForSecret{msg: fixtureMessage1Q()}
+	q := "what is the question?"
+	c.smp.question = &q
+
+	s, _ := c.SaveState(fixtureIntegrityKey)
+	restored := restoredConversation(t, s, bobPrivateKey)
+
+	question, ok := restored.SMPQuestion()
+	assertTrue(t, ok)
+	assertEquals(t, question, q)
+	assertDeepEquals(t, restored.smp.state, c.smp.state)
+}
+
+func Test_RestoreState_rejectsTamperedSnapshots(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	s, _ := alice.SaveState(fixtureIntegrityKey)
+	s[len(conversationStateMagic)+10] ^= 0x01
+
+	c := &Conversation{Policies: Policy(allowV3)}
+	c.SetOurKeys([]PrivateKey{alicePrivateKey})
+	assertEquals(t, c.RestoreState(s, fixtureIntegrityKey, 0), errConversationStateIntegrity)
+	assertFalse(t, c.IsEncrypted())
+}
+
+func Test_RestoreState_rejectsSnapshotsWithTheWrongKey(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	s, _ := alice.SaveState(fixtureIntegrityKey)
+
+	c := &Conversation{Policies: Policy(allowV3)}
+	c.SetOurKeys([]PrivateKey{alicePrivateKey})
+	assertEquals(t, c.RestoreState(s, []byte("another key"), 0), errConversationStateIntegrity)
+}
+
+func Test_RestoreState_rejectsUnknownFormatVersions(t *testing.T) {
+	c := bobContextAfterAKE()
+	s, _ := c.SaveState(fixtureIntegrityKey)
+
+	for _, v := range []uint16{minConversationStateVersion - 1, conversationStateVersion + 1} {
+		content := makeCopy(s[:len(s)-32])
+		copy(content[len(conversationStateMagic):], gotrax.SerializeShort(v))
+		withVersion := append(content, stateMAC(fixtureIntegrityKey, content)...)
+
+		restored := &Conversation{Policies: Policy(allowV3)}
+		assertEquals(t, restored.RestoreState(withVersion, fixtureIntegrityKey, 0), errConversationStateVersion)
+	}
+}
+
+func Test_RestoreState_acceptsVersion1SnapshotsWithoutAnExporterSecret(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	// Version 1 snapshots are the same as the current ones, except for the missing exporter secret
+	marker := gotrax.AppendData(nil, alice.exporterSecret)
+	state := alice.appendState(nil)
+	at := bytes.Index(state, marker)
+	assertTrue(t, at > 0)
+
+	content := append(makeCopy(conversationStateMagic), gotrax.SerializeShort(1)...)
+	content = append(content, state[:at]...)
+	content = append(content, state[at+len(marker):]...)
+	s := ConversationState(append(content, stateMAC(fixtureIntegrityKey, content)...))
+
+	restored := restoredConversation(t, s, alicePrivateKey)
+	assertTrue(t, restored.IsEncrypted())
+	assertNil(t, restored.exporterSecret)
+
+	_, err := restored.ExportKeyingMaterial("voice", nil, 32)
+	assertEquals(t, err, errCannotExportUnencrypted)
+
+	toBob, err := restored.Send(ValidMessage("after restart"))
+	assertNil(t, err)
+	plain, _, err := bob.Receive(toBob[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("after restart"))
+}
+
+func Test_RestoreState_rejectsProtocolVersionsNotAllowedByPolicy(t *testing.T) {
+	c := bobContextAfterAKE()
+	s, _ := c.SaveState(fixtureIntegrityKey)
+
+	restored := &Conversation{Policies: Policy(allowV2)}
+	assertEquals(t, restored.RestoreState(s, fixtureIntegrityKey, 0), errInvalidVersion)
+	assertNil(t, restored.version)
+}
+
+func Test_RestoreState_rejectsTruncatedSnapshots(t *testing.T) {
+	c := bobContextAfterAKE()
+	s, _ := c.SaveState(fixtureIntegrityKey)
+
+	content := makeCopy(s[:len(s)-40])
+	s = append(content, stateMAC(fixtureIntegrityKey, content)...)
+
+	restored := &Conversation{Policies: Policy(allowV3)}
+	assertEquals(t, restored.RestoreState(s, fixtureIntegrityKey, 0), errCorruptConversationState)
+}
+
+func Test_RestoreState_onlyWorksOnFreshConversations(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	s, _ := alice.SaveState(fixtureIntegrityKey)
+
+	assertEquals(t, bob.RestoreState(s, fixtureIntegrityKey, 0), errConversationStateNotFresh)
+}
+
+func Test_RestoreState_failsIfOurKeyIsNotAvailable(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	s, _ := alice.SaveState(fixtureIntegrityKey)
+
+	c := &Conversation{Policies: Policy(allowV3)}
+	c.SetOurKeys([]PrivateKey{bobPrivateKey})
+	assertNotNil(t, c.RestoreState(s, fixtureIntegrityKey, 0))
+	assertFalse(t, c.IsEncrypted())
+}
+
+func Test_ConversationState_Wipe_removesAllContent(t *testing.T) {
+	c := bobContextAfterAKE()
+	s, _ := c.SaveState(fixtureIntegrityKey)
+	s.Wipe()
+	assertDeepEquals(t, []byte(s), make([]byte, len(s)))
+}
+
+func Test_SaveState_increasesTheGenerationOfEverySnapshot(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+
+	s1, _ := alice.SaveState(fixtureIntegrityKey)
+	s2, _ := alice.SaveState(fixtureIntegrityKey)
+	assertEquals(t, s1.Generation(), uint64(1))
+	assertEquals(t, s2.Generation(), uint64(2))
+
+	restored := &Conversation{Rand: rand.Reader, Policies: Policy(allowV3)}
+	restored.SetOurKeys([]PrivateKey{alicePrivateKey})
+	assertNil(t, restored.RestoreState(s2, fixtureIntegrityKey, s2.Generation()))
+
+	s3, _ := restored.SaveState(fixtureIntegrityKey)
+	assertEquals(t, s3.Generation(), uint64(3))
+	assertEquals(t, ConversationState(nil).Generation(), uint64(0))
+}
+
+func Test_RestoreState_rejectsSnapshotsOlderThanTheLastGeneration(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	old, _ := alice.SaveState(fixtureIntegrityKey)
+
+	toBob, _ := alice.Send(ValidMessage("sent after the old snapshot"))
+	deliverAll(t, bob, toBob)
+	latest, _ := alice.SaveState(fixtureIntegrityKey)
+
+	restored := &Conversation{Policies: Policy(allowV3)}
+	restored.SetOurKeys([]PrivateKey{alicePrivateKey})
+	assertEquals(t, restored.RestoreState(old, fixtureIntegrityKey, latest.Generation()), errConversationStateRolledBack)
+	assertFalse(t, restored.IsEncrypted())
+
+	assertNil(t, restored.RestoreState(latest, fixtureIntegrityKey, latest.Generation()))
+	assertTrue(t, restored.IsEncrypted())
+}
@@ -138,7 +138,7 @@ func Test_Send_returnsErrorIfFaislToGenerateDataMsg(t *testing.T) {
 
 	c := bobContextAfterAKE()
 	c.msgState = encrypted
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 	c.keys.theirKeyID = 0
 	s, err := c.Send(msg)
 
@@ -154,7 +154,7 @@ func Test_send_appendWhitespaceTagsWhenAllowedbyThePolicy(t *testing.T) {
 	}
 
 	c := &Conversation{}
-	c.Policies = policies(allowV3 | sendWhitespaceTag)
+	c.Policies = Policy(allowV3 | sendWhitespaceTag)
 
 	m, _ := c.Send([]byte("hello"))
 	wsPos := len(m[0]) - len(expectedWhitespaceTag)
@@ -164,7 +164,7 @@ func Test_send_appendWhitespaceTagsWhenAllowedbyThePolicy(t *testing.T) {
 func Test_send_doesNotAppendWhitespaceTagsWhenItsNotAllowedbyThePolicy(t *testing.T) {
 	m := []byte("hello")
 	c := &Conversation{}
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 
 	toSend, _ := c.Send(m)
 	assertDeepEquals(t, toSend, []ValidMessage{m})
@@ -179,7 +179,7 @@ func Test_send_appendWhitespaceTagsIfReceivesNonDHCommitMsgBeforeSendingFirstMes
 	}
 
 	c := &Conversation{}
-	c.Policies = policies(allowV3 | sendWhitespaceTag)
+	c.Policies = Policy(allowV3 | sendWhitespaceTag)
 
 	_, _, err := c.Receive(ValidMessage("hi"))
 	assertNil(t, err)
@@ -198,7 +198,7 @@ func Test_send_stopAppendingWhitespaceTagsIfReceivesNonDHCommitMsg(t *testing.T)
 	}
 
 	c := &Conversation{}
-	c.Policies = policies(allowV3 | sendWhitespaceTag)
+	c.Policies = Policy(allowV3 | sendWhitespaceTag)
 
 	m, err := c.Send(hello)
 	assertNil(t, err)
@@ -216,7 +216,7 @@ func Test_send_dataMessageWhenItsMsgStateEncrypted(t *testing.T) {
 	m := []byte("hello")
 	c := bobContextAfterAKE()
 	c.msgState = encrypted
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 	toSend, _ := c.Send(m)
 
 	stub := bobContextAfterAKE()
@@ -229,7 +229,7 @@ func Test_send_dataMessageWhenItsMsgStateEncrypted(t *testing.T) {
 
 func Test_encodeWithoutFragment(t *testing.T) {
 	c := newConversation(otrV2{}, fixtureRand())
-	c.Policies = policies(allowV2 | allowV3 | whitespaceStartAKE)
+	c.Policies = Policy(allowV2 | allowV3 | whitespaceStartAKE)
 	c.SetFragmentSize(64)
 
 	msg := c.fragEncode([]byte("one two three"))
@@ -242,7 +242,7 @@ func Test_encodeWithoutFragment(t *testing.T) {
 
 func Test_encodeWithoutFragmentTooSmall(t *testing.T) {
 	c := newConversation(otrV2{}, fixtureRand())
-	c.Policies = policies(allowV2 | allowV3 | whitespaceStartAKE)
+	c.Policies = Policy(allowV2 | allowV3 | whitespaceStartAKE)
 	c.SetFragmentSize(18)
 
 	msg := c.fragEncode([]byte("one two three"))
@@ -255,7 +255,7 @@ func Test_encodeWithoutFragmentTooSmall(t *testing.T) {
 
 func Test_encodeWithFragment(t *testing.T) {
 	c := newConversation(otrV2{}, fixtureRand())
-	c.Policies = policies(allowV2 | allowV3 | whitespaceStartAKE)
+	c.Policies = Policy(allowV2 | allowV3 | whitespaceStartAKE)
 	c.SetFragmentSize(22)
 
 	msg := c.fragEncode([]byte("one two three"))
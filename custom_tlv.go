@@ -0,0 +1,132 @@
+package otr3
+
+// TLV is a type/length/value record carried inside an encrypted data message. The types up to and including 8 are
+// used by the protocol itself, and 0x0100 and 0x0101 are used for delivery receipts. All other types are available
+// for applications.
+type TLV struct {
+	Type  uint16
+	Value []byte
+}
+
+// TLVHandler handles TLVs of a type registered by the application
+type TLVHandler interface {
+	// HandleTLV is called for every received TLV of a registered type. If a reply is returned it will be sent back to
+	// the peer in a data message. Returning an error will make the whole data message be treated as corrupt.
+	HandleTLV(t TLV) (reply *TLV, err error)
+}
+
+type dynamicTLVHandler struct {
+	eh func(t TLV) (*TLV, error)
+}
+
+func (d dynamicTLVHandler) HandleTLV(t TLV) (*TLV, error) {
+	return d.eh(t)
+}
+
+func isReservedTLVType(tp uint16) bool {
+	return tp < uint16(len(tlvHandlers)) || isReceiptTLVType(tp)
+}
+
+func (t TLV) toTLV() tlv {
+	return tlv{
+		tlvType:   t.Type,
+		tlvLength: uint16(len(t.Value)),
+		tlvValue:  makeCopy(t.Value),
+	}
+}
+
+func (t tlv) toTLV() TLV {
+	return TLV{
+		Type:  t.tlvType,
+		Value: makeCopy(t.tlvValue[:t.tlvLength]),
+	}
+}
+
+// RegisterTLVHandler makes the conversation call the handler for every TLV of the given type it receives. Registering
+// a nil handler removes the handler for the type. The types used by the protocol can't be registered.
+func (c *Conversation) RegisterTLVHandler(tlvType uint16, handler TLVHandler) error {
+	if isReservedTLVType(tlvType) {
+		return errReservedTLVType
+	}
+
+	if handler == nil {
+		delete(c.customTLVHandlers, tlvType)
+		return nil
+	}
+
+	if c.customTLVHandlers == nil {
+		c.customTLVHandlers = make(map[uint16]TLVHandler)
+	}
+	c.customTLVHandlers[tlvType] = handler
+	return nil
+}
+
+// SetUnknownTLVHandler assigns a handler that will be called for all received TLVs that neither the protocol nor a
+// registered handler knows about. Without it those TLVs are ignored.
+func (c *Conversation) SetUnknownTLVHandler(handler TLVHandler) {
+	c.unknownTLVHandler = handler
+}
+
+func (c *Conversation) customTLVHandler(tp uint16) (TLVHandler, bool) {
+	if h, ok := c.customTLVHandlers[tp]; ok {
+		return h, true
+	}
+	return c.unknownTLVHandler, c.unknownTLVHandler != nil
+}
+
+func (c *Conversation) processCustomTLV(h TLVHandler, t tlv) (*tlv, error) {
+	reply, err := h.HandleTLV(t.toTLV())
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	if err := checkCustomTLV(*reply); err != nil {
+		return nil, err
+	}
+
+	r := reply.toTLV()
+	return &r, nil
+}
+
+func checkCustomTLV(t TLV) error {
+	if isReservedTLVType(t.Type) {
+		return errReservedTLVType
+	}
+	if len(t.Value) > 0xFFFF {
+		return errTLVTooLong
+	}
+	return nil
+}
+
+// SendWithTLVs sends the message together with the given TLVs in one data message. The message can be empty, in which
+// case only the TLVs are sent. This only works in an encrypted conversation, and the TLVs can't be of the types the
+// protocol uses.
+func (c *Conversation) SendWithTLVs(m ValidMessage, tlvs ...TLV) ([]ValidMessage, error) {
+	if c.msgState != encrypted {
+		return nil, errCannotSendUnencrypted
+	}
+
+	ts := make([]tlv, 0, len(tlvs))
+	for _, t := range tlvs {
+		if err := checkCustomTLV(t); err != nil {
+			return nil, err
+		}
+		ts = append(ts, t.toTLV())
+	}
+
+	message := makeCopy(m)
+	defer wipeBytes(message)
+
+	flag := messageFlagNormal
+	if len(message) == 0 {
+		flag = messageFlagIgnoreUnreadable
+	}
+
+	result, _, err := c.createSerializedDataMessage(message, flag, ts)
+	if err != nil {
+		c.messageEvent(MessageEventEncryptionError)
+		c.generatePotentialErrorMessage(ErrorCodeEncryptionError)
+	}
+
+	return c.withInjections(result, err)
+}
@@ -0,0 +1,148 @@
+package otr3
+
+import "testing"
+
+func Test_RegisterTLVHandler_failsForTheTypesOfTheProtocol(t *testing.T) {
+	c := &Conversation{}
+
+	err := c.RegisterTLVHandler(tlvTypeSMP1, dynamicTLVHandler{func(TLV) (*TLV, error) { return nil, nil }})
+	assertEquals(t, err, errReservedTLVType)
+	assertNil(t, c.customTLVHandlers)
+}
+
+func Test_RegisterTLVHandler_removesTheHandlerWhenGivenNil(t *testing.T) {
+	c := &Conversation{}
+	c.RegisterTLVHandler(0x42, dynamicTLVHandler{func(TLV) (*TLV, error) { return nil, nil }})
+
+	assertNil(t, c.RegisterTLVHandler(0x42, nil))
+
+	_, err := c.messageHandlerForTLV(tlv{tlvType: 0x42})
+	assertNotNil(t, err)
+}
+
+func Test_SendWithTLVs_deliversTheTLVsToTheRegisteredHandler(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	var received []TLV
+	bob.RegisterTLVHandler(0x42, dynamicTLVHandler{func(tl TLV) (*TLV, error) {
+		received = append(received, tl)
+		return nil, nil
+	}})
+
+	msg, err := alice.SendWithTLVs(ValidMessage("hello"), TLV{Type: 0x42, Value: []byte{0x01, 0x02}}, TLV{Type: 0x42})
+	assertNil(t, err)
+
+	plain, _, err := bob.Receive(msg[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+	assertDeepEquals(t, received, []TLV{{Type: 0x42, Value: []byte{0x01, 0x02}}, {Type: 0x42, Value: []byte{}}})
+}
+
+func Test_SendWithTLVs_canSendTLVsWithoutAMessage(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	called := false
+	bob.RegisterTLVHandler(0x42, dynamicTLVHandler{func(TLV) (*TLV, error) {
+		called = true
+		return nil, nil
+	}})
+
+	msg, err := alice.SendWithTLVs(nil, TLV{Type: 0x42})
+	assertNil(t, err)
+	assertEquals(t, extractDataMessageFlag(mustDecodeDataMessage(t, bob, msg[0])), messageFlagIgnoreUnreadable)
+
+	plain, _, err := bob.Receive(msg[0])
+	assertNil(t, err)
+	assertNil(t, plain)
+	assertTrue(t, called)
+}
+
+func Test_SendWithTLVs_sendsTheReplyOfTheHandlerBack(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	bob.RegisterTLVHandler(0x42, dynamicTLVHandler{func(tl TLV) (*TLV, error) {
+		return &TLV{Type: 0x43, Value: append([]byte("re: "), tl.Value...)}, nil
+	}})
+
+	var reply TLV
+	alice.RegisterTLVHandler(0x43, dynamicTLVHandler{func(tl TLV) (*TLV, error) {
+		reply = tl
+		return nil, nil
+	}})
+
+	msg, _ := alice.SendWithTLVs(nil, TLV{Type: 0x42, Value: []byte("ping")})
+	deliverAll(t, alice, deliverAll(t, bob, msg))
+
+	assertDeepEquals(t, reply, TLV{Type: 0x43, Value: []byte("re: ping")})
+}
+
+func Test_SendWithTLVs_worksForOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runDAKE(t, alice, bob)
+
+	var received TLV
+	bob.RegisterTLVHandler(0x42, dynamicTLVHandler{func(tl TLV) (*TLV, error) {
+		received = tl
+		return nil, nil
+	}})
+
+	msg, err := alice.SendWithTLVs(ValidMessage("hi"), TLV{Type: 0x42, Value: []byte{0x01}})
+	assertNil(t, err)
+	deliverAll(t, bob, msg)
+
+	assertDeepEquals(t, received, TLV{Type: 0x42, Value: []byte{0x01}})
+}
+
+func Test_SendWithTLVs_failsForTheTypesOfTheProtocol(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+
+	_, err := alice.SendWithTLVs(nil, TLV{Type: tlvTypeDisconnected})
+	assertEquals(t, err, errReservedTLVType)
+}
+
+func Test_SendWithTLVs_failsForTooLongValues(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+
+	_, err := alice.SendWithTLVs(nil, TLV{Type: 0x42, Value: make([]byte, 0x10000)})
+	assertEquals(t, err, errTLVTooLong)
+}
+
+func Test_SendWithTLVs_failsWhenNotEncrypted(t *testing.T) {
+	c := &Conversation{}
+
+	_, err := c.SendWithTLVs(ValidMessage("hello"), TLV{Type: 0x42})
+	assertEquals(t, err, errCannotSendUnencrypted)
+}
+
+func Test_processTLVs_surfacesUnknownTLVsToTheUnknownTLVHandler(t *testing.T) {
+	c := newConversation(otrV3{}, fixtureRand())
+	c.msgState = encrypted
+
+	var unknown []TLV
+	c.SetUnknownTLVHandler(dynamicTLVHandler{func(tl TLV) (*TLV, error) {
+		unknown = append(unknown, tl)
+		return nil, nil
+	}})
+	c.RegisterTLVHandler(0x42, dynamicTLVHandler{func(TLV) (*TLV, error) { return nil, nil }})
+
+	_, err := c.processTLVs([]tlv{{0x42, 0, nil}, {0x99, 1, []byte{0x07}}}, dataMessageExtra{})
+	assertNil(t, err)
+	assertDeepEquals(t, unknown, []TLV{{Type: 0x99, Value: []byte{0x07}}})
+}
+
+func Test_processTLVs_failsWhenTheHandlerFails(t *testing.T) {
+	c := newConversation(otrV3{}, fixtureRand())
+	c.msgState = encrypted
+	c.RegisterTLVHandler(0x42, dynamicTLVHandler{func(TLV) (*TLV, error) { return nil, errCorruptAgentMessage }})
+
+	_, err := c.processTLVs([]tlv{{0x42, 0, nil}}, dataMessageExtra{})
+	assertEquals(t, err, errCorruptAgentMessage)
+}
+
+func mustDecodeDataMessage(t *testing.T, c *Conversation, m ValidMessage) []byte {
+	decoded, err := c.decode(encodedMessage(m))
+	assertNil(t, err)
+	_, body, err := c.parseMessageHeader(decoded)
+	assertNil(t, err)
+	return body
+}
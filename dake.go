@@ -0,0 +1,409 @@
+package otr3
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/coyim/gotrax"
+)
+
+// dake holds the state of an OTRv4 interactive DAKE in progress. In the terms of the OTRv4 specification, Bob is
+// the one who sends the identity message and Alice is the one who answers it with an auth-r message.
+type dake struct {
+	state dakeState
+
+	ourProfile   *ClientProfile
+	theirProfile *ClientProfile
+
+	ourECDHSecret *ed448Scalar
+	ourECDH       *ed448Point
+	theirECDH     *ed448Point
+
+	ourDHSecret *big.Int
+	ourDH       *big.Int
+	theirDH     *big.Int
+
+	// identityMsg is only used to store the message so we can re-transmit it if needed
+	identityMsg messageWithHeader
+
+	lastStateChange time.Time
+}
+
+// otrv4Session holds the result of a finished OTRv4 DAKE
+type otrv4Session struct {
+	sharedSecret []byte
+	ratchet      *ratchet
+
+	// weAreBob is true if we sent the identity message
+	weAreBob bool
+}
+
+func (c *Conversation) ensureDAKE() {
+	if c.dake != nil {
+		return
+	}
+
+	c.dake = &dake{state: dakeStateNone{}}
+}
+
+// copy creates a copy of the DAKE that doesn't share any mutable state with the original
+func (d *dake) copy() *dake {
+	return &dake{
+		state:           d.state,
+		ourProfile:      d.ourProfile,
+		theirProfile:    d.theirProfile,
+		ourECDHSecret:   copyEd448Scalar(d.ourECDHSecret),
+		ourECDH:         d.ourECDH,
+		theirECDH:       d.theirECDH,
+		ourDHSecret:     copyBigInt(d.ourDHSecret),
+		ourDH:           copyBigInt(d.ourDH),
+		theirDH:         copyBigInt(d.theirDH),
+		identityMsg:     makeCopy(d.identityMsg),
+		lastStateChange: d.lastStateChange,
+	}
+}
+
+func (d *dake) wipe() {
+	if d == nil {
+		return
+	}
+
+	wipeEd448Scalar(d.ourECDHSecret)
+	d.ourECDHSecret = nil
+	wipeBigInt(d.ourDHSecret)
+	d.ourDHSecret = nil
+	d.ourECDH, d.theirECDH = nil, nil
+	d.ourDH, d.theirDH = nil, nil
+	d.theirProfile = nil
+	d.identityMsg = nil
+}
+
+func (s *otrv4Session) wipe() {
+	if s == nil {
+		return
+	}
+	wipeBytes(s.sharedSecret)
+	s.sharedSecret = nil
+	s.ratchet.wipe()
+	s.ratchet = nil
+}
+
+func (c *Conversation) isOTRv4() bool {
+	return c.version != nil && c.version.protocolVersion() == 4
+}
+
+// sendAKEStart starts the AKE of the version we have committed to
+func (c *Conversation) sendAKEStart() (messageWithHeader, error) {
+	if c.isOTRv4() {
+		return c.sendIdentity()
+	}
+	return c.sendDHCommit()
+}
+
+// StartOTRv4 starts the OTRv4 DAKE with the peer and returns the identity message to send. OTRv4 is never offered in
+// query messages or whitespace tags, since this implementation doesn't follow the wire format of the OTRv4
+// specification - it only works with peers that use this library and allow OTRv4 too. It fails if the policy doesn't
+// allow OTRv4, we have no Ed448 key, or the conversation has already committed to another version.
+func (c *Conversation) StartOTRv4() ([]ValidMessage, error) {
+	if !c.Policies.has(allowV4) || !c.hasKeyForVersion(4) {
+		return nil, errInvalidVersion
+	}
+
+	if err := c.commitToVersionFrom(1 << 4); err != nil {
+		return nil, err
+	}
+
+	if !c.isOTRv4() {
+		return nil, errInvalidVersion
+	}
+
+	ts, err := c.sendAKEStart()
+	return c.withInjections(c.encodeAndCombine(compactMessagesWithHeader(ts)), err)
+}
+
+func (c *Conversation) ourEd448Key() (*Ed448PrivateKey, error) {
+	if k, ok := c.ourCurrentKey.(*Ed448PrivateKey); ok {
+		return k, nil
+	}
+	return nil, errInvalidEd448Key
+}
+
+// ourClientProfile returns the client profile we use in the DAKE, creating a new one when there is none yet or the
+// old one has expired
+func (c *Conversation) ourClientProfile() (*ClientProfile, error) {
+	if err := c.generateInstanceTag(); err != nil {
+		return nil, err
+	}
+
+	if p := c.clientProfile; p != nil && p.InstanceTag == c.ourInstanceTag && p.Expiration.After(c.now()) &&
+		p.PublicKey != nil && p.PublicKey.IsSame(c.ourCurrentKey.PublicKey()) {
+		return p, nil
+	}
+
+	key, err := c.ourEd448Key()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newClientProfile(c.rand(), key, c.ourInstanceTag, []byte("4"), c.now().Add(defaultClientProfileValidity))
+	if err != nil {
+		return nil, err
+	}
+
+	c.clientProfile = p
+	return p, nil
+}
+
+func (c *Conversation) generateDAKEKeys() (err error) {
+	if c.dake.ourProfile, err = c.ourClientProfile(); err != nil {
+		return err
+	}
+
+	if c.dake.ourECDHSecret, err = randomEd448Secret(c.rand()); err != nil {
+		return err
+	}
+	c.dake.ourECDH = ed448BaseMul(c.dake.ourECDHSecret)
+
+	c.dake.ourDHSecret, c.dake.ourDH, err = dh3072KeyPair(c.rand())
+	return err
+}
+
+func (c *Conversation) sendIdentity() (toSend messageWithHeader, err error) {
+	c.dake.wipe()
+	c.dake = nil
+	c.ensureDAKE()
+
+	if err = c.generateDAKEKeys(); err != nil {
+		return nil, err
+	}
+
+	msg := identityMessage{profile: c.dake.ourProfile, y: c.dake.ourECDH, b: c.dake.ourDH}
+	if toSend, err = c.wrapMessageHeader(msgTypeIdentity, msg.serialize()); err != nil {
+		return nil, err
+	}
+
+	c.dake.identityMsg = toSend
+	c.dake.state = dakeStateAwaitingAuthR{}
+	c.dake.lastStateChange = c.now()
+
+	return toSend, nil
+}
+
+// checkTheirDAKEValues validates the client profile and ephemeral keys of the peer
+func (c *Conversation) checkTheirDAKEValues(profile *ClientProfile, ecdh *ed448Point, dh *big.Int) error {
+	if err := profile.Validate(c.theirInstanceTag, c.now()); err != nil {
+		return err
+	}
+
+	if !isValidEd448Point(ecdh) || !isDH3072GroupElement(dh) {
+		return errInvalidOTRMessage
+	}
+
+	c.dake.theirProfile = profile
+	c.dake.theirECDH = ecdh
+	c.dake.theirDH = dh
+
+	return nil
+}
+
+func (c *Conversation) processIdentity(msg []byte) error {
+	var m identityMessage
+	if err := m.deserialize(msg); err != nil {
+		return err
+	}
+
+	return c.checkTheirDAKEValues(m.profile, m.y, m.b)
+}
+
+// phi is the shared session state both parties bind the DAKE to
+func (c *Conversation) phi(weAreBob bool) []byte {
+	if weAreBob {
+		return gotrax.AppendWord(gotrax.AppendWord(nil, c.ourInstanceTag), c.theirInstanceTag)
+	}
+	return gotrax.AppendWord(gotrax.AppendWord(nil, c.theirInstanceTag), c.ourInstanceTag)
+}
+
+// dakeTranscript calculates the message that is signed in the auth-r or auth-i message
+func (c *Conversation) dakeTranscript(weAreBob, authI bool) []byte {
+	d := c.dake
+
+	bob, alice := d.ourProfile, d.theirProfile
+	y, x := d.ourECDH, d.theirECDH
+	b, a := d.ourDH, d.theirDH
+	if !weAreBob {
+		bob, alice = alice, bob
+		y, x = x, y
+		b, a = a, b
+	}
+
+	prefix := byte(0x00)
+	usages := [3]byte{usageAuthRBobClientProfile, usageAuthRAliceClientProfile, usageAuthRPhi}
+	if authI {
+		prefix = byte(0x01)
+		usages = [3]byte{usageAuthIBobClientProfile, usageAuthIAliceClientProfile, usageAuthIPhi}
+	}
+
+	out := []byte{prefix}
+	out = append(out, kdf(usages[0], 64, bob.Serialize())...)
+	out = append(out, kdf(usages[1], 64, alice.Serialize())...)
+	out = append(out, y.encode()...)
+	out = append(out, x.encode()...)
+	out = gotrax.AppendMPI(out, b)
+	out = gotrax.AppendMPI(out, a)
+	return append(out, kdf(usages[2], 64, c.phi(weAreBob))...)
+}
+
+// authRRing is the ring Alice signs the auth-r message with: the forging key of Bob, the long-term key of Alice and
+// the ECDH key of Bob
+func (c *Conversation) authRRing(weAreBob bool) [3]*ed448Point {
+	d := c.dake
+	if weAreBob {
+		return [3]*ed448Point{d.ourProfile.ForgingKey.point, d.theirProfile.PublicKey.point, d.ourECDH}
+	}
+	return [3]*ed448Point{d.theirProfile.ForgingKey.point, d.ourProfile.PublicKey.point, d.theirECDH}
+}
+
+// authIRing is the ring Bob signs the auth-i message with: the long-term key of Bob, the forging key of Alice and
+// the ECDH key of Alice
+func (c *Conversation) authIRing(weAreBob bool) [3]*ed448Point {
+	d := c.dake
+	if weAreBob {
+		return [3]*ed448Point{d.ourProfile.PublicKey.point, d.theirProfile.ForgingKey.point, d.theirECDH}
+	}
+	return [3]*ed448Point{d.theirProfile.PublicKey.point, d.ourProfile.ForgingKey.point, d.ourECDH}
+}
+
+func (c *Conversation) authRMessage() (messageWithHeader, error) {
+	if err := c.generateDAKEKeys(); err != nil {
+		return nil, err
+	}
+
+	key, err := c.ourEd448Key()
+	if err != nil {
+		return nil, err
+	}
+
+	sigma, err := ringSign(c.rand(), key.secret, c.authRRing(false), c.dakeTranscript(false, false))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := authRMessage{profile: c.dake.ourProfile, x: c.dake.ourECDH, a: c.dake.ourDH, sigma: sigma}
+	return c.wrapMessageHeader(msgTypeAuthR, msg.serialize())
+}
+
+func (c *Conversation) processAuthR(msg []byte) error {
+	var m authRMessage
+	if err := m.deserialize(msg); err != nil {
+		return err
+	}
+
+	if err := c.checkTheirDAKEValues(m.profile, m.x, m.a); err != nil {
+		return err
+	}
+
+	if !m.sigma.verify(c.authRRing(true), c.dakeTranscript(true, false)) {
+		return newOtrError("bad signature in auth-r message")
+	}
+
+	return nil
+}
+
+func (c *Conversation) authIMessage() (messageWithHeader, error) {
+	key, err := c.ourEd448Key()
+	if err != nil {
+		return nil, err
+	}
+
+	sigma, err := ringSign(c.rand(), key.secret, c.authIRing(true), c.dakeTranscript(true, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.wrapMessageHeader(msgTypeAuthI, authIMessage{sigma: sigma}.serialize())
+}
+
+func (c *Conversation) processAuthI(msg []byte) error {
+	var m authIMessage
+	if err := m.deserialize(msg); err != nil {
+		return err
+	}
+
+	if !m.sigma.verify(c.authIRing(false), c.dakeTranscript(false, true)) {
+		return newOtrError("bad signature in auth-i message")
+	}
+
+	return nil
+}
+
+// dakeSharedSecret mixes the ECDH and DH shared secrets into the shared secret of the session
+func (c *Conversation) dakeSharedSecret() ([]byte, error) {
+	kECDH := c.dake.theirECDH.mul(c.dake.ourECDHSecret)
+	if kECDH.isIdentity() {
+		return nil, errInvalidOTRMessage
+	}
+
+	kDH := dh3072SharedSecret(c.dake.ourDHSecret, c.dake.theirDH)
+	defer wipeBytes(kDH)
+
+	braceKey := kdf(usageThirdBraceKey, 32, kDH)
+	defer wipeBytes(braceKey)
+
+	return kdf(usageSharedSecret, 64, kECDH.encode(), braceKey), nil
+}
+
+func (c *Conversation) dakeHasFinished(weAreBob bool) error {
+	k, err := c.dakeSharedSecret()
+	if err != nil {
+		return err
+	}
+
+	c.v4.wipe()
+	c.v4 = &otrv4Session{sharedSecret: k, weAreBob: weAreBob, ratchet: newRatchet(k, weAreBob)}
+	copy(c.ssid[:], kdf(usageSSID, len(c.ssid), k))
+	c.setExporterSecret(exporterSecretFrom(k))
+	c.theirKey = c.dake.theirProfile.PublicKey
+	c.theirClientProfile = c.dake.theirProfile
+	c.dake.wipe()
+
+	previousMsgState := c.msgState
+	c.lastMessageStateChange = c.now()
+	c.msgState = encrypted
+	c.resetRefreshState()
+	if previousMsgState != encrypted {
+		defer c.securityEvent(c.recordTheirFingerprint())
+	}
+	defer c.signalSecurityEventIf(previousMsgState == encrypted, StillSecure)
+
+	if c.ourCurrentKey.PublicKey().IsSame(c.theirKey) {
+		c.messageEvent(MessageEventMessageReflected)
+	}
+
+	return nil
+}
+
+func (c *Conversation) processDAKE(msgType byte, msg []byte) (toSend []messageWithHeader, err error) {
+	c.ensureDAKE()
+
+	var toSendSingle messageWithHeader
+
+	switch msgType {
+	case msgTypeIdentity:
+		c.dake.state, toSendSingle, err = c.dake.state.receiveIdentityMessage(c, msg)
+	case msgTypeAuthR:
+		c.dake.state, toSendSingle, err = c.dake.state.receiveAuthRMessage(c, msg)
+	case msgTypeAuthI:
+		c.dake.state, toSendSingle, err = c.dake.state.receiveAuthIMessage(c, msg)
+	default:
+		err = newOtrErrorf("unknown message type 0x%X", msgType)
+	}
+
+	c.dake.lastStateChange = c.now()
+
+	return compactMessagesWithHeader(toSendSingle), err
+}
+
+func (c *Conversation) receiveDAKEMessage(msgType byte, messageBody []byte) (plain MessagePlaintext, toSend []messageWithHeader, err error) {
+	toSend, err = c.potentialAuthError(c.processDAKE(msgType, messageBody))
+	return
+}
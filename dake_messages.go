@@ -0,0 +1,108 @@
+package otr3
+
+import (
+	"math/big"
+
+	"github.com/coyim/gotrax"
+)
+
+const (
+	msgTypeIdentity = byte(0x35)
+	msgTypeAuthR    = byte(0x36)
+	msgTypeAuthI    = byte(0x37)
+)
+
+// identityMessage starts the OTRv4 interactive DAKE. It carries the client profile of the sender and its
+// ephemeral ECDH and DH public keys.
+type identityMessage struct {
+	profile *ClientProfile
+	y       *ed448Point
+	b       *big.Int
+}
+
+// authRMessage answers an identity message with the client profile and ephemeral keys of the receiver, and a ring
+// signature that authenticates them
+type authRMessage struct {
+	profile *ClientProfile
+	x       *ed448Point
+	a       *big.Int
+	sigma   *ringSignature
+}
+
+// authIMessage finishes the DAKE with a ring signature from the sender of the identity message
+type authIMessage struct {
+	sigma *ringSignature
+}
+
+func extractEd448Point(in []byte) ([]byte, *ed448Point, bool) {
+	if len(in) < ed448PointLen {
+		return in, nil, false
+	}
+	p, ok := decodeEd448Point(in[:ed448PointLen])
+	return in[ed448PointLen:], p, ok
+}
+
+func (m identityMessage) serialize() []byte {
+	out := m.profile.Serialize()
+	out = append(out, m.y.encode()...)
+	return gotrax.AppendMPI(out, m.b)
+}
+
+func (m *identityMessage) deserialize(msg []byte) error {
+	m.profile = &ClientProfile{}
+
+	var ok bool
+	if msg, ok = m.profile.Parse(msg); !ok {
+		return newOtrError("corrupt identity message")
+	}
+	if msg, m.y, ok = extractEd448Point(msg); !ok {
+		return newOtrError("corrupt identity message")
+	}
+	if msg, m.b, ok = gotrax.ExtractMPI(msg); !ok || len(msg) != 0 {
+		return newOtrError("corrupt identity message")
+	}
+
+	return nil
+}
+
+func (m authRMessage) serialize() []byte {
+	out := m.profile.Serialize()
+	out = append(out, m.x.encode()...)
+	out = gotrax.AppendMPI(out, m.a)
+	return append(out, m.sigma.serialize()...)
+}
+
+func (m *authRMessage) deserialize(msg []byte) error {
+	m.profile = &ClientProfile{}
+	m.sigma = &ringSignature{}
+
+	var ok bool
+	if msg, ok = m.profile.Parse(msg); !ok {
+		return newOtrError("corrupt auth-r message")
+	}
+	if msg, m.x, ok = extractEd448Point(msg); !ok {
+		return newOtrError("corrupt auth-r message")
+	}
+	if msg, m.a, ok = gotrax.ExtractMPI(msg); !ok {
+		return newOtrError("corrupt auth-r message")
+	}
+	if msg, ok = m.sigma.deserialize(msg); !ok || len(msg) != 0 {
+		return newOtrError("corrupt auth-r message")
+	}
+
+	return nil
+}
+
+func (m authIMessage) serialize() []byte {
+	return m.sigma.serialize()
+}
+
+func (m *authIMessage) deserialize(msg []byte) error {
+	m.sigma = &ringSignature{}
+
+	if rest, ok := m.sigma.deserialize(msg); !ok || len(rest) != 0 {
+		return newOtrError("corrupt auth-i message")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package otr3
+
+import "bytes"
+
+type dakeState interface {
+	receiveIdentityMessage(*Conversation, []byte) (dakeState, messageWithHeader, error)
+	receiveAuthRMessage(*Conversation, []byte) (dakeState, messageWithHeader, error)
+	receiveAuthIMessage(*Conversation, []byte) (dakeState, messageWithHeader, error)
+	identityString() string
+}
+
+type dakeStateNone struct{}
+type dakeStateAwaitingAuthR struct{}
+type dakeStateAwaitingAuthI struct{}
+
+func (s dakeStateNone) receiveIdentityMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	c.dake.wipe()
+
+	if err := c.processIdentity(msg); err != nil {
+		return s, nil, err
+	}
+
+	authRMsg, err := c.authRMessage()
+	if err != nil {
+		return s, nil, err
+	}
+
+	return dakeStateAwaitingAuthI{}, authRMsg, nil
+}
+
+func (s dakeStateAwaitingAuthR) receiveIdentityMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	var m identityMessage
+	if err := m.deserialize(msg); err != nil {
+		return s, nil, err
+	}
+
+	// Both of us sent an identity message at the same time. As with the DH-Commit messages in OTRv3, the one with
+	// the higher ECDH key wins: if that is ours, we ignore theirs and resend ours.
+	if bytes.Compare(c.dake.ourECDH.encode(), m.y.encode()) == 1 {
+		return s, c.dake.identityMsg, nil
+	}
+
+	return dakeStateNone{}.receiveIdentityMessage(c, msg)
+}
+
+func (s dakeStateAwaitingAuthI) receiveIdentityMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	// The peer has started over, so we forget the auth-r message we sent and answer the new identity message
+	return dakeStateNone{}.receiveIdentityMessage(c, msg)
+}
+
+func (s dakeStateNone) receiveAuthRMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	return s, nil, nil
+}
+
+func (s dakeStateAwaitingAuthR) receiveAuthRMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	if err := c.processAuthR(msg); err != nil {
+		return s, nil, err
+	}
+
+	authIMsg, err := c.authIMessage()
+	if err != nil {
+		return s, nil, err
+	}
+
+	return dakeStateNone{}, authIMsg, c.dakeHasFinished(true)
+}
+
+func (s dakeStateAwaitingAuthI) receiveAuthRMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	return s, nil, nil
+}
+
+func (s dakeStateNone) receiveAuthIMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	return s, nil, nil
+}
+
+func (s dakeStateAwaitingAuthR) receiveAuthIMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	return s, nil, nil
+}
+
+func (s dakeStateAwaitingAuthI) receiveAuthIMessage(c *Conversation, msg []byte) (dakeState, messageWithHeader, error) {
+	if err := c.processAuthI(msg); err != nil {
+		return s, nil, err
+	}
+
+	return dakeStateNone{}, nil, c.dakeHasFinished(false)
+}
+
+func (dakeStateNone) identityString() string          { return "DAKESTATE_NONE" }
+func (dakeStateAwaitingAuthR) identityString() string { return "DAKESTATE_WAITING_AUTH_R" }
+func (dakeStateAwaitingAuthI) identityString() string { return "DAKESTATE_WAITING_AUTH_I" }
@@ -0,0 +1,275 @@
+package otr3
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// generateEd448Key is a test utility that creates a new Ed448 key. Tests that need one are skipped when OTRv4 is not
+// available.
+func generateEd448Key(t *testing.T) *Ed448PrivateKey {
+	if !ed448Available {
+		t.Skip("OTRv4 needs Go 1.15 or later")
+	}
+
+	k := &Ed448PrivateKey{}
+	if err := k.Generate(rand.Reader); err != nil {
+		t.Fatalf("Unexpected error when generating key: %v", err)
+	}
+	return k
+}
+
+func otrv4ConversationPair(t *testing.T) (alice, bob *Conversation) {
+	return conversationPair(allowV3|allowV4, nil,
+		[]PrivateKey{alicePrivateKey, generateEd448Key(t)}, []PrivateKey{bobPrivateKey, generateEd448Key(t)})
+}
+
+// runDAKE is a test utility that lets alice start the OTRv4 DAKE with bob and delivers all messages between them until
+// it is done
+func runDAKE(t *testing.T, alice, bob *Conversation) {
+	toBob, err := alice.StartOTRv4()
+	assertNil(t, err)
+	exchangeAll(t, alice, bob, toBob)
+
+	if !alice.IsEncrypted() || !bob.IsEncrypted() {
+		t.Fatalf("Expected the DAKE to finish with both conversations encrypted")
+	}
+}
+
+func Test_DAKE_finishesWithBothSidesEncryptedUsingOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	runDAKE(t, alice, bob)
+
+	assertEquals(t, alice.version, otrV4{})
+	assertEquals(t, bob.version, otrV4{})
+	assertDeepEquals(t, alice.GetSSID(), bob.GetSSID())
+	assertDeepEquals(t, alice.v4.sharedSecret, bob.v4.sharedSecret)
+	assertTrue(t, alice.v4.weAreBob != bob.v4.weAreBob)
+	assertNil(t, alice.dake.ourECDHSecret)
+	assertNil(t, bob.dake.ourECDHSecret)
+}
+
+func Test_DAKE_authenticatesTheLongTermKeysOfBothSides(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	runDAKE(t, alice, bob)
+
+	assertDeepEquals(t, alice.GetTheirKey().Fingerprint(), bob.ourCurrentKey.PublicKey().Fingerprint())
+	assertDeepEquals(t, bob.GetTheirKey().Fingerprint(), alice.ourCurrentKey.PublicKey().Fingerprint())
+	assertEquals(t, len(alice.GetTheirKey().Fingerprint()), ed448FingerprintLen)
+}
+
+func Test_DAKE_signalsGoingSecureOnBothSides(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	toAlice, err := bob.StartOTRv4()
+	assertNil(t, err)
+	toBob := deliverAll(t, alice, toAlice)
+
+	bob.expectSecurityEvent(t, func() {
+		toAlice = deliverAll(t, bob, toBob)
+	}, GoneSecure)
+
+	alice.expectSecurityEvent(t, func() {
+		deliverAll(t, alice, toAlice)
+	}, GoneSecure)
+}
+
+func Test_DAKE_isNotStartedByAQueryMessage(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	runAKE(t, alice, bob)
+
+	assertEquals(t, alice.version, otrV3{})
+	assertEquals(t, bob.version, otrV3{})
+	assertNil(t, alice.v4)
+}
+
+func Test_StartOTRv4_failsWithoutAnEd448Key(t *testing.T) {
+	alice, _ := otrv4ConversationPair(t)
+	alice.SetOurKeys([]PrivateKey{alicePrivateKey})
+
+	_, err := alice.StartOTRv4()
+
+	assertEquals(t, err, errInvalidVersion)
+	assertNil(t, alice.version)
+}
+
+func Test_StartOTRv4_failsAfterCommittingToAnotherVersion(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runAKE(t, alice, bob)
+
+	_, err := alice.StartOTRv4()
+
+	assertEquals(t, err, errInvalidVersion)
+}
+
+func Test_DAKE_isRejectedByAPeerThatDoesntAllowOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	bob.Policies = Policy(allowV3)
+
+	toBob, err := alice.StartOTRv4()
+	assertNil(t, err)
+	_, _, err = bob.Receive(toBob[0])
+
+	assertNotNil(t, err)
+	assertFalse(t, bob.IsEncrypted())
+}
+
+func Test_DAKE_isNotStartedByAWhitespaceTag(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	alice.Policies.add(sendWhitespaceTag)
+	bob.Policies.add(whitespaceStartAKE)
+
+	msg, err := alice.Send(ValidMessage("hello"))
+	assertNil(t, err)
+
+	plain, _, err := bob.Receive(msg[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+	assertEquals(t, bob.version, otrV3{})
+}
+
+func Test_DAKE_resolvesIdentityMessagesSentAtTheSameTime(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	assertNil(t, alice.commitToVersionFrom(1<<4))
+	assertNil(t, bob.commitToVersionFrom(1<<4))
+
+	fromAlice, err := alice.sendIdentity()
+	assertNil(t, err)
+	fromBob, err := bob.sendIdentity()
+	assertNil(t, err)
+
+	toAlice := deliverAll(t, alice, bob.fragEncode(fromBob))
+	toBob := deliverAll(t, bob, alice.fragEncode(fromAlice))
+	for i := 0; i < 3 && len(toBob)+len(toAlice) > 0; i++ {
+		toAlice = deliverAll(t, alice, toBob)
+		toBob = deliverAll(t, bob, toAlice)
+	}
+
+	assertTrue(t, alice.IsEncrypted())
+	assertTrue(t, bob.IsEncrypted())
+	assertDeepEquals(t, alice.GetSSID(), bob.GetSSID())
+}
+
+func Test_DAKE_rejectsAnAuthRMessageWithABadSignature(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	toBob, err := alice.StartOTRv4()
+	assertNil(t, err)
+	toAlice := deliverAll(t, bob, toBob)
+
+	decoded, err := alice.decode(encodedMessage(toAlice[0]))
+	assertNil(t, err)
+	_, body, err := alice.parseMessageHeader(decoded)
+	assertNil(t, err)
+	body[len(body)-1] ^= 0x01
+
+	_, _, err = alice.receiveDAKEMessage(msgTypeAuthR, body)
+	assertNotNil(t, err)
+	assertFalse(t, alice.IsEncrypted())
+	assertEquals(t, alice.dake.state, dakeStateAwaitingAuthR{})
+}
+
+func Test_DAKE_ignoresAuthIMessagesWhenNotWaitingForThem(t *testing.T) {
+	alice, _ := otrv4ConversationPair(t)
+	alice.ensureDAKE()
+
+	s, toSend, err := alice.dake.state.receiveAuthIMessage(alice, []byte{0x01, 0x02})
+	assertEquals(t, s, dakeStateNone{})
+	assertNil(t, toSend)
+	assertNil(t, err)
+}
+
+func Test_DAKE_timesOutLikeTheOTRv3AKE(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	clock := fixtureClock()
+	alice.SetClock(clock)
+	toAlice, err := bob.StartOTRv4()
+	assertNil(t, err)
+	deliverAll(t, alice, toAlice)
+
+	assertTrue(t, alice.akeInProgress())
+	clock.Advance(alice.akeTimeoutInterval())
+	_, err = alice.Tick()
+	assertNil(t, err)
+	assertFalse(t, alice.akeInProgress())
+}
+
+func Test_DAKE_exchangesDataMessagesInBothDirections(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	runDAKE(t, alice, bob)
+
+	for i := 0; i < 4; i++ {
+		msg, err := alice.Send(ValidMessage("hello"))
+		assertNil(t, err)
+		plain, _, err := bob.Receive(msg[0])
+		assertNil(t, err)
+		assertDeepEquals(t, plain, MessagePlaintext("hello"))
+
+		msg, err = bob.Send(ValidMessage("hi"))
+		assertNil(t, err)
+		plain, _, err = alice.Receive(msg[0])
+		assertNil(t, err)
+		assertDeepEquals(t, plain, MessagePlaintext("hi"))
+	}
+}
+
+func Test_DAKE_tellsThePeerAboutTheDisconnect(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	runDAKE(t, alice, bob)
+	msg, err := alice.End()
+	assertNil(t, err)
+
+	bob.expectSecurityEvent(t, func() {
+		_, _, err = bob.Receive(msg[0])
+	}, GoneInsecure)
+	assertNil(t, err)
+	assertEquals(t, bob.msgState, finished)
+}
+
+func Test_DAKE_isWipedWhenTheConversationEnds(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+
+	runDAKE(t, alice, bob)
+	_, err := alice.End()
+	assertNil(t, err)
+
+	assertNil(t, alice.v4)
+	assertNil(t, alice.dake)
+	assertFalse(t, alice.IsEncrypted())
+}
+
+func Test_DAKE_usesTheClientProfileWeWereGiven(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	key := alice.ourKeys[1].(*Ed448PrivateKey)
+	alice.ourInstanceTag = 0x1234
+
+	p, _ := NewClientProfile(rand.Reader, &key.Ed448PublicKey, 0x1234, time.Now().Add(time.Hour))
+	assertNil(t, p.Sign(rand.Reader, key, alicePrivateKey.(*DSAPrivateKey)))
+	alice.SetClientProfile(p)
+
+	runDAKE(t, alice, bob)
+
+	theirs := bob.GetTheirClientProfile()
+	assertDeepEquals(t, theirs.Serialize(), p.Serialize())
+	assertNil(t, theirs.VerifyTransitionalSignature(&alicePrivateKey.(*DSAPrivateKey).DSAPublicKey))
+}
+
+func Test_DAKE_replacesAClientProfileForAnotherInstanceTag(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	key := alice.ourKeys[1].(*Ed448PrivateKey)
+	alice.ourInstanceTag = 0x1234
+
+	p, _ := newClientProfile(rand.Reader, key, 0x4321, []byte("4"), time.Now().Add(time.Hour))
+	alice.SetClientProfile(p)
+
+	runDAKE(t, alice, bob)
+
+	assertEquals(t, bob.GetTheirClientProfile().InstanceTag, uint32(0x1234))
+	assertNil(t, bob.GetTheirClientProfile().DSAKey)
+}
@@ -56,6 +56,7 @@ func (c *Conversation) genDataMsgWithFlag(message []byte, flag byte, tlvs ...tlv
 
 	c.updateMayRetransmitTo(noRetransmit)
 	c.lastMessage(message)
+	c.countMessageForKeyRotation()
 
 	x := dataMessageExtra{keys.extraKey[:]}
 
@@ -69,8 +70,16 @@ func extractDataMessageFlag(msg []byte) byte {
 	return msg[0]
 }
 
-func (c *Conversation) createSerializedDataMessage(msg []byte, flag byte, tlvs []tlv) ([]ValidMessage, dataMessageExtra, error) {
-	dataMsg, x, err := c.genDataMsgWithFlag(msg, flag, tlvs...)
+// genDataMsgWithHeader creates a data message for the protocol version of the conversation, serialized and with the
+// message header
+func (c *Conversation) genDataMsgWithHeader(message []byte, flag byte, tlvs ...tlv) (messageWithHeader, dataMessageExtra, error) {
+	tlvs = append(tlvs, c.receiptRequestTLVs(message)...)
+
+	if c.v4 != nil {
+		return c.genRatchetDataMsg(message, flag, tlvs...)
+	}
+
+	dataMsg, x, err := c.genDataMsgWithFlag(message, flag, tlvs...)
 	if err != nil {
 		return nil, dataMessageExtra{}, err
 	}
@@ -80,6 +89,41 @@ func (c *Conversation) createSerializedDataMessage(msg []byte, flag byte, tlvs [
 		return nil, dataMessageExtra{}, err
 	}
 
+	return res, x, nil
+}
+
+func (c *Conversation) genRatchetDataMsg(message []byte, flag byte, tlvs ...tlv) (messageWithHeader, dataMessageExtra, error) {
+	if c.msgState != encrypted {
+		return nil, dataMessageExtra{}, errCannotSendUnencrypted
+	}
+
+	header, err := c.messageHeader(msgTypeData)
+	if err != nil {
+		return nil, dataMessageExtra{}, err
+	}
+
+	plain := plainDataMsg{
+		message: message,
+		tlvs:    tlvs,
+	}
+
+	dataMessage, extraKey, err := c.v4.ratchet.encrypt(c.rand(), header, flag, plain)
+	if err != nil {
+		return nil, dataMessageExtra{}, err
+	}
+
+	c.updateMayRetransmitTo(noRetransmit)
+	c.lastMessage(message)
+
+	return append(header, dataMessage.serialize()...), dataMessageExtra{extraKey}, nil
+}
+
+func (c *Conversation) createSerializedDataMessage(msg []byte, flag byte, tlvs []tlv) ([]ValidMessage, dataMessageExtra, error) {
+	res, x, err := c.genDataMsgWithHeader(msg, flag, tlvs...)
+	if err != nil {
+		return nil, dataMessageExtra{}, err
+	}
+
 	c.updateLastSent()
 	return c.fragEncode(res), x, nil
 }
@@ -93,7 +137,9 @@ func (c *Conversation) encode(msg messageWithHeader) encodedMessage {
 }
 
 func (c *Conversation) processDataMessage(header, msg []byte) (plain MessagePlaintext, toSend messageWithHeader, err error) {
-	ignoreUnreadable := (extractDataMessageFlag(msg) & messageFlagIgnoreUnreadable) == messageFlagIgnoreUnreadable
+	flag := extractDataMessageFlag(msg)
+	c.detailDataMessageFlag(flag)
+	ignoreUnreadable := (flag & messageFlagIgnoreUnreadable) == messageFlagIgnoreUnreadable
 	plain, toSend, err = c.processDataMessageWithRawErrors(header, msg)
 	if err != nil && ignoreUnreadable {
 		err = nil
@@ -105,14 +151,49 @@ func (c *Conversation) processDataMessage(header, msg []byte) (plain MessagePlai
 // and a data message (with header) generated in response to any TLV contained in the incoming message.
 // The header and message compose the decoded incoming message.
 func (c *Conversation) processDataMessageWithRawErrors(header, msg []byte) (plain MessagePlaintext, toSend messageWithHeader, err error) {
-	dataMessage := dataMsg{}
-
 	if c.msgState != encrypted {
 		err = errMessageNotInPrivate
 		c.messageEvent(MessageEventReceivedMessageNotInPrivate)
 		return
 	}
 
+	var p plainDataMsg
+	var x dataMessageExtra
+	if c.v4 != nil {
+		p, x, err = c.decryptRatchetDataMessage(header, msg)
+	} else {
+		p, x, err = c.decryptDataMessage(header, msg)
+	}
+	if err != nil {
+		return
+	}
+	c.detailDecryptedDataMessage(p)
+
+	plain = makeCopy(p.message)
+	if len(plain) == 0 {
+		plain = nil
+		c.messageEvent(MessageEventLogHeartbeatReceived)
+	} else {
+		c.countMessageForRefresh()
+	}
+
+	var tlvs []tlv
+
+	tlvs, err = c.processTLVs(p.tlvs, x)
+	if err != nil {
+		return
+	}
+
+	if len(tlvs) > 0 {
+		toSend, _, err = c.genDataMsgWithHeader(nil, decideFlagFrom(tlvs), tlvs...)
+	}
+
+	return
+}
+
+// decryptDataMessage checks and decrypts an OTRv2 or OTRv3 data message, and rotates the keys afterwards
+func (c *Conversation) decryptDataMessage(header, msg []byte) (p plainDataMsg, x dataMessageExtra, err error) {
+	dataMessage := dataMsg{}
 	if err = dataMessage.deserialize(msg, c.version); err != nil {
 		return
 	}
@@ -130,48 +211,36 @@ func (c *Conversation) processDataMessageWithRawErrors(header, msg []byte) (plai
 		return
 	}
 
-	p := plainDataMsg{}
 	//this can't return an error since receivingAESKey is a AES-128 key
 	p.decrypt(sessionKeys.receivingAESKey[:], dataMessage.topHalfCtr, dataMessage.encryptedMsg)
 
-	plain = makeCopy(p.message)
-	if len(plain) == 0 {
-		plain = nil
-		c.messageEvent(MessageEventLogHeartbeatReceived)
-	}
-
-	err = c.rotateKeys(dataMessage)
-	if err != nil {
+	if err = c.rotateKeys(dataMessage); err != nil {
 		return
 	}
+	c.detailKeyIDs(dataMessage.senderKeyID, dataMessage.recipientKeyID)
 
-	var tlvs []tlv
+	return p, dataMessageExtra{sessionKeys.extraKey}, nil
+}
 
-	tlvs, err = c.processTLVs(p.tlvs, dataMessageExtra{sessionKeys.extraKey})
-	if err != nil {
+// decryptRatchetDataMessage checks and decrypts an OTRv4 data message with the double ratchet
+func (c *Conversation) decryptRatchetDataMessage(header, msg []byte) (p plainDataMsg, x dataMessageExtra, err error) {
+	dataMessage := ratchetDataMsg{}
+	if err = dataMessage.deserialize(msg); err != nil {
 		return
 	}
 
-	if len(tlvs) > 0 {
-		var reply dataMsg
-		reply, _, err = c.genDataMsgWithFlag(nil, decideFlagFrom(tlvs), tlvs...)
-		if err != nil {
-			return
-		}
-
-		toSend, err = c.wrapMessageHeader(msgTypeData, reply.serialize(c.version))
-		if err != nil {
-			return
-		}
+	p, extraKey, err := c.v4.ratchet.decrypt(header, &dataMessage)
+	if err != nil {
+		return
 	}
 
-	return
+	return p, dataMessageExtra{extraKey}, nil
 }
 
 func decideFlagFrom(tlvs []tlv) byte {
 	flag := byte(0x00)
 	for _, t := range tlvs {
-		if t.tlvType >= tlvTypeSMP1 && t.tlvType <= tlvTypeSMP1WithQuestion {
+		if t.tlvType >= tlvTypeSMP1 && t.tlvType <= tlvTypeSMP1WithQuestion || t.tlvType == tlvTypeReceipt {
 			flag = messageFlagIgnoreUnreadable
 		}
 
@@ -181,6 +250,7 @@ func decideFlagFrom(tlvs []tlv) byte {
 
 func (c *Conversation) processSMPTLV(t tlv, x dataMessageExtra) (toSend *tlv, err error) {
 	c.smp.ensureSMP()
+	c.smp.lastProgress = c.now()
 
 	smpMessage, ok := t.smpMessage()
 	if !ok {
@@ -194,7 +264,7 @@ func (c *Conversation) processTLVs(tlvs []tlv, x dataMessageExtra) ([]tlv, error
 	var retTLVs []tlv
 
 	for _, t := range tlvs {
-		mh, e := messageHandlerForTLV(t)
+		mh, e := c.messageHandlerForTLV(t)
 		if e != nil {
 			continue
 		}
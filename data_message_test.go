@@ -104,7 +104,7 @@ func Test_genDataMsg_setsLastMessageWhenNewMessageIsPlaintext(t *testing.T) {
 
 	assertDeepEquals(t, c.resend.pending(),
 		[]messageToResend{
-			messageToResend{MessagePlaintext(msg), nil},
+			messageToResend{m: MessagePlaintext(msg), sent: true},
 		})
 }
 
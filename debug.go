@@ -42,7 +42,7 @@ func (c *Conversation) dump(w *bufio.Writer) {
 	w.WriteString(fmt.Sprintf("  Msgstate: %d (%s)\n\n", c.msgState, c.msgState.identityString()))
 	w.WriteString(fmt.Sprintf("  Protocol version: %d\n", c.version.protocolVersion()))
 	w.WriteString(fmt.Sprintf("  OTR offer: %s\n\n", c.otrOffer()))
-	if c.ake == nil {
+	if c.ake == nil && c.dake == nil {
 		w.WriteString("  Auth info: NULL\n")
 	} else {
 		c.dumpAKE(w)
@@ -62,6 +62,10 @@ func (c *Conversation) dumpAKE(w *bufio.Writer) {
 		w.WriteString(fmt.Sprintf("    State: %d (%s)\n", c.ake.state.identity(), c.ake.state.identityString()))
 	}
 
+	if c.dake != nil && c.dake.state != nil {
+		w.WriteString(fmt.Sprintf("    DAKE state: %s\n", c.dake.state.identityString()))
+	}
+
 	w.WriteString(fmt.Sprintf("    Our keyid:   %d\n", c.keys.ourKeyID))
 	w.WriteString(fmt.Sprintf("    Their keyid: %d\n", c.keys.theirKeyID))
 	w.WriteString(fmt.Sprintf("    Their fingerprint: %X\n", c.theirKey.Fingerprint()))
@@ -0,0 +1,83 @@
+package otr3
+
+// MessageID identifies a message the user sent with SendWithID. The IDs are unique within a conversation.
+type MessageID uint64
+
+// DeliveryEvent tells what happened to a message the user sent
+type DeliveryEvent int
+
+const (
+	// DeliveryQueued is signalled when a message is kept until a secure conversation has been established
+	DeliveryQueued DeliveryEvent = iota
+	// DeliverySent is signalled when a message has been encrypted and sent, either right away or after the AKE finished
+	DeliverySent
+	// DeliverySentInPlaintext is signalled when a message has been sent without encryption, since our policy allows it
+	DeliverySentInPlaintext
+	// DeliveryResent is signalled when a message has been sent again, since the peer couldn't read it
+	DeliveryResent
+	// DeliveryDropped is signalled when a message will never be sent, for example since the secure conversation has
+	// finished, or since it took too long to establish one
+	DeliveryDropped
+	// DeliveryEncryptionFailed is signalled when a message couldn't be encrypted and was not sent
+	DeliveryEncryptionFailed
+	// DeliveryAcknowledged is signalled when the peer has sent a receipt for a message, which means it could decrypt
+	// it. Receipts are only requested and sent with PolicyRequestReceipts.
+	DeliveryAcknowledged
+)
+
+// DeliveryEventHandler handles DeliveryEvents
+type DeliveryEventHandler interface {
+	// HandleDeliveryEvent is called when something happened to the message with the given ID. The error is only set
+	// for DeliveryEncryptionFailed.
+	HandleDeliveryEvent(id MessageID, event DeliveryEvent, err error)
+}
+
+type dynamicDeliveryEventHandler struct {
+	eh func(id MessageID, event DeliveryEvent, err error)
+}
+
+func (d dynamicDeliveryEventHandler) HandleDeliveryEvent(id MessageID, event DeliveryEvent, err error) {
+	d.eh(id, event, err)
+}
+
+// SetDeliveryEventHandler assigns handler for DeliveryEvent
+func (c *Conversation) SetDeliveryEventHandler(handler DeliveryEventHandler) {
+	c.deliveryEventHandler = handler
+}
+
+func (c *Conversation) deliveryEvent(id MessageID, e DeliveryEvent) {
+	c.deliveryEventWithError(id, e, nil)
+}
+
+func (c *Conversation) deliveryEventWithError(id MessageID, e DeliveryEvent, err error) {
+	if id != 0 && c.deliveryEventHandler != nil {
+		c.deliveryEventHandler.HandleDeliveryEvent(id, e, err)
+	}
+}
+
+func (c *Conversation) nextMessageID() MessageID {
+	c.lastMessageID++
+	return c.lastMessageID
+}
+
+// String returns the string representation of the DeliveryEvent
+func (s DeliveryEvent) String() string {
+	switch s {
+	case DeliveryQueued:
+		return "DeliveryQueued"
+	case DeliverySent:
+		return "DeliverySent"
+	case DeliverySentInPlaintext:
+		return "DeliverySentInPlaintext"
+	case DeliveryResent:
+		return "DeliveryResent"
+	case DeliveryDropped:
+		return "DeliveryDropped"
+	case DeliveryEncryptionFailed:
+		return "DeliveryEncryptionFailed"
+	case DeliveryAcknowledged:
+		return "DeliveryAcknowledged"
+	default:
+		return "DELIVERY EVENT: (THIS SHOULD NEVER HAPPEN)"
+	}
+}
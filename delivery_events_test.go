@@ -0,0 +1,139 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+type deliveryEventRecord struct {
+	id    MessageID
+	event DeliveryEvent
+}
+
+func (c *Conversation) recordDeliveryEvents() *[]deliveryEventRecord {
+	var events []deliveryEventRecord
+	c.SetDeliveryEventHandler(dynamicDeliveryEventHandler{func(id MessageID, event DeliveryEvent, err error) {
+		events = append(events, deliveryEventRecord{id, event})
+	}})
+	return &events
+}
+
+func Test_DeliveryEvent_hasValidStringImplementation(t *testing.T) {
+	assertEquals(t, DeliveryQueued.String(), "DeliveryQueued")
+	assertEquals(t, DeliveryEncryptionFailed.String(), "DeliveryEncryptionFailed")
+	assertEquals(t, DeliveryEvent(20000).String(), "DELIVERY EVENT: (THIS SHOULD NEVER HAPPEN)")
+}
+
+func Test_SendWithID_returnsANewIDForEveryMessage(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+
+	id1, _, _ := alice.SendWithID(ValidMessage("one"))
+	id2, _, _ := alice.SendWithID(ValidMessage("two"))
+
+	assertEquals(t, id1, MessageID(1))
+	assertEquals(t, id2, MessageID(2))
+	assertEquals(t, alice.currentMessageID, MessageID(0))
+}
+
+func Test_SendWithID_signalsThatAnEncryptedMessageWasSent(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	events := alice.recordDeliveryEvents()
+
+	id, _, err := alice.SendWithID(ValidMessage("hello"))
+
+	assertNil(t, err)
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliverySent}})
+}
+
+func Test_SendWithID_signalsThatAMessageWasSentInPlaintext(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3)}
+	events := c.recordDeliveryEvents()
+
+	id, _, _ := c.SendWithID(ValidMessage("hello"))
+
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliverySentInPlaintext}})
+}
+
+func Test_SendWithID_signalsQueuedMessagesAndSendsThemAfterTheAKE(t *testing.T) {
+	alice, bob := conversationPairWithClock(fixtureClock(), allowV3|requireEncryption)
+	events := alice.recordDeliveryEvents()
+
+	id1, toBob, _ := alice.SendWithID(ValidMessage("one"))
+	id2, _, _ := alice.SendWithID(ValidMessage("two"))
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id1, DeliveryQueued}, {id2, DeliveryQueued}})
+
+	toAlice := deliverAll(t, bob, toBob)
+	for len(toAlice) > 0 {
+		toAlice = deliverAll(t, bob, deliverAll(t, alice, toAlice))
+	}
+
+	assertTrue(t, alice.IsEncrypted())
+	assertDeepEquals(t, (*events)[2:], []deliveryEventRecord{{id1, DeliverySent}, {id2, DeliverySent}})
+}
+
+func Test_SendWithID_signalsDroppedMessagesWhenTheResendIntervalHasPassed(t *testing.T) {
+	clock := fixtureClock()
+	alice, _ := conversationPairWithClock(clock, allowV3|requireEncryption)
+	events := alice.recordDeliveryEvents()
+
+	id, _, _ := alice.SendWithID(ValidMessage("hello"))
+	clock.Advance(defaultResendInterval + time.Second)
+	alice.Tick()
+
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliveryQueued}, {id, DeliveryDropped}})
+	assertEquals(t, len(alice.resend.pending()), 0)
+}
+
+func Test_SendWithID_doesntSignalSentMessagesAsDropped(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	alice.SendWithID(ValidMessage("hello"))
+	events := alice.recordDeliveryEvents()
+
+	alice.dropQueuedMessages()
+
+	assertNil(t, *events)
+}
+
+func Test_SendWithID_signalsResentMessages(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	events := alice.recordDeliveryEvents()
+
+	id, _, _ := alice.SendWithID(ValidMessage("hello"))
+	alice.Receive(ValidMessage("?OTR Error: couldn't read that"))
+
+	// The peer has lost the conversation and starts over
+	restarted := &Conversation{Rand: bob.Rand, Policies: bob.Policies, ourInstanceTag: bob.ourInstanceTag}
+	restarted.SetOurKeys([]PrivateKey{bobPrivateKey})
+	runAKE(t, alice, restarted)
+
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliverySent}, {id, DeliveryResent}})
+}
+
+func Test_SendWithID_signalsDroppedMessagesWhenTheConversationHasFinished(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	msg, _ := alice.End()
+	deliverAll(t, bob, msg)
+	events := bob.recordDeliveryEvents()
+
+	id, _, err := bob.SendWithID(ValidMessage("hello"))
+
+	assertNotNil(t, err)
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliveryDropped}})
+}
+
+func Test_SendWithID_signalsWhenTheEncryptionFailed(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runDAKE(t, bob, alice)
+	bob.Rand = fixedRand([]string{})
+
+	var failure error
+	bob.SetDeliveryEventHandler(dynamicDeliveryEventHandler{func(id MessageID, event DeliveryEvent, err error) {
+		assertEquals(t, event, DeliveryEncryptionFailed)
+		failure = err
+	}})
+
+	_, _, err := bob.SendWithID(ValidMessage("hello"))
+
+	assertNotNil(t, err)
+	assertEquals(t, failure, err)
+}
@@ -0,0 +1,62 @@
+package otr3
+
+import (
+	"io"
+	"math/big"
+)
+
+// OTRv4 uses the 3072 bit Diffie-Hellman group from RFC 3526 in addition to Ed448, so that the shared secrets are
+// protected by a second, unrelated hardness assumption.
+var (
+	dh3072P         *big.Int
+	dh3072Q         *big.Int // the order of the subgroup generated by dh3072G, (p-1)/2
+	dh3072G         *big.Int
+	dh3072PMinusTwo *big.Int
+)
+
+const (
+	dh3072Len       = 384
+	dh3072SecretLen = 80
+)
+
+func init() {
+	dh3072P, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+			"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05"+
+			"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB"+
+			"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+			"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718"+
+			"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33"+
+			"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7"+
+			"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864"+
+			"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2"+
+			"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF", 16)
+
+	dh3072Q = new(big.Int).Rsh(dh3072P, 1)
+	dh3072G = big.NewInt(2)
+	dh3072PMinusTwo = sub(dh3072P, big.NewInt(2))
+}
+
+// dh3072KeyPair generates a new secret exponent and its public value
+func dh3072KeyPair(r io.Reader) (secret, public *big.Int, err error) {
+	if secret, err = randSizedMPI(r, dh3072SecretLen); err != nil {
+		return nil, nil, err
+	}
+	return secret, new(big.Int).Exp(dh3072G, secret, dh3072P), nil
+}
+
+// isDH3072GroupElement returns true if the value is in the subgroup generated by the generator,
+// and is not one of the trivial values
+func isDH3072GroupElement(n *big.Int) bool {
+	return n != nil && gte(n, dh3072G) && lte(n, dh3072PMinusTwo) &&
+		eq(new(big.Int).Exp(n, dh3072Q, dh3072P), big.NewInt(1))
+}
+
+// dh3072SharedSecret returns the shared secret as a fixed size big-endian value
+func dh3072SharedSecret(secret, theirPublic *big.Int) []byte {
+	s := new(big.Int).Exp(theirPublic, secret, dh3072P)
+	defer wipeBigInt(s)
+	return paddedBytes(s, dh3072Len)
+}
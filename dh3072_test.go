@@ -0,0 +1,32 @@
+package otr3
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func Test_dh3072KeyPair_agreesOnASharedSecret(t *testing.T) {
+	x, gx, err := dh3072KeyPair(rand.Reader)
+	assertNil(t, err)
+	y, gy, err := dh3072KeyPair(rand.Reader)
+	assertNil(t, err)
+
+	assertTrue(t, isDH3072GroupElement(gx))
+	assertTrue(t, isDH3072GroupElement(gy))
+
+	s1 := dh3072SharedSecret(x, gy)
+	assertEquals(t, len(s1), dh3072Len)
+	assertDeepEquals(t, s1, dh3072SharedSecret(y, gx))
+}
+
+func Test_isDH3072GroupElement_rejectsTrivialAndOutOfGroupValues(t *testing.T) {
+	assertFalse(t, isDH3072GroupElement(nil))
+	assertFalse(t, isDH3072GroupElement(big.NewInt(1)))
+	assertFalse(t, isDH3072GroupElement(sub(dh3072P, big.NewInt(1))))
+	assertFalse(t, isDH3072GroupElement(dh3072P))
+
+	// -2 is not a quadratic residue modulo p, so it is outside of the subgroup generated by 2
+	assertFalse(t, isDH3072GroupElement(sub(dh3072P, big.NewInt(2))))
+	assertTrue(t, isDH3072GroupElement(big.NewInt(4)))
+}
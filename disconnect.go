@@ -9,7 +9,12 @@ func (c *Conversation) processDisconnectedTLV(t tlv, x dataMessageExtra) (toSend
 	c.lastMessageStateChange = time.Time{}
 	c.msgState = finished
 	c.smp.wipe()
+	c.setExporterSecret(nil)
 	c.ake = nil
+	c.dake.wipe()
+	c.dake = nil
+	c.v4.wipe()
+	c.v4 = nil
 
 	c.keys = keyManagementContext{}
 
@@ -39,4 +39,10 @@
 //  // Use Authenticate to start a SMP process
 //  toSend, err := c.StartAuthenticate("My pet's name?",[]byte{"Gopher"})
 //  toSend, err := c.ProvideAuthenticationSecret([]byte{"Gopher"})
+//
+// OTRv4
+//
+// The OTRv4 of this library is private - it doesn't follow the wire format of the OTRv4 specification, and can only
+// be used with peers that use this library too. It is never advertised in query messages or whitespace tags, and only
+// starts when both sides allow it with PolicyAllowV4, have an Ed448 key, and one of them calls StartOTRv4.
 package otr3
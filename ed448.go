@@ -0,0 +1,91 @@
+package otr3
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// OTRv4 uses the Ed448-Goldilocks curve from RFC 8032 for long-term keys, ephemeral ECDH keys and ring signatures.
+// The curve arithmetic lives in ed448_circl.go, since it needs Go 1.15. With older versions of Go, OTRv4 is not
+// available at all - see ed448_unavailable.go.
+
+const (
+	ed448PointLen  = 57
+	ed448ScalarLen = 57
+)
+
+// isValidEd448Point returns true if the point is in the prime order subgroup and is not the identity,
+// which is what OTRv4 requires of all points received from the peer
+func isValidEd448Point(p *ed448Point) bool {
+	return p != nil && !p.isIdentity() && p.timesOrder().isIdentity()
+}
+
+// encodeEd448Scalar returns the 57 byte little-endian encoding OTRv4 uses for scalars
+func encodeEd448Scalar(s *ed448Scalar) []byte {
+	out := make([]byte, ed448ScalarLen)
+	copy(out, s[:])
+	return out
+}
+
+// decodeEd448Scalar returns not ok for scalars that are not reduced modulo q
+func decodeEd448Scalar(in []byte) (*ed448Scalar, bool) {
+	if len(in) != ed448ScalarLen || in[ed448ScalarLen-1] != 0 {
+		return nil, false
+	}
+
+	s := reduceEd448Scalar(in)
+	return s, bytes.Equal(s[:], in[:ed448ScalarLen-1])
+}
+
+func wipeEd448Scalar(s *ed448Scalar) {
+	if s != nil {
+		*s = ed448Scalar{}
+	}
+}
+
+func copyEd448Scalar(s *ed448Scalar) *ed448Scalar {
+	if s == nil {
+		return nil
+	}
+	res := *s
+	return &res
+}
+
+// ed448SecretFromSymmetric turns 57 bytes of secret data into a secret scalar, as described in RFC 8032, section 5.2.5
+func ed448SecretFromSymmetric(sym []byte) *ed448Scalar {
+	h := make([]byte, 2*ed448ScalarLen)
+	defer wipeBytes(h)
+	sha3.ShakeSum256(h, sym)
+
+	h[0] &= 0xFC
+	h[ed448ScalarLen-1] = 0
+	h[ed448ScalarLen-2] |= 0x80
+
+	return reduceEd448Scalar(h[:ed448ScalarLen])
+}
+
+// randomEd448Secret generates a new secret scalar, the same way as a new key pair is generated
+func randomEd448Secret(r io.Reader) (*ed448Scalar, error) {
+	sym := make([]byte, ed448ScalarLen)
+	defer wipeBytes(sym)
+
+	if err := randomInto(r, sym); err != nil {
+		return nil, err
+	}
+
+	return ed448SecretFromSymmetric(sym), nil
+}
+
+// randomEd448Scalar generates a uniformly random scalar modulo q
+func randomEd448Scalar(r io.Reader) (*ed448Scalar, error) {
+	buf := make([]byte, 2*ed448ScalarLen)
+	defer wipeBytes(buf)
+
+	if err := randomInto(r, buf); err != nil {
+		return nil, err
+	}
+
+	return reduceEd448Scalar(buf), nil
+}
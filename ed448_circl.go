@@ -0,0 +1,121 @@
+//go:build go1.15
+// +build go1.15
+
+package otr3
+
+import (
+	"github.com/cloudflare/circl/ecc/goldilocks"
+	"github.com/cloudflare/circl/sign/ed448"
+)
+
+// The curve arithmetic and the signatures of Ed448 come from circl, which does all operations on secret scalars in
+// constant time. circl needs Go 1.15 or later.
+
+// ed448Available is true when OTRv4 can be used
+const ed448Available = true
+
+var ed448Curve goldilocks.Curve
+
+// ed448Scalar is a scalar modulo the order of the prime order subgroup. All operations on it run in constant time.
+type ed448Scalar = goldilocks.Scalar
+
+var (
+	ed448G = &ed448Point{p: *ed448Curve.Generator()}
+	ed448Q = ed448Curve.Order()
+)
+
+// ed448Point is a point on Ed448. Points are never modified once created.
+type ed448Point struct {
+	p goldilocks.Point
+}
+
+func ed448Identity() *ed448Point {
+	return &ed448Point{p: *ed448Curve.Identity()}
+}
+
+func (p *ed448Point) add(o *ed448Point) *ed448Point {
+	return &ed448Point{p: *ed448Curve.Add(&p.p, &o.p)}
+}
+
+func (p *ed448Point) double() *ed448Point {
+	return &ed448Point{p: *ed448Curve.Double(&p.p)}
+}
+
+func (p *ed448Point) neg() *ed448Point {
+	res := &ed448Point{p: p.p}
+	res.p.Neg()
+	return res
+}
+
+// mul returns k*p in constant time. The point has to be in the prime order subgroup, which all points we accept from
+// the peer are.
+func (p *ed448Point) mul(k *ed448Scalar) *ed448Point {
+	return &ed448Point{p: *ed448Curve.ScalarMult(k, &p.p)}
+}
+
+func ed448BaseMul(k *ed448Scalar) *ed448Point {
+	return &ed448Point{p: *ed448Curve.ScalarBaseMult(k)}
+}
+
+func (p *ed448Point) equals(o *ed448Point) bool {
+	return p.p.IsEqual(&o.p)
+}
+
+func (p *ed448Point) isIdentity() bool {
+	return p.p.IsIdentity()
+}
+
+// encode returns the 57 byte encoding of the point from RFC 8032, section 5.2.2
+func (p *ed448Point) encode() []byte {
+	// ToBytes normalizes the point it is called on
+	q := p.p
+	out := make([]byte, ed448PointLen)
+	_ = q.ToBytes(out)
+	return out
+}
+
+// decodeEd448Point decodes a point as described in RFC 8032, section 5.2.3. It returns not ok if the encoding is
+// invalid or not on the curve.
+func decodeEd448Point(in []byte) (*ed448Point, bool) {
+	if len(in) != ed448PointLen || in[ed448PointLen-1]&0x7F != 0 {
+		return nil, false
+	}
+
+	p, err := goldilocks.FromBytes(in)
+	if err != nil {
+		return nil, false
+	}
+	return &ed448Point{p: *p}, true
+}
+
+// timesOrder returns q*p. It can't use mul, which only works for points in the prime order subgroup, so it uses
+// double-and-add instead. That doesn't run in constant time, but both q and the points we check are public.
+func (p *ed448Point) timesOrder() *ed448Point {
+	res := ed448Curve.Identity()
+	for i := 8*len(ed448Q) - 1; i >= 0; i-- {
+		res.Double()
+		if (ed448Q[i/8]>>uint(i%8))&1 == 1 {
+			res.Add(&p.p)
+		}
+	}
+	return &ed448Point{p: *res}
+}
+
+func reduceEd448Scalar(in []byte) *ed448Scalar {
+	s := &ed448Scalar{}
+	s.FromBytes(in)
+	return s
+}
+
+// ed448SigningKey returns the key circl signs with for the given secret
+func ed448SigningKey(sym []byte) []byte {
+	return ed448.NewKeyFromSeed(sym)
+}
+
+func ed448Sign(key, message []byte) []byte {
+	return ed448.Sign(key, message, "")
+}
+
+func ed448Verify(pub, message, sig []byte) bool {
+	return ed448.Verify(pub, message, sig, "")
+}
@@ -0,0 +1,151 @@
+package otr3
+
+import (
+	"io"
+
+	"github.com/coyim/gotrax"
+)
+
+var ed448KeyTypeValue = uint16(0x0010)
+
+const (
+	ed448SignatureLen   = ed448PointLen + ed448ScalarLen
+	ed448FingerprintLen = 56
+)
+
+// Ed448PublicKey is an Ed448 public key, used as the long-term key for OTRv4
+type Ed448PublicKey struct {
+	point *ed448Point
+}
+
+// Ed448PrivateKey is an Ed448 private key, used as the long-term key for OTRv4
+type Ed448PrivateKey struct {
+	Ed448PublicKey
+
+	// sym is the secret the key is derived from, as described in RFC 8032
+	sym []byte
+	// signing is the key in the form circl uses for signatures
+	signing []byte
+	// secret is the secret scalar, which the ring signatures of the DAKE need
+	secret *ed448Scalar
+}
+
+// IsAvailableForVersion returns true if this key is possible to use with the given version
+func (pub *Ed448PublicKey) IsAvailableForVersion(v uint16) bool {
+	return v == 4
+}
+
+// IsSame returns true if the given public key is an Ed448 public key that is equal to this key
+func (pub *Ed448PublicKey) IsSame(other PublicKey) bool {
+	oth, ok := other.(*Ed448PublicKey)
+	return ok && pub.point != nil && oth.point != nil && pub.point.equals(oth.point)
+}
+
+// Parse takes the given data and tries to parse it into the PublicKey receiver. It will return not ok if the data is
+// malformed, not for an Ed448 key or not a valid point
+func (pub *Ed448PublicKey) Parse(in []byte) (index []byte, ok bool) {
+	var typeTag uint16
+	if index, typeTag, ok = gotrax.ExtractShort(in); !ok || typeTag != ed448KeyTypeValue || len(index) < ed448PointLen {
+		return in, false
+	}
+
+	p, ok := decodeEd448Point(index[:ed448PointLen])
+	if !ok || !isValidEd448Point(p) {
+		return in, false
+	}
+
+	pub.point = p
+	return index[ed448PointLen:], true
+}
+
+func (pub *Ed448PublicKey) serialize() []byte {
+	if pub.point == nil {
+		return nil
+	}
+	return append(gotrax.AppendShort(nil, ed448KeyTypeValue), pub.point.encode()...)
+}
+
+// Fingerprint returns the OTRv4 fingerprint of the key, which is 56 bytes long
+func (pub *Ed448PublicKey) Fingerprint() []byte {
+	if pub.point == nil {
+		return nil
+	}
+	return kdf(usageFingerprint, ed448FingerprintLen, pub.point.encode())
+}
+
+// Verify will verify an Ed448 signature of the message. Ed448 signs the full message, so it shouldn't be hashed first.
+func (pub *Ed448PublicKey) Verify(message, sig []byte) (nextPoint []byte, sigOk bool) {
+	if len(sig) < ed448SignatureLen || pub.point == nil {
+		return nil, false
+	}
+
+	return sig[ed448SignatureLen:], ed448Verify(pub.point.encode(), message, sig[:ed448SignatureLen])
+}
+
+// Parse will parse a Private Key from the given data. The private key is serialized as the key type followed by the
+// 57 byte secret it is derived from. Without Go 1.15, it always returns not ok, since OTRv4 is not available then.
+func (priv *Ed448PrivateKey) Parse(in []byte) (index []byte, ok bool) {
+	if !ed448Available {
+		return in, false
+	}
+
+	var typeTag uint16
+	if index, typeTag, ok = gotrax.ExtractShort(in); !ok || typeTag != ed448KeyTypeValue || len(index) < ed448ScalarLen {
+		return in, false
+	}
+
+	priv.setSymmetric(index[:ed448ScalarLen])
+	return index[ed448ScalarLen:], true
+}
+
+// Serialize will return the serialization of the private key to a byte array
+func (priv *Ed448PrivateKey) Serialize() []byte {
+	if priv.sym == nil {
+		return nil
+	}
+	return append(gotrax.AppendShort(nil, ed448KeyTypeValue), priv.sym...)
+}
+
+// Sign will generate an Ed448 signature of the message. The signature is deterministic, so no randomness is needed.
+func (priv *Ed448PrivateKey) Sign(_ io.Reader, message []byte) ([]byte, error) {
+	if priv.signing == nil {
+		return nil, errInvalidEd448Key
+	}
+	return ed448Sign(priv.signing, message), nil
+}
+
+// Generate will generate a new Ed448 Private Key with the randomness provided. It fails without Go 1.15, since OTRv4 is
+// not available then.
+func (priv *Ed448PrivateKey) Generate(rand io.Reader) error {
+	if !ed448Available {
+		return errOTRv4Unavailable
+	}
+
+	sym := make([]byte, ed448ScalarLen)
+	defer wipeBytes(sym)
+
+	if err := randomInto(rand, sym); err != nil {
+		return err
+	}
+
+	priv.setSymmetric(sym)
+	return nil
+}
+
+func (priv *Ed448PrivateKey) setSymmetric(sym []byte) {
+	priv.sym = makeCopy(sym)
+	priv.signing = ed448SigningKey(sym)
+	priv.secret = ed448SecretFromSymmetric(sym)
+	priv.point = ed448BaseMul(priv.secret)
+}
+
+// PublicKey returns the public key corresponding to this private key
+func (priv *Ed448PrivateKey) PublicKey() PublicKey {
+	return &priv.Ed448PublicKey
+}
+
+func (priv *Ed448PrivateKey) wipe() {
+	wipeBytes(priv.sym)
+	wipeBytes(priv.signing)
+	wipeEd448Scalar(priv.secret)
+}
@@ -0,0 +1,131 @@
+//go:build go1.15
+// +build go1.15
+
+package otr3
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The first test vector for Ed448 from RFC 8032, section 7.4
+var (
+	rfc8032SecretKey = bytesFromHex("6c82a562cb808d10d632be89c8513ebf6c929f34ddfa8c9f63c9960ef6e348a3528c8a3fcc2f044e39a3fc5b94492f8f032e7549a20098f95b")
+	rfc8032PublicKey = bytesFromHex("5fd7449b59b461fd2ce787ec616ad46a1da1342485a70e1f8a0ea75d80e96778edf124769b46c7061bd6783df1e50f6cd1fa1abeafe8256180")
+	rfc8032Signature = bytesFromHex("533a37f6bbe457251f023c0d88f976ae2dfb504a843e34d2074fd823d41a591f2b233f034f628281f2fd7a22ddd47d7828c59bd0a21bfd3980ff0d2028d4b18a9df63e006c5d1c2d345b925d8dc00b4104852db99ac5c7cdda8530a113a0f4dbb61149f05a7363268c71d95808ff2e652600")
+)
+
+func Test_ed448G_isValidAndHasOrderQ(t *testing.T) {
+	assertTrue(t, isValidEd448Point(ed448G))
+	assertTrue(t, ed448G.timesOrder().isIdentity())
+	assertFalse(t, ed448G.isIdentity())
+}
+
+func Test_ed448SecretFromSymmetric_derivesThePublicKeyFromRFC8032(t *testing.T) {
+	secret := ed448SecretFromSymmetric(rfc8032SecretKey)
+	assertDeepEquals(t, ed448BaseMul(secret).encode(), rfc8032PublicKey)
+}
+
+func Test_Ed448PrivateKey_Sign_createsTheSignatureFromRFC8032(t *testing.T) {
+	priv := &Ed448PrivateKey{}
+	priv.setSymmetric(rfc8032SecretKey)
+
+	sig, err := priv.Sign(nil, []byte{})
+	assertNil(t, err)
+	assertDeepEquals(t, sig, rfc8032Signature)
+}
+
+func Test_Ed448PublicKey_Verify_acceptsTheSignatureFromRFC8032(t *testing.T) {
+	pub, ok := decodeEd448Point(rfc8032PublicKey)
+	assertTrue(t, ok)
+
+	rest, ok := (&Ed448PublicKey{point: pub}).Verify([]byte{}, append(makeCopy(rfc8032Signature), 0x01))
+	assertTrue(t, ok)
+	assertDeepEquals(t, rest, []byte{0x01})
+}
+
+func Test_Ed448PublicKey_Verify_rejectsAModifiedSignatureOrMessage(t *testing.T) {
+	pub, _ := decodeEd448Point(rfc8032PublicKey)
+	key := &Ed448PublicKey{point: pub}
+
+	sig := makeCopy(rfc8032Signature)
+	sig[3] ^= 0x01
+	_, ok := key.Verify([]byte{}, sig)
+	assertFalse(t, ok)
+	_, ok = key.Verify([]byte{0x01}, rfc8032Signature)
+	assertFalse(t, ok)
+	_, ok = key.Verify([]byte{}, rfc8032Signature[1:])
+	assertFalse(t, ok)
+}
+
+func Test_decodeEd448Point_roundTripsEncodedPoints(t *testing.T) {
+	p := ed448BaseMul(reduceEd448Scalar([]byte{0x39, 0x30}))
+	res, ok := decodeEd448Point(p.encode())
+	assertTrue(t, ok)
+	assertTrue(t, res.equals(p))
+
+	res, ok = decodeEd448Point(p.neg().encode())
+	assertTrue(t, ok)
+	assertTrue(t, res.equals(p.neg()))
+}
+
+func Test_decodeEd448Point_rejectsInvalidEncodings(t *testing.T) {
+	_, ok := decodeEd448Point(rfc8032PublicKey[1:])
+	assertFalse(t, ok)
+
+	bad := makeCopy(rfc8032PublicKey)
+	bad[56] |= 0x01
+	_, ok = decodeEd448Point(bad)
+	assertFalse(t, ok)
+
+	// y = 2 is not the y coordinate of any point on the curve
+	notOnCurve := make([]byte, ed448PointLen)
+	notOnCurve[0] = 0x02
+	_, ok = decodeEd448Point(notOnCurve)
+	assertFalse(t, ok)
+
+	// p = 2^448 - 2^224 - 1 is not a valid y coordinate
+	tooLarge := bytes.Repeat([]byte{0xFF}, ed448PointLen)
+	tooLarge[28] = 0xFE
+	tooLarge[56] = 0x00
+	_, ok = decodeEd448Point(tooLarge)
+	assertFalse(t, ok)
+}
+
+func Test_isValidEd448Point_rejectsTheIdentityAndSmallOrderPoints(t *testing.T) {
+	assertFalse(t, isValidEd448Point(ed448Identity()))
+	assertFalse(t, isValidEd448Point(nil))
+
+	// (0, -1) has order two
+	minusOneY := bytes.Repeat([]byte{0xFF}, ed448PointLen)
+	minusOneY[0] = 0xFE
+	minusOneY[28] = 0xFE
+	minusOneY[56] = 0x00
+	minusOne, ok := decodeEd448Point(minusOneY)
+	assertTrue(t, ok)
+	assertFalse(t, isValidEd448Point(minusOne))
+
+	// Adding it to a valid point gives a point outside of the prime order subgroup
+	assertFalse(t, isValidEd448Point(ed448G.add(minusOne)))
+	assertTrue(t, isValidEd448Point(ed448G))
+}
+
+func Test_ed448Point_addAndDoubleAgree(t *testing.T) {
+	assertTrue(t, ed448G.add(ed448G).equals(ed448G.double()))
+	assertTrue(t, ed448G.add(ed448G.neg()).isIdentity())
+	assertTrue(t, ed448G.mul(reduceEd448Scalar([]byte{0x03})).equals(ed448G.double().add(ed448G)))
+}
+
+func Test_decodeEd448Scalar_rejectsUnreducedScalars(t *testing.T) {
+	_, ok := decodeEd448Scalar(encodeEd448Scalar(&ed448Q))
+	assertFalse(t, ok)
+
+	tooLong := encodeEd448Scalar(reduceEd448Scalar([]byte{42}))
+	tooLong[ed448ScalarLen-1] = 0x01
+	_, ok = decodeEd448Scalar(tooLong)
+	assertFalse(t, ok)
+
+	s, ok := decodeEd448Scalar(encodeEd448Scalar(reduceEd448Scalar([]byte{42})))
+	assertTrue(t, ok)
+	assertDeepEquals(t, s, reduceEd448Scalar([]byte{42}))
+}
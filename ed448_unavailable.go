@@ -0,0 +1,55 @@
+//go:build !go1.15
+// +build !go1.15
+
+package otr3
+
+// circl, which the Ed448 arithmetic comes from, needs Go 1.15 or later. With older versions OTRv4 is not available:
+// no Ed448 key can be generated or parsed, and no point from the peer is accepted, so a conversation can never commit
+// to version 4. The definitions below only exist so that the rest of the library builds, and are never used.
+
+// ed448Available is true when OTRv4 can be used
+const ed448Available = false
+
+type ed448Scalar [ed448ScalarLen - 1]byte
+
+func (s *ed448Scalar) Add(x, y *ed448Scalar) {}
+func (s *ed448Scalar) Sub(x, y *ed448Scalar) {}
+func (s *ed448Scalar) Mul(x, y *ed448Scalar) {}
+
+var (
+	ed448G = &ed448Point{}
+	ed448Q = ed448Scalar{}
+)
+
+type ed448Point struct{}
+
+func (p *ed448Point) add(o *ed448Point) *ed448Point  { return p }
+func (p *ed448Point) mul(k *ed448Scalar) *ed448Point { return p }
+func (p *ed448Point) equals(o *ed448Point) bool      { return false }
+func (p *ed448Point) isIdentity() bool               { return true }
+func (p *ed448Point) timesOrder() *ed448Point        { return p }
+func (p *ed448Point) encode() []byte                 { return nil }
+
+func ed448BaseMul(k *ed448Scalar) *ed448Point {
+	return &ed448Point{}
+}
+
+func decodeEd448Point(in []byte) (*ed448Point, bool) {
+	return nil, false
+}
+
+func reduceEd448Scalar(in []byte) *ed448Scalar {
+	return &ed448Scalar{}
+}
+
+func ed448SigningKey(sym []byte) []byte {
+	return nil
+}
+
+func ed448Sign(key, message []byte) []byte {
+	return nil
+}
+
+func ed448Verify(pub, message, sig []byte) bool {
+	return false
+}
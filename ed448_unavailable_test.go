@@ -0,0 +1,20 @@
+//go:build !go1.15
+// +build !go1.15
+
+package otr3
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func Test_Ed448PrivateKey_Generate_failsWithoutOTRv4(t *testing.T) {
+	k := &Ed448PrivateKey{}
+	assertEquals(t, k.Generate(rand.Reader), errOTRv4Unavailable)
+}
+
+func Test_Ed448PrivateKey_Parse_failsWithoutOTRv4(t *testing.T) {
+	k := &Ed448PrivateKey{}
+	_, ok := k.Parse([]byte{0x00, 0x10, 0x01, 0x02})
+	assertFalse(t, ok)
+}
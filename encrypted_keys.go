@@ -0,0 +1,242 @@
+package otr3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/coyim/gotrax"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The encrypted key container holds the same libotr formatted private keys that ExportKeys writes, encrypted with
+// AES-256-GCM under a key derived from the passphrase with scrypt. All of the header is authenticated.
+//
+//	magic "OTR3-ENCRYPTED-KEYS"
+//	SHORT format version
+//	BYTE  scrypt log2(N)
+//	INT   scrypt r
+//	INT   scrypt p
+//	DATA  salt
+//	DATA  nonce
+//	DATA  ciphertext
+var encryptedKeysMagic = []byte("OTR3-ENCRYPTED-KEYS")
+
+const encryptedKeysVersion = uint16(1)
+
+const (
+	encryptedKeysSaltLen = 16
+	encryptedKeysKeyLen  = 32
+
+	// Refuse parameters that would take unreasonable amounts of memory, since they come from the file
+	maxEncryptedKeysLogN = 22
+	maxEncryptedKeysR    = 32
+	maxEncryptedKeysP    = 16
+)
+
+type kdfParameters struct {
+	logN uint8
+	r, p uint32
+}
+
+// The size of the buffered writer the keys are exported through
+const exportBufferSize = 4096
+
+// The scrypt parameters for new containers use 32 MiB of memory
+var defaultKDFParameters = kdfParameters{logN: 15, r: 8, p: 1}
+
+// ExportEncryptedKeys writes the accounts to w in an encrypted container protected by the passphrase
+func ExportEncryptedKeys(acs []*Account, w io.Writer, passphrase []byte) error {
+	// Growing the buffer would leave copies of the keys behind, so it starts out large enough for most keys
+	var plain bytes.Buffer
+	plain.Grow(2048 * (len(acs) + 1))
+	bw := bufio.NewWriterSize(&plain, exportBufferSize)
+	exportAccounts(acs, bw)
+	defer wipeBytes(plain.Bytes())
+	defer wipeBufferedWriter(bw, exportBufferSize)
+
+	return writeEncryptedKeys(plain.Bytes(), w, passphrase, defaultKDFParameters)
+}
+
+// ExportEncryptedKeysToFile will create the named file (or truncate it) and write the accounts to it in an encrypted
+// container protected by the passphrase. The file is only readable by the current user.
+func ExportEncryptedKeysToFile(acs []*Account, fname string, passphrase []byte) error {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	return firstError(ExportEncryptedKeys(acs, f, passphrase), f.Close())
+}
+
+// ImportEncryptedKeys reads an encrypted container written by ExportEncryptedKeys and returns the accounts in it.
+// The decrypted key material is wiped from all intermediate buffers once the accounts have been parsed.
+func ImportEncryptedKeys(r io.Reader, passphrase []byte) ([]*Account, error) {
+	plain, err := readEncryptedKeys(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(plain)
+
+	br := bufio.NewReaderSize(bytes.NewReader(plain), len(plain)+16)
+	defer wipeBufferedReader(br, len(plain)+16)
+
+	res, ok := readAccounts(br)
+	if !ok {
+		return nil, newOtrError("couldn't import data into private key")
+	}
+	return res, nil
+}
+
+// ImportEncryptedKeysFromFile reads the named encrypted container and returns the accounts in it
+func ImportEncryptedKeysFromFile(fname string, passphrase []byte) ([]*Account, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ImportEncryptedKeys(f, passphrase)
+}
+
+// ChangeEncryptedKeysPassphrase reads an encrypted container from r and writes the same keys to w, protected by the
+// new passphrase. The keys are never parsed, so they stay exactly the same.
+func ChangeEncryptedKeysPassphrase(r io.Reader, w io.Writer, oldPassphrase, newPassphrase []byte) error {
+	plain, err := readEncryptedKeys(r, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	defer wipeBytes(plain)
+
+	return writeEncryptedKeys(plain, w, newPassphrase, defaultKDFParameters)
+}
+
+func writeEncryptedKeys(plain []byte, w io.Writer, passphrase []byte, params kdfParameters) error {
+	salt := make([]byte, encryptedKeysSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return errShortRandomRead
+	}
+
+	aead, err := encryptedKeysAEAD(passphrase, salt, params)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errShortRandomRead
+	}
+
+	header := makeCopy(encryptedKeysMagic)
+	header = gotrax.AppendShort(header, encryptedKeysVersion)
+	header = append(header, params.logN)
+	header = gotrax.AppendWord(header, params.r)
+	header = gotrax.AppendWord(header, params.p)
+	header = gotrax.AppendData(header, salt)
+	header = gotrax.AppendData(header, nonce)
+
+	out := gotrax.AppendData(header, aead.Seal(nil, nonce, plain, header))
+	_, err = w.Write(out)
+	return err
+}
+
+func readEncryptedKeys(r io.Reader, passphrase []byte) ([]byte, error) {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(in, encryptedKeysMagic) {
+		return nil, errCorruptEncryptedKeys
+	}
+	rest := in[len(encryptedKeysMagic):]
+
+	var version uint16
+	var params kdfParameters
+	var salt, nonce, ciphertext []byte
+	var ok bool
+
+	if rest, version, ok = gotrax.ExtractShort(rest); !ok {
+		return nil, errCorruptEncryptedKeys
+	}
+	if version != encryptedKeysVersion {
+		return nil, errUnsupportedEncryptedKeysVersion
+	}
+
+	if rest, params.logN, ok = gotrax.ExtractByte(rest); !ok {
+		return nil, errCorruptEncryptedKeys
+	}
+	if rest, params.r, ok = gotrax.ExtractWord(rest); !ok {
+		return nil, errCorruptEncryptedKeys
+	}
+	if rest, params.p, ok = gotrax.ExtractWord(rest); !ok {
+		return nil, errCorruptEncryptedKeys
+	}
+	if rest, salt, ok = gotrax.ExtractData(rest); !ok {
+		return nil, errCorruptEncryptedKeys
+	}
+	if rest, nonce, ok = gotrax.ExtractData(rest); !ok {
+		return nil, errCorruptEncryptedKeys
+	}
+	header := in[:len(in)-len(rest)]
+	if rest, ciphertext, ok = gotrax.ExtractData(rest); !ok || len(rest) != 0 {
+		return nil, errCorruptEncryptedKeys
+	}
+
+	if params.logN > maxEncryptedKeysLogN || params.r > maxEncryptedKeysR || params.p > maxEncryptedKeysP {
+		return nil, errInvalidKDFParameters
+	}
+
+	aead, err := encryptedKeysAEAD(passphrase, salt, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, errCorruptEncryptedKeys
+	}
+
+	plain, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+
+	return plain, nil
+}
+
+func encryptedKeysAEAD(passphrase, salt []byte, params kdfParameters) (cipher.AEAD, error) {
+	if params.logN < 1 || params.logN > 30 {
+		return nil, errInvalidKDFParameters
+	}
+
+	key, err := scrypt.Key(passphrase, salt, 1<<params.logN, int(params.r), int(params.p), encryptedKeysKeyLen)
+	if err != nil {
+		return nil, errInvalidKDFParameters
+	}
+	defer wipeBytes(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// wipeBufferedReader overwrites the internal buffer of the reader, which holds a copy of everything read through it
+func wipeBufferedReader(br *bufio.Reader, size int) {
+	br.Reset(bytes.NewReader(make([]byte, size)))
+	br.Peek(size)
+}
+
+// wipeBufferedWriter overwrites the internal buffer of the writer, which holds a copy of everything written through it
+func wipeBufferedWriter(bw *bufio.Writer, size int) {
+	bw.Reset(ioutil.Discard)
+	bw.Write(make([]byte, size-1))
+	bw.Write([]byte{0})
+	bw.Flush()
+}
@@ -0,0 +1,160 @@
+package otr3
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var fixturePassphrase = []byte("correct horse battery staple")
+
+// withCheapKDF makes the tests fast by using much weaker scrypt parameters than the default ones
+func withCheapKDF(f func()) {
+	old := defaultKDFParameters
+	defaultKDFParameters = kdfParameters{logN: 4, r: 1, p: 1}
+	defer func() { defaultKDFParameters = old }()
+	f()
+}
+
+func fixtureAccounts() []*Account {
+	return []*Account{
+		&Account{Name: "alice@example.org", Protocol: "prpl-jabber", Key: alicePrivateKey},
+		&Account{Name: "bob@example.org", Protocol: "prpl-jabber", Key: bobPrivateKey},
+	}
+}
+
+func assertSameAccounts(t *testing.T, actual, expected []*Account) {
+	assertEquals(t, len(actual), len(expected))
+	for i := range expected {
+		assertEquals(t, actual[i].Name, expected[i].Name)
+		assertEquals(t, actual[i].Protocol, expected[i].Protocol)
+		assertDeepEquals(t, actual[i].Key.(*DSAPrivateKey).Serialize(), expected[i].Key.(*DSAPrivateKey).Serialize())
+	}
+}
+
+func Test_EncryptedKeys_canBeExportedAndImported(t *testing.T) {
+	withCheapKDF(func() {
+		var buf bytes.Buffer
+		assertNil(t, ExportEncryptedKeys(fixtureAccounts(), &buf, fixturePassphrase))
+
+		assertFalse(t, bytes.Contains(buf.Bytes(), []byte("alice@example.org")))
+
+		acs, err := ImportEncryptedKeys(&buf, fixturePassphrase)
+		assertNil(t, err)
+		assertSameAccounts(t, acs, fixtureAccounts())
+	})
+}
+
+func Test_EncryptedKeys_canBeExportedToAndImportedFromFiles(t *testing.T) {
+	withCheapKDF(func() {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		fname := filepath.Join(dir, "otr.private_key.enc")
+		assertNil(t, ExportEncryptedKeysToFile(fixtureAccounts(), fname, fixturePassphrase))
+
+		acs, err := ImportEncryptedKeysFromFile(fname, fixturePassphrase)
+		assertNil(t, err)
+		assertSameAccounts(t, acs, fixtureAccounts())
+	})
+}
+
+func Test_ImportEncryptedKeys_failsWithTheWrongPassphrase(t *testing.T) {
+	withCheapKDF(func() {
+		var buf bytes.Buffer
+		ExportEncryptedKeys(fixtureAccounts(), &buf, fixturePassphrase)
+
+		_, err := ImportEncryptedKeys(&buf, []byte("wrong"))
+		assertEquals(t, err, errWrongPassphrase)
+	})
+}
+
+func Test_ImportEncryptedKeys_detectsTamperingWithTheHeader(t *testing.T) {
+	withCheapKDF(func() {
+		var buf bytes.Buffer
+		ExportEncryptedKeys(fixtureAccounts(), &buf, fixturePassphrase)
+		data := buf.Bytes()
+
+		// Flip a bit in the salt
+		data[len(encryptedKeysMagic)+2+1+4+4+4] ^= 0x01
+		_, err := ImportEncryptedKeys(bytes.NewReader(data), fixturePassphrase)
+		assertEquals(t, err, errWrongPassphrase)
+	})
+}
+
+func Test_ImportEncryptedKeys_rejectsCorruptOrUnsupportedData(t *testing.T) {
+	withCheapKDF(func() {
+		var buf bytes.Buffer
+		ExportEncryptedKeys(fixtureAccounts(), &buf, fixturePassphrase)
+		data := buf.Bytes()
+
+		_, err := ImportEncryptedKeys(bytes.NewReader([]byte("(privkeys)")), fixturePassphrase)
+		assertEquals(t, err, errCorruptEncryptedKeys)
+
+		_, err = ImportEncryptedKeys(bytes.NewReader(data[:len(data)-1]), fixturePassphrase)
+		assertEquals(t, err, errCorruptEncryptedKeys)
+
+		other := makeCopy(data)
+		other[len(encryptedKeysMagic)+1] = 2
+		_, err = ImportEncryptedKeys(bytes.NewReader(other), fixturePassphrase)
+		assertEquals(t, err, errUnsupportedEncryptedKeysVersion)
+
+		expensive := makeCopy(data)
+		expensive[len(encryptedKeysMagic)+2] = 40
+		_, err = ImportEncryptedKeys(bytes.NewReader(expensive), fixturePassphrase)
+		assertEquals(t, err, errInvalidKDFParameters)
+
+		invalid := makeCopy(data)
+		invalid[len(encryptedKeysMagic)+2] = 0
+		_, err = ImportEncryptedKeys(bytes.NewReader(invalid), fixturePassphrase)
+		assertEquals(t, err, errInvalidKDFParameters)
+	})
+}
+
+func Test_ChangeEncryptedKeysPassphrase_keepsTheSameKeys(t *testing.T) {
+	withCheapKDF(func() {
+		var before, after bytes.Buffer
+		ExportEncryptedKeys(fixtureAccounts(), &before, fixturePassphrase)
+
+		err := ChangeEncryptedKeysPassphrase(bytes.NewReader(before.Bytes()), &after, fixturePassphrase, []byte("new passphrase"))
+		assertNil(t, err)
+
+		_, err = ImportEncryptedKeys(bytes.NewReader(after.Bytes()), fixturePassphrase)
+		assertEquals(t, err, errWrongPassphrase)
+
+		acs, err := ImportEncryptedKeys(bytes.NewReader(after.Bytes()), []byte("new passphrase"))
+		assertNil(t, err)
+		assertSameAccounts(t, acs, fixtureAccounts())
+	})
+}
+
+func Test_ChangeEncryptedKeysPassphrase_needsTheOldPassphrase(t *testing.T) {
+	withCheapKDF(func() {
+		var before, after bytes.Buffer
+		ExportEncryptedKeys(fixtureAccounts(), &before, fixturePassphrase)
+
+		err := ChangeEncryptedKeysPassphrase(&before, &after, []byte("guess"), []byte("new passphrase"))
+		assertEquals(t, err, errWrongPassphrase)
+		assertEquals(t, after.Len(), 0)
+	})
+}
+
+func Test_wipeBufferedReader_overwritesTheBufferedData(t *testing.T) {
+	secret := []byte("a very secret key")
+	br := bufio.NewReaderSize(bytes.NewReader(secret), 32)
+	br.Peek(len(secret))
+
+	wipeBufferedReader(br, 32)
+	peeked, _ := br.Peek(32)
+	assertDeepEquals(t, peeked, make([]byte, 32))
+}
+
+func Test_Account_Wipe_overwritesThePrivateKey(t *testing.T) {
+	var buf bytes.Buffer
+	exportAccounts([]*Account{&Account{Name: "a", Protocol: "p", Key: alicePrivateKey}}, &buf)
+	acs, _ := ImportKeys(&buf)
+
+	acs[0].Wipe()
+	assertEquals(t, acs[0].Key.(*DSAPrivateKey).X.Sign(), 0)
+}
@@ -16,6 +16,46 @@ var errUnsupportedOTRVersion = newOtrError("unsupported OTR version")
 var errWrongProtocolVersion = newOtrError("wrong protocol version")
 var errMessageNotInPrivate = newOtrError("message not in private")
 var errCannotSendUnencrypted = newOtrConflictError("cannot send message in unencrypted state")
+var errCorruptConversationState = newOtrError("corrupt conversation state")
+var errConversationStateIntegrity = newOtrError("conversation state failed integrity check")
+var errConversationStateVersion = newOtrError("conversation state has an unsupported format version")
+var errConversationStateRolledBack = newOtrError("conversation state is older than the latest one saved")
+var errUnknownAccount = newOtrError("unknown account")
+var errUnknownInstance = newOtrError("no conversation for the given instance")
+var errConversationStateNotFresh = newOtrError("conversation state can only be restored into a fresh conversation")
+var errAKETimedOut = newOtrError("the authenticated key exchange timed out")
+var errUnknownTrustLevel = newOtrError("unknown trust level")
+var errInvalidKDFParameters = newOtrError("invalid key derivation parameters")
+var errCorruptEncryptedKeys = newOtrError("corrupt encrypted keys")
+var errUnsupportedEncryptedKeysVersion = newOtrError("encrypted keys have an unsupported format version")
+var errWrongPassphrase = newOtrError("couldn't decrypt keys - wrong passphrase or corrupt data")
+var errUnknownAgentOperation = newOtrError("unknown agent operation")
+var errCorruptAgentMessage = newOtrError("corrupt agent message")
+var errAgentKeyMaterialUnavailable = newOtrError("the key material is only available to the agent")
+var errInsecureAgentDirectory = newOtrError("the directory of the agent socket can be accessed by other users")
+var errOTRv4Unavailable = newOtrError("OTRv4 needs Go 1.15 or later")
+var errInvalidEd448Key = newOtrError("invalid Ed448 key")
+var errNotInRing = newOtrError("the signing key is not part of the ring")
+var errInvalidClientProfile = newOtrError("invalid client profile")
+var errClientProfileExpired = newOtrError("the client profile has expired")
+var errClientProfileKeyMismatch = newOtrError("the key doesn't belong to the client profile")
+var errInvalidTransitionalSignature = newOtrError("invalid transitional signature in client profile")
+var errTooManySkippedMessages = newOtrError("too many messages are missing before this message")
+var errMessageKeyUsed = newOtrError("the key for this message has already been used")
+var errOTRv4StateUnsupported = newOtrError("the state of OTRv4 conversations can not be saved")
+var errReservedTLVType = newOtrError("the TLV type is used by the protocol")
+var errTLVTooLong = newOtrError("the TLV value is too long")
+var errCannotRefreshUnencrypted = newOtrConflictError("cannot refresh the session in unencrypted state")
+var errCannotExportUnencrypted = newOtrConflictError("cannot export keying material in unencrypted state")
+var errInvalidExportLength = newOtrError("invalid length of keying material to export")
+var errUnknownSASFormat = newOtrError("unknown format for the short authentication string")
+var errCannotConfirmSASUnencrypted = newOtrConflictError("cannot confirm the short authentication string in unencrypted state")
+var errNoTrustStore = newOtrError("no trust store has been set")
+var errSASChanged = newOtrError("the short authentication string doesn't match the current session")
+var errInvalidFingerprint = newOtrError("invalid fingerprint")
+var errInvalidFingerprintURI = newOtrError("invalid fingerprint URI")
+var errQueueFull = newOtrError("too many messages are waiting for a secure conversation")
+var errPlaintextNotAllowed = newOtrError("refusing to send the message unencrypted")
 
 // OtrError is an error in the OTR library
 type OtrError struct {
@@ -0,0 +1,50 @@
+package otr3
+
+import "github.com/coyim/gotrax"
+
+const exporterSecretLength = 64
+
+// The exporter is not part of OTRv4, so it uses its own domain for the KDF. That way its usage IDs can never collide
+// with the ones OTRv4 defines.
+var exporterKDFDomain = []byte("OTR3-Exporter")
+
+const (
+	usageExporterSecret   = byte(0x01)
+	usageExportedMaterial = byte(0x02)
+)
+
+// maxExportedLength limits the size of exported keying material, so mistakes don't end up allocating huge buffers
+const maxExportedLength = 1024
+
+func exporterSecretFrom(sharedSecret []byte) []byte {
+	return kdfWithDomain(exporterKDFDomain, usageExporterSecret, exporterSecretLength, sharedSecret)
+}
+
+// setExporterSecret replaces the exporter secret with a copy of the given one, wiping the old one
+func (c *Conversation) setExporterSecret(s []byte) {
+	wipeBytes(c.exporterSecret)
+	c.exporterSecret = nil
+	if s != nil {
+		c.exporterSecret = makeCopy(s)
+	}
+}
+
+// ExportKeyingMaterial derives length bytes of keying material bound to the current secure session, in the style of
+// the TLS exporter. Both peers get the same material for the same label and context without sending any messages,
+// and different sessions, labels or contexts give unrelated material. The material is derived from the shared secret
+// of the AKE that established the session, so it changes when the session is refreshed. The context can be nil.
+// Sessions restored from a snapshot in the version 1 format don't have the secret, so they can't export anything
+// until they are refreshed.
+func (c *Conversation) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if c.msgState != encrypted || c.exporterSecret == nil {
+		return nil, errCannotExportUnencrypted
+	}
+
+	if length <= 0 || length > maxExportedLength {
+		return nil, errInvalidExportLength
+	}
+
+	in := gotrax.AppendData(nil, []byte(label))
+	in = gotrax.AppendData(in, context)
+	return kdfWithDomain(exporterKDFDomain, usageExportedMaterial, length, c.exporterSecret, c.ssid[:], in), nil
+}
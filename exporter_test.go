@@ -0,0 +1,87 @@
+package otr3
+
+import "testing"
+
+func Test_ExportKeyingMaterial_givesTheSameMaterialToBothPeers(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	a, err := alice.ExportKeyingMaterial("voice", []byte("call 1"), 32)
+	assertNil(t, err)
+	b, err := bob.ExportKeyingMaterial("voice", []byte("call 1"), 32)
+	assertNil(t, err)
+
+	assertEquals(t, len(a), 32)
+	assertDeepEquals(t, a, b)
+}
+
+func Test_ExportKeyingMaterial_givesDifferentMaterialForDifferentLabelsAndContexts(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+
+	voice, _ := alice.ExportKeyingMaterial("voice", nil, 32)
+	file, _ := alice.ExportKeyingMaterial("file", nil, 32)
+	withContext, _ := alice.ExportKeyingMaterial("voice", []byte{0x01}, 32)
+	// The label and context are length prefixed, so moving bytes between them makes a difference
+	shifted, _ := alice.ExportKeyingMaterial("voic", []byte("e"), 32)
+
+	assertNotEquals(t, string(voice), string(file))
+	assertNotEquals(t, string(voice), string(withContext))
+	assertNotEquals(t, string(voice), string(shifted))
+}
+
+func Test_ExportKeyingMaterial_givesDifferentMaterialForDifferentSessions(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	before, _ := alice.ExportKeyingMaterial("voice", nil, 32)
+
+	msgs, _ := alice.Refresh()
+	exchangeAll(t, alice, bob, msgs)
+
+	after, _ := alice.ExportKeyingMaterial("voice", nil, 32)
+	fromBob, _ := bob.ExportKeyingMaterial("voice", nil, 32)
+	assertNotEquals(t, string(before), string(after))
+	assertDeepEquals(t, after, fromBob)
+}
+
+func Test_ExportKeyingMaterial_worksForOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runDAKE(t, alice, bob)
+
+	a, err := alice.ExportKeyingMaterial("voice", nil, 64)
+	assertNil(t, err)
+	b, _ := bob.ExportKeyingMaterial("voice", nil, 64)
+
+	assertDeepEquals(t, a, b)
+}
+
+func Test_ExportKeyingMaterial_failsWithoutEncryption(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	alice.End()
+
+	_, err := alice.ExportKeyingMaterial("voice", nil, 32)
+	assertEquals(t, err, errCannotExportUnencrypted)
+	assertNil(t, alice.exporterSecret)
+
+	_, err = (&Conversation{}).ExportKeyingMaterial("voice", nil, 32)
+	assertEquals(t, err, errCannotExportUnencrypted)
+}
+
+func Test_ExportKeyingMaterial_failsForInvalidLengths(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+
+	_, err := alice.ExportKeyingMaterial("voice", nil, 0)
+	assertEquals(t, err, errInvalidExportLength)
+
+	_, err = alice.ExportKeyingMaterial("voice", nil, maxExportedLength+1)
+	assertEquals(t, err, errInvalidExportLength)
+}
+
+func Test_ExportKeyingMaterial_survivesSavingTheState(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	before, _ := alice.ExportKeyingMaterial("voice", nil, 32)
+
+	s, _ := alice.SaveState(fixtureIntegrityKey)
+	restored := restoredConversation(t, s, alicePrivateKey)
+	after, err := restored.ExportKeyingMaterial("voice", nil, 32)
+
+	assertNil(t, err)
+	assertDeepEquals(t, after, before)
+}
@@ -140,3 +140,21 @@ func Test_UseExtraSymmetricKey_returnsTheGeneratedSymmetricKey(t *testing.T) {
 	k, _, _ := c.UseExtraSymmetricKey(0x1234, []byte{0xAB, 0xCD, 0xEE})
 	assertDeepEquals(t, k, bytesFromHex("0e1810c7c62c3bace6450dcbef16af8a271b5ac93030b83e9d0d80e0641e3c18"))
 }
+
+func Test_UseExtraSymmetricKey_givesTheSameKeyToTheHandlerSetOnThePeer(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	var received []byte
+	var receivedUsage uint32
+	bob.SetReceivedKeyHandler(dynamicReceivedKeyHandler{func(usage uint32, usageData []byte, symkey []byte) {
+		receivedUsage = usage
+		received = makeCopy(symkey)
+	}})
+
+	key, toSend, err := alice.UseExtraSymmetricKey(0x42, []byte("some data"))
+	assertNil(t, err)
+	deliverAll(t, bob, toSend)
+
+	assertEquals(t, receivedUsage, uint32(0x42))
+	assertDeepEquals(t, received, key)
+}
@@ -0,0 +1,165 @@
+// Package filetransfer sends files encrypted under the extra symmetric key of an OTR conversation.
+//
+// The sender describes the file in an Offer and announces it with Announce, which asks the peer to use the current
+// extra symmetric key for the transfer. The receiver gets the offer and the same key through a KeyHandler. The file
+// itself is encrypted in authenticated chunks and moved over a Transport, which can be anything the application
+// has - the conversation is only used to agree on the key and the file.
+package filetransfer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"github.com/coyim/gotrax"
+	"github.com/coyim/otr3"
+)
+
+// Usage is the usage value for extra symmetric keys used for file transfers. It spells "otf1".
+const Usage = uint32(0x6F746631)
+
+// IDLength is the length of the random ID of a transfer
+const IDLength = 16
+
+var (
+	errCorruptOffer = errors.New("filetransfer: the offer is corrupt")
+	errShortKey     = errors.New("filetransfer: the key is too short")
+)
+
+// Offer describes a file the sender wants to transfer
+type Offer struct {
+	// ID tells transfers apart, and is chosen at random by the sender
+	ID [IDLength]byte
+	// Name is the name of the file, as proposed by the sender. Receivers should not use it as a path without cleaning it.
+	Name string
+	// Size is the number of bytes in the file
+	Size uint64
+	// Hash is the SHA-256 hash of the file content
+	Hash [sha256.Size]byte
+}
+
+// NewOffer creates an offer for the content of r, reading all of it to find the size and the hash. The reader is moved
+// back to where it was, so it can be used to send the file afterwards.
+func NewOffer(name string, r io.ReadSeeker) (*Offer, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	o := &Offer{Name: name, Size: uint64(size)}
+	copy(o.Hash[:], h.Sum(nil))
+	if _, err := io.ReadFull(rand.Reader, o.ID[:]); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *Offer) serialize() []byte {
+	out := append([]byte{}, o.ID[:]...)
+	out = gotrax.AppendData(out, []byte(o.Name))
+	out = gotrax.AppendLong(out, o.Size)
+	return append(out, o.Hash[:]...)
+}
+
+func parseOffer(in []byte) (*Offer, error) {
+	o := &Offer{}
+	if len(in) < IDLength {
+		return nil, errCorruptOffer
+	}
+	copy(o.ID[:], in)
+	in = in[IDLength:]
+
+	var name []byte
+	var ok bool
+	if in, name, ok = gotrax.ExtractData(in); !ok {
+		return nil, errCorruptOffer
+	}
+	o.Name = string(name)
+
+	if in, o.Size, ok = gotrax.ExtractLong(in); !ok || len(in) != len(o.Hash) {
+		return nil, errCorruptOffer
+	}
+	copy(o.Hash[:], in)
+
+	return o, nil
+}
+
+// fileKey derives the key that encrypts the file from the extra symmetric key. The offer is part of the derivation,
+// so a key can never be used for two different files.
+func fileKey(extraKey []byte, o *Offer) ([]byte, error) {
+	if len(extraKey) < 32 {
+		return nil, errShortKey
+	}
+
+	mac := hmac.New(sha256.New, extraKey)
+	mac.Write([]byte("OTR3 file transfer"))
+	mac.Write(o.serialize())
+	return mac.Sum(nil), nil
+}
+
+// Announce asks the peer to use the current extra symmetric key for transferring the file described by the offer. It
+// returns the key, which should be given to Send, and the messages to send to the peer.
+func Announce(c *otr3.Conversation, o *Offer) ([]byte, []otr3.ValidMessage, error) {
+	return c.UseExtraSymmetricKey(Usage, o.serialize())
+}
+
+// KeyHandler is an otr3.ReceivedKeyHandler that calls OnOffer for every file transfer the peer announces. Keys for other
+// usages are given to Next, if it is set.
+type KeyHandler struct {
+	OnOffer func(o *Offer, key []byte)
+	Next    otr3.ReceivedKeyHandler
+}
+
+// ReceivedSymmetricKey implements otr3.ReceivedKeyHandler
+func (h *KeyHandler) ReceivedSymmetricKey(usage uint32, usageData []byte, symkey []byte) {
+	if usage != Usage {
+		if h.Next != nil {
+			h.Next.ReceivedSymmetricKey(usage, usageData, symkey)
+		}
+		return
+	}
+
+	o, err := parseOffer(usageData)
+	if err != nil || h.OnOffer == nil {
+		return
+	}
+	h.OnOffer(o, append([]byte{}, symkey...))
+}
+
+// Send encrypts the content of r with the key and uploads it over the transport
+func Send(t Transport, key []byte, o *Offer, r io.Reader) error {
+	w, err := t.Upload(o)
+	if err != nil {
+		return err
+	}
+
+	if err := Encrypt(w, key, o, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Receive downloads the file over the transport and writes the decrypted content to w. The content written is only
+// known to be the complete and correct file when Receive returns without an error.
+func Receive(t Transport, key []byte, o *Offer, w io.Writer) error {
+	r, err := t.Download(o)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return Decrypt(w, key, o, r)
+}
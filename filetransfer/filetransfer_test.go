@@ -0,0 +1,128 @@
+package filetransfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/coyim/otr3"
+)
+
+func Test_NewOffer_describesTheContentAndRewindsTheReader(t *testing.T) {
+	content := []byte("hello world")
+	r := bytes.NewReader(content)
+	r.Seek(6, 0)
+
+	o, err := NewOffer("hello.txt", r)
+
+	assertNil(t, err)
+	assertEquals(t, o.Name, "hello.txt")
+	assertEquals(t, o.Size, uint64(5))
+	assertEquals(t, o.Hash, sha256.Sum256([]byte("world")))
+	assertEquals(t, r.Len(), 5)
+}
+
+func Test_NewOffer_usesADifferentIDForEveryOffer(t *testing.T) {
+	o1, _ := NewOffer("a", bytes.NewReader(nil))
+	o2, _ := NewOffer("a", bytes.NewReader(nil))
+
+	assertEquals(t, o1.ID == o2.ID, false)
+}
+
+func Test_parseOffer_readsASerializedOffer(t *testing.T) {
+	o := fixtureOffer([]byte("content"))
+
+	parsed, err := parseOffer(o.serialize())
+
+	assertNil(t, err)
+	assertDeepEquals(t, parsed, o)
+}
+
+func Test_parseOffer_failsForCorruptOffers(t *testing.T) {
+	s := fixtureOffer([]byte("content")).serialize()
+
+	for _, corrupt := range [][]byte{nil, s[:IDLength], s[:len(s)-1], append(s, 0x00)} {
+		_, err := parseOffer(corrupt)
+		assertEquals(t, err, errCorruptOffer)
+	}
+}
+
+type recordingKeyHandler struct {
+	usages []uint32
+}
+
+func (r *recordingKeyHandler) ReceivedSymmetricKey(usage uint32, usageData []byte, symkey []byte) {
+	r.usages = append(r.usages, usage)
+}
+
+func Test_KeyHandler_givesOtherUsagesToTheNextHandler(t *testing.T) {
+	next := &recordingKeyHandler{}
+	called := false
+	h := &KeyHandler{OnOffer: func(*Offer, []byte) { called = true }, Next: next}
+
+	h.ReceivedSymmetricKey(0x01, nil, fixtureKey)
+	h.ReceivedSymmetricKey(Usage, []byte("corrupt"), fixtureKey)
+
+	assertDeepEquals(t, next.usages, []uint32{0x01})
+	assertEquals(t, called, false)
+}
+
+func conversationWithNewKey(t *testing.T) *otr3.Conversation {
+	key := &otr3.DSAPrivateKey{}
+	if err := key.Generate(rand.Reader); err != nil {
+		t.Fatalf("Unexpected error when generating key: %v", err)
+	}
+
+	c := &otr3.Conversation{Rand: rand.Reader}
+	c.Policies.AllowV3()
+	c.SetOurKeys([]otr3.PrivateKey{key})
+	return c
+}
+
+func deliver(t *testing.T, to *otr3.Conversation, msgs []otr3.ValidMessage) []otr3.ValidMessage {
+	var ret []otr3.ValidMessage
+	for _, m := range msgs {
+		_, toSend, err := to.Receive(m)
+		if err != nil {
+			t.Fatalf("Unexpected error when delivering message: %v", err)
+		}
+		ret = append(ret, toSend...)
+	}
+	return ret
+}
+
+func Test_transferringAFileBetweenTwoConversations(t *testing.T) {
+	alice, bob := conversationWithNewKey(t), conversationWithNewKey(t)
+	toBob := []otr3.ValidMessage{alice.QueryMessage()}
+	for i := 0; i < 5 && len(toBob) > 0; i++ {
+		toBob = deliver(t, alice, deliver(t, bob, toBob))
+	}
+
+	var offered *Offer
+	var receivedKey []byte
+	bob.SetReceivedKeyHandler(&KeyHandler{OnOffer: func(o *Offer, key []byte) {
+		offered, receivedKey = o, key
+	}})
+
+	content := bytes.Repeat([]byte("file content "), 10000)
+	file := bytes.NewReader(content)
+	o, _ := NewOffer("content.txt", file)
+	key, toSend, err := Announce(alice, o)
+	assertNil(t, err)
+	deliver(t, bob, toSend)
+
+	assertDeepEquals(t, offered, o)
+	assertDeepEquals(t, receivedKey, key)
+
+	transport := NewPipeTransport()
+	sent := make(chan error)
+	go func() { sent <- Send(transport, key, o, file) }()
+
+	var received bytes.Buffer
+	err = Receive(transport, receivedKey, offered, &received)
+
+	assertNil(t, err)
+	assertNil(t, <-sent)
+	assertDeepEquals(t, received.Bytes(), content)
+}
@@ -0,0 +1,158 @@
+package filetransfer
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// chunkSize is the size of the plaintext in every chunk except the last one
+const chunkSize = 64 * 1024
+
+// The header of every chunk has a flag telling if it is the last chunk, and the length of the encrypted chunk
+const chunkHeaderLength = 1 + 4
+
+const (
+	chunkMore  = byte(0x00)
+	chunkFinal = byte(0x01)
+)
+
+var (
+	errCorruptChunk = errors.New("filetransfer: a chunk of the file is corrupt")
+	errTruncated    = errors.New("filetransfer: the file ended too early")
+	errWrongSize    = errors.New("filetransfer: the file doesn't have the size in the offer")
+	errWrongHash    = errors.New("filetransfer: the file doesn't have the hash in the offer")
+)
+
+func newAEAD(key []byte, o *Offer) (cipher.AEAD, error) {
+	k, err := fileKey(key, o)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce uses the number of the chunk as nonce, which is safe since every file has its own key
+func chunkNonce(aead cipher.AEAD, n uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n)
+	return nonce
+}
+
+// chunkHeader is authenticated with the chunk, so chunks can't be reordered, dropped or marked as the last one
+func chunkHeader(flag byte, length int) []byte {
+	header := make([]byte, chunkHeaderLength)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(length))
+	return header
+}
+
+// Encrypt reads the content of the file from r, and writes it to w encrypted in authenticated chunks
+func Encrypt(w io.Writer, key []byte, o *Offer, r io.Reader) error {
+	aead, err := newAEAD(key, o)
+	if err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(r)
+	buf := make([]byte, chunkSize)
+	for n := uint64(0); ; n++ {
+		l, err := io.ReadFull(in, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+
+		flag := chunkMore
+		if err != nil {
+			flag = chunkFinal
+		} else if _, err := in.Peek(1); err == io.EOF {
+			flag = chunkFinal
+		} else if err != nil {
+			return err
+		}
+
+		header := chunkHeader(flag, l+aead.Overhead())
+		sealed := aead.Seal(header, chunkNonce(aead, n), buf[:l], header)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+
+		if flag == chunkFinal {
+			return nil
+		}
+	}
+}
+
+// Decrypt reads the encrypted chunks of the file from r, and writes the decrypted content to w. It fails as soon as a
+// chunk is corrupt, and at the end if the file doesn't match the size and hash in the offer.
+func Decrypt(w io.Writer, key []byte, o *Offer, r io.Reader) error {
+	aead, err := newAEAD(key, o)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	size := uint64(0)
+	header := make([]byte, chunkHeaderLength)
+	for n := uint64(0); ; n++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return truncatedIfEOF(err)
+		}
+
+		flag, length := header[0], binary.BigEndian.Uint32(header[1:])
+		if flag > chunkFinal || length < uint32(aead.Overhead()) || length > uint32(chunkSize+aead.Overhead()) {
+			return errCorruptChunk
+		}
+
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(r, sealed); err != nil {
+			return truncatedIfEOF(err)
+		}
+
+		plain, err := aead.Open(sealed[:0], chunkNonce(aead, n), sealed, header)
+		if err != nil {
+			return errCorruptChunk
+		}
+
+		size += uint64(len(plain))
+		if size > o.Size {
+			return errWrongSize
+		}
+
+		h.Write(plain)
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+
+		if flag == chunkFinal {
+			break
+		}
+	}
+
+	if size != o.Size {
+		return errWrongSize
+	}
+
+	if subtle.ConstantTimeCompare(h.Sum(nil), o.Hash[:]) != 1 {
+		return errWrongHash
+	}
+
+	return nil
+}
+
+func truncatedIfEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errTruncated
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package filetransfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+var fixtureKey = bytes.Repeat([]byte{0x42}, 32)
+
+func fixtureOffer(content []byte) *Offer {
+	o, _ := NewOffer("file.txt", bytes.NewReader(content))
+	return o
+}
+
+func encrypted(t *testing.T, o *Offer, content []byte) []byte {
+	var out bytes.Buffer
+	if err := Encrypt(&out, fixtureKey, o, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Unexpected error when encrypting: %v", err)
+	}
+	return out.Bytes()
+}
+
+func Test_Encrypt_andDecrypt_roundTripFilesOfAllSizes(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		content := bytes.Repeat([]byte{0xAB}, size)
+		o := fixtureOffer(content)
+
+		var out bytes.Buffer
+		err := Decrypt(&out, fixtureKey, o, bytes.NewReader(encrypted(t, o, content)))
+
+		assertNil(t, err)
+		assertEquals(t, bytes.Equal(out.Bytes(), content), true)
+	}
+}
+
+func Test_Encrypt_doesntLeaveAnEmptyChunkAfterAFullOne(t *testing.T) {
+	o := fixtureOffer(make([]byte, chunkSize))
+
+	enc := encrypted(t, o, make([]byte, chunkSize))
+
+	assertEquals(t, len(enc), chunkHeaderLength+chunkSize+16)
+	assertEquals(t, enc[0], chunkFinal)
+}
+
+func Test_Decrypt_failsForATamperedChunk(t *testing.T) {
+	content := []byte("some secret content")
+	o := fixtureOffer(content)
+	enc := encrypted(t, o, content)
+	enc[chunkHeaderLength] ^= 0x01
+
+	err := Decrypt(&bytes.Buffer{}, fixtureKey, o, bytes.NewReader(enc))
+
+	assertEquals(t, err, errCorruptChunk)
+}
+
+func Test_Decrypt_failsWhenTheLastChunkIsMissing(t *testing.T) {
+	content := make([]byte, chunkSize+10)
+	o := fixtureOffer(content)
+	enc := encrypted(t, o, content)
+
+	err := Decrypt(&bytes.Buffer{}, fixtureKey, o, bytes.NewReader(enc[:chunkHeaderLength+chunkSize+16]))
+
+	assertEquals(t, err, errTruncated)
+}
+
+func Test_Decrypt_failsWhenAChunkIsMarkedAsTheLastOne(t *testing.T) {
+	content := make([]byte, chunkSize+10)
+	o := fixtureOffer(content)
+	enc := encrypted(t, o, content)
+	enc[0] = chunkFinal
+
+	err := Decrypt(&bytes.Buffer{}, fixtureKey, o, bytes.NewReader(enc))
+
+	assertEquals(t, err, errCorruptChunk)
+}
+
+func Test_Decrypt_failsWithAnotherKey(t *testing.T) {
+	content := []byte("some secret content")
+	o := fixtureOffer(content)
+	enc := encrypted(t, o, content)
+
+	err := Decrypt(&bytes.Buffer{}, bytes.Repeat([]byte{0x43}, 32), o, bytes.NewReader(enc))
+
+	assertEquals(t, err, errCorruptChunk)
+}
+
+func Test_Decrypt_failsForAnotherOffer(t *testing.T) {
+	content := []byte("some secret content")
+	o := fixtureOffer(content)
+	enc := encrypted(t, o, content)
+
+	other := *o
+	other.Name = "other.txt"
+	err := Decrypt(&bytes.Buffer{}, fixtureKey, &other, bytes.NewReader(enc))
+
+	assertEquals(t, err, errCorruptChunk)
+}
+
+func Test_Decrypt_failsWhenTheContentDoesntMatchTheOffer(t *testing.T) {
+	content := []byte("some secret content")
+	o := fixtureOffer(content)
+
+	// A sender that lies in its offer
+	longer := *o
+	longer.Size++
+	err := Decrypt(&bytes.Buffer{}, fixtureKey, &longer, bytes.NewReader(encrypted(t, &longer, content)))
+	assertEquals(t, err, errWrongSize)
+
+	shorter := *o
+	shorter.Size--
+	err = Decrypt(&bytes.Buffer{}, fixtureKey, &shorter, bytes.NewReader(encrypted(t, &shorter, content)))
+	assertEquals(t, err, errWrongSize)
+
+	wrongHash := *o
+	wrongHash.Hash = sha256.Sum256([]byte("something else"))
+	err = Decrypt(&bytes.Buffer{}, fixtureKey, &wrongHash, bytes.NewReader(encrypted(t, &wrongHash, content)))
+	assertEquals(t, err, errWrongHash)
+}
+
+func Test_Encrypt_failsWithAShortKey(t *testing.T) {
+	o := fixtureOffer(nil)
+
+	err := Encrypt(&bytes.Buffer{}, []byte{0x01}, o, bytes.NewReader(nil))
+
+	assertEquals(t, err, errShortKey)
+}
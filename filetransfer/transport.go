@@ -0,0 +1,73 @@
+package filetransfer
+
+import (
+	"io"
+	"sync"
+)
+
+// Transport moves the encrypted file from the sender to the receiver. It can be anything the application has, for
+// example a direct connection or an upload to a server, since the content is already encrypted and authenticated.
+type Transport interface {
+	// Upload returns where the sender writes the encrypted file for the offer
+	Upload(o *Offer) (io.WriteCloser, error)
+	// Download returns where the receiver reads the encrypted file for the offer from
+	Download(o *Offer) (io.ReadCloser, error)
+}
+
+// PipeTransport is a Transport that moves files in memory, with a pipe for every transfer. The sender and the receiver
+// have to run at the same time, since writes block until the data has been read.
+type PipeTransport struct {
+	lock  sync.Mutex
+	pipes map[[IDLength]byte]*pipe
+}
+
+type pipe struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+// NewPipeTransport creates a new, empty PipeTransport
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{pipes: make(map[[IDLength]byte]*pipe)}
+}
+
+func (t *PipeTransport) pipeFor(o *Offer) *pipe {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	p, ok := t.pipes[o.ID]
+	if !ok {
+		r, w := io.Pipe()
+		p = &pipe{r, w}
+		t.pipes[o.ID] = p
+	}
+	return p
+}
+
+func (t *PipeTransport) forget(o *Offer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.pipes, o.ID)
+}
+
+// Upload implements Transport
+func (t *PipeTransport) Upload(o *Offer) (io.WriteCloser, error) {
+	return t.pipeFor(o).w, nil
+}
+
+// Download implements Transport. Closing the reader makes the transfer available for a new upload.
+func (t *PipeTransport) Download(o *Offer) (io.ReadCloser, error) {
+	return &downloadCloser{t.pipeFor(o).r, t, o}, nil
+}
+
+type downloadCloser struct {
+	*io.PipeReader
+	t *PipeTransport
+	o *Offer
+}
+
+func (d *downloadCloser) Close() error {
+	d.t.forget(d.o)
+	return d.PipeReader.Close()
+}
@@ -0,0 +1,87 @@
+package otr3
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// The number of hex digits in every group of a formatted fingerprint
+const fingerprintGroupLength = 8
+
+const fingerprintURIScheme = "otr"
+const fingerprintURIParameter = "fp"
+
+// FormatFingerprint formats a fingerprint the way libotr and most OTR clients show it: upper case hex digits in groups
+// of eight, separated by spaces.
+func FormatFingerprint(fpr []byte) string {
+	h := strings.ToUpper(hex.EncodeToString(fpr))
+
+	groups := make([]string, 0, len(h)/fingerprintGroupLength+1)
+	for len(h) > fingerprintGroupLength {
+		groups = append(groups, h[:fingerprintGroupLength])
+		h = h[fingerprintGroupLength:]
+	}
+	return strings.Join(append(groups, h), " ")
+}
+
+// ParseFingerprint parses a fingerprint written in hex, for example one the user has pasted. Spaces and colons between
+// the digits are ignored, as is the case of the digits. Only fingerprints of the lengths used by OTRv3 and OTRv4 are
+// accepted.
+func ParseFingerprint(s string) ([]byte, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r == ':' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, s)
+
+	fpr, err := hex.DecodeString(digits)
+	if err != nil || (len(fpr) != sha1.Size && len(fpr) != ed448FingerprintLen) {
+		return nil, errInvalidFingerprint
+	}
+	return fpr, nil
+}
+
+// FingerprintsEqual compares two fingerprints in constant time
+func FingerprintsEqual(a, b []byte) bool {
+	return len(a) > 0 && subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// FingerprintMatches returns true if the string, as parsed by ParseFingerprint, is the given fingerprint
+func FingerprintMatches(fpr []byte, s string) bool {
+	parsed, err := ParseFingerprint(s)
+	return err == nil && FingerprintsEqual(fpr, parsed)
+}
+
+// FingerprintURI creates an otr: URI carrying the account and its fingerprint, for example
+// "otr:alice@example.org?fp=0123...". It can be shared as a link or as the payload of a QR code, so the peer can verify
+// the fingerprint out of band with ParseFingerprintURI.
+func FingerprintURI(account string, fpr []byte) string {
+	u := url.URL{
+		Scheme:   fingerprintURIScheme,
+		Opaque:   url.PathEscape(account),
+		RawQuery: url.Values{fingerprintURIParameter: []string{hex.EncodeToString(fpr)}}.Encode(),
+	}
+	return u.String()
+}
+
+// ParseFingerprintURI returns the account and fingerprint in an URI created by FingerprintURI
+func ParseFingerprintURI(uri string) (account string, fpr []byte, err error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || !strings.EqualFold(u.Scheme, fingerprintURIScheme) || u.Opaque == "" {
+		return "", nil, errInvalidFingerprintURI
+	}
+
+	if account, err = url.PathUnescape(u.Opaque); err != nil {
+		return "", nil, errInvalidFingerprintURI
+	}
+
+	if fpr, err = ParseFingerprint(u.Query().Get(fingerprintURIParameter)); err != nil {
+		return "", nil, err
+	}
+
+	return account, fpr, nil
+}
@@ -0,0 +1,101 @@
+package otr3
+
+import "testing"
+
+func Test_FormatFingerprint_groupsTheHexDigitsInEights(t *testing.T) {
+	fpr := bobPrivateKey.PublicKey().Fingerprint()
+
+	assertEquals(t, FormatFingerprint(fpr), "8798FAA7 735267FB 84577330 98482E94 096D4ABD")
+	assertEquals(t, FormatFingerprint([]byte{0xAB, 0xCD}), "ABCD")
+	assertEquals(t, FormatFingerprint(nil), "")
+}
+
+func Test_ParseFingerprint_ignoresSpacesColonsAndCase(t *testing.T) {
+	fpr := bobPrivateKey.PublicKey().Fingerprint()
+
+	for _, s := range []string{
+		"8798FAA7 735267FB 84577330 98482E94 096D4ABD",
+		"8798faa7735267fb8457733098482e94096d4abd",
+		"87:98:fa:a7:73:52:67:fb:84:57:73:30:98:48:2e:94:09:6d:4a:bd",
+		"  8798FAA7\t735267FB\n84577330 98482E94 096D4ABD\n",
+	} {
+		res, err := ParseFingerprint(s)
+		assertNil(t, err)
+		assertDeepEquals(t, res, fpr)
+	}
+}
+
+func Test_ParseFingerprint_acceptsOTRv4Fingerprints(t *testing.T) {
+	fpr := make([]byte, ed448FingerprintLen)
+	fpr[0] = 0x42
+
+	res, err := ParseFingerprint(FormatFingerprint(fpr))
+	assertNil(t, err)
+	assertDeepEquals(t, res, fpr)
+}
+
+func Test_ParseFingerprint_failsForInvalidFingerprints(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"8798FAA7 735267FB 84577330 98482E94",
+		"8798FAA7 735267FB 84577330 98482E94 096D4ABD 00",
+		"8798FAA7 735267FB 84577330 98482E94 096D4ABG",
+		"8798FAA7-735267FB-84577330-98482E94-096D4ABD",
+	} {
+		_, err := ParseFingerprint(s)
+		assertEquals(t, err, errInvalidFingerprint)
+	}
+}
+
+func Test_FingerprintsEqual_comparesTheFingerprints(t *testing.T) {
+	fpr := bobPrivateKey.PublicKey().Fingerprint()
+
+	assertTrue(t, FingerprintsEqual(fpr, makeCopy(fpr)))
+	assertFalse(t, FingerprintsEqual(fpr, alicePrivateKey.PublicKey().Fingerprint()))
+	assertFalse(t, FingerprintsEqual(fpr, fpr[:10]))
+	assertFalse(t, FingerprintsEqual(nil, nil))
+}
+
+func Test_FingerprintMatches_parsesTheString(t *testing.T) {
+	fpr := bobPrivateKey.PublicKey().Fingerprint()
+
+	assertTrue(t, FingerprintMatches(fpr, "8798faa7:735267fb:84577330:98482e94:096d4abd"))
+	assertFalse(t, FingerprintMatches(fpr, FormatFingerprint(alicePrivateKey.PublicKey().Fingerprint())))
+	assertFalse(t, FingerprintMatches(fpr, "not a fingerprint"))
+}
+
+func Test_FingerprintURI_canBeParsedAgain(t *testing.T) {
+	fpr := bobPrivateKey.PublicKey().Fingerprint()
+
+	uri := FingerprintURI("bob@example.org/some resource", fpr)
+	assertEquals(t, uri, "otr:bob@example.org%2Fsome%20resource?fp=8798faa7735267fb8457733098482e94096d4abd")
+
+	account, res, err := ParseFingerprintURI(uri)
+	assertNil(t, err)
+	assertEquals(t, account, "bob@example.org/some resource")
+	assertDeepEquals(t, res, fpr)
+}
+
+func Test_ParseFingerprintURI_isTolerantOfTheSchemeCaseAndFingerprintFormat(t *testing.T) {
+	account, res, err := ParseFingerprintURI(" OTR:bob@example.org?fp=8798FAA7%20735267FB%2084577330%2098482E94%20096D4ABD ")
+
+	assertNil(t, err)
+	assertEquals(t, account, "bob@example.org")
+	assertDeepEquals(t, res, bobPrivateKey.PublicKey().Fingerprint())
+}
+
+func Test_ParseFingerprintURI_failsForInvalidURIs(t *testing.T) {
+	for _, uri := range []string{
+		"",
+		"xmpp:bob@example.org?fp=8798faa7735267fb8457733098482e94096d4abd",
+		"otr:?fp=8798faa7735267fb8457733098482e94096d4abd",
+		"otr://bob@example.org?fp=8798faa7735267fb8457733098482e94096d4abd",
+		"otr:bob%zz@example.org?fp=8798faa7735267fb8457733098482e94096d4abd",
+	} {
+		_, _, err := ParseFingerprintURI(uri)
+		assertEquals(t, err, errInvalidFingerprintURI)
+	}
+
+	_, _, err := ParseFingerprintURI("otr:bob@example.org")
+	assertEquals(t, err, errInvalidFingerprint)
+}
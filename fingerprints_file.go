@@ -0,0 +1,179 @@
+package otr3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// FingerprintEntry is one line of a libotr fingerprints file. Trust is the trust string as written by libotr,
+// which is empty for unverified fingerprints.
+type FingerprintEntry struct {
+	Peer        string
+	Account     string
+	Protocol    string
+	Fingerprint []byte
+	Trust       string
+
+	// raw is the line this entry was read from, and read is the entry as it was read. As long as the entry
+	// hasn't changed, raw is written back instead of the formatted entry.
+	raw  string
+	read *FingerprintEntry
+}
+
+// Fingerprints is the content of a libotr fingerprints file, usually called otr.fingerprints.
+// Every line holds the peer, our account, the protocol, the fingerprint and the trust, separated by tabs.
+type Fingerprints struct {
+	Entries []*FingerprintEntry
+
+	layout libotrFileLayout
+}
+
+func (e *FingerprintEntry) sameAs(o *FingerprintEntry) bool {
+	return e.Peer == o.Peer &&
+		e.Account == o.Account &&
+		e.Protocol == o.Protocol &&
+		bytes.Equal(e.Fingerprint, o.Fingerprint) &&
+		e.Trust == o.Trust
+}
+
+func (e *FingerprintEntry) format() string {
+	return strings.Join([]string{e.Peer, e.Account, e.Protocol, hex.EncodeToString(e.Fingerprint), e.Trust}, "\t") + "\n"
+}
+
+// TrustLevel returns the trust level the trust string of this entry stands for. As in libotr, any trust string
+// other than the empty one means that the fingerprint has been verified.
+func (e *FingerprintEntry) TrustLevel() TrustLevel {
+	switch e.Trust {
+	case "":
+		return TrustUnverified
+	case "smp":
+		return TrustSMPVerified
+	default:
+		return TrustVerified
+	}
+}
+
+// SetTrustLevel sets the trust string of this entry to the one Pidgin uses for the trust level
+func (e *FingerprintEntry) SetTrustLevel(l TrustLevel) {
+	switch l {
+	case TrustSMPVerified:
+		e.Trust = "smp"
+	case TrustVerified:
+		e.Trust = "verified"
+	default:
+		e.Trust = ""
+	}
+}
+
+func parseFingerprintEntry(line string, n int) (*FingerprintEntry, error) {
+	fields := strings.Split(lineContent(line), "\t")
+	if len(fields) != 4 && len(fields) != 5 {
+		return nil, lineErrorf(n, "expected 4 or 5 tab separated fields, found %d", len(fields))
+	}
+
+	fpr, err := hex.DecodeString(fields[3])
+	if err != nil || len(fpr) != 20 {
+		return nil, lineErrorf(n, "invalid fingerprint %q", fields[3])
+	}
+
+	e := &FingerprintEntry{
+		Peer:        fields[0],
+		Account:     fields[1],
+		Protocol:    fields[2],
+		Fingerprint: fpr,
+		raw:         line,
+	}
+	if len(fields) == 5 {
+		e.Trust = fields[4]
+	}
+
+	read := *e
+	read.Fingerprint = makeCopy(fpr)
+	e.read = &read
+
+	return e, nil
+}
+
+// ImportFingerprints will read the libotr formatted fingerprints given. Errors for malformed lines contain the line number.
+func ImportFingerprints(r io.Reader) (*Fingerprints, error) {
+	lines, err := splitLibotrFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fingerprints{}
+	var pending []string
+	for i, line := range lines {
+		if isLibotrCommentOrBlank(lineContent(line)) {
+			pending = append(pending, line)
+			continue
+		}
+
+		e, err := parseFingerprintEntry(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+
+		f.layout.keep(pending, e)
+		pending = nil
+		f.Entries = append(f.Entries, e)
+	}
+	f.layout.trailer = pending
+
+	return f, nil
+}
+
+// ImportFingerprintsFromFile will read the libotr formatted fingerprints file given
+func ImportFingerprintsFromFile(fname string) (*Fingerprints, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ImportFingerprints(file)
+}
+
+// WriteTo writes the fingerprints in libotr format. Fingerprints that were imported are written back exactly as they
+// were read unless they have been changed.
+func (f *Fingerprints) WriteTo(w io.Writer) (int64, error) {
+	lw := &libotrLineWriter{w: w}
+	for _, e := range f.Entries {
+		lw.writeAll(f.layout.before[e])
+		if e.read != nil && e.sameAs(e.read) {
+			lw.write(e.raw)
+		} else {
+			lw.write(e.format())
+		}
+	}
+	lw.writeAll(f.layout.trailer)
+
+	return lw.n, lw.err
+}
+
+// ExportToFile will create the named file (or truncate it) and write the fingerprints to it in libotr format
+func (f *Fingerprints) ExportToFile(fname string) error {
+	return exportToFile(fname, f.WriteTo)
+}
+
+// Find returns the entry for the fingerprint of the peer, or nil if there is none
+func (f *Fingerprints) Find(account, protocol, peer string, fingerprint []byte) *FingerprintEntry {
+	for _, e := range f.Entries {
+		if e.Account == account && e.Protocol == protocol && e.Peer == peer && bytes.Equal(e.Fingerprint, fingerprint) {
+			return e
+		}
+	}
+	return nil
+}
+
+// ImportInto records the trust level of every fingerprint in the given trust store
+func (f *Fingerprints) ImportInto(store TrustStore) error {
+	for _, e := range f.Entries {
+		if err := store.SetTrustLevel(e.Account, e.Protocol, e.Peer, e.Fingerprint, e.TrustLevel()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package otr3
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixtureFingerprintsFile = "bob@example.org\talice@example.org\tprpl-jabber\t1b2c3d4e5f60718293a4b5c6d7e8f90011223344\tverified\n" +
+	"carol\talice@example.org\tprpl-jabber\t00112233445566778899aabbccddeeff00112233\t\n" +
+	"# a comment\r\n" +
+	"\n" +
+	"dave\talice\tprpl-irc\t00112233445566778899AABBCCDDEEFF00112233\r\n" +
+	"erin\talice\tprpl-irc\t0102030405060708090a0b0c0d0e0f1011121314\tsmp"
+
+func Test_ImportFingerprints_readsAllEntries(t *testing.T) {
+	f, err := ImportFingerprints(strings.NewReader(fixtureFingerprintsFile))
+	assertNil(t, err)
+	assertEquals(t, len(f.Entries), 4)
+
+	e := f.Entries[0]
+	assertEquals(t, e.Peer, "bob@example.org")
+	assertEquals(t, e.Account, "alice@example.org")
+	assertEquals(t, e.Protocol, "prpl-jabber")
+	assertDeepEquals(t, e.Fingerprint, bytesFromHex("1b2c3d4e5f60718293a4b5c6d7e8f90011223344"))
+	assertEquals(t, e.Trust, "verified")
+
+	assertEquals(t, f.Entries[1].Trust, "")
+	assertEquals(t, f.Entries[2].Peer, "dave")
+	assertDeepEquals(t, f.Entries[2].Fingerprint, f.Entries[1].Fingerprint)
+	assertEquals(t, f.Entries[3].Trust, "smp")
+}
+
+func Test_Fingerprints_roundTripsByteForByte(t *testing.T) {
+	f, _ := ImportFingerprints(strings.NewReader(fixtureFingerprintsFile))
+
+	var out bytes.Buffer
+	n, err := f.WriteTo(&out)
+	assertNil(t, err)
+	assertEquals(t, out.String(), fixtureFingerprintsFile)
+	assertEquals(t, n, int64(len(fixtureFingerprintsFile)))
+}
+
+func Test_Fingerprints_WriteTo_formatsChangedAndNewEntriesLikeLibotr(t *testing.T) {
+	f, _ := ImportFingerprints(strings.NewReader(fixtureFingerprintsFile))
+	f.Entries[2].SetTrustLevel(TrustVerified)
+	f.Entries = append(f.Entries, &FingerprintEntry{
+		Peer:        "frank",
+		Account:     "alice",
+		Protocol:    "prpl-irc",
+		Fingerprint: bytesFromHex("aabbccddeeff00112233445566778899aabbccdd"),
+	})
+
+	var out bytes.Buffer
+	f.WriteTo(&out)
+	assertEquals(t, out.String(), "bob@example.org\talice@example.org\tprpl-jabber\t1b2c3d4e5f60718293a4b5c6d7e8f90011223344\tverified\n"+
+		"carol\talice@example.org\tprpl-jabber\t00112233445566778899aabbccddeeff00112233\t\n"+
+		"# a comment\r\n"+
+		"\n"+
+		"dave\talice\tprpl-irc\t00112233445566778899aabbccddeeff00112233\tverified\n"+
+		"erin\talice\tprpl-irc\t0102030405060708090a0b0c0d0e0f1011121314\tsmp\n"+
+		"frank\talice\tprpl-irc\taabbccddeeff00112233445566778899aabbccdd\t\n")
+}
+
+func Test_ImportFingerprints_returnsErrorsWithLineNumbers(t *testing.T) {
+	_, err := ImportFingerprints(strings.NewReader("bob\talice\tprpl-irc\t00112233445566778899aabbccddeeff00112233\n\nbob\talice\n"))
+	assertDeepEquals(t, err, newOtrError("line 3: expected 4 or 5 tab separated fields, found 2"))
+
+	_, err = ImportFingerprints(strings.NewReader("bob\talice\tprpl-irc\t0011zz\tverified\n"))
+	assertDeepEquals(t, err, newOtrError("line 1: invalid fingerprint \"0011zz\""))
+
+	_, err = ImportFingerprints(strings.NewReader("bob\talice\tprpl-irc\t0011\n"))
+	assertDeepEquals(t, err, newOtrError("line 1: invalid fingerprint \"0011\""))
+}
+
+func Test_FingerprintEntry_TrustLevel_followsLibotr(t *testing.T) {
+	e := &FingerprintEntry{}
+	assertEquals(t, e.TrustLevel(), TrustUnverified)
+	e.Trust = "smp"
+	assertEquals(t, e.TrustLevel(), TrustSMPVerified)
+	e.Trust = "yes"
+	assertEquals(t, e.TrustLevel(), TrustVerified)
+
+	e.SetTrustLevel(TrustUnknown)
+	assertEquals(t, e.Trust, "")
+	e.SetTrustLevel(TrustSMPVerified)
+	assertEquals(t, e.Trust, "smp")
+}
+
+func Test_Fingerprints_ImportInto_recordsTheTrustOfAllEntries(t *testing.T) {
+	f, _ := ImportFingerprints(strings.NewReader(fixtureFingerprintsFile))
+	s := NewMemoryTrustStore()
+	assertNil(t, f.ImportInto(s))
+
+	l, _ := s.TrustLevel("alice@example.org", "prpl-jabber", "bob@example.org", f.Entries[0].Fingerprint)
+	assertEquals(t, l, TrustVerified)
+	l, _ = s.TrustLevel("alice@example.org", "prpl-jabber", "carol", f.Entries[1].Fingerprint)
+	assertEquals(t, l, TrustUnverified)
+	l, _ = s.TrustLevel("alice", "prpl-irc", "erin", f.Entries[3].Fingerprint)
+	assertEquals(t, l, TrustSMPVerified)
+}
+
+func Test_Fingerprints_Find_findsTheEntryForAFingerprint(t *testing.T) {
+	f, _ := ImportFingerprints(strings.NewReader(fixtureFingerprintsFile))
+	assertEquals(t, f.Find("alice", "prpl-irc", "dave", f.Entries[1].Fingerprint), f.Entries[2])
+	assertNil(t, f.Find("alice", "prpl-irc", "bob", f.Entries[1].Fingerprint))
+}
+
+func Test_Fingerprints_canBeExportedToAndImportedFromFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "otr.fingerprints")
+	f, _ := ImportFingerprints(strings.NewReader(fixtureFingerprintsFile))
+	assertNil(t, f.ExportToFile(fname))
+
+	back, err := ImportFingerprintsFromFile(fname)
+	assertNil(t, err)
+	var out bytes.Buffer
+	back.WriteTo(&out)
+	assertEquals(t, out.String(), fixtureFingerprintsFile)
+
+	_, err = ImportFingerprintsFromFile(filepath.Join(dir, "missing"))
+	assertNotNil(t, err)
+}
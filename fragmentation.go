@@ -47,7 +47,8 @@ func (c *Conversation) fragment(data encodedMessage, fraglen uint16) []ValidMess
 		return []ValidMessage{ValidMessage(data)}
 	}
 
-	fakeHeader := c.version.fragmentPrefix(1, 1, c.ourInstanceTag, c.theirInstanceTag)
+	id := fragmentIdentifier(data)
+	fakeHeader := c.version.fragmentPrefix(1, 1, id, c.ourInstanceTag, c.theirInstanceTag)
 	realFraglen := (fraglen - uint16(len(fakeHeader))) - 1
 
 	if realFraglen <= 0 {
@@ -57,7 +58,7 @@ func (c *Conversation) fragment(data encodedMessage, fraglen uint16) []ValidMess
 	numFragments := (l / int(realFraglen)) + 1
 	ret := make([]ValidMessage, numFragments)
 	for i := 0; i < numFragments; i++ {
-		prefix := c.version.fragmentPrefix(i, numFragments, c.ourInstanceTag, c.theirInstanceTag)
+		prefix := c.version.fragmentPrefix(i, numFragments, id, c.ourInstanceTag, c.theirInstanceTag)
 		ret[i] = append(append(prefix, fragmentData(data, i, realFraglen, uint16(l))...), fragmentSeparator[0])
 	}
 	return ret
@@ -2,6 +2,7 @@ package otr3
 
 import (
 	"crypto/rand"
+	"fmt"
 	"testing"
 )
 
@@ -91,6 +92,57 @@ func Test_fragment_returnsFragmentsForNeededFragmentationForV2(t *testing.T) {
 	})
 }
 
+func Test_fragment_returnsFragmentsWithAnIdentifierForV4(t *testing.T) {
+	ctx := newConversation(otrV4{}, rand.Reader)
+	ctx.ourInstanceTag = defaultInstanceTag
+	ctx.theirInstanceTag = defaultInstanceTag + 2
+
+	data := []byte("one one one two two two three three three four four four five five five")
+	id := fmt.Sprintf("%08x", fragmentIdentifier(data))
+
+	res := ctx.fragment(data, 60)
+	assertEquals(t, len(res), 5)
+	assertDeepEquals(t, res[0], ValidMessage("?OTR|"+id+"|00000100|00000102,00001,00005,one one one two,"))
+	assertDeepEquals(t, res[4], ValidMessage("?OTR|"+id+"|00000100|00000102,00005,00005,e five five,"))
+}
+
+func Test_isFragmented_recognizesV4AndV3FragmentsWhenRunningV4(t *testing.T) {
+	assertTrue(t, otrV4{}.isFragmented([]byte("?OTR|0a0b0c0d|00000100|00000102,00001,00003,one ,")))
+	assertTrue(t, otrV4{}.isFragmented([]byte("?OTR|00000100|00000102,00001,00011,one ,")))
+}
+
+func Test_receiveFragment_reassemblesV4Fragments(t *testing.T) {
+	sender := newConversation(otrV4{}, rand.Reader)
+	sender.ourInstanceTag = defaultInstanceTag + 2
+	sender.theirInstanceTag = defaultInstanceTag
+
+	receiver := newConversation(otrV4{}, rand.Reader)
+	receiver.ourInstanceTag = defaultInstanceTag
+	receiver.theirInstanceTag = defaultInstanceTag + 2
+
+	data := []byte("one one one two two two three three three four four four five five five")
+
+	fctx := fragmentationContext{}
+	var err error
+	for _, f := range sender.fragment(data, 60) {
+		fctx, err = receiver.receiveFragment(fctx, f)
+		assertNil(t, err)
+	}
+
+	assertTrue(t, fragmentsFinished(fctx))
+	assertDeepEquals(t, fctx.frag, data)
+}
+
+func Test_parseOTRv4FragmentInstanceTags_returnsTheInstanceTags(t *testing.T) {
+	s, r, ok := parseOTRv4FragmentInstanceTags([]byte("?OTR|0a0b0c0d|00000100|00000102,00001,00003,one ,"))
+	assertTrue(t, ok)
+	assertEquals(t, s, uint32(0x100))
+	assertEquals(t, r, uint32(0x102))
+
+	_, _, ok = parseOTRv4FragmentInstanceTags([]byte("?OTR|00000100|00000102,00001,00011,one ,"))
+	assertFalse(t, ok)
+}
+
 func Test_receiveFragment_returnsANewFragmentationContextForANewMessage(t *testing.T) {
 	c := newConversation(otrV2{}, rand.Reader)
 	data := []byte("?OTR,00001,00004,one ,")
@@ -267,7 +319,7 @@ func Test_receiveFragment_returnsErrorIfTheFragmentIsNotCorrect(t *testing.T) {
 func Test_parseFragmentPrefix_resolveVersion2IfNotDefined(t *testing.T) {
 	fragment := []byte("?OTR,00001,00004,?OTR:AAICAAAAxJh7YMX8vCry1O+3ewL88,")
 
-	c := &Conversation{Policies: policies(allowV2)}
+	c := &Conversation{Policies: Policy(allowV2)}
 	c.parseFragmentPrefix(fragment)
 
 	assertEquals(t, c.version, otrV2{})
@@ -277,7 +329,7 @@ func Test_parseFragmentPrefix_resolveVersion2IfNotDefined(t *testing.T) {
 func Test_parseFragmentPrefix_rejectsVersion2IfNotAllowedByThePolicy(t *testing.T) {
 	fragment := []byte("?OTR,00001,00004,?OTR:AAICAAAAxJh7YMX8vCry1O+3ewL88,")
 
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	_, ignore, ok := c.parseFragmentPrefix(fragment)
 
 	assertEquals(t, ok, false)
@@ -289,7 +341,7 @@ func Test_parseFragmentPrefix_rejectsVersion2IfNotAllowedByThePolicy(t *testing.
 func Test_parseFragmentPrefix_resolveVersion3IfNotDefined(t *testing.T) {
 	fragment := []byte("?OTR|5a73a599|27e31597,00001,00003,?OTR:AAMDJ+MVmSfjF,")
 
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	c.parseFragmentPrefix(fragment)
 
 	assertEquals(t, c.version, otrV3{})
@@ -298,7 +350,7 @@ func Test_parseFragmentPrefix_resolveVersion3IfNotDefined(t *testing.T) {
 func Test_parseFragmentPrefix_rejectsVersion3IfNotAllowedByThePolicy(t *testing.T) {
 	fragment := []byte("?OTR|5a73a599|27e31597,00001,00003,?OTR:AAMDJ+MVmSfjF,")
 
-	c := &Conversation{Policies: policies(allowV2)}
+	c := &Conversation{Policies: Policy(allowV2)}
 	_, ignore, ok := c.parseFragmentPrefix(fragment)
 
 	assertEquals(t, ok, false)
@@ -51,12 +51,12 @@ func Test_AKE_forVersion3And2InThePolicy(t *testing.T) {
 	alice := &Conversation{Rand: rand.Reader}
 	alice.SetOurKeys([]PrivateKey{alicePrivateKey})
 	alice.ourCurrentKey = alicePrivateKey
-	alice.Policies = policies(allowV2 | allowV3)
+	alice.Policies = Policy(allowV2 | allowV3)
 
 	bob := &Conversation{Rand: rand.Reader}
 	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
 	bob.ourCurrentKey = bobPrivateKey
-	bob.Policies = policies(allowV2 | allowV3)
+	bob.Policies = Policy(allowV2 | allowV3)
 
 	var toSend []ValidMessage
 	var err error
@@ -107,12 +107,12 @@ func Test_AKE_withVersion3ButWithoutVersion2InThePolicy(t *testing.T) {
 	alice := &Conversation{Rand: rand.Reader}
 	alice.SetOurKeys([]PrivateKey{alicePrivateKey})
 	alice.ourCurrentKey = alicePrivateKey
-	alice.Policies = policies(allowV3)
+	alice.Policies = Policy(allowV3)
 
 	bob := &Conversation{Rand: rand.Reader}
 	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
 	bob.ourCurrentKey = bobPrivateKey
-	bob.Policies = policies(allowV3)
+	bob.Policies = Policy(allowV3)
 
 	var toSend []ValidMessage
 	var err error
@@ -164,11 +164,11 @@ func Test_processDataMessageShouldExtractData(t *testing.T) {
 	var err error
 
 	alice := &Conversation{Rand: rand.Reader}
-	alice.Policies = policies(allowV2 | allowV3)
+	alice.Policies = Policy(allowV2 | allowV3)
 	alice.SetOurKeys([]PrivateKey{alicePrivateKey})
 
 	bob := &Conversation{Rand: rand.Reader}
-	bob.Policies = policies(allowV2 | allowV3)
+	bob.Policies = Policy(allowV2 | allowV3)
 	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
 
 	msg := []byte("?OTRv3?")
@@ -219,11 +219,11 @@ func Test_startingAKE_shouldNotBreakTheEncryptedChannel(t *testing.T) {
 	var err error
 
 	alice := &Conversation{Rand: rand.Reader}
-	alice.Policies = policies(allowV2 | allowV3)
+	alice.Policies = Policy(allowV2 | allowV3)
 	alice.SetOurKeys([]PrivateKey{alicePrivateKey})
 
 	bob := &Conversation{Rand: rand.Reader}
-	bob.Policies = policies(allowV2 | allowV3)
+	bob.Policies = Policy(allowV2 | allowV3)
 	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
 
 	//Alice send Bob queryMsg
@@ -3,14 +3,30 @@ package otr3
 import "time"
 
 // How long after sending a packet should we wait to send a heartbeat?
-const heartbeatInterval = 60 * time.Second
+const defaultHeartbeatInterval = 60 * time.Second
 
 type heartbeatContext struct {
-	lastSent time.Time
+	lastSent     time.Time
+	lastReceived time.Time
+	interval     time.Duration
+}
+
+// SetHeartbeatInterval sets how long after sending a message we should wait before sending a heartbeat.
+// A zero duration restores the default of 60 seconds.
+func (c *Conversation) SetHeartbeatInterval(d time.Duration) {
+	c.heartbeat.interval = d
+}
+
+func (c *Conversation) heartbeatInterval() time.Duration {
+	if c.heartbeat.interval == 0 {
+		return defaultHeartbeatInterval
+	}
+	return c.heartbeat.interval
 }
 
 func (c *Conversation) updateLastSent() {
-	c.heartbeat.lastSent = time.Now()
+	c.heartbeat.lastSent = c.now()
+	c.keyRotation.receivedSinceSent = 0
 }
 
 func (c *Conversation) maybeHeartbeat(plain MessagePlaintext, toSend messageWithHeader, err error) (MessagePlaintext, []messageWithHeader, error) {
@@ -26,17 +42,18 @@ func (c *Conversation) potentialHeartbeat(plain MessagePlaintext) (toSend messag
 		return
 	}
 
-	now := time.Now()
-	if !c.heartbeat.lastSent.Before(now.Add(-heartbeatInterval)) {
+	now := c.now()
+	c.heartbeat.lastReceived = now
+	c.keyRotation.receivedSinceSent++
+	if !c.heartbeat.lastSent.Before(now.Add(-c.acknowledgeInterval())) && !c.tooManyMessagesUnacknowledged() {
 		return
 	}
 
-	dataMsg, _, err := c.genDataMsgWithFlag(nil, messageFlagIgnoreUnreadable)
-	if err != nil {
-		return nil, err
-	}
+	return c.heartbeatMessage()
+}
 
-	toSend, err = c.wrapMessageHeader(msgTypeData, dataMsg.serialize(c.version))
+func (c *Conversation) heartbeatMessage() (toSend messageWithHeader, err error) {
+	toSend, _, err = c.genDataMsgWithHeader(nil, messageFlagIgnoreUnreadable)
 	if err != nil {
 		return nil, err
 	}
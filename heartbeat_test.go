@@ -81,3 +81,29 @@ func Test_potentialHeartbeat_returnsAnErrorIfWeCantPutTogetherAMessage(t *testin
 	_, err := c.potentialHeartbeat(plain)
 	assertDeepEquals(t, err, newOtrConflictError("invalid key id for local peer"))
 }
+
+func Test_heartbeatInterval_usesTheDefaultUnlessSet(t *testing.T) {
+	c := &Conversation{}
+	assertEquals(t, c.heartbeatInterval(), defaultHeartbeatInterval)
+
+	c.SetHeartbeatInterval(5 * time.Second)
+	assertEquals(t, c.heartbeatInterval(), 5*time.Second)
+}
+
+func Test_potentialHeartbeat_usesTheConfiguredIntervalAndClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c := bobContextAfterAKE()
+	c.msgState = encrypted
+	c.SetClock(clock)
+	c.SetHeartbeatInterval(5 * time.Minute)
+	c.updateLastSent()
+	plain := []byte("Foo plain")
+
+	clock.Advance(4 * time.Minute)
+	ret, _ := c.potentialHeartbeat(plain)
+	assertNil(t, ret)
+
+	clock.Advance(2 * time.Minute)
+	ret, _ = c.potentialHeartbeat(plain)
+	assertNotNil(t, ret)
+}
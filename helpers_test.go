@@ -1,8 +1,10 @@
 package otr3
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"io"
+	"io/ioutil"
 	"math/big"
 	"reflect"
 	"testing"
@@ -102,7 +104,7 @@ func parseIntoPrivateKey(hexString string) PrivateKey {
 }
 
 func newConversation(v otrVersion, rand io.Reader) *Conversation {
-	var p policy
+	var p Policy
 	switch v {
 	case otrV3{}:
 		p = allowV3
@@ -119,7 +121,7 @@ func newConversation(v otrVersion, rand io.Reader) *Conversation {
 			state: smpStateExpect1{},
 		},
 		ake:              akeNotStarted,
-		Policies:         policies(p),
+		Policies:         Policy(p),
 		fragmentSize:     65535, //we are not testing fragmentation by default
 		ourInstanceTag:   0x101, //every conversation should be able to talk to each other
 		theirInstanceTag: 0x101,
@@ -184,3 +186,76 @@ func (c *Conversation) doesntExpectSecurityEvent(t *testing.T, f func()) {
 
 	f()
 }
+
+// conversationPair is a test utility that creates two conversations with the given policy. Alice and bob use the
+// given keys, or only their DSA keys if none are given, and the given clock, or the real time if it is nil.
+func conversationPair(p Policy, clock Clock, aliceKeys, bobKeys []PrivateKey) (alice, bob *Conversation) {
+	if aliceKeys == nil {
+		aliceKeys = []PrivateKey{alicePrivateKey}
+	}
+	if bobKeys == nil {
+		bobKeys = []PrivateKey{bobPrivateKey}
+	}
+
+	alice = &Conversation{Rand: rand.Reader, Policies: p}
+	alice.SetOurKeys(aliceKeys)
+
+	bob = &Conversation{Rand: rand.Reader, Policies: p}
+	bob.SetOurKeys(bobKeys)
+
+	if clock != nil {
+		alice.SetClock(clock)
+		bob.SetClock(clock)
+	}
+
+	return alice, bob
+}
+
+// encryptedConversationPair is a test utility that creates two conversations and runs the AKE between them,
+// failing the test if they don't both end up in an encrypted state
+func encryptedConversationPair(t *testing.T) (alice, bob *Conversation) {
+	alice, bob = conversationPair(allowV2|allowV3, nil, nil, nil)
+	runAKE(t, alice, bob)
+	return alice, bob
+}
+
+// runAKE is a test utility that lets alice start an AKE with bob and delivers all messages between them until it is done
+func runAKE(t *testing.T, alice, bob *Conversation) {
+	exchangeAll(t, alice, bob, []ValidMessage{alice.QueryMessage()})
+
+	if !alice.IsEncrypted() || !bob.IsEncrypted() {
+		t.Fatalf("Expected the AKE to finish with both conversations encrypted")
+	}
+}
+
+// exchangeAll delivers the messages from one conversation to the other, and all answers back and forth until there
+// are none left. It returns how many answers the receiving conversation sent.
+func exchangeAll(t *testing.T, from, to *Conversation, msgs []ValidMessage) (answers int) {
+	for i := 0; i < 5 && len(msgs) > 0; i++ {
+		toFrom := deliverAll(t, to, msgs)
+		answers += len(toFrom)
+		msgs = deliverAll(t, from, toFrom)
+	}
+	return
+}
+
+func deliverAll(t *testing.T, to *Conversation, msgs []ValidMessage) []ValidMessage {
+	var ret []ValidMessage
+	for _, m := range msgs {
+		_, toSend, err := to.Receive(m)
+		if err != nil {
+			t.Fatalf("Unexpected error when delivering message: %v", err)
+		}
+		ret = append(ret, toSend...)
+	}
+	return ret
+}
+
+// tempDir creates a new temporary directory, which the caller has to remove
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "otr3")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %v", err)
+	}
+	return dir
+}
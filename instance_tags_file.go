@@ -0,0 +1,178 @@
+package otr3
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The header libotr writes at the start of every instance tags file
+var instanceTagsFileHeader = []string{
+	"# WARNING! You shouldn't copy this file to another computer. It is unnecessary\n",
+	"#          and can cause problems.\n",
+}
+
+// InstanceTagEntry is one line of a libotr instance tags file
+type InstanceTagEntry struct {
+	Account  string
+	Protocol string
+	Tag      uint32
+
+	// raw is the line this entry was read from, and read is the entry as it was read. As long as the entry
+	// hasn't changed, raw is written back instead of the formatted entry.
+	raw  string
+	read *InstanceTagEntry
+}
+
+// InstanceTags is the content of a libotr instance tags file, usually called otr.instance_tags.
+// Every line holds our account, the protocol and our instance tag in hex, separated by tabs.
+type InstanceTags struct {
+	Entries []*InstanceTagEntry
+
+	header []string
+	layout libotrFileLayout
+}
+
+// NewInstanceTags creates an empty instance tags file with the same header as libotr writes
+func NewInstanceTags() *InstanceTags {
+	return &InstanceTags{header: instanceTagsFileHeader}
+}
+
+func (e *InstanceTagEntry) sameAs(o *InstanceTagEntry) bool {
+	return e.Account == o.Account && e.Protocol == o.Protocol && e.Tag == o.Tag
+}
+
+func (e *InstanceTagEntry) format() string {
+	return fmt.Sprintf("%s\t%s\t%08x\n", e.Account, e.Protocol, e.Tag)
+}
+
+func parseInstanceTagEntry(line string, n int) (*InstanceTagEntry, error) {
+	fields := strings.Split(lineContent(line), "\t")
+	if len(fields) != 3 {
+		return nil, lineErrorf(n, "expected 3 tab separated fields, found %d", len(fields))
+	}
+
+	tag, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil || uint32(tag) < minValidInstanceTag {
+		return nil, lineErrorf(n, "invalid instance tag %q", fields[2])
+	}
+
+	e := &InstanceTagEntry{
+		Account:  fields[0],
+		Protocol: fields[1],
+		Tag:      uint32(tag),
+		raw:      line,
+	}
+	read := *e
+	e.read = &read
+
+	return e, nil
+}
+
+// ImportInstanceTags will read the libotr formatted instance tags given. Errors for malformed lines contain the line number.
+func ImportInstanceTags(r io.Reader) (*InstanceTags, error) {
+	lines, err := splitLibotrFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &InstanceTags{}
+	var pending []string
+	for i, line := range lines {
+		if isLibotrCommentOrBlank(lineContent(line)) {
+			pending = append(pending, line)
+			continue
+		}
+
+		e, err := parseInstanceTagEntry(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+
+		f.layout.keep(pending, e)
+		pending = nil
+		f.Entries = append(f.Entries, e)
+	}
+	f.layout.trailer = pending
+
+	return f, nil
+}
+
+// ImportInstanceTagsFromFile will read the libotr formatted instance tags file given
+func ImportInstanceTagsFromFile(fname string) (*InstanceTags, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ImportInstanceTags(file)
+}
+
+// WriteTo writes the instance tags in libotr format. Instance tags that were imported are written back exactly as they
+// were read unless they have been changed.
+func (f *InstanceTags) WriteTo(w io.Writer) (int64, error) {
+	lw := &libotrLineWriter{w: w}
+	lw.writeAll(f.header)
+	for _, e := range f.Entries {
+		lw.writeAll(f.layout.before[e])
+		if e.read != nil && e.sameAs(e.read) {
+			lw.write(e.raw)
+		} else {
+			lw.write(e.format())
+		}
+	}
+	lw.writeAll(f.layout.trailer)
+
+	return lw.n, lw.err
+}
+
+// ExportToFile will create the named file (or truncate it) and write the instance tags to it in libotr format
+func (f *InstanceTags) ExportToFile(fname string) error {
+	return exportToFile(fname, f.WriteTo)
+}
+
+// Get returns the instance tag for the account, and not ok if there is none
+func (f *InstanceTags) Get(account, protocol string) (uint32, bool) {
+	for _, e := range f.Entries {
+		if e.Account == account && e.Protocol == protocol {
+			return e.Tag, true
+		}
+	}
+	return 0, false
+}
+
+// Set sets the instance tag for the account, adding an entry if there is none
+func (f *InstanceTags) Set(account, protocol string, tag uint32) {
+	for _, e := range f.Entries {
+		if e.Account == account && e.Protocol == protocol {
+			e.Tag = tag
+			return
+		}
+	}
+	f.Entries = append(f.Entries, &InstanceTagEntry{Account: account, Protocol: protocol, Tag: tag})
+}
+
+// ApplyTo sets all the instance tags in the given user state
+func (f *InstanceTags) ApplyTo(u *UserState) {
+	for _, e := range f.Entries {
+		u.SetInstanceTag(e.Account, e.Protocol, e.Tag)
+	}
+}
+
+// UpdateFrom sets the instance tags of all accounts of the given user state that have one
+func (f *InstanceTags) UpdateFrom(u *UserState) {
+	ids := make([]accountID, 0, len(u.instanceTags))
+	for id := range u.instanceTags {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].name < ids[j].name || (ids[i].name == ids[j].name && ids[i].protocol < ids[j].protocol)
+	})
+
+	for _, id := range ids {
+		f.Set(id.name, id.protocol, u.instanceTags[id])
+	}
+}
@@ -0,0 +1,102 @@
+package otr3
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixtureInstanceTagsFile = "# WARNING! You shouldn't copy this file to another computer. It is unnecessary\n" +
+	"#          and can cause problems.\n" +
+	"alice@example.org\tprpl-jabber\t7c4a9b01\n" +
+	"alice\tprpl-irc\t00000101\n"
+
+func Test_ImportInstanceTags_readsAllEntries(t *testing.T) {
+	f, err := ImportInstanceTags(strings.NewReader(fixtureInstanceTagsFile))
+	assertNil(t, err)
+	assertEquals(t, len(f.Entries), 2)
+
+	tag, ok := f.Get("alice@example.org", "prpl-jabber")
+	assertTrue(t, ok)
+	assertEquals(t, tag, uint32(0x7c4a9b01))
+
+	tag, ok = f.Get("alice", "prpl-irc")
+	assertTrue(t, ok)
+	assertEquals(t, tag, uint32(0x101))
+
+	_, ok = f.Get("alice", "prpl-jabber")
+	assertFalse(t, ok)
+}
+
+func Test_InstanceTags_roundTripsByteForByte(t *testing.T) {
+	for _, content := range []string{
+		fixtureInstanceTagsFile,
+		"alice\tprpl-irc\tABCDEF01\r\n# trailing comment",
+		"",
+	} {
+		f, err := ImportInstanceTags(strings.NewReader(content))
+		assertNil(t, err)
+
+		var out bytes.Buffer
+		f.WriteTo(&out)
+		assertEquals(t, out.String(), content)
+	}
+}
+
+func Test_NewInstanceTags_writesTheSameFormatAsLibotr(t *testing.T) {
+	f := NewInstanceTags()
+	f.Set("alice@example.org", "prpl-jabber", 0x7c4a9b01)
+	f.Set("alice", "prpl-irc", 0x200)
+	f.Set("alice", "prpl-irc", 0x101)
+
+	var out bytes.Buffer
+	f.WriteTo(&out)
+	assertEquals(t, out.String(), fixtureInstanceTagsFile)
+}
+
+func Test_ImportInstanceTags_returnsErrorsWithLineNumbers(t *testing.T) {
+	_, err := ImportInstanceTags(strings.NewReader("# comment\nalice\tprpl-irc\n"))
+	assertDeepEquals(t, err, newOtrError("line 2: expected 3 tab separated fields, found 2"))
+
+	_, err = ImportInstanceTags(strings.NewReader("alice\tprpl-irc\t00000101\nalice\tprpl-jabber\tnothex\n"))
+	assertDeepEquals(t, err, newOtrError("line 2: invalid instance tag \"nothex\""))
+
+	_, err = ImportInstanceTags(strings.NewReader("alice\tprpl-irc\t000000ff\n"))
+	assertDeepEquals(t, err, newOtrError("line 1: invalid instance tag \"000000ff\""))
+}
+
+func Test_InstanceTags_canBeMovedBetweenFilesAndUserStates(t *testing.T) {
+	f, _ := ImportInstanceTags(strings.NewReader(fixtureInstanceTagsFile))
+	u := NewUserState(nil)
+	f.ApplyTo(u)
+
+	tag, _ := u.InstanceTag("alice@example.org", "prpl-jabber")
+	assertEquals(t, tag, uint32(0x7c4a9b01))
+
+	u.SetInstanceTag("bob", "prpl-irc", 0x4242)
+	f.UpdateFrom(u)
+
+	tag, ok := f.Get("bob", "prpl-irc")
+	assertTrue(t, ok)
+	assertEquals(t, tag, uint32(0x4242))
+
+	var out bytes.Buffer
+	f.WriteTo(&out)
+	assertEquals(t, out.String(), fixtureInstanceTagsFile+"bob\tprpl-irc\t00004242\n")
+}
+
+func Test_InstanceTags_canBeExportedToAndImportedFromFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "otr.instance_tags")
+	f, _ := ImportInstanceTags(strings.NewReader(fixtureInstanceTagsFile))
+	assertNil(t, f.ExportToFile(fname))
+
+	back, err := ImportInstanceTagsFromFile(fname)
+	assertNil(t, err)
+	var out bytes.Buffer
+	back.WriteTo(&out)
+	assertEquals(t, out.String(), fixtureInstanceTagsFile)
+}
@@ -0,0 +1,60 @@
+package otr3
+
+import "golang.org/x/crypto/sha3"
+
+// The usage IDs that separate the different uses of the OTRv4 key derivation function
+const (
+	usageFingerprint             = byte(0x00)
+	usageThirdBraceKey           = byte(0x01)
+	usageBraceKey                = byte(0x02)
+	usageSharedSecret            = byte(0x03)
+	usageSSID                    = byte(0x04)
+	usageAuthRBobClientProfile   = byte(0x05)
+	usageAuthRAliceClientProfile = byte(0x06)
+	usageAuthRPhi                = byte(0x07)
+	usageAuthIBobClientProfile   = byte(0x08)
+	usageAuthIAliceClientProfile = byte(0x09)
+	usageAuthIPhi                = byte(0x0A)
+	usageFirstRootKey            = byte(0x0B)
+	usageFirstChainKey           = byte(0x0C)
+	usageFirstECDHSecret         = byte(0x0D)
+	usageFirstDHSecret           = byte(0x0E)
+	usageRootKey                 = byte(0x10)
+	usageChainKey                = byte(0x11)
+	usageNextChainKey            = byte(0x12)
+	usageMessageKey              = byte(0x13)
+	usageMACKey                  = byte(0x14)
+	usageExtraSymmetricKey       = byte(0x15)
+	usageDataMessageSections     = byte(0x16)
+	usageAuthenticator           = byte(0x17)
+	usageRingSignatureChallenge  = byte(0x1D)
+	usageTransitionalSignature   = byte(0x1E)
+)
+
+var kdfDomain = []byte("OTRv4")
+
+// kdf is KDF_1 from OTRv4: SHAKE-256 over the domain, the usage ID and the values, with an output of the given size
+func kdf(usage byte, size int, values ...[]byte) []byte {
+	return kdfWithDomain(kdfDomain, usage, size, values...)
+}
+
+// kdfWithDomain is KDF_1 with another domain than the one of OTRv4, for derivations that are not part of OTRv4
+func kdfWithDomain(domain []byte, usage byte, size int, values ...[]byte) []byte {
+	h := sha3.NewShake256()
+	h.Write(domain)
+	h.Write([]byte{usage})
+	for _, v := range values {
+		h.Write(v)
+	}
+
+	out := make([]byte, size)
+	h.Read(out)
+	return out
+}
+
+// hashToScalar derives a scalar modulo the order of Ed448 from the values
+func hashToScalar(usage byte, values ...[]byte) *ed448Scalar {
+	h := kdf(usage, 2*ed448ScalarLen, values...)
+	defer wipeBytes(h)
+	return reduceEd448Scalar(h)
+}
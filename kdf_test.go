@@ -0,0 +1,13 @@
+package otr3
+
+import "testing"
+
+func Test_kdf_separatesUsages(t *testing.T) {
+	assertNotEquals(t, string(kdf(usageSSID, 8, []byte("hello"))), string(kdf(usageFingerprint, 8, []byte("hello"))))
+	assertDeepEquals(t, kdf(usageSSID, 64, []byte("hello"))[:8], kdf(usageSSID, 8, []byte("hello")))
+}
+
+func Test_kdfWithDomain_separatesDomains(t *testing.T) {
+	assertDeepEquals(t, kdfWithDomain(kdfDomain, usageSSID, 8, []byte("hello")), kdf(usageSSID, 8, []byte("hello")))
+	assertNotEquals(t, string(kdfWithDomain(exporterKDFDomain, usageSSID, 8, []byte("hello"))), string(kdf(usageSSID, 8, []byte("hello"))))
+}
@@ -177,6 +177,8 @@ func (k *keyManagementContext) revealMACKeysForOurPreviousKeyID() {
 }
 
 func (c *Conversation) rotateKeys(dataMessage dataMsg) error {
+	defer c.recordKeyRotation(c.keys.ourKeyID, c.keys.theirKeyID)
+
 	if err := c.keys.rotateOurKeys(dataMessage.recipientKeyID, c.rand()); err != nil {
 		return err
 	}
@@ -0,0 +1,132 @@
+package otr3
+
+import "time"
+
+// KeyRotationPolicy decides when we acknowledge the newest DH key of the peer with an ignore-unreadable data message,
+// even if the user has nothing to say. In OTRv3 a side can only start using a new DH key when the other side has
+// acknowledged it, so without these acknowledgements a peer that is the only one sending would keep encrypting with
+// the same key pair. When we are the one sending, and have used our key pair for twice as many messages or twice as
+// long as the policy allows without the peer acknowledging our next key, the session is refreshed with a new AKE
+// instead, which replaces all keys. The twice leaves the peer time to acknowledge the key, which is much cheaper.
+// A zero value in a field disables that limit, in which case only the heartbeat interval applies.
+type KeyRotationPolicy struct {
+	// AfterMessages is the number of messages received without sending anything after which we acknowledge the keys
+	AfterMessages int
+	// After is the time after which we acknowledge the keys when we have received messages without sending anything.
+	// It only makes a difference if it is shorter than the heartbeat interval.
+	After time.Duration
+}
+
+// KeyStatistics describes the DH keys currently used in an OTRv3 conversation
+type KeyStatistics struct {
+	OurKeyID   uint32
+	TheirKeyID uint32
+	// OurKeyAge is how long ago we started using our current key pair
+	OurKeyAge time.Duration
+	// TheirKeyAge is how long ago we started using the current key of the peer
+	TheirKeyAge time.Duration
+	// MessagesSentWithOurKey is the number of data messages we have encrypted with our current key pair
+	MessagesSentWithOurKey int
+}
+
+type keyRotationContext struct {
+	policy                 KeyRotationPolicy
+	receivedSinceSent      int
+	ourKeyRotated          time.Time
+	theirKeyRotated        time.Time
+	messagesSentWithOurKey int
+
+	// sentSinceReplaced and lastReplaced count from when our key pair was last rotated, or a refresh was started to
+	// replace it
+	sentSinceReplaced int
+	lastReplaced      time.Time
+}
+
+// SetKeyRotationPolicy sets when we should acknowledge the keys of the peer, so that they can be rotated, and when we
+// should refresh the session because the peer hasn't acknowledged ours
+func (c *Conversation) SetKeyRotationPolicy(p KeyRotationPolicy) {
+	c.keyRotation.policy = p
+}
+
+// KeyStatistics returns information about the DH keys of the conversation. It returns not ok if the conversation is not
+// encrypted, or uses OTRv4, where the double ratchet takes care of the keys.
+func (c *Conversation) KeyStatistics() (stats KeyStatistics, ok bool) {
+	if c.msgState != encrypted || c.v4 != nil {
+		return KeyStatistics{}, false
+	}
+
+	now := c.now()
+	return KeyStatistics{
+		// The key we send with is the one before the newest, which we have only announced
+		OurKeyID:               c.keys.ourKeyID - 1,
+		TheirKeyID:             c.keys.theirKeyID,
+		OurKeyAge:              now.Sub(c.keyRotation.ourKeyRotated),
+		TheirKeyAge:            now.Sub(c.keyRotation.theirKeyRotated),
+		MessagesSentWithOurKey: c.keyRotation.messagesSentWithOurKey,
+	}, true
+}
+
+// acknowledgeInterval returns how long after our last sent message we acknowledge the keys of the peer
+func (c *Conversation) acknowledgeInterval() time.Duration {
+	interval := c.heartbeatInterval()
+	if after := c.keyRotation.policy.After; after > 0 && after < interval {
+		return after
+	}
+	return interval
+}
+
+func (c *Conversation) tooManyMessagesUnacknowledged() bool {
+	n := c.keyRotation.policy.AfterMessages
+	return n > 0 && c.keyRotation.receivedSinceSent >= n
+}
+
+// ourKeysNeedReplacing returns true if we have used our key pair for too many messages without the peer letting us
+// rotate it
+func (c *Conversation) ourKeysNeedReplacing() bool {
+	n := c.keyRotation.policy.AfterMessages
+	if n > 0 && c.keyRotation.sentSinceReplaced >= 2*n {
+		return true
+	}
+
+	deadline, ok := c.ourKeysReplacementDeadline()
+	return ok && !c.now().Before(deadline)
+}
+
+// ourKeysReplacementDeadline returns when we have used our key pair for too long, if there is such a limit and we have
+// sent messages with it
+func (c *Conversation) ourKeysReplacementDeadline() (time.Time, bool) {
+	after := c.keyRotation.policy.After
+	if after == 0 || c.msgState != encrypted || c.keyRotation.sentSinceReplaced == 0 {
+		return time.Time{}, false
+	}
+	return c.keyRotation.lastReplaced.Add(2 * after), true
+}
+
+func (c *Conversation) countMessageForKeyRotation() {
+	c.keyRotation.messagesSentWithOurKey++
+	c.keyRotation.sentSinceReplaced++
+}
+
+func (c *Conversation) markOurKeysReplaced() {
+	c.keyRotation.sentSinceReplaced = 0
+	c.keyRotation.lastReplaced = c.now()
+}
+
+func (c *Conversation) resetKeyStatistics() {
+	now := c.now()
+	c.keyRotation.ourKeyRotated = now
+	c.keyRotation.theirKeyRotated = now
+	c.keyRotation.messagesSentWithOurKey = 0
+	c.markOurKeysReplaced()
+}
+
+func (c *Conversation) recordKeyRotation(ourKeyID, theirKeyID uint32) {
+	if c.keys.ourKeyID != ourKeyID {
+		c.keyRotation.ourKeyRotated = c.now()
+		c.keyRotation.messagesSentWithOurKey = 0
+		c.markOurKeysReplaced()
+	}
+	if c.keys.theirKeyID != theirKeyID {
+		c.keyRotation.theirKeyRotated = c.now()
+	}
+}
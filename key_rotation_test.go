@@ -0,0 +1,172 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+func oneSidedConversationPair(t *testing.T) (alice, bob *Conversation, clock *FakeClock) {
+	clock = fixtureClock()
+	alice, bob = conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+
+	// Otherwise bob would send a heartbeat for the first message
+	bob.updateLastSent()
+	return alice, bob, clock
+}
+
+// sendOneSided sends n messages from alice to bob, delivering whatever bob answers back to alice
+func sendOneSided(t *testing.T, alice, bob *Conversation, n int) (answers int) {
+	for i := 0; i < n; i++ {
+		msg, _ := alice.Send(ValidMessage("broadcast"))
+		answers += exchangeAll(t, alice, bob, msg)
+	}
+	return
+}
+
+func Test_oneSidedTraffic_doesntRotateOurKeysWithoutAKeyRotationPolicy(t *testing.T) {
+	alice, bob, _ := oneSidedConversationPair(t)
+	keyID := alice.keys.ourKeyID
+
+	answers := sendOneSided(t, alice, bob, 10)
+
+	assertEquals(t, answers, 0)
+	assertEquals(t, alice.keys.ourKeyID, keyID)
+}
+
+func Test_oneSidedTraffic_rotatesOurKeysAfterTheConfiguredNumberOfMessages(t *testing.T) {
+	alice, bob, _ := oneSidedConversationPair(t)
+	bob.SetKeyRotationPolicy(KeyRotationPolicy{AfterMessages: 3})
+	keyID := alice.keys.ourKeyID
+
+	assertEquals(t, sendOneSided(t, alice, bob, 2), 0)
+	assertEquals(t, alice.keys.ourKeyID, keyID)
+
+	assertEquals(t, sendOneSided(t, alice, bob, 1), 1)
+	assertEquals(t, alice.keys.ourKeyID, keyID+1)
+
+	sendOneSided(t, alice, bob, 3)
+	assertEquals(t, alice.keys.ourKeyID, keyID+2)
+}
+
+func Test_oneSidedTraffic_rotatesOurKeysAfterTheConfiguredTime(t *testing.T) {
+	alice, bob, clock := oneSidedConversationPair(t)
+	bob.SetKeyRotationPolicy(KeyRotationPolicy{After: 10 * time.Second})
+	keyID := alice.keys.ourKeyID
+
+	assertEquals(t, sendOneSided(t, alice, bob, 1), 0)
+	clock.Advance(11 * time.Second)
+	assertEquals(t, sendOneSided(t, alice, bob, 1), 1)
+
+	assertEquals(t, alice.keys.ourKeyID, keyID+1)
+}
+
+func Test_Tick_acknowledgesTheKeysAfterTheKeyRotationTime(t *testing.T) {
+	alice, bob, clock := oneSidedConversationPair(t)
+	bob.SetKeyRotationPolicy(KeyRotationPolicy{After: 10 * time.Second})
+	keyID := alice.keys.ourKeyID
+
+	clock.Advance(time.Second)
+	sendOneSided(t, alice, bob, 1)
+	deadline, _ := bob.NextDeadline()
+	assertEquals(t, deadline, fixtureClock().Now().Add(10*time.Second))
+
+	clock.Advance(9 * time.Second)
+	msgs, _ := bob.Tick()
+	assertEquals(t, len(msgs), 1)
+	deliverAll(t, alice, msgs)
+
+	assertEquals(t, alice.keys.ourKeyID, keyID+1)
+}
+
+func Test_oneSidedTraffic_refreshesTheSessionWhenThePeerDoesntRotateOurKeys(t *testing.T) {
+	alice, bob, _ := oneSidedConversationPair(t)
+	alice.SetKeyRotationPolicy(KeyRotationPolicy{AfterMessages: 2})
+	ssid := alice.GetSSID()
+
+	sendOneSided(t, alice, bob, 3)
+	assertEquals(t, alice.GetSSID(), ssid)
+
+	sendOneSided(t, alice, bob, 1)
+	assertFalse(t, alice.GetSSID() == ssid)
+	assertEquals(t, alice.GetSSID(), bob.GetSSID())
+	assertEquals(t, alice.keyRotation.sentSinceReplaced, 0)
+}
+
+func Test_oneSidedTraffic_refreshesTheSessionWhenOurKeysAreTooOld(t *testing.T) {
+	alice, bob, clock := oneSidedConversationPair(t)
+	alice.SetKeyRotationPolicy(KeyRotationPolicy{After: 10 * time.Second})
+	ssid := alice.GetSSID()
+
+	sendOneSided(t, alice, bob, 1)
+	deadline, _ := alice.NextDeadline()
+	assertEquals(t, deadline, fixtureClock().Now().Add(20*time.Second))
+
+	clock.Advance(20 * time.Second)
+	msgs, err := alice.Tick()
+	assertNil(t, err)
+	exchangeAll(t, alice, bob, msgs)
+
+	assertFalse(t, alice.GetSSID() == ssid)
+	assertEquals(t, alice.GetSSID(), bob.GetSSID())
+}
+
+func Test_oneSidedTraffic_rotatesOurKeysWithoutARefreshWhenThePeerAcknowledgesThem(t *testing.T) {
+	alice, bob, _ := oneSidedConversationPair(t)
+	alice.SetKeyRotationPolicy(KeyRotationPolicy{AfterMessages: 3})
+	bob.SetKeyRotationPolicy(KeyRotationPolicy{AfterMessages: 3})
+	ssid := alice.GetSSID()
+	keyID := alice.keys.ourKeyID
+
+	sendOneSided(t, alice, bob, 10)
+
+	assertEquals(t, alice.GetSSID(), ssid)
+	assertEquals(t, alice.keys.ourKeyID, keyID+3)
+}
+
+func Test_acknowledgeInterval_onlyUsesTheKeyRotationTimeIfItIsShorter(t *testing.T) {
+	c := &Conversation{}
+	c.SetKeyRotationPolicy(KeyRotationPolicy{After: time.Hour})
+	assertEquals(t, c.acknowledgeInterval(), defaultHeartbeatInterval)
+
+	c.SetKeyRotationPolicy(KeyRotationPolicy{After: time.Second})
+	assertEquals(t, c.acknowledgeInterval(), time.Second)
+}
+
+func Test_KeyStatistics_isNotAvailableWithoutEncryption(t *testing.T) {
+	c := &Conversation{}
+
+	_, ok := c.KeyStatistics()
+
+	assertFalse(t, ok)
+}
+
+func Test_KeyStatistics_isNotAvailableForOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runDAKE(t, alice, bob)
+
+	_, ok := alice.KeyStatistics()
+
+	assertFalse(t, ok)
+}
+
+func Test_KeyStatistics_describesTheAgeAndUseOfTheKeys(t *testing.T) {
+	alice, bob, clock := oneSidedConversationPair(t)
+	bob.SetKeyRotationPolicy(KeyRotationPolicy{AfterMessages: 2})
+
+	clock.Advance(time.Minute)
+	sendOneSided(t, alice, bob, 1)
+	stats, ok := alice.KeyStatistics()
+	assertTrue(t, ok)
+	assertEquals(t, stats.OurKeyAge, time.Minute)
+	assertEquals(t, stats.MessagesSentWithOurKey, 1)
+
+	clock.Advance(time.Minute)
+	sendOneSided(t, alice, bob, 1)
+	stats, _ = alice.KeyStatistics()
+	assertEquals(t, stats.OurKeyID, alice.keys.ourKeyID-1)
+	assertEquals(t, stats.TheirKeyID, alice.keys.theirKeyID)
+	assertEquals(t, stats.OurKeyAge, time.Duration(0))
+	assertEquals(t, stats.TheirKeyAge, time.Duration(0))
+	assertEquals(t, stats.MessagesSentWithOurKey, 0)
+}
@@ -28,13 +28,19 @@ type PublicKey interface {
 	IsSame(PublicKey) bool
 }
 
+// Signer is the part of a private key that is used to authenticate us in the AKE. It doesn't need access
+// to the key material, so it can be implemented by something that asks another process to sign.
+type Signer interface {
+	PublicKey() PublicKey
+	Sign(io.Reader, []byte) ([]byte, error)
+}
+
 // PrivateKey is a private key used to sign messages
 type PrivateKey interface {
+	Signer
 	Parse([]byte) ([]byte, bool)
 	Serialize() []byte
-	Sign(io.Reader, []byte) ([]byte, error)
 	Generate(io.Reader) error
-	PublicKey() PublicKey
 	IsAvailableForVersion(uint16) bool
 }
 
@@ -294,6 +300,10 @@ func ParsePrivateKey(in []byte) (index []byte, ok bool, key PrivateKey) {
 		key = &DSAPrivateKey{}
 		index, ok = key.Parse(in)
 		return
+	case ed448KeyTypeValue:
+		key = &Ed448PrivateKey{}
+		index, ok = key.Parse(in)
+		return
 	}
 
 	return in, false, nil
@@ -312,6 +322,10 @@ func ParsePublicKey(in []byte) (index []byte, ok bool, key PublicKey) {
 		key = &DSAPublicKey{}
 		index, ok = key.Parse(in)
 		return
+	case ed448KeyTypeValue:
+		key = &Ed448PublicKey{}
+		index, ok = key.Parse(in)
+		return
 	}
 
 	return in, false, nil
@@ -0,0 +1,92 @@
+package otr3
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// libotrFileLayout keeps the lines of a libotr text file that are not entries, such as comments, so that the file
+// can be written back exactly as it was read. Every such line is kept together with the entry that follows it.
+type libotrFileLayout struct {
+	before  map[interface{}][]string
+	trailer []string
+}
+
+func (l *libotrFileLayout) keep(pending []string, entry interface{}) {
+	if len(pending) == 0 {
+		return
+	}
+	if l.before == nil {
+		l.before = make(map[interface{}][]string)
+	}
+	l.before[entry] = pending
+}
+
+// splitLibotrFile splits the content of a libotr text file into lines, keeping the line endings
+func splitLibotrFile(r io.Reader) ([]string, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// lineContent returns the line without its line ending
+func lineContent(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+func isLibotrCommentOrBlank(content string) bool {
+	return strings.TrimSpace(content) == "" || strings.HasPrefix(content, "#")
+}
+
+// libotrLineWriter writes lines to a libotr text file, making sure every line that is followed by another line ends
+// with a newline, even if it didn't when it was read
+type libotrLineWriter struct {
+	w           io.Writer
+	n           int64
+	needNewline bool
+	err         error
+}
+
+func (lw *libotrLineWriter) write(line string) {
+	if lw.err != nil {
+		return
+	}
+
+	if lw.needNewline {
+		line = "\n" + line
+	}
+	lw.needNewline = !strings.HasSuffix(line, "\n")
+
+	n, err := io.WriteString(lw.w, line)
+	lw.n += int64(n)
+	lw.err = err
+}
+
+func (lw *libotrLineWriter) writeAll(lines []string) {
+	for _, l := range lines {
+		lw.write(l)
+	}
+}
+
+func exportToFile(fname string, write func(io.Writer) (int64, error)) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	_, err = write(f)
+	return firstError(err, f.Close())
+}
+
+func lineErrorf(line int, format string, a ...interface{}) error {
+	return newOtrErrorf("line %d: "+format, append([]interface{}{line}, a...)...)
+}
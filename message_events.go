@@ -56,6 +56,12 @@ const (
 
 	// MessageEventReceivedMessageForOtherInstance is triggered when we receive and discard a message for another instance
 	MessageEventReceivedMessageForOtherInstance
+
+	// MessageEventMessageDropped is signaled when a queued message is forgotten without being sent, since no secure conversation was established in time
+	MessageEventMessageDropped
+
+	// MessageEventTrustStoreError is signaled when the trust store could not be read or updated. The error from the trust store is attached.
+	MessageEventTrustStoreError
 )
 
 // MessageEventHandler handles MessageEvents
@@ -125,6 +131,10 @@ func (s MessageEvent) String() string {
 		return "MessageEventReceivedMessageUnrecognized"
 	case MessageEventReceivedMessageForOtherInstance:
 		return "MessageEventReceivedMessageForOtherInstance"
+	case MessageEventMessageDropped:
+		return "MessageEventMessageDropped"
+	case MessageEventTrustStoreError:
+		return "MessageEventTrustStoreError"
 	default:
 		return "MESSAGE EVENT: (THIS SHOULD NEVER HAPPEN)"
 	}
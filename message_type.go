@@ -12,6 +12,9 @@ const (
 	msgGuessDHKey
 	msgGuessRevealSig
 	msgGuessSignature
+	msgGuessIdentity
+	msgGuessAuthR
+	msgGuessAuthI
 	msgGuessV1KeyExch
 	msgGuessData
 	msgGuessError
@@ -42,6 +45,15 @@ func guessMessageType(msg []byte) messageTypeGuess {
 		case bytes.HasPrefix(msg, []byte("?OTR:AAIS")):
 			return msgGuessSignature
 
+		case bytes.HasPrefix(msg, []byte("?OTR:AAQ1")):
+			return msgGuessIdentity
+		case bytes.HasPrefix(msg, []byte("?OTR:AAQ2")):
+			return msgGuessAuthR
+		case bytes.HasPrefix(msg, []byte("?OTR:AAQ3")):
+			return msgGuessAuthI
+
+		case bytes.HasPrefix(msg, []byte("?OTR:AAQD")):
+			return msgGuessData
 		case bytes.HasPrefix(msg, []byte("?OTR:AAED")):
 			return msgGuessData
 		case bytes.HasPrefix(msg, []byte("?OTR:AAID")):
@@ -38,7 +38,7 @@ func (v otrV2) parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, i
 	return data[5:], false, true
 }
 
-func (v otrV2) fragmentPrefix(n, total int, itags uint32, itagr uint32) []byte {
+func (v otrV2) fragmentPrefix(n, total int, id, itags, itagr uint32) []byte {
 	return []byte(fmt.Sprintf("%s%05d,%05d,", string(otrv2FragmentationPrefix), n+1, total))
 }
 
@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash"
+	"io"
 	"math/big"
 	"strconv"
 
@@ -43,9 +44,10 @@ func parseItag(s []byte) (uint32, error) {
 	return uint32(v), nil
 }
 
-func (v otrV3) parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, ignore bool, ok bool) {
+// parseFragmentInstanceTags extracts the sender and receiver instance tags from the prefix of an OTRv3 fragment
+func parseFragmentInstanceTags(data []byte) (sender, receiver uint32, ok bool) {
 	if len(data) < 23 {
-		return data, false, false
+		return 0, 0, false
 	}
 
 	header := data[:23]
@@ -53,16 +55,25 @@ func (v otrV3) parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, i
 	itagParts := bytes.Split(headerPart, fragmentItagsSeparator)
 
 	if len(itagParts) < 3 {
-		return data, false, false
+		return 0, 0, false
 	}
 
-	senderInstanceTag, err1 := parseItag(itagParts[1])
+	sender, err1 := parseItag(itagParts[1])
 	if err1 != nil {
-		return data, false, false
+		return 0, 0, false
 	}
 
-	receiverInstanceTag, err2 := parseItag(itagParts[2])
+	receiver, err2 := parseItag(itagParts[2])
 	if err2 != nil {
+		return 0, 0, false
+	}
+
+	return sender, receiver, true
+}
+
+func (v otrV3) parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, ignore bool, ok bool) {
+	senderInstanceTag, receiverInstanceTag, ok := parseFragmentInstanceTags(data)
+	if !ok {
 		return data, false, false
 	}
 
@@ -78,7 +89,7 @@ func (v otrV3) parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, i
 	return data[23:], false, true
 }
 
-func (v otrV3) fragmentPrefix(n, total int, itags uint32, itagr uint32) []byte {
+func (v otrV3) fragmentPrefix(n, total int, id, itags, itagr uint32) []byte {
 	return []byte(fmt.Sprintf("%s%08x|%08x,%05d,%05d,", string(otrv3FragmentationPrefix), itags, itagr, n+1, total))
 }
 
@@ -107,20 +118,29 @@ func (c *Conversation) generateInstanceTag() error {
 		return nil
 	}
 
+	ret, err := randomInstanceTag(c.rand())
+	if err != nil {
+		return err
+	}
+
+	c.ourInstanceTag = ret
+
+	return nil
+}
+
+func randomInstanceTag(r io.Reader) (uint32, error) {
 	var ret uint32
 	var dst [4]byte
 
 	for ret < minValidInstanceTag {
-		if err := c.randomInto(dst[:]); err != nil {
-			return err
+		if err := randomInto(r, dst[:]); err != nil {
+			return 0, err
 		}
 
 		ret = binary.BigEndian.Uint32(dst[:])
 	}
 
-	c.ourInstanceTag = ret
-
-	return nil
+	return ret, nil
 }
 
 func malformedMessage(c *Conversation) {
@@ -152,15 +172,25 @@ func (v otrV3) verifyInstanceTags(c *Conversation, their, our uint32) error {
 	return nil
 }
 
-func (v otrV3) parseMessageHeader(c *Conversation, msg []byte) ([]byte, []byte, error) {
+// extractInstanceTags reads the sender and receiver instance tags from a decoded OTRv3 message and returns the rest of the message after the header
+func extractInstanceTags(msg []byte) (rest []byte, sender, receiver uint32, ok bool) {
 	if len(msg) < otrv3HeaderLen {
+		return nil, 0, 0, false
+	}
+
+	rest, sender, _ = gotrax.ExtractWord(msg[messageHeaderPrefix:])
+	rest, receiver, _ = gotrax.ExtractWord(rest)
+	return rest, sender, receiver, true
+}
+
+func (v otrV3) parseMessageHeader(c *Conversation, msg []byte) ([]byte, []byte, error) {
+	rest, senderInstanceTag, receiverInstanceTag, ok := extractInstanceTags(msg)
+	if !ok {
 		malformedMessage(c)
 		return nil, nil, errInvalidOTRMessage
 	}
 	header := msg[:otrv3HeaderLen]
-
-	msg, senderInstanceTag, _ := gotrax.ExtractWord(msg[messageHeaderPrefix:])
-	msg, receiverInstanceTag, _ := gotrax.ExtractWord(msg)
+	msg = rest
 
 	if err := v.verifyInstanceTags(c, senderInstanceTag, receiverInstanceTag); err != nil {
 		return nil, nil, err
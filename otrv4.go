@@ -0,0 +1,140 @@
+package otr3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/coyim/gotrax"
+	"golang.org/x/crypto/sha3"
+)
+
+// OTRv4 fragments also carry an identifier, so that fragments of different messages can't be mixed up:
+//
+//	?OTR|identifier|sender instance|receiver instance,index,total,piece,
+const otrv4FragmentPrefixLen = 32
+
+type otrV4 struct{}
+
+func (v otrV4) parameterLength() int {
+	return dh3072SecretLen
+}
+
+func (v otrV4) isGroupElement(n *big.Int) bool {
+	return isDH3072GroupElement(n)
+}
+
+func isOTRv4Fragment(data []byte) bool {
+	return bytes.HasPrefix(data, otrv3FragmentationPrefix) && len(data) >= otrv4FragmentPrefixLen &&
+		data[22] == fragmentItagsSeparator[0] && data[31] == fragmentSeparator[0]
+}
+
+func (v otrV4) isFragmented(data []byte) bool {
+	return isOTRv4Fragment(data) || otrV3{}.isFragmented(data)
+}
+
+// parseOTRv4FragmentInstanceTags extracts the sender and receiver instance tags from the prefix of an OTRv4 fragment
+func parseOTRv4FragmentInstanceTags(data []byte) (sender, receiver uint32, ok bool) {
+	if !isOTRv4Fragment(data) {
+		return 0, 0, false
+	}
+
+	var err1, err2 error
+	sender, err1 = parseItag(data[14:22])
+	receiver, err2 = parseItag(data[23:31])
+	return sender, receiver, err1 == nil && err2 == nil
+}
+
+func (v otrV4) parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, ignore bool, ok bool) {
+	senderInstanceTag, receiverInstanceTag, ok := parseOTRv4FragmentInstanceTags(data)
+	if !ok {
+		return data, false, false
+	}
+
+	if err := v.verifyInstanceTags(c, senderInstanceTag, receiverInstanceTag); err != nil {
+		switch err {
+		case errInvalidOTRMessage:
+			return data, false, false
+		case errReceivedMessageForOtherInstance:
+			return data, true, true
+		}
+	}
+
+	return data[otrv4FragmentPrefixLen:], false, true
+}
+
+func (v otrV4) fragmentPrefix(n, total int, id, itags, itagr uint32) []byte {
+	return []byte(fmt.Sprintf("%s%08x|%08x|%08x,%05d,%05d,", string(otrv3FragmentationPrefix), id, itags, itagr, n+1, total))
+}
+
+func (v otrV4) protocolVersion() uint16 {
+	return 4
+}
+
+// whitespaceTag returns nothing, since OTRv4 is never advertised - see StartOTRv4
+func (v otrV4) whitespaceTag() []byte {
+	return nil
+}
+
+func (v otrV4) messageHeader(c *Conversation, msgType byte) ([]byte, error) {
+	if err := c.generateInstanceTag(); err != nil {
+		return nil, err
+	}
+
+	out := gotrax.AppendShort(nil, v.protocolVersion())
+	out = append(out, msgType)
+	out = gotrax.AppendWord(out, c.ourInstanceTag)
+	out = gotrax.AppendWord(out, c.theirInstanceTag)
+	return out, nil
+}
+
+// OTRv4 uses instance tags exactly like OTRv3
+func (v otrV4) verifyInstanceTags(c *Conversation, their, our uint32) error {
+	return otrV3{}.verifyInstanceTags(c, their, our)
+}
+
+func (v otrV4) parseMessageHeader(c *Conversation, msg []byte) ([]byte, []byte, error) {
+	return otrV3{}.parseMessageHeader(c, msg)
+}
+
+func (v otrV4) hashInstance() hash.Hash {
+	return sha3.New512()
+}
+
+func (v otrV4) hash(val []byte) []byte {
+	ret := sha3.Sum512(val)
+	return ret[:]
+}
+
+func (v otrV4) hashLength() int {
+	return 64
+}
+
+func (v otrV4) hash2Instance() hash.Hash {
+	return sha3.New256()
+}
+
+func (v otrV4) hash2(val []byte) []byte {
+	ret := sha3.Sum256(val)
+	return ret[:]
+}
+
+func (v otrV4) hash2Length() int {
+	return 32
+}
+
+func (v otrV4) truncateLength() int {
+	return 64
+}
+
+func (v otrV4) keyLength() int {
+	return 32
+}
+
+// fragmentIdentifier derives the identifier OTRv4 puts in all fragments of a message from the message itself
+func fragmentIdentifier(data []byte) uint32 {
+	h := sha256.Sum256(data)
+	return gotrax.DeserializeWord(h[:4])
+}
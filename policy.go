@@ -1,50 +1,233 @@
 package otr3
 
-type policies int
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
-type policy int
+// Policy is a set of flags that decide how a conversation behaves, such as which protocol versions are allowed and
+// whether encryption is required. The flags have the same values as the OTRL_POLICY_* flags in libotr.
+type Policy int
 
 const (
-	allowV2 policy = 2 << iota
+	allowV2 Policy = 2 << iota
 	allowV3
 	requireEncryption
 	sendWhitespaceTag
 	whitespaceStartAKE
 	errorStartAKE
+	allowV4
+	requestReceipts
+)
+
+// These are the individual policy flags
+const (
+	// PolicyAllowV2 allows version 2 of the protocol
+	PolicyAllowV2 = allowV2
+	// PolicyAllowV3 allows version 3 of the protocol
+	PolicyAllowV3 = allowV3
+	// PolicyRequireEncryption refuses to send unencrypted messages
+	PolicyRequireEncryption = requireEncryption
+	// PolicySendWhitespaceTag advertises our support of OTR using the whitespace tag
+	PolicySendWhitespaceTag = sendWhitespaceTag
+	// PolicyWhitespaceStartAKE starts the AKE when we receive a whitespace tag
+	PolicyWhitespaceStartAKE = whitespaceStartAKE
+	// PolicyErrorStartAKE starts the AKE when we receive an OTR error message
+	PolicyErrorStartAKE = errorStartAKE
+	// PolicyAllowV4 allows the OTRv4 of this library, which is private: it doesn't follow the wire format of the OTRv4
+	// specification, so it is never advertised and has to be started with Conversation.StartOTRv4. It has no
+	// counterpart in libotr, so none of the presets include it. Without Go 1.15, it has no effect.
+	PolicyAllowV4 = allowV4
+	// PolicyRequestReceipts asks the peer to acknowledge every encrypted message it could decrypt, and acknowledges the
+	// messages of the peer when it asks for that. Without it, no receipts are requested or sent. It is not part of the
+	// OTR specification, so peers that don't know about it will never acknowledge anything.
+	PolicyRequestReceipts = requestReceipts
+)
+
+// These are the policy presets from libotr
+const (
+	// PolicyNever never uses OTR
+	PolicyNever Policy = 0
+	// PolicyManual only uses OTR when asked to
+	PolicyManual = allowV2 | allowV3
+	// PolicyOpportunistic advertises OTR and starts it as soon as the peer supports it
+	PolicyOpportunistic = PolicyManual | sendWhitespaceTag | whitespaceStartAKE | errorStartAKE
+	// PolicyAlways refuses to send anything unencrypted
+	PolicyAlways = PolicyManual | requireEncryption | whitespaceStartAKE | errorStartAKE
+	// PolicyDefault is the default policy of libotr
+	PolicyDefault = PolicyOpportunistic
 )
 
-func (p *policies) isOTREnabled() bool {
-	return p.has(allowV2) || p.has(allowV3)
+var policyPresets = []struct {
+	name string
+	p    Policy
+}{
+	{"NEVER", PolicyNever},
+	{"MANUAL", PolicyManual},
+	{"OPPORTUNISTIC", PolicyOpportunistic},
+	{"ALWAYS", PolicyAlways},
+	{"DEFAULT", PolicyDefault},
+}
+
+var policyFlags = []struct {
+	name string
+	p    Policy
+}{
+	{"ALLOW_V2", allowV2},
+	{"ALLOW_V3", allowV3},
+	{"REQUIRE_ENCRYPTION", requireEncryption},
+	{"SEND_WHITESPACE_TAG", sendWhitespaceTag},
+	{"WHITESPACE_START_AKE", whitespaceStartAKE},
+	{"ERROR_START_AKE", errorStartAKE},
+	{"ALLOW_V4", allowV4},
+	{"REQUEST_RECEIPTS", requestReceipts},
+}
+
+func (p *Policy) isOTREnabled() bool {
+	return p.has(allowV2) || p.has(allowV3) || p.has(allowV4)
 }
 
-func (p *policies) has(c policy) bool {
+func (p *Policy) has(c Policy) bool {
 	return int(*p)&int(c) == int(c)
 }
 
-func (p *policies) add(c policy) {
-	*p = policies(int(*p) | int(c))
+func (p *Policy) add(c Policy) {
+	*p = Policy(int(*p) | int(c))
+}
+
+// Has returns true if all the flags of the given policy are set in this policy
+func (p Policy) Has(c Policy) bool {
+	return p.has(c)
+}
+
+// Add sets all the flags of the given policy in this policy
+func (p *Policy) Add(c Policy) {
+	p.add(c)
 }
 
-func (p *policies) AllowV2() {
+// Remove clears all the flags of the given policy from this policy
+func (p *Policy) Remove(c Policy) {
+	*p = Policy(int(*p) &^ int(c))
+}
+
+// AllowV2 adds the policy of allowing version 2 of the protocol
+func (p *Policy) AllowV2() {
 	p.add(allowV2)
 }
 
-func (p *policies) AllowV3() {
+// AllowV3 adds the policy of allowing version 3 of the protocol
+func (p *Policy) AllowV3() {
 	p.add(allowV3)
 }
 
-func (p *policies) RequireEncryption() {
+// AllowV4 adds the policy of allowing the private OTRv4 of this library
+func (p *Policy) AllowV4() {
+	p.add(allowV4)
+}
+
+// RequestReceipts adds the policy of exchanging receipts for the encrypted messages with the peer
+func (p *Policy) RequestReceipts() {
+	p.add(requestReceipts)
+}
+
+// RequireEncryption adds the policy of refusing to send unencrypted messages
+func (p *Policy) RequireEncryption() {
 	p.add(requireEncryption)
 }
 
-func (p *policies) SendWhitespaceTag() {
+// SendWhitespaceTag adds the policy of advertising OTR support with the whitespace tag
+func (p *Policy) SendWhitespaceTag() {
 	p.add(sendWhitespaceTag)
 }
 
-func (p *policies) WhitespaceStartAKE() {
+// WhitespaceStartAKE adds the policy of starting the AKE when receiving a whitespace tag
+func (p *Policy) WhitespaceStartAKE() {
 	p.add(whitespaceStartAKE)
 }
 
-func (p *policies) ErrorStartAKE() {
+// ErrorStartAKE adds the policy of starting the AKE when receiving an OTR error message
+func (p *Policy) ErrorStartAKE() {
 	p.add(errorStartAKE)
 }
+
+// String returns the name of the preset matching this policy, or the names of its flags separated by |
+func (p Policy) String() string {
+	for _, preset := range policyPresets {
+		if preset.p == p {
+			return preset.name
+		}
+	}
+
+	var names []string
+	rest := p
+	for _, f := range policyFlags {
+		if p.has(f.p) {
+			names = append(names, f.name)
+			rest.Remove(f.p)
+		}
+	}
+
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%X", int(rest)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// ParsePolicy parses a policy in the format returned by Policy.String. Names are case insensitive and can have
+// the OTRL_POLICY_ prefix used by libotr. Flags and presets can be combined with |, commas or spaces.
+func ParsePolicy(s string) (Policy, error) {
+	var p Policy
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '|' || r == ',' || r == ' ' || r == '\t'
+	})
+
+	for _, f := range fields {
+		v, ok := parsePolicyName(f)
+		if !ok {
+			return 0, newOtrErrorf("unknown policy %q", f)
+		}
+		p.add(v)
+	}
+
+	return p, nil
+}
+
+func parsePolicyName(s string) (Policy, bool) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "OTRL_POLICY_")
+
+	for _, preset := range policyPresets {
+		if preset.name == name {
+			return preset.p, true
+		}
+	}
+
+	for _, f := range policyFlags {
+		if f.name == name {
+			return f.p, true
+		}
+	}
+
+	if strings.HasPrefix(name, "0X") {
+		v, err := strconv.ParseUint(name[2:], 16, 31)
+		return Policy(v), err == nil
+	}
+
+	return 0, false
+}
+
+// MarshalText implements encoding.TextMarshaler, which also makes policies marshal to JSON strings
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting everything ParsePolicy accepts
+func (p *Policy) UnmarshalText(text []byte) error {
+	v, err := ParsePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
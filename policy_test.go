@@ -1,41 +1,135 @@
 package otr3
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
 
 func Test_policies_requireEncryption_addsRequirementOfEncryption(t *testing.T) {
-	p := policies(0)
+	p := Policy(0)
 	p.RequireEncryption()
 	assertEquals(t, p.has(requireEncryption), true)
 }
 
 func Test_policies_sendWhitespaceTag_addsPolicyForSendingWhitespaceTag(t *testing.T) {
-	p := policies(0)
+	p := Policy(0)
 	p.SendWhitespaceTag()
 	assertEquals(t, p.has(sendWhitespaceTag), true)
 }
 
 func Test_policies_whitespaceStartAKE_addsWhitespaceStartAKEPolicy(t *testing.T) {
-	p := policies(0)
+	p := Policy(0)
 	p.WhitespaceStartAKE()
 	assertEquals(t, p.has(whitespaceStartAKE), true)
 }
 
 func Test_policies_errorStartAKE_addsErrorStartAKEPolicy(t *testing.T) {
-	p := policies(0)
+	p := Policy(0)
 	p.ErrorStartAKE()
 	assertEquals(t, p.has(errorStartAKE), true)
 }
 
 func Test_policies_Allowv2_addsV2Policy(t *testing.T) {
-	p := policies(allowV3)
+	p := Policy(allowV3)
 	p.AllowV2()
 	assertEquals(t, p.has(allowV2), true)
 	assertEquals(t, p.has(allowV3), true)
 }
 
 func Test_policies_Allowv3_addsV3Policy(t *testing.T) {
-	p := policies(allowV2)
+	p := Policy(allowV2)
 	p.AllowV3()
 	assertEquals(t, p.has(allowV3), true)
 	assertEquals(t, p.has(allowV2), true)
 }
+
+func Test_Policy_hasTheSameValuesAsLibotr(t *testing.T) {
+	assertEquals(t, int(PolicyAllowV2), 0x02)
+	assertEquals(t, int(PolicyAllowV3), 0x04)
+	assertEquals(t, int(PolicyRequireEncryption), 0x08)
+	assertEquals(t, int(PolicySendWhitespaceTag), 0x10)
+	assertEquals(t, int(PolicyWhitespaceStartAKE), 0x20)
+	assertEquals(t, int(PolicyErrorStartAKE), 0x40)
+	assertEquals(t, PolicyDefault, PolicyOpportunistic)
+}
+
+func Test_Policy_Has_checksAllFlags(t *testing.T) {
+	assertTrue(t, PolicyAlways.Has(PolicyRequireEncryption))
+	assertTrue(t, PolicyAlways.Has(PolicyManual))
+	assertFalse(t, PolicyManual.Has(PolicyAlways))
+	assertTrue(t, PolicyNever.Has(PolicyNever))
+}
+
+func Test_Policy_Remove_clearsTheGivenFlags(t *testing.T) {
+	p := PolicyAlways
+	p.Remove(PolicyRequireEncryption | PolicyAllowV2)
+	assertFalse(t, p.Has(PolicyRequireEncryption))
+	assertFalse(t, p.Has(PolicyAllowV2))
+	assertTrue(t, p.Has(PolicyAllowV3))
+
+	p.Remove(PolicyRequireEncryption)
+	assertTrue(t, p.Has(PolicyAllowV3))
+}
+
+func Test_Policy_Add_setsTheGivenFlags(t *testing.T) {
+	p := PolicyNever
+	p.Add(PolicyManual)
+	assertEquals(t, p, PolicyManual)
+}
+
+func Test_Policy_String_usesPresetNamesWhenPossible(t *testing.T) {
+	assertEquals(t, PolicyNever.String(), "NEVER")
+	assertEquals(t, PolicyManual.String(), "MANUAL")
+	assertEquals(t, PolicyOpportunistic.String(), "OPPORTUNISTIC")
+	assertEquals(t, PolicyAlways.String(), "ALWAYS")
+	assertEquals(t, (PolicyAllowV3 | PolicyRequireEncryption).String(), "ALLOW_V3|REQUIRE_ENCRYPTION")
+	assertEquals(t, (PolicyAllowV2 | Policy(0x1000)).String(), "ALLOW_V2|0x1000")
+}
+
+func Test_ParsePolicy_parsesWhatStringReturns(t *testing.T) {
+	for _, p := range []Policy{PolicyNever, PolicyManual, PolicyOpportunistic, PolicyAlways, PolicyAllowV3 | PolicyErrorStartAKE, PolicyAllowV2 | Policy(0x1000)} {
+		parsed, err := ParsePolicy(p.String())
+		assertNil(t, err)
+		assertEquals(t, parsed, p)
+	}
+}
+
+func Test_ParsePolicy_acceptsLibotrNamesAndSeveralSeparators(t *testing.T) {
+	p, err := ParsePolicy("OTRL_POLICY_ALLOW_V3, require_encryption | Whitespace_Start_AKE")
+	assertNil(t, err)
+	assertEquals(t, p, PolicyAllowV3|PolicyRequireEncryption|PolicyWhitespaceStartAKE)
+
+	p, err = ParsePolicy("default")
+	assertNil(t, err)
+	assertEquals(t, p, PolicyDefault)
+
+	p, err = ParsePolicy("MANUAL|REQUIRE_ENCRYPTION")
+	assertNil(t, err)
+	assertEquals(t, p, PolicyManual|PolicyRequireEncryption)
+}
+
+func Test_ParsePolicy_returnsErrorForUnknownNames(t *testing.T) {
+	_, err := ParsePolicy("ALLOW_V3|ALLOW_V5")
+	assertDeepEquals(t, err, newOtrErrorf("unknown policy %q", "ALLOW_V5"))
+
+	_, err = ParsePolicy("0xZZ")
+	assertNotNil(t, err)
+}
+
+func Test_Policy_canBeMarshaledToAndFromJSON(t *testing.T) {
+	type config struct {
+		Policy Policy `json:"policy"`
+	}
+
+	b, err := json.Marshal(config{PolicyAlways})
+	assertNil(t, err)
+	assertEquals(t, string(b), `{"policy":"ALWAYS"}`)
+
+	var c config
+	err = json.Unmarshal([]byte(`{"policy":"ALLOW_V3|SEND_WHITESPACE_TAG"}`), &c)
+	assertNil(t, err)
+	assertEquals(t, c.Policy, PolicyAllowV3|PolicySendWhitespaceTag)
+
+	err = json.Unmarshal([]byte(`{"policy":"SOMETIMES"}`), &c)
+	assertNotNil(t, err)
+}
@@ -37,7 +37,7 @@ func parseOTRQueryMessage(msg ValidMessage) []int {
 	return ret
 }
 
-func extractVersionsFromQueryMessage(p policies, msg ValidMessage) int {
+func extractVersionsFromQueryMessage(p Policy, msg ValidMessage) int {
 	versions := 0
 	for _, v := range parseOTRQueryMessage(msg) {
 		switch {
@@ -51,11 +51,20 @@ func extractVersionsFromQueryMessage(p policies, msg ValidMessage) int {
 	return versions
 }
 
-var timeoutLength = time.Duration(1) * time.Minute
+const defaultQueryIgnoreInterval = time.Duration(1) * time.Minute
 
-func isWithinTimeToIgnoreQueryMessage(t time.Time) bool {
-	return t.Add(timeoutLength).After(time.Now())
+// SetQueryIgnoreInterval sets for how long after a change in the AKE or the message state repeated query
+// messages will be ignored. A zero duration restores the default of one minute.
+func (c *Conversation) SetQueryIgnoreInterval(d time.Duration) {
+	c.queryIgnoreInterval = d
+}
 
+func (c *Conversation) isWithinTimeToIgnoreQueryMessage(t time.Time) bool {
+	interval := c.queryIgnoreInterval
+	if interval == 0 {
+		interval = defaultQueryIgnoreInterval
+	}
+	return t.Add(interval).After(c.now())
 }
 
 func (c *Conversation) receiveQueryMessage(msg ValidMessage) ([]messageWithHeader, error) {
@@ -65,12 +74,13 @@ func (c *Conversation) receiveQueryMessage(msg ValidMessage) ([]messageWithHeade
 		return nil, err
 	}
 
-	if dontIgnoreFastRepeatQueryMessage != "true" && ((c.msgState == encrypted && isWithinTimeToIgnoreQueryMessage(c.lastMessageStateChange)) ||
-		(c.ake != nil && isWithinTimeToIgnoreQueryMessage(c.ake.lastStateChange))) {
+	if dontIgnoreFastRepeatQueryMessage != "true" && ((c.msgState == encrypted && c.isWithinTimeToIgnoreQueryMessage(c.lastMessageStateChange)) ||
+		(c.ake != nil && c.isWithinTimeToIgnoreQueryMessage(c.ake.lastStateChange)) ||
+		(c.dake != nil && c.isWithinTimeToIgnoreQueryMessage(c.dake.lastStateChange))) {
 		return nil, nil
 	}
 
-	ts, err := c.sendDHCommit()
+	ts, err := c.sendAKEStart()
 	return c.potentialAuthError(compactMessagesWithHeader(ts), err)
 }
 
@@ -1,13 +1,15 @@
 package otr3
 
 import (
+	"crypto/rand"
 	"testing"
+	"time"
 )
 
 func Test_receiveQueryMessage_ignoreVersion1(t *testing.T) {
 	queryMsg := []byte("?OTR?")
 
-	c := &Conversation{Policies: policies(allowV2 | allowV3)}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -19,7 +21,7 @@ func Test_receiveQueryMessage_ignoreVersion1(t *testing.T) {
 func Test_receiveQueryMessage_ignoreVersion1AndSupportVersion2(t *testing.T) {
 	queryMsg := []byte("?OTR?v2?")
 
-	c := &Conversation{Policies: policies(allowV2 | allowV3)}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -32,7 +34,7 @@ func Test_receiveQueryMessage_ignoreVersion1AndSupportVersion2(t *testing.T) {
 func Test_receiveQueryMessage_ignoreBizarreClaim(t *testing.T) {
 	queryMsg := []byte("?OTRv?")
 
-	c := &Conversation{Policies: policies(allowV2 | allowV3)}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -44,7 +46,7 @@ func Test_receiveQueryMessage_ignoreBizarreClaim(t *testing.T) {
 func Test_receiveQueryMessage_ignoreAdditionalText(t *testing.T) {
 	queryMsg := []byte("?OTRv2? I like number 3")
 
-	c := &Conversation{Policies: policies(allowV2 | allowV3)}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -57,7 +59,7 @@ func Test_receiveQueryMessage_ignoreAdditionalText(t *testing.T) {
 func Test_receiveQueryMessage_sendDHCommitv3AndTransitToStateAwaitingDHKey(t *testing.T) {
 	queryMsg := []byte("?OTRv23?")
 
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -70,7 +72,7 @@ func Test_receiveQueryMessage_sendDHCommitv3AndTransitToStateAwaitingDHKey(t *te
 func Test_receiveQueryMessageV2_sendDHCommitv2(t *testing.T) {
 	queryMsg := []byte("?OTRvx23?")
 
-	c := &Conversation{Policies: policies(allowV2)}
+	c := &Conversation{Policies: Policy(allowV2)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -83,7 +85,7 @@ func Test_receiveQueryMessageV2_sendDHCommitv2(t *testing.T) {
 func Test_receiveQueryMessageV2V3_sendDHCommitv3WhenV2AndV3AreAllowed(t *testing.T) {
 	queryMsg := []byte("?OTRvx23?")
 
-	c := &Conversation{Policies: policies(allowV2 | allowV3)}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	msg, err := c.receiveQueryMessage(queryMsg)
 
@@ -123,7 +125,7 @@ func Test_receiveQueryMessage_signalsMessageEventOnFailure(t *testing.T) {
 }
 
 func Test_receiveQueryMessage_returnsErrorIfNoCompatibleVersionCouldBeFound(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	_, err := c.receiveQueryMessage([]byte("?OTRv?2?"))
 	assertEquals(t, err, errUnsupportedOTRVersion)
@@ -131,7 +133,7 @@ func Test_receiveQueryMessage_returnsErrorIfNoCompatibleVersionCouldBeFound(t *t
 
 func Test_receiveQueryMessage_returnsErrorIfDhCommitMessageGeneratesError(t *testing.T) {
 	c := &Conversation{
-		Policies: policies(allowV2),
+		Policies: Policy(allowV2),
 		Rand:     fixedRand([]string{"ABCDABCD"}),
 	}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
@@ -157,7 +159,7 @@ func Test_parseOTRQueryMessage(t *testing.T) {
 }
 
 func Test_extractVersionsFromQueryMessage_returnsNilForUnsupportedVersions(t *testing.T) {
-	p := policies(0)
+	p := Policy(0)
 	msg := []byte("?OTR?")
 	versions := extractVersionsFromQueryMessage(p, msg)
 
@@ -166,7 +168,7 @@ func Test_extractVersionsFromQueryMessage_returnsNilForUnsupportedVersions(t *te
 
 func Test_extractVersionsFromQueryMessage_acceptsBothV2AndV3IfThePolicyAllows(t *testing.T) {
 	msg := []byte("?OTRv32?")
-	p := policies(allowV2 | allowV3)
+	p := Policy(allowV2 | allowV3)
 	versions := extractVersionsFromQueryMessage(p, msg)
 
 	assertEquals(t, versions, 1<<2|1<<3)
@@ -174,19 +176,66 @@ func Test_extractVersionsFromQueryMessage_acceptsBothV2AndV3IfThePolicyAllows(t
 
 func Test_extractVersionsFromQueryMessage_acceptsOTRV2IfHasOnlyAllowV2Policy(t *testing.T) {
 	msg := []byte("?OTRv32?")
-	p := policies(allowV2)
+	p := Policy(allowV2)
 	versions := extractVersionsFromQueryMessage(p, msg)
 
 	assertEquals(t, versions, 1<<2)
 }
 
 func Test_QueryMessage_returnsARegularQueryMessage(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	assertEquals(t, string(c.QueryMessage()), "?OTRv3?")
 }
 
+func Test_QueryMessage_neverAdvertisesV4(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3 | allowV4)}
+	c.SetOurKeys([]PrivateKey{alicePrivateKey, &Ed448PrivateKey{}})
+	assertEquals(t, string(c.QueryMessage()), "?OTRv3?")
+}
+
+func Test_receiveQueryMessage_ignoresV4(t *testing.T) {
+	c := &Conversation{Rand: rand.Reader, Policies: Policy(allowV3 | allowV4)}
+	c.SetOurKeys([]PrivateKey{alicePrivateKey, generateEd448Key(t)})
+
+	toSend, err := c.receiveQueryMessage([]byte("?OTRv34?"))
+
+	assertNil(t, err)
+	assertEquals(t, c.version, otrV3{})
+	assertNil(t, c.dake)
+	assertEquals(t, toSend[0][2], msgTypeDHCommit)
+}
+
 func Test_QueryMessage_returnsAQueryMessageWithExtraMessage(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	c.SetFriendlyQueryMessage("hello foobarium")
 	assertEquals(t, string(c.QueryMessage()), "?OTRv3? hello foobarium")
 }
+
+func Test_isWithinTimeToIgnoreQueryMessage_usesTheConfiguredIntervalAndClock(t *testing.T) {
+	start := time.Now()
+	clock := NewFakeClock(start)
+	c := &Conversation{}
+	c.SetClock(clock)
+
+	clock.Advance(59 * time.Second)
+	assertTrue(t, c.isWithinTimeToIgnoreQueryMessage(start))
+	clock.Advance(2 * time.Second)
+	assertFalse(t, c.isWithinTimeToIgnoreQueryMessage(start))
+
+	c.SetQueryIgnoreInterval(5 * time.Minute)
+	assertTrue(t, c.isWithinTimeToIgnoreQueryMessage(start))
+}
+
+func Test_receiveQueryMessage_isNotIgnoredAfterTheIntervalHasPassed(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	alice, bob := encryptedConversationPair(t)
+	bob.SetClock(clock)
+	bob.lastMessageStateChange = clock.Now()
+
+	_, toSend, _ := bob.Receive(alice.QueryMessage())
+	assertNil(t, toSend)
+
+	clock.Advance(2 * time.Minute)
+	_, toSend, _ = bob.Receive(alice.QueryMessage())
+	assertEquals(t, len(toSend), 1)
+}
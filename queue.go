@@ -0,0 +1,75 @@
+package otr3
+
+import "time"
+
+const defaultMaxQueuedMessages = 100
+
+// QueuedMessage is a message the user has sent that is waiting for a secure conversation to be established
+type QueuedMessage struct {
+	ID       MessageID
+	Message  MessagePlaintext
+	QueuedAt time.Time
+}
+
+// SetMaxQueuedMessages sets how many messages can wait for a secure conversation at the same time. Sending more
+// messages than that fails. A zero value restores the default of 100 messages.
+func (c *Conversation) SetMaxQueuedMessages(n int) {
+	c.resend.maxQueued = n
+}
+
+func (c *Conversation) maxQueuedMessages() int {
+	if c.resend.maxQueued == 0 {
+		return defaultMaxQueuedMessages
+	}
+	return c.resend.maxQueued
+}
+
+// SetQueuedMessageExpiry sets for how long a message can wait for a secure conversation before it is dropped. A zero
+// duration makes the messages expire after the resend interval.
+func (c *Conversation) SetQueuedMessageExpiry(d time.Duration) {
+	c.resend.expiry = d
+}
+
+func (c *Conversation) queuedMessageExpiry() time.Duration {
+	if c.resend.expiry == 0 {
+		return c.resendInterval()
+	}
+	return c.resend.expiry
+}
+
+// QueuedMessages returns the messages waiting for a secure conversation, in the order they will be sent
+func (c *Conversation) QueuedMessages() []QueuedMessage {
+	var ret []QueuedMessage
+	for _, m := range c.resend.queued() {
+		ret = append(ret, QueuedMessage{ID: m.id, Message: makeCopy(m.m), QueuedAt: m.queuedAt})
+	}
+	return ret
+}
+
+// CancelQueuedMessage removes the message with the given ID from the queue, so it will never be sent. It returns false
+// if no such message is waiting.
+func (c *Conversation) CancelQueuedMessage(id MessageID) bool {
+	removed := c.resend.remove(func(m messageToResend) bool {
+		return m.id == id
+	})
+	for _, m := range removed {
+		c.deliveryEvent(m.id, DeliveryDropped)
+	}
+	return len(removed) > 0
+}
+
+// FlushQueuedMessages sends the queued messages that haven't expired right away, in the order they were queued,
+// instead of waiting for the next Tick. It only works in an encrypted conversation.
+func (c *Conversation) FlushQueuedMessages() ([]ValidMessage, error) {
+	if c.msgState != encrypted {
+		return nil, errCannotSendUnencrypted
+	}
+
+	c.dropExpiredQueuedMessages()
+	msgs, err := c.sendAgain(c.resend.remove(func(messageToResend) bool { return true }), false)
+	if err != nil {
+		return c.withInjections(nil, err)
+	}
+
+	return c.withInjections(c.encodeAndCombine(msgs), nil)
+}
@@ -0,0 +1,199 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+func queueingConversation() (*Conversation, *FakeClock) {
+	clock := fixtureClock()
+	c := &Conversation{Policies: Policy(allowV3 | requireEncryption)}
+	c.SetClock(clock)
+	return c, clock
+}
+
+func Test_Send_onlySendsOneQueryForAllQueuedMessages(t *testing.T) {
+	c, _ := queueingConversation()
+
+	first, _ := c.Send(ValidMessage("one"))
+	second, err := c.Send(ValidMessage("two"))
+
+	assertDeepEquals(t, first, []ValidMessage{c.QueryMessage()})
+	assertNil(t, err)
+	assertNil(t, second)
+	assertEquals(t, len(c.QueuedMessages()), 2)
+}
+
+func Test_Send_sendsANewQueryOnceTheQueueHasBeenEmptied(t *testing.T) {
+	c, _ := queueingConversation()
+	id, _, _ := c.SendWithID(ValidMessage("one"))
+	c.CancelQueuedMessage(id)
+
+	msgs, _ := c.Send(ValidMessage("two"))
+
+	assertDeepEquals(t, msgs, []ValidMessage{c.QueryMessage()})
+}
+
+func Test_Send_sendsANewQueryWhenNoAKEHasStartedWithinTheResendInterval(t *testing.T) {
+	c, clock := queueingConversation()
+	c.SetResendInterval(10 * time.Second)
+	c.Send(ValidMessage("one"))
+
+	clock.Advance(9 * time.Second)
+	msgs, _ := c.Send(ValidMessage("two"))
+	assertNil(t, msgs)
+
+	clock.Advance(1 * time.Second)
+	msgs, _ = c.Send(ValidMessage("three"))
+	assertDeepEquals(t, msgs, []ValidMessage{c.QueryMessage()})
+}
+
+func Test_Send_sendsANewQueryWhenNoAKEHasStartedWithinTheAKETimeout(t *testing.T) {
+	c, clock := queueingConversation()
+	c.SetAKETimeout(5 * time.Second)
+	c.Send(ValidMessage("one"))
+
+	clock.Advance(5 * time.Second)
+	msgs, _ := c.Send(ValidMessage("two"))
+
+	assertDeepEquals(t, msgs, []ValidMessage{c.QueryMessage()})
+}
+
+func Test_Send_failsWhenTheQueueIsFull(t *testing.T) {
+	c, _ := queueingConversation()
+	c.SetMaxQueuedMessages(2)
+	events := c.recordDeliveryEvents()
+
+	c.Send(ValidMessage("one"))
+	c.Send(ValidMessage("two"))
+	id, msgs, err := c.SendWithID(ValidMessage("three"))
+
+	assertEquals(t, err, errQueueFull)
+	assertNil(t, msgs)
+	assertEquals(t, len(c.QueuedMessages()), 2)
+	assertDeepEquals(t, (*events)[2], deliveryEventRecord{id, DeliveryDropped})
+}
+
+func Test_maxQueuedMessages_hasADefault(t *testing.T) {
+	c := &Conversation{}
+	assertEquals(t, c.maxQueuedMessages(), defaultMaxQueuedMessages)
+
+	c.SetMaxQueuedMessages(3)
+	assertEquals(t, c.maxQueuedMessages(), 3)
+}
+
+func Test_QueuedMessages_returnsTheMessagesInOrder(t *testing.T) {
+	c, clock := queueingConversation()
+
+	id1, _, _ := c.SendWithID(ValidMessage("one"))
+	clock.Advance(time.Second)
+	id2, _, _ := c.SendWithID(ValidMessage("two"))
+
+	assertDeepEquals(t, c.QueuedMessages(), []QueuedMessage{
+		{ID: id1, Message: MessagePlaintext("one"), QueuedAt: fixtureClock().Now()},
+		{ID: id2, Message: MessagePlaintext("two"), QueuedAt: fixtureClock().Now().Add(time.Second)},
+	})
+}
+
+func Test_QueuedMessages_doesntIncludeMessagesAlreadySent(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	alice.Send(ValidMessage("hello"))
+
+	assertNil(t, alice.QueuedMessages())
+}
+
+func Test_CancelQueuedMessage_removesOnlyTheGivenMessage(t *testing.T) {
+	c, _ := queueingConversation()
+	events := c.recordDeliveryEvents()
+
+	id1, _, _ := c.SendWithID(ValidMessage("one"))
+	id2, _, _ := c.SendWithID(ValidMessage("two"))
+
+	assertTrue(t, c.CancelQueuedMessage(id1))
+	assertFalse(t, c.CancelQueuedMessage(id1))
+	assertEquals(t, len(c.QueuedMessages()), 1)
+	assertEquals(t, c.QueuedMessages()[0].ID, id2)
+	assertDeepEquals(t, (*events)[2], deliveryEventRecord{id1, DeliveryDropped})
+}
+
+func Test_Tick_dropsQueuedMessagesThatHaveExpired(t *testing.T) {
+	c, clock := queueingConversation()
+	c.SetQueuedMessageExpiry(10 * time.Second)
+	events := c.recordDeliveryEvents()
+
+	id1, _, _ := c.SendWithID(ValidMessage("one"))
+	clock.Advance(5 * time.Second)
+	id2, _, _ := c.SendWithID(ValidMessage("two"))
+
+	deadline, _ := c.NextDeadline()
+	assertEquals(t, deadline, fixtureClock().Now().Add(10*time.Second))
+
+	clock.Advance(5 * time.Second)
+	c.Tick()
+
+	assertEquals(t, len(c.QueuedMessages()), 1)
+	assertEquals(t, c.QueuedMessages()[0].ID, id2)
+	assertDeepEquals(t, (*events)[2:], []deliveryEventRecord{{id1, DeliveryDropped}})
+}
+
+func Test_queuedMessageExpiry_defaultsToTheResendInterval(t *testing.T) {
+	c := &Conversation{}
+	c.SetResendInterval(time.Minute * 3)
+
+	assertEquals(t, c.queuedMessageExpiry(), time.Minute*3)
+}
+
+func Test_Send_sendsTheQueuedMessagesInOrderWhenTheConversationGoesSecure(t *testing.T) {
+	alice, bob := conversationPairWithClock(fixtureClock(), allowV3|requireEncryption)
+	bob.updateLastSent()
+
+	toBob, _ := alice.Send(ValidMessage("one"))
+	more, _ := alice.Send(ValidMessage("two"))
+	assertNil(t, more)
+
+	var received []MessagePlaintext
+	toAlice := deliverAll(t, bob, toBob)
+	for len(toAlice) > 0 {
+		toBob = deliverAll(t, alice, toAlice)
+		toAlice = nil
+		for _, m := range toBob {
+			plain, ts, err := bob.Receive(m)
+			assertNil(t, err)
+			if plain != nil {
+				received = append(received, plain)
+			}
+			toAlice = append(toAlice, ts...)
+		}
+	}
+
+	assertDeepEquals(t, received, []MessagePlaintext{MessagePlaintext("one"), MessagePlaintext("two")})
+	assertNil(t, alice.QueuedMessages())
+}
+
+func Test_FlushQueuedMessages_failsWithoutEncryption(t *testing.T) {
+	c, _ := queueingConversation()
+	c.Send(ValidMessage("one"))
+
+	_, err := c.FlushQueuedMessages()
+
+	assertEquals(t, err, errCannotSendUnencrypted)
+	assertEquals(t, len(c.QueuedMessages()), 1)
+}
+
+func Test_FlushQueuedMessages_sendsTheQueuedMessages(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	alice.currentMessageID = 1
+	alice.queueMessage(MessagePlaintext("waiting"))
+	alice.currentMessageID = 0
+	events := alice.recordDeliveryEvents()
+
+	msgs, err := alice.FlushQueuedMessages()
+
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+	plain, _, err := bob.Receive(msgs[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("waiting"))
+	assertNil(t, alice.QueuedMessages())
+	assertDeepEquals(t, *events, []deliveryEventRecord{{1, DeliverySent}})
+}
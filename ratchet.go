@@ -0,0 +1,436 @@
+package otr3
+
+import (
+	"io"
+	"math/big"
+)
+
+// The limits on how many message keys a ratchet keeps for messages that haven't arrived yet. A message that would
+// need more keys to be skipped is rejected, and when too many keys are stored the oldest ones are forgotten.
+const (
+	defaultRatchetMaxSkip   = 1000
+	defaultRatchetMaxStored = 1000
+)
+
+// A new DH key is used in every third ratchet. The other ratchets derive the brace key from the previous one.
+const ratchetDHInterval = 3
+
+const (
+	ratchetRootKeyLen    = 64
+	ratchetChainKeyLen   = 64
+	ratchetMessageKeyLen = 32
+	ratchetExtraKeyLen   = 32
+	ratchetBraceKeyLen   = 32
+)
+
+// skippedKeyID identifies the message key of a message that hasn't arrived yet by the ECDH key of its chain and
+// its number in the chain
+type skippedKeyID struct {
+	ecdh      string
+	messageID uint32
+}
+
+// messageKeys are the keys for one message. They are only used once.
+type messageKeys struct {
+	encKey   []byte
+	macKey   []byte
+	extraKey []byte
+}
+
+func (k messageKeys) wipe() {
+	wipeBytes(k.encKey)
+	wipeBytes(k.macKey)
+	wipeBytes(k.extraKey)
+}
+
+// ratchet is the OTRv4 double ratchet. Every time the direction of the conversation changes the sender generates a
+// new ECDH key, and every third time a new 3072-bit DH key as well, and mixes them into the root key. Each message in
+// a chain is encrypted with its own key, derived from the chain key, which is then replaced.
+//
+// The ratchet is self-contained: it can be seeded from any shared secret, as long as exactly one side starts it.
+type ratchet struct {
+	rootKey  []byte
+	braceKey []byte
+
+	ratchetID         uint32
+	sendingMessageID  uint32
+	previousChainN    uint32
+	receivingMessages uint32
+
+	ourECDHSecret *ed448Scalar
+	ourECDH       *ed448Point
+	theirECDH     *ed448Point
+
+	ourDHSecret *big.Int
+	ourDH       *big.Int
+	theirDH     *big.Int
+
+	sendingChainKey   []byte
+	receivingChainKey []byte
+
+	// shouldRatchet is true when we have to start a new sending chain before we send the next message
+	shouldRatchet bool
+	// sendDH is true when the current sending chain was started with a new DH key, which the messages have to carry
+	sendDH bool
+
+	skipped      map[skippedKeyID]messageKeys
+	skippedOrder []skippedKeyID
+	maxSkip      uint32
+	maxStored    int
+
+	// macKeysToReveal are the MAC keys of messages we have received, which are revealed in the next new chain we send
+	macKeysToReveal [][]byte
+}
+
+// firstRatchetKeys derives the ECDH and DH keys the side that doesn't start the ratchet begins with. Both sides know
+// them, which is fine since they are replaced with the first ratchet and are only as secret as the shared secret.
+func firstRatchetKeys(sharedSecret []byte) (ecdhSecret *ed448Scalar, dhSecret *big.Int) {
+	sym := kdf(usageFirstECDHSecret, ed448ScalarLen, sharedSecret)
+	defer wipeBytes(sym)
+
+	ecdhSecret = ed448SecretFromSymmetric(sym)
+
+	dh := kdf(usageFirstDHSecret, dh3072SecretLen, sharedSecret)
+	defer wipeBytes(dh)
+
+	return ecdhSecret, new(big.Int).SetBytes(dh)
+}
+
+// newRatchet seeds a ratchet from the shared secret. Exactly one of the sides has to start the ratchet, which means it
+// does the first ratchet when it sends its first message. The other side can send right away as well, in a chain that
+// is derived directly from the shared secret.
+func newRatchet(sharedSecret []byte, weStart bool) *ratchet {
+	r := &ratchet{
+		rootKey:   kdf(usageFirstRootKey, ratchetRootKeyLen, sharedSecret),
+		skipped:   make(map[skippedKeyID]messageKeys),
+		maxSkip:   defaultRatchetMaxSkip,
+		maxStored: defaultRatchetMaxStored,
+	}
+
+	ecdhSecret, dhSecret := firstRatchetKeys(sharedSecret)
+	firstChain := kdf(usageFirstChainKey, ratchetChainKeyLen, sharedSecret)
+
+	if weStart {
+		r.theirECDH = ed448BaseMul(ecdhSecret)
+		r.theirDH = new(big.Int).Exp(dh3072G, dhSecret, dh3072P)
+		r.receivingChainKey = firstChain
+		r.shouldRatchet = true
+		wipeEd448Scalar(ecdhSecret)
+		wipeBigInt(dhSecret)
+	} else {
+		r.ourECDHSecret, r.ourECDH = ecdhSecret, ed448BaseMul(ecdhSecret)
+		r.ourDHSecret, r.ourDH = dhSecret, new(big.Int).Exp(dh3072G, dhSecret, dh3072P)
+		r.sendingChainKey = firstChain
+	}
+
+	return r
+}
+
+func deriveMessageKeys(chainKey []byte) messageKeys {
+	enc := kdf(usageMessageKey, ratchetMessageKeyLen, chainKey)
+	return messageKeys{
+		encKey:   enc,
+		macKey:   kdf(usageMACKey, ratchetMACKeyLen, enc),
+		extraKey: kdf(usageExtraSymmetricKey, ratchetExtraKeyLen, []byte{0xFF}, chainKey),
+	}
+}
+
+func nextChainKey(chainKey []byte) []byte {
+	return kdf(usageNextChainKey, ratchetChainKeyLen, chainKey)
+}
+
+// mixKeys derives the new root key and chain key from the current root key, the ECDH shared secret and the brace key
+func mixKeys(rootKey []byte, ecdh *ed448Point, braceKey []byte) (newRootKey, chainKey []byte, err error) {
+	if ecdh.isIdentity() {
+		return nil, nil, errInvalidOTRMessage
+	}
+
+	k := kdf(usageSharedSecret, 64, ecdh.encode(), braceKey)
+	defer wipeBytes(k)
+
+	return kdf(usageRootKey, ratchetRootKeyLen, rootKey, k), kdf(usageChainKey, ratchetChainKeyLen, rootKey, k), nil
+}
+
+func nextBraceKey(braceKey []byte, ourDHSecret, theirDH *big.Int, newDH bool) ([]byte, error) {
+	if newDH {
+		kDH := dh3072SharedSecret(ourDHSecret, theirDH)
+		defer wipeBytes(kDH)
+		return kdf(usageThirdBraceKey, ratchetBraceKeyLen, kDH), nil
+	}
+
+	if braceKey == nil {
+		return nil, errInvalidOTRMessage
+	}
+	return kdf(usageBraceKey, ratchetBraceKeyLen, braceKey), nil
+}
+
+// ratchetSending starts a new sending chain with a new ECDH key, and a new DH key every third time
+func (r *ratchet) ratchetSending(rand io.Reader) (err error) {
+	ecdhSecret, err := randomEd448Secret(rand)
+	if err != nil {
+		return err
+	}
+
+	newDH := r.ratchetID%ratchetDHInterval == 0
+	dhSecret, dh := r.ourDHSecret, r.ourDH
+	if newDH {
+		if dhSecret, dh, err = dh3072KeyPair(rand); err != nil {
+			return err
+		}
+	}
+
+	braceKey, err := nextBraceKey(r.braceKey, dhSecret, r.theirDH, newDH)
+	if err != nil {
+		return err
+	}
+
+	rootKey, chainKey, err := mixKeys(r.rootKey, r.theirECDH.mul(ecdhSecret), braceKey)
+	if err != nil {
+		return err
+	}
+
+	wipeEd448Scalar(r.ourECDHSecret)
+	r.ourECDHSecret, r.ourECDH = ecdhSecret, ed448BaseMul(ecdhSecret)
+	if newDH {
+		wipeBigInt(r.ourDHSecret)
+		r.ourDHSecret, r.ourDH = dhSecret, dh
+	}
+
+	wipeBytes(r.braceKey)
+	wipeBytes(r.rootKey)
+	wipeBytes(r.sendingChainKey)
+	r.braceKey, r.rootKey, r.sendingChainKey = braceKey, rootKey, chainKey
+
+	r.previousChainN = r.sendingMessageID
+	r.sendingMessageID = 0
+	r.ratchetID++
+	r.shouldRatchet = false
+	r.sendDH = newDH
+
+	return nil
+}
+
+// encrypt creates a data message for the plain text. The header is authenticated together with the message.
+func (r *ratchet) encrypt(rand io.Reader, header []byte, flag byte, plain plainDataMsg) (*ratchetDataMsg, []byte, error) {
+	var revealed [][]byte
+	if r.shouldRatchet {
+		if err := r.ratchetSending(rand); err != nil {
+			return nil, nil, err
+		}
+		revealed, r.macKeysToReveal = r.macKeysToReveal, nil
+	}
+
+	keys := deriveMessageKeys(r.sendingChainKey)
+	defer wipeBytes(keys.encKey)
+	defer wipeBytes(keys.macKey)
+
+	dh := new(big.Int)
+	if r.sendDH {
+		dh = r.ourDH
+	}
+
+	msg := &ratchetDataMsg{
+		flag:            flag,
+		previousChainN:  r.previousChainN,
+		ratchetID:       r.ratchetID,
+		messageID:       r.sendingMessageID,
+		ecdh:            r.ourECDH,
+		dh:              dh,
+		encryptedMsg:    encryptRatchetMessage(keys.encKey, plain),
+		revealedMACKeys: revealed,
+	}
+	msg.sign(keys.macKey, header)
+
+	next := nextChainKey(r.sendingChainKey)
+	wipeBytes(r.sendingChainKey)
+	r.sendingChainKey = next
+	r.sendingMessageID++
+
+	return msg, keys.extraKey, nil
+}
+
+// Each message key is only used once, so the counter can always start at zero
+func encryptRatchetMessage(key []byte, plain plainDataMsg) []byte {
+	return plain.encrypt(key, [8]byte{})
+}
+
+func decryptRatchetMessage(key, encrypted []byte) (plainDataMsg, error) {
+	p := plainDataMsg{}
+	err := p.decrypt(key, [8]byte{}, encrypted)
+	return p, err
+}
+
+// skipKeys walks the chain from the message number from up to the message number to, returning the keys of the
+// messages in between and the chain key for the message to
+func skipKeys(ecdh *ed448Point, chainKey []byte, from, to uint32) (map[skippedKeyID]messageKeys, []skippedKeyID, []byte) {
+	keys := make(map[skippedKeyID]messageKeys)
+	var order []skippedKeyID
+
+	id := string(ecdh.encode())
+	for n := from; n < to; n++ {
+		k := skippedKeyID{ecdh: id, messageID: n}
+		keys[k] = deriveMessageKeys(chainKey)
+		order = append(order, k)
+		chainKey = nextChainKey(chainKey)
+	}
+
+	return keys, order, chainKey
+}
+
+// decrypt checks and decrypts a data message. The state of the ratchet is only changed if the message is authentic.
+// Messages can arrive out of order, as long as not too many messages are missing.
+func (r *ratchet) decrypt(header []byte, msg *ratchetDataMsg) (plainDataMsg, []byte, error) {
+	id := skippedKeyID{ecdh: string(msg.ecdh.encode()), messageID: msg.messageID}
+	if keys, ok := r.skipped[id]; ok {
+		return r.decryptWithSkippedKey(header, msg, id, keys)
+	}
+
+	newChain := r.theirECDH == nil || !msg.ecdh.equals(r.theirECDH)
+
+	var oldSkipped map[skippedKeyID]messageKeys
+	var oldOrder []skippedKeyID
+
+	chainKey, from := r.receivingChainKey, r.receivingMessages
+	rootKey, braceKey := r.rootKey, r.braceKey
+	if newChain {
+		if r.ourECDHSecret == nil {
+			return plainDataMsg{}, nil, errInvalidOTRMessage
+		}
+
+		if r.receivingChainKey != nil {
+			if msg.previousChainN < r.receivingMessages || msg.previousChainN-r.receivingMessages > r.maxSkip {
+				return plainDataMsg{}, nil, errTooManySkippedMessages
+			}
+			oldSkipped, oldOrder, _ = skipKeys(r.theirECDH, r.receivingChainKey, r.receivingMessages, msg.previousChainN)
+		}
+
+		var err error
+		if braceKey, err = nextBraceKey(r.braceKey, r.ourDHSecret, msg.dh, msg.dh.Sign() != 0); err != nil {
+			return plainDataMsg{}, nil, err
+		}
+		if rootKey, chainKey, err = mixKeys(r.rootKey, msg.ecdh.mul(r.ourECDHSecret), braceKey); err != nil {
+			return plainDataMsg{}, nil, err
+		}
+		from = 0
+	}
+
+	if msg.messageID < from {
+		return plainDataMsg{}, nil, errMessageKeyUsed
+	}
+	if msg.messageID-from > r.maxSkip {
+		return plainDataMsg{}, nil, errTooManySkippedMessages
+	}
+
+	newSkipped, newOrder, chainKey := skipKeys(msg.ecdh, chainKey, from, msg.messageID)
+	keys := deriveMessageKeys(chainKey)
+
+	p, err := r.open(header, msg, keys)
+	if err != nil {
+		return plainDataMsg{}, nil, err
+	}
+
+	if newChain {
+		r.storeSkipped(oldSkipped, oldOrder)
+
+		r.theirECDH = msg.ecdh
+		if msg.dh.Sign() != 0 {
+			r.theirDH = msg.dh
+		}
+		wipeBytes(r.rootKey)
+		wipeBytes(r.braceKey)
+		r.rootKey, r.braceKey = rootKey, braceKey
+		r.ratchetID++
+		r.shouldRatchet = true
+	}
+	r.storeSkipped(newSkipped, newOrder)
+
+	wipeBytes(r.receivingChainKey)
+	r.receivingChainKey = nextChainKey(chainKey)
+	r.receivingMessages = msg.messageID + 1
+
+	r.macKeysToReveal = append(r.macKeysToReveal, keys.macKey)
+	wipeBytes(keys.encKey)
+
+	return p, keys.extraKey, nil
+}
+
+func (r *ratchet) decryptWithSkippedKey(header []byte, msg *ratchetDataMsg, id skippedKeyID, keys messageKeys) (plainDataMsg, []byte, error) {
+	p, err := r.open(header, msg, keys)
+	if err != nil {
+		return plainDataMsg{}, nil, err
+	}
+
+	delete(r.skipped, id)
+	r.removeFromSkippedOrder(id)
+	r.macKeysToReveal = append(r.macKeysToReveal, keys.macKey)
+	wipeBytes(keys.encKey)
+
+	return p, keys.extraKey, nil
+}
+
+func (r *ratchet) open(header []byte, msg *ratchetDataMsg, keys messageKeys) (plainDataMsg, error) {
+	if err := msg.checkSign(keys.macKey, header); err != nil {
+		return plainDataMsg{}, err
+	}
+	return decryptRatchetMessage(keys.encKey, msg.encryptedMsg)
+}
+
+// storeSkipped keeps the keys for messages that haven't arrived yet. If there are too many, the oldest keys are
+// forgotten and their MAC keys are revealed, since those messages can't be read anymore anyway.
+func (r *ratchet) storeSkipped(keys map[skippedKeyID]messageKeys, order []skippedKeyID) {
+	for _, id := range order {
+		r.skipped[id] = keys[id]
+		r.skippedOrder = append(r.skippedOrder, id)
+	}
+
+	for len(r.skippedOrder) > r.maxStored {
+		id := r.skippedOrder[0]
+		r.skippedOrder = r.skippedOrder[1:]
+
+		k := r.skipped[id]
+		delete(r.skipped, id)
+		r.macKeysToReveal = append(r.macKeysToReveal, k.macKey)
+		wipeBytes(k.encKey)
+		wipeBytes(k.extraKey)
+	}
+}
+
+func (r *ratchet) removeFromSkippedOrder(id skippedKeyID) {
+	for i, o := range r.skippedOrder {
+		if o == id {
+			r.skippedOrder = append(r.skippedOrder[:i], r.skippedOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *ratchet) wipe() {
+	if r == nil {
+		return
+	}
+
+	wipeBytes(r.rootKey)
+	r.rootKey = nil
+	wipeBytes(r.braceKey)
+	r.braceKey = nil
+	wipeBytes(r.sendingChainKey)
+	r.sendingChainKey = nil
+	wipeBytes(r.receivingChainKey)
+	r.receivingChainKey = nil
+
+	wipeEd448Scalar(r.ourECDHSecret)
+	r.ourECDHSecret = nil
+	wipeBigInt(r.ourDHSecret)
+	r.ourDHSecret = nil
+
+	for id, k := range r.skipped {
+		k.wipe()
+		delete(r.skipped, id)
+	}
+	r.skippedOrder = nil
+
+	for _, k := range r.macKeysToReveal {
+		wipeBytes(k)
+	}
+	r.macKeysToReveal = nil
+}
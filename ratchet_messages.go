@@ -0,0 +1,130 @@
+package otr3
+
+import (
+	"crypto/subtle"
+	"math/big"
+
+	"github.com/coyim/gotrax"
+)
+
+const (
+	ratchetMACKeyLen        = 64
+	ratchetAuthenticatorLen = 64
+)
+
+// ratchetDataMsg is an OTRv4 data message. The DH key is only sent with the messages of every third ratchet, and is
+// zero otherwise.
+type ratchetDataMsg struct {
+	flag            byte
+	previousChainN  uint32
+	ratchetID       uint32
+	messageID       uint32
+	ecdh            *ed448Point
+	dh              *big.Int
+	encryptedMsg    []byte
+	authenticator   []byte
+	revealedMACKeys [][]byte
+
+	serializeUnsignedCache []byte
+}
+
+func (m ratchetDataMsg) serializeUnsigned() []byte {
+	var out []byte
+
+	out = append(out, m.flag)
+	out = gotrax.AppendWord(out, m.previousChainN)
+	out = gotrax.AppendWord(out, m.ratchetID)
+	out = gotrax.AppendWord(out, m.messageID)
+	out = append(out, m.ecdh.encode()...)
+	out = gotrax.AppendMPI(out, m.dh)
+	return gotrax.AppendData(out, m.encryptedMsg)
+}
+
+// calculateAuthenticator authenticates the header of the message together with the unsigned part of the message
+func (m *ratchetDataMsg) calculateAuthenticator(macKey, header []byte) []byte {
+	if m.serializeUnsignedCache == nil {
+		m.serializeUnsignedCache = m.serializeUnsigned()
+	}
+
+	sections := kdf(usageDataMessageSections, 64, header, m.serializeUnsignedCache)
+	return kdf(usageAuthenticator, ratchetAuthenticatorLen, macKey, sections)
+}
+
+func (m *ratchetDataMsg) sign(macKey, header []byte) {
+	m.authenticator = m.calculateAuthenticator(macKey, header)
+}
+
+func (m *ratchetDataMsg) checkSign(macKey, header []byte) error {
+	if subtle.ConstantTimeCompare(m.authenticator, m.calculateAuthenticator(macKey, header)) == 0 {
+		return newOtrConflictError("bad authenticator in data message")
+	}
+	return nil
+}
+
+func (m ratchetDataMsg) serialize() []byte {
+	if m.serializeUnsignedCache == nil {
+		m.serializeUnsignedCache = m.serializeUnsigned()
+	}
+
+	out := makeCopy(m.serializeUnsignedCache)
+	out = append(out, m.authenticator...)
+
+	revealed := make([]byte, 0, len(m.revealedMACKeys)*ratchetMACKeyLen)
+	for _, k := range m.revealedMACKeys {
+		revealed = append(revealed, k...)
+	}
+	return gotrax.AppendData(out, revealed)
+}
+
+func (m *ratchetDataMsg) deserialize(msg []byte) error {
+	if len(msg) == 0 {
+		return newOtrError("ratchetDataMsg.deserialize empty message")
+	}
+	m.flag = msg[0]
+	in := msg[1:]
+
+	var ok bool
+	if in, m.previousChainN, ok = gotrax.ExtractWord(in); !ok {
+		return newOtrError("ratchetDataMsg.deserialize corrupted previous chain message number")
+	}
+	if in, m.ratchetID, ok = gotrax.ExtractWord(in); !ok {
+		return newOtrError("ratchetDataMsg.deserialize corrupted ratchet id")
+	}
+	if in, m.messageID, ok = gotrax.ExtractWord(in); !ok {
+		return newOtrError("ratchetDataMsg.deserialize corrupted message id")
+	}
+	if in, m.ecdh, ok = extractEd448Point(in); !ok || !isValidEd448Point(m.ecdh) {
+		return newOtrError("ratchetDataMsg.deserialize corrupted ECDH key")
+	}
+	if in, m.dh, ok = gotrax.ExtractMPI(in); !ok {
+		return newOtrError("ratchetDataMsg.deserialize corrupted DH key")
+	}
+	if m.dh.Sign() != 0 && !isDH3072GroupElement(m.dh) {
+		return newOtrError("ratchetDataMsg.deserialize invalid DH key")
+	}
+	if in, m.encryptedMsg, ok = gotrax.ExtractData(in); !ok {
+		return newOtrError("ratchetDataMsg.deserialize corrupted encrypted message")
+	}
+	m.serializeUnsignedCache = msg[:len(msg)-len(in)]
+
+	if len(in) < ratchetAuthenticatorLen {
+		return newOtrError("ratchetDataMsg.deserialize corrupted authenticator")
+	}
+	m.authenticator = in[:ratchetAuthenticatorLen]
+	in = in[ratchetAuthenticatorLen:]
+
+	var revealed []byte
+	if in, revealed, ok = gotrax.ExtractData(in); !ok || len(revealed)%ratchetMACKeyLen != 0 {
+		return newOtrError("ratchetDataMsg.deserialize corrupted revealed MAC keys")
+	}
+	for len(revealed) > 0 {
+		m.revealedMACKeys = append(m.revealedMACKeys, revealed[:ratchetMACKeyLen])
+		revealed = revealed[ratchetMACKeyLen:]
+	}
+
+	if len(in) != 0 {
+		return newOtrError("ratchetDataMsg.deserialize trailing data")
+	}
+
+	return nil
+}
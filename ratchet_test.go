@@ -0,0 +1,253 @@
+//go:build go1.15
+// +build go1.15
+
+package otr3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+var ratchetTestHeader = []byte{0x00, 0x04, 0x03, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x01}
+
+func ratchetPair() (starter, other *ratchet) {
+	secret := bytes.Repeat([]byte{0x42}, 64)
+	return newRatchet(secret, true), newRatchet(secret, false)
+}
+
+func ratchetSend(t *testing.T, r *ratchet, text string) *ratchetDataMsg {
+	msg, _, err := r.encrypt(rand.Reader, ratchetTestHeader, messageFlagNormal, plainDataMsg{message: []byte(text)})
+	assertNil(t, err)
+
+	res := &ratchetDataMsg{}
+	assertNil(t, res.deserialize(msg.serialize()))
+	return res
+}
+
+func ratchetReceive(t *testing.T, r *ratchet, msg *ratchetDataMsg, expected string) {
+	p, _, err := r.decrypt(ratchetTestHeader, msg)
+	assertNil(t, err)
+	assertDeepEquals(t, p.message, []byte(expected))
+}
+
+func Test_ratchet_exchangesMessagesInBothDirections(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	for i := 0; i < 7; i++ {
+		ratchetReceive(t, bob, ratchetSend(t, alice, "hello"), "hello")
+		ratchetReceive(t, bob, ratchetSend(t, alice, "again"), "again")
+		ratchetReceive(t, alice, ratchetSend(t, bob, "hi"), "hi")
+	}
+
+	assertEquals(t, alice.ratchetID, uint32(14))
+	assertEquals(t, bob.ratchetID, uint32(14))
+}
+
+func Test_ratchet_theSideThatDoesntStartCanSendFirst(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	ratchetReceive(t, alice, ratchetSend(t, bob, "first"), "first")
+	ratchetReceive(t, bob, ratchetSend(t, alice, "second"), "second")
+	ratchetReceive(t, alice, ratchetSend(t, bob, "third"), "third")
+}
+
+func Test_ratchet_bothSidesCanSendBeforeReceivingAnything(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	fromAlice := ratchetSend(t, alice, "from alice")
+	fromBob := ratchetSend(t, bob, "from bob")
+
+	ratchetReceive(t, bob, fromAlice, "from alice")
+	ratchetReceive(t, alice, fromBob, "from bob")
+	ratchetReceive(t, alice, ratchetSend(t, bob, "reply"), "reply")
+}
+
+func Test_ratchet_sendsANewDHKeyEveryThirdRatchet(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	var withDH []bool
+	for i := 0; i < 3; i++ {
+		msg := ratchetSend(t, alice, "ping")
+		withDH = append(withDH, msg.dh.Sign() != 0)
+		ratchetReceive(t, bob, msg, "ping")
+
+		msg = ratchetSend(t, bob, "pong")
+		withDH = append(withDH, msg.dh.Sign() != 0)
+		ratchetReceive(t, alice, msg, "pong")
+	}
+
+	assertDeepEquals(t, withDH, []bool{true, false, false, true, false, false})
+}
+
+func Test_ratchet_allMessagesOfAChainWithANewDHKeyCarryIt(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	ratchetSend(t, alice, "lost")
+	msg := ratchetSend(t, alice, "arrives")
+
+	assertTrue(t, msg.dh.Sign() != 0)
+	ratchetReceive(t, bob, msg, "arrives")
+}
+
+func Test_ratchet_decryptsMessagesThatArriveOutOfOrder(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	first := ratchetSend(t, alice, "one")
+	second := ratchetSend(t, alice, "two")
+	third := ratchetSend(t, alice, "three")
+
+	ratchetReceive(t, bob, third, "three")
+	assertEquals(t, len(bob.skipped), 2)
+	ratchetReceive(t, bob, first, "one")
+	ratchetReceive(t, bob, second, "two")
+	assertEquals(t, len(bob.skipped), 0)
+}
+
+func Test_ratchet_decryptsMessagesFromAPreviousChain(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	ratchetReceive(t, bob, ratchetSend(t, alice, "one"), "one")
+	late := ratchetSend(t, alice, "late")
+	ratchetReceive(t, alice, ratchetSend(t, bob, "reply"), "reply")
+
+	ratchetReceive(t, bob, ratchetSend(t, alice, "new chain"), "new chain")
+	ratchetReceive(t, bob, late, "late")
+}
+
+func Test_ratchet_rejectsAMessageThatWasAlreadyReceived(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	msg := ratchetSend(t, alice, "once")
+	ratchetReceive(t, bob, msg, "once")
+
+	_, _, err := bob.decrypt(ratchetTestHeader, msg)
+	assertEquals(t, err, errMessageKeyUsed)
+}
+
+func Test_ratchet_rejectsAMessageWhenTooManyMessagesAreMissing(t *testing.T) {
+	alice, bob := ratchetPair()
+	bob.maxSkip = 2
+
+	for i := 0; i < 3; i++ {
+		ratchetSend(t, alice, "lost")
+	}
+
+	_, _, err := bob.decrypt(ratchetTestHeader, ratchetSend(t, alice, "too far"))
+	assertEquals(t, err, errTooManySkippedMessages)
+	assertEquals(t, len(bob.skipped), 0)
+}
+
+func Test_ratchet_forgetsTheOldestKeysAndRevealsTheirMACKeys(t *testing.T) {
+	alice, bob := ratchetPair()
+	bob.maxStored = 2
+
+	for i := 0; i < 3; i++ {
+		ratchetSend(t, alice, "lost")
+	}
+	ratchetReceive(t, bob, ratchetSend(t, alice, "arrives"), "arrives")
+
+	assertEquals(t, len(bob.skipped), 2)
+	assertEquals(t, len(bob.skippedOrder), 2)
+	assertEquals(t, bob.skippedOrder[0].messageID, uint32(1))
+	assertEquals(t, len(bob.macKeysToReveal), 2)
+}
+
+func Test_ratchet_revealsTheMACKeysOfReceivedMessagesInTheNextChain(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	first := ratchetSend(t, alice, "one")
+	sent := first.serialize()
+	ratchetReceive(t, bob, first, "one")
+	ratchetReceive(t, bob, ratchetSend(t, alice, "two"), "two")
+
+	reply := ratchetSend(t, bob, "reply")
+	assertEquals(t, len(reply.revealedMACKeys), 2)
+
+	// Anyone can check the first message with the revealed key now
+	revealed := &ratchetDataMsg{}
+	assertNil(t, revealed.deserialize(sent))
+	assertNil(t, revealed.checkSign(reply.revealedMACKeys[0], ratchetTestHeader))
+	assertNil(t, bob.macKeysToReveal)
+
+	assertEquals(t, len(ratchetSend(t, bob, "more").revealedMACKeys), 0)
+}
+
+func Test_ratchet_leavesTheStateAloneForATamperedMessage(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	rootKey := makeCopy(bob.rootKey)
+	msg := ratchetSend(t, alice, "hello")
+	msg.encryptedMsg = makeCopy(msg.encryptedMsg)
+	msg.encryptedMsg[0] ^= 0x01
+	msg.serializeUnsignedCache = nil
+
+	_, _, err := bob.decrypt(ratchetTestHeader, msg)
+	assertNotNil(t, err)
+	assertDeepEquals(t, bob.rootKey, rootKey)
+	assertEquals(t, bob.ratchetID, uint32(0))
+	assertEquals(t, bob.receivingMessages, uint32(0))
+	assertFalse(t, bob.shouldRatchet)
+}
+
+func Test_ratchet_rejectsAMessageWithAnotherHeader(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	msg := ratchetSend(t, alice, "hello")
+	_, _, err := bob.decrypt([]byte{0x00, 0x04, 0x03}, msg)
+	assertNotNil(t, err)
+}
+
+func Test_ratchet_givesBothSidesTheSameExtraSymmetricKey(t *testing.T) {
+	alice, bob := ratchetPair()
+
+	msg, sent, err := alice.encrypt(rand.Reader, ratchetTestHeader, messageFlagNormal, plainDataMsg{message: []byte("x")})
+	assertNil(t, err)
+	_, received, err := bob.decrypt(ratchetTestHeader, msg)
+	assertNil(t, err)
+
+	assertEquals(t, len(sent), ratchetExtraKeyLen)
+	assertDeepEquals(t, sent, received)
+}
+
+func Test_ratchet_wipe_removesAllKeys(t *testing.T) {
+	alice, bob := ratchetPair()
+	ratchetSend(t, alice, "lost")
+	ratchetReceive(t, bob, ratchetSend(t, alice, "hello"), "hello")
+
+	bob.wipe()
+
+	assertNil(t, bob.rootKey)
+	assertNil(t, bob.receivingChainKey)
+	assertNil(t, bob.ourECDHSecret)
+	assertEquals(t, len(bob.skipped), 0)
+}
+
+func Test_ratchetDataMsg_serializesAndDeserializes(t *testing.T) {
+	alice, _ := ratchetPair()
+	msg, _, _ := alice.encrypt(rand.Reader, ratchetTestHeader, messageFlagIgnoreUnreadable, plainDataMsg{message: []byte("hello")})
+	msg.revealedMACKeys = [][]byte{bytes.Repeat([]byte{0x01}, ratchetMACKeyLen)}
+
+	res := ratchetDataMsg{}
+	assertNil(t, res.deserialize(msg.serialize()))
+
+	assertEquals(t, res.flag, messageFlagIgnoreUnreadable)
+	assertEquals(t, res.ratchetID, uint32(1))
+	assertTrue(t, res.ecdh.equals(msg.ecdh))
+	assertEquals(t, res.dh.Cmp(msg.dh), 0)
+	assertDeepEquals(t, res.encryptedMsg, msg.encryptedMsg)
+	assertDeepEquals(t, res.authenticator, msg.authenticator)
+	assertDeepEquals(t, res.revealedMACKeys, msg.revealedMACKeys)
+}
+
+func Test_ratchetDataMsg_deserialize_failsForTrailingDataOrBrokenMACKeys(t *testing.T) {
+	alice, _ := ratchetPair()
+	msg, _, _ := alice.encrypt(rand.Reader, ratchetTestHeader, messageFlagNormal, plainDataMsg{message: []byte("hello")})
+	ser := msg.serialize()
+
+	assertNotNil(t, (&ratchetDataMsg{}).deserialize(append(makeCopy(ser), 0x00)))
+
+	msg.revealedMACKeys = [][]byte{{0x01, 0x02}}
+	assertNotNil(t, (&ratchetDataMsg{}).deserialize(msg.serialize()))
+	assertNotNil(t, (&ratchetDataMsg{}).deserialize(ser[:20]))
+}
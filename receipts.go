@@ -0,0 +1,63 @@
+package otr3
+
+import "github.com/coyim/gotrax"
+
+// The TLV types for delivery receipts. They are not part of the OTR specification, which is fine since peers ignore
+// TLVs they don't know.
+const (
+	tlvTypeReceiptRequest = uint16(0x0100)
+	tlvTypeReceipt        = uint16(0x0101)
+)
+
+func isReceiptTLVType(tp uint16) bool {
+	return tp == tlvTypeReceiptRequest || tp == tlvTypeReceipt
+}
+
+func receiptTLV(tp uint16, id MessageID) tlv {
+	return tlv{
+		tlvType:   tp,
+		tlvLength: 8,
+		tlvValue:  gotrax.AppendLong(nil, uint64(id)),
+	}
+}
+
+// receiptRequestTLVs returns the TLV asking the peer to acknowledge the message we are sending now. We only ask for
+// receipts for messages the user sent, since those are the only ones we can signal events for.
+func (c *Conversation) receiptRequestTLVs(message []byte) []tlv {
+	if len(message) == 0 || c.currentMessageID == 0 || !c.Policies.has(requestReceipts) {
+		return nil
+	}
+	return []tlv{receiptTLV(tlvTypeReceiptRequest, c.currentMessageID)}
+}
+
+// processReceiptRequestTLV acknowledges a message the peer has sent us, if our policy allows receipts. The
+// acknowledgement is sent back in a data message flagged as ignore-unreadable, together with the answers to the other
+// TLVs.
+func (c *Conversation) processReceiptRequestTLV(t tlv, x dataMessageExtra) (*tlv, error) {
+	if !c.Policies.has(requestReceipts) {
+		return nil, nil
+	}
+
+	_, id, ok := gotrax.ExtractLong(t.tlvValue[:t.tlvLength])
+	if !ok {
+		return nil, newOtrError("corrupt receipt request")
+	}
+
+	r := receiptTLV(tlvTypeReceipt, MessageID(id))
+	return &r, nil
+}
+
+// processReceiptTLV signals that the peer has decrypted one of our messages. Since the peer can only know the IDs of
+// the messages we sent, anything else is ignored.
+func (c *Conversation) processReceiptTLV(t tlv, x dataMessageExtra) (*tlv, error) {
+	_, id, ok := gotrax.ExtractLong(t.tlvValue[:t.tlvLength])
+	if !ok {
+		return nil, newOtrError("corrupt receipt")
+	}
+
+	if id != 0 && MessageID(id) <= c.lastMessageID {
+		c.deliveryEvent(MessageID(id), DeliveryAcknowledged)
+	}
+
+	return nil, nil
+}
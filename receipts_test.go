@@ -0,0 +1,116 @@
+package otr3
+
+import "testing"
+
+func receiptConversationPair(t *testing.T) (alice, bob *Conversation) {
+	alice, bob = encryptedConversationPair(t)
+	alice.Policies.RequestReceipts()
+	bob.Policies.RequestReceipts()
+
+	// Otherwise bob would send a heartbeat together with the receipt
+	bob.updateLastSent()
+	return alice, bob
+}
+
+func Test_receipts_areNotRequestedWithoutThePolicy(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	bob.updateLastSent()
+
+	msg, _ := alice.Send(ValidMessage("hello"))
+	res, err := bob.ReceiveDetailed(msg[0])
+
+	assertNil(t, err)
+	assertNil(t, res.TLVs)
+	assertNil(t, res.ToSend)
+}
+
+func Test_receipts_areNotSentWithoutThePolicy(t *testing.T) {
+	alice, bob := receiptConversationPair(t)
+	bob.Policies = Policy(allowV2 | allowV3)
+	events := alice.recordDeliveryEvents()
+
+	id, msg, _ := alice.SendWithID(ValidMessage("hello"))
+	plain, toAlice, err := bob.Receive(msg[0])
+
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+	assertNil(t, toAlice)
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliverySent}})
+}
+
+func Test_receipts_areSentAutomaticallyAndSignalledWithTheMessageID(t *testing.T) {
+	alice, bob := receiptConversationPair(t)
+	events := alice.recordDeliveryEvents()
+
+	id, msg, _ := alice.SendWithID(ValidMessage("hello"))
+	plain, toAlice, err := bob.Receive(msg[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+	assertEquals(t, len(toAlice), 1)
+	assertEquals(t, extractDataMessageFlag(mustDecodeDataMessage(t, alice, toAlice[0])), messageFlagIgnoreUnreadable)
+
+	plain, _, err = alice.Receive(toAlice[0])
+	assertNil(t, err)
+	assertNil(t, plain)
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliverySent}, {id, DeliveryAcknowledged}})
+}
+
+func Test_receipts_areRequestedForMessagesSentAfterTheAKE(t *testing.T) {
+	alice, bob := conversationPairWithClock(fixtureClock(), allowV3|requireEncryption|requestReceipts)
+	events := alice.recordDeliveryEvents()
+
+	id, toBob, _ := alice.SendWithID(ValidMessage("queued"))
+	toAlice := deliverAll(t, bob, toBob)
+	for i := 0; i < 5 && len(toAlice) > 0; i++ {
+		toAlice = deliverAll(t, bob, deliverAll(t, alice, toAlice))
+	}
+
+	assertDeepEquals(t, (*events)[len(*events)-1], deliveryEventRecord{id, DeliveryAcknowledged})
+}
+
+func Test_receipts_areNotRequestedForMessagesWithoutAnID(t *testing.T) {
+	alice, _ := receiptConversationPair(t)
+
+	assertNil(t, alice.receiptRequestTLVs([]byte("hello")))
+
+	alice.currentMessageID = 1
+	assertNil(t, alice.receiptRequestTLVs(nil))
+}
+
+func Test_receipts_workForOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	alice.Policies.RequestReceipts()
+	bob.Policies.RequestReceipts()
+	runDAKE(t, alice, bob)
+	events := alice.recordDeliveryEvents()
+
+	id, msg, _ := alice.SendWithID(ValidMessage("hello"))
+	deliverAll(t, alice, deliverAll(t, bob, msg))
+
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliverySent}, {id, DeliveryAcknowledged}})
+}
+
+func Test_processReceiptTLV_ignoresIDsWeHaventUsed(t *testing.T) {
+	c := &Conversation{lastMessageID: 3}
+	events := c.recordDeliveryEvents()
+
+	c.processReceiptTLV(receiptTLV(tlvTypeReceipt, 0), dataMessageExtra{})
+	c.processReceiptTLV(receiptTLV(tlvTypeReceipt, 4), dataMessageExtra{})
+	c.processReceiptTLV(receiptTLV(tlvTypeReceipt, 3), dataMessageExtra{})
+
+	assertDeepEquals(t, *events, []deliveryEventRecord{{3, DeliveryAcknowledged}})
+}
+
+func Test_processReceiptRequestTLV_failsForACorruptRequest(t *testing.T) {
+	c := &Conversation{Policies: Policy(requestReceipts)}
+
+	_, err := c.processReceiptRequestTLV(tlv{tlvTypeReceiptRequest, 2, []byte{0x00, 0x01}}, dataMessageExtra{})
+	assertNotNil(t, err)
+}
+
+func Test_RegisterTLVHandler_failsForTheReceiptTypes(t *testing.T) {
+	c := &Conversation{}
+
+	err := c.RegisterTLVHandler(tlvTypeReceipt, dynamicTLVHandler{func(TLV) (*TLV, error) { return nil, nil }})
+	assertEquals(t, err, errReservedTLVType)
+}
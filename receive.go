@@ -36,7 +36,8 @@ func (c *Conversation) receiveUnit(m ValidMessage, forgetFragments bool) (plain
 		}
 	case msgGuessUnknown:
 		c.messageEvent(MessageEventReceivedMessageUnrecognized)
-	case msgGuessDHCommit, msgGuessDHKey, msgGuessRevealSig, msgGuessSignature, msgGuessData:
+	case msgGuessDHCommit, msgGuessDHKey, msgGuessRevealSig, msgGuessSignature, msgGuessData,
+		msgGuessIdentity, msgGuessAuthR, msgGuessAuthI:
 		plain, messagesToSend, err = c.receiveEncoded(encodedMessage(message))
 	}
 
@@ -151,9 +152,11 @@ func (c *Conversation) receiveDecoded(message messageWithHeader) (plain MessageP
 	}
 
 	msgType := messageHeader[2]
-	switch msgType {
-	case msgTypeData:
+	switch {
+	case msgType == msgTypeData:
 		return c.receiveDataMessage(messageHeader, messageBody)
+	case c.isOTRv4():
+		return c.receiveDAKEMessage(msgType, messageBody)
 	default:
 		return c.receiveAKEMessage(msgType, messageBody)
 	}
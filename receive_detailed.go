@@ -0,0 +1,75 @@
+package otr3
+
+// ReceivedMessage is the result of ReceiveDetailed. Apart from what Receive returns, it tells how the message was
+// protected.
+type ReceivedMessage struct {
+	// Plain is the human readable message, if there is one
+	Plain MessagePlaintext
+	// ToSend are the messages to send back to the peer
+	ToSend []ValidMessage
+
+	// Encrypted is true if the message was an encrypted data message that we could decrypt and authenticate. Only
+	// then the plain text was protected by OTR.
+	Encrypted bool
+	// Heartbeat is true for encrypted data messages without any human readable content
+	Heartbeat bool
+	// IgnoreUnreadable is true if the peer flagged the data message as one we can ignore if we can't read it
+	IgnoreUnreadable bool
+
+	// SenderInstanceTag is the instance tag of the peer that sent the data message
+	SenderInstanceTag uint32
+	// SenderKeyID and RecipientKeyID are the key IDs of the keys that decrypted an OTRv2 or OTRv3 data message. They
+	// are zero for OTRv4, which uses a new key for every message.
+	SenderKeyID    uint32
+	RecipientKeyID uint32
+
+	// TLVs are the TLVs the data message contained, except for padding
+	TLVs []TLV
+}
+
+// ReceiveDetailed handles a message from a peer, exactly like Receive. The result also describes whether the message
+// was encrypted, and with which keys, so that the protection of every single message can be shown.
+func (c *Conversation) ReceiveDetailed(m ValidMessage) (*ReceivedMessage, error) {
+	res := &ReceivedMessage{}
+
+	c.receiveDetails = res
+	defer func() { c.receiveDetails = nil }()
+
+	var err error
+	res.Plain, res.ToSend, err = c.Receive(m)
+	if err != nil {
+		res.Encrypted = false
+	}
+
+	return res, err
+}
+
+func (c *Conversation) detailDataMessageFlag(flag byte) {
+	if c.receiveDetails != nil {
+		c.receiveDetails.IgnoreUnreadable = flag&messageFlagIgnoreUnreadable == messageFlagIgnoreUnreadable
+	}
+}
+
+func (c *Conversation) detailKeyIDs(sender, recipient uint32) {
+	if c.receiveDetails != nil {
+		c.receiveDetails.SenderKeyID = sender
+		c.receiveDetails.RecipientKeyID = recipient
+	}
+}
+
+func (c *Conversation) detailDecryptedDataMessage(p plainDataMsg) {
+	d := c.receiveDetails
+	if d == nil {
+		return
+	}
+
+	d.Encrypted = true
+	d.Heartbeat = len(p.message) == 0
+	d.SenderInstanceTag = c.theirInstanceTag
+
+	for _, t := range p.tlvs {
+		if t.tlvType != tlvTypePadding {
+			d.TLVs = append(d.TLVs, t.toTLV())
+		}
+	}
+}
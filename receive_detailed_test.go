@@ -0,0 +1,90 @@
+package otr3
+
+import "testing"
+
+func Test_ReceiveDetailed_describesAnEncryptedMessage(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	msg, _ := alice.Send(ValidMessage("hello"))
+	res, err := bob.ReceiveDetailed(msg[0])
+
+	assertNil(t, err)
+	assertDeepEquals(t, res.Plain, MessagePlaintext("hello"))
+	assertTrue(t, res.Encrypted)
+	assertFalse(t, res.Heartbeat)
+	assertFalse(t, res.IgnoreUnreadable)
+	assertEquals(t, res.SenderInstanceTag, alice.ourInstanceTag)
+	assertEquals(t, res.SenderKeyID, alice.keys.ourKeyID-1)
+	assertEquals(t, res.RecipientKeyID, alice.keys.theirKeyID)
+	assertNil(t, res.TLVs)
+}
+
+func Test_ReceiveDetailed_describesAPlaintextMessage(t *testing.T) {
+	_, bob := encryptedConversationPair(t)
+
+	res, err := bob.ReceiveDetailed(ValidMessage("hello"))
+
+	assertNil(t, err)
+	assertDeepEquals(t, res.Plain, MessagePlaintext("hello"))
+	assertFalse(t, res.Encrypted)
+	assertEquals(t, res.SenderInstanceTag, uint32(0))
+}
+
+func Test_ReceiveDetailed_describesAHeartbeatWithTLVs(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	bob.RegisterTLVHandler(0x42, dynamicTLVHandler{func(TLV) (*TLV, error) { return nil, nil }})
+
+	msg, _ := alice.SendWithTLVs(nil, TLV{Type: 0x42, Value: []byte{0x01}})
+	res, err := bob.ReceiveDetailed(msg[0])
+
+	assertNil(t, err)
+	assertNil(t, res.Plain)
+	assertTrue(t, res.Encrypted)
+	assertTrue(t, res.Heartbeat)
+	assertTrue(t, res.IgnoreUnreadable)
+	assertDeepEquals(t, res.TLVs, []TLV{{Type: 0x42, Value: []byte{0x01}}})
+}
+
+func Test_ReceiveDetailed_isNotEncryptedForAnUnreadableMessage(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	msg, _ := alice.Send(ValidMessage("hello"))
+	bob.ReceiveDetailed(msg[0])
+	res, err := bob.ReceiveDetailed(msg[0])
+
+	assertNotNil(t, err)
+	assertFalse(t, res.Encrypted)
+	assertNil(t, bob.receiveDetails)
+}
+
+func Test_ReceiveDetailed_describesAnOTRv4Message(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runDAKE(t, alice, bob)
+
+	msg, _ := alice.Send(ValidMessage("hello"))
+	res, err := bob.ReceiveDetailed(msg[0])
+
+	assertNil(t, err)
+	assertTrue(t, res.Encrypted)
+	assertEquals(t, res.SenderInstanceTag, alice.ourInstanceTag)
+	assertEquals(t, res.SenderKeyID, uint32(0))
+}
+
+func Test_ReceiveDetailed_onlyDescribesTheLastFragment(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	alice.fragmentSize = 100
+
+	msg, _ := alice.Send(ValidMessage("hello"))
+	assertTrue(t, len(msg) > 1)
+
+	for _, m := range msg[:len(msg)-1] {
+		res, err := bob.ReceiveDetailed(m)
+		assertNil(t, err)
+		assertFalse(t, res.Encrypted)
+	}
+
+	res, err := bob.ReceiveDetailed(msg[len(msg)-1])
+	assertNil(t, err)
+	assertTrue(t, res.Encrypted)
+	assertDeepEquals(t, res.Plain, MessagePlaintext("hello"))
+}
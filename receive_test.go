@@ -8,7 +8,7 @@ import (
 func Test_receiveDecoded_resolveProtocolVersion(t *testing.T) {
 	c := &Conversation{}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 	_, _, err := c.receiveDecoded(fixtureDHCommitMsg())
 
 	assertNil(t, err)
@@ -16,7 +16,7 @@ func Test_receiveDecoded_resolveProtocolVersion(t *testing.T) {
 
 	c = &Conversation{}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
-	c.Policies = policies(allowV2)
+	c.Policies = Policy(allowV2)
 	_, _, err = c.receiveDecoded(fixtureDHCommitMsgV2())
 
 	assertNil(t, err)
@@ -69,7 +69,7 @@ func Test_receivePlaintext_signalsAMessageEventThatItWasUnencryptedIfRequiringEn
 	c := &Conversation{}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	c.msgState = plainText
-	c.Policies = policies(requireEncryption)
+	c.Policies = Policy(requireEncryption)
 
 	c.expectMessageEvent(t, func() {
 		c.receivePlaintext(ValidMessage("Hello world"))
@@ -100,7 +100,7 @@ func Test_receiveTaggedPlaintext_signalsAMessageEventThatItWasUnencryptedIfRequi
 	c := &Conversation{}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
 	c.msgState = plainText
-	c.Policies = policies(requireEncryption)
+	c.Policies = Policy(requireEncryption)
 
 	c.expectMessageEvent(t, func() {
 		c.receiveTaggedPlaintext(ValidMessage("Hello \t  \t\t\t\t \t \t \t   world"))
@@ -120,7 +120,7 @@ func Test_receiveTaggedPlaintext_doesntSignalAMessageEventThatItWasUnencryptedIf
 func Test_Receive_signalsAMessageEventWhenWeReceiveAMessageThatLooksLikeAnOTRMessageButWeCantUnderstandIt(t *testing.T) {
 	c := &Conversation{}
 	c.SetOurKeys([]PrivateKey{bobPrivateKey})
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 
 	c.expectMessageEvent(t, func() {
 		c.Receive(ValidMessage("?OTR Something: strange"))
@@ -133,7 +133,7 @@ func Test_Receive_signalsAMessageEventWhenWeReceiveADataMessageForAnotherInstanc
 	alice.theirInstanceTag = 0x301
 	alice.SetOurKeys([]PrivateKey{alicePrivateKey})
 	alice.ourCurrentKey = alicePrivateKey
-	alice.Policies = policies(allowV3)
+	alice.Policies = Policy(allowV3)
 	alice.theirKey = bobPrivateKey.PublicKey()
 
 	bob := &Conversation{Rand: rand.Reader}
@@ -141,7 +141,7 @@ func Test_Receive_signalsAMessageEventWhenWeReceiveADataMessageForAnotherInstanc
 	bob.theirInstanceTag = 0x201
 	bob.SetOurKeys([]PrivateKey{bobPrivateKey})
 	bob.ourCurrentKey = bobPrivateKey
-	bob.Policies = policies(allowV3)
+	bob.Policies = Policy(allowV3)
 	bob.theirKey = alicePrivateKey.PublicKey()
 
 	var toSend []ValidMessage
@@ -227,7 +227,7 @@ func Test_receiveErrorMessage_willSignalAnEventWithTheErrorMessageWithoutLeading
 
 func Test_Receive_returnsAnErrorIfWeReceiveARequestToStartAVersion1KeyExchange(t *testing.T) {
 	c := &Conversation{}
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 
 	_, _, err := c.Receive(ValidMessage("?OTR:AAEK"))
 
@@ -0,0 +1,84 @@
+package otr3
+
+import "time"
+
+// RefreshPolicy decides when an encrypted conversation automatically runs a new AKE, which authenticates the long term
+// keys again and gives the conversation a new SSID. A zero value in a field disables that limit.
+type RefreshPolicy struct {
+	// AfterMessages is the number of messages sent and received after which the session is refreshed
+	AfterMessages int
+	// After is the time after which the session is refreshed
+	After time.Duration
+}
+
+// SetRefreshPolicy sets when the conversation should refresh its session automatically. Refreshes because of the number
+// of messages happen when sending or in Tick, and refreshes because of time happen in Tick.
+func (c *Conversation) SetRefreshPolicy(p RefreshPolicy) {
+	c.refreshPolicy = p
+}
+
+// Refresh starts a new AKE in an encrypted conversation. The current session keeps working until the AKE has finished,
+// at which point StillSecure is signalled. Nothing happens if an AKE is already in progress.
+func (c *Conversation) Refresh() ([]ValidMessage, error) {
+	if c.msgState != encrypted {
+		return nil, errCannotRefreshUnencrypted
+	}
+
+	ts, err := c.refresh()
+	return c.withInjections(c.encodeAndCombine(ts), err)
+}
+
+func (c *Conversation) refresh() ([]messageWithHeader, error) {
+	if c.akeInProgress() {
+		return nil, nil
+	}
+
+	c.refreshState.messages = 0
+	c.refreshState.lastAttempt = c.now()
+	c.markOurKeysReplaced()
+
+	ts, err := c.sendAKEStart()
+	if err != nil {
+		return nil, err
+	}
+	return compactMessagesWithHeader(ts), nil
+}
+
+// refreshDeadline returns when the session should be refreshed because of time, if there is such a limit
+func (c *Conversation) refreshDeadline() (time.Time, bool) {
+	if c.refreshPolicy.After == 0 || c.msgState != encrypted {
+		return time.Time{}, false
+	}
+
+	since := c.lastMessageStateChange
+	if c.refreshState.lastAttempt.After(since) {
+		since = c.refreshState.lastAttempt
+	}
+	return since.Add(c.refreshPolicy.After), true
+}
+
+func (c *Conversation) refreshIsDue() bool {
+	if c.msgState != encrypted || c.akeInProgress() {
+		return false
+	}
+
+	if c.refreshPolicy.AfterMessages > 0 && c.refreshState.messages >= c.refreshPolicy.AfterMessages {
+		return true
+	}
+
+	if c.ourKeysNeedReplacing() {
+		return true
+	}
+
+	deadline, ok := c.refreshDeadline()
+	return ok && !c.now().Before(deadline)
+}
+
+func (c *Conversation) countMessageForRefresh() {
+	c.refreshState.messages++
+}
+
+func (c *Conversation) resetRefreshState() {
+	c.refreshState.messages = 0
+	c.refreshState.lastAttempt = time.Time{}
+}
@@ -0,0 +1,159 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Refresh_failsWithoutEncryption(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3)}
+
+	_, err := c.Refresh()
+
+	assertEquals(t, err, errCannotRefreshUnencrypted)
+}
+
+func Test_Refresh_runsANewAKEAndSignalsStillSecure(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	ssid := alice.GetSSID()
+	alice.expectSecurityEvent(t, func() {
+		bob.expectSecurityEvent(t, func() {
+			msgs, err := alice.Refresh()
+			assertNil(t, err)
+			exchangeAll(t, alice, bob, msgs)
+		}, StillSecure)
+	}, StillSecure)
+
+	assertTrue(t, alice.IsEncrypted())
+	assertFalse(t, alice.GetSSID() == ssid)
+	assertEquals(t, alice.GetSSID(), bob.GetSSID())
+
+	msg, _ := alice.Send(ValidMessage("after the refresh"))
+	plain, _, err := bob.Receive(msg[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("after the refresh"))
+}
+
+func Test_Refresh_keepsTheCurrentSessionWorkingDuringTheAKE(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	alice.Refresh()
+	msg, _ := alice.Send(ValidMessage("still here"))
+	plain, _, err := bob.Receive(msg[0])
+
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("still here"))
+}
+
+func Test_Refresh_doesNothingWhenAnAKEIsInProgress(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	alice.Refresh()
+
+	msgs, err := alice.Refresh()
+
+	assertNil(t, err)
+	assertNil(t, msgs)
+}
+
+func Test_Refresh_worksForOTRv4(t *testing.T) {
+	alice, bob := otrv4ConversationPair(t)
+	runDAKE(t, alice, bob)
+	ssid := alice.GetSSID()
+
+	msgs, err := alice.Refresh()
+	assertNil(t, err)
+	exchangeAll(t, alice, bob, msgs)
+
+	assertFalse(t, alice.GetSSID() == ssid)
+	assertEquals(t, alice.GetSSID(), bob.GetSSID())
+}
+
+func Test_Send_refreshesTheSessionAfterTheConfiguredNumberOfMessages(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	alice.SetRefreshPolicy(RefreshPolicy{AfterMessages: 2})
+	ssid := alice.GetSSID()
+
+	first, _ := alice.Send(ValidMessage("one"))
+	assertEquals(t, len(first), 1)
+	second, _ := alice.Send(ValidMessage("two"))
+	assertEquals(t, len(second), 2)
+
+	exchangeAll(t, alice, bob, second)
+	assertFalse(t, alice.GetSSID() == ssid)
+	assertEquals(t, alice.refreshState.messages, 0)
+}
+
+func Test_Send_reportsAFailedRefreshWithoutFailingTheMessage(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	alice.SetRefreshPolicy(RefreshPolicy{AfterMessages: 1})
+	alice.Rand = fixedRand([]string{})
+	var setupErr error
+	alice.messageEventHandler = dynamicMessageEventHandler{func(e MessageEvent, _ []byte, err error, _ ...interface{}) {
+		if e == MessageEventSetupError {
+			setupErr = err
+		}
+	}}
+	events := alice.recordDeliveryEvents()
+
+	msgs, err := alice.Send(ValidMessage("hello"))
+
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+	assertEquals(t, setupErr, errShortRandomRead)
+	assertEquals(t, (*events)[0].event, DeliverySent)
+	plain, _, err := bob.Receive(msgs[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+}
+
+func Test_Receive_countsReceivedMessagesForTheRefresh(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+	alice.SetRefreshPolicy(RefreshPolicy{AfterMessages: 1})
+
+	msg, _ := bob.Send(ValidMessage("hello"))
+	alice.Receive(msg[0])
+
+	assertTrue(t, alice.refreshIsDue())
+	deadline, ok := alice.NextDeadline()
+	assertTrue(t, ok)
+	assertEquals(t, deadline, clock.Now())
+}
+
+func Test_Tick_refreshesTheSessionWhenTheTimeHasCome(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+	alice.SetRefreshPolicy(RefreshPolicy{After: time.Hour})
+	ssid := alice.GetSSID()
+
+	deadline, _ := alice.NextDeadline()
+	assertEquals(t, deadline, clock.Now().Add(time.Hour))
+
+	clock.Advance(time.Hour)
+	msgs, err := alice.Tick()
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+
+	exchangeAll(t, alice, bob, msgs)
+	assertFalse(t, alice.GetSSID() == ssid)
+	deadline, _ = alice.refreshDeadline()
+	assertEquals(t, deadline, clock.Now().Add(time.Hour))
+}
+
+func Test_Tick_waitsAnotherIntervalWhenTheRefreshDidntFinish(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+	alice.SetRefreshPolicy(RefreshPolicy{After: time.Hour})
+
+	clock.Advance(time.Hour)
+	alice.Tick()
+	clock.Advance(defaultAKETimeout)
+	alice.Tick()
+
+	assertFalse(t, alice.refreshIsDue())
+	deadline, _ := alice.NextDeadline()
+	assertEquals(t, deadline, fixtureClock().Now().Add(2*time.Hour))
+}
@@ -5,7 +5,7 @@ import (
 	"time"
 )
 
-const resendInterval = 60 * time.Second
+const defaultResendInterval = 60 * time.Second
 
 type retransmitFlag int
 
@@ -18,14 +18,22 @@ const (
 )
 
 type messageToResend struct {
-	m      MessagePlaintext
-	opaque []interface{}
+	m        MessagePlaintext
+	id       MessageID
+	sent     bool
+	queuedAt time.Time
+	opaque   []interface{}
 }
 
 type resendContext struct {
 	mayRetransmit    retransmitFlag
 	messageTransform func([]byte) []byte
 	retransmitting   bool
+	interval         time.Duration
+	maxQueued        int
+	expiry           time.Duration
+	// querySentAt is when we last asked the peer for an AKE for the queued messages, or zero if we haven't
+	querySentAt time.Time
 
 	messages struct {
 		m []messageToResend
@@ -34,6 +42,14 @@ type resendContext struct {
 }
 
 func (r *resendContext) later(msg MessagePlaintext, opaque ...interface{}) {
+	r.laterWithID(msg, 0, false, opaque...)
+}
+
+func (r *resendContext) laterWithID(msg MessagePlaintext, id MessageID, sent bool, opaque ...interface{}) {
+	r.add(messageToResend{m: msg, id: id, sent: sent, opaque: opaque})
+}
+
+func (r *resendContext) add(m messageToResend) {
 	if r.retransmitting {
 		return
 	}
@@ -44,7 +60,8 @@ func (r *resendContext) later(msg MessagePlaintext, opaque ...interface{}) {
 	if r.messages.m == nil {
 		r.messages.m = make([]messageToResend, 0, 5)
 	}
-	r.messages.m = append(r.messages.m, messageToResend{makeCopy(msg), opaque})
+	m.m = makeCopy(m.m)
+	r.messages.m = append(r.messages.m, m)
 }
 
 func (r *resendContext) pending() []messageToResend {
@@ -57,11 +74,68 @@ func (r *resendContext) pending() []messageToResend {
 	return ret
 }
 
+// queued returns the messages that have never been sent, in the order they were queued
+func (r *resendContext) queued() []messageToResend {
+	r.messages.RLock()
+	defer r.messages.RUnlock()
+
+	var ret []messageToResend
+	for _, m := range r.messages.m {
+		if !m.sent {
+			ret = append(ret, m)
+		}
+	}
+
+	return ret
+}
+
+// remove forgets the messages that have never been sent and match the predicate, returning them
+func (r *resendContext) remove(f func(messageToResend) bool) []messageToResend {
+	r.messages.Lock()
+	defer r.messages.Unlock()
+
+	var removed []messageToResend
+	kept := r.messages.m[:0]
+	for _, m := range r.messages.m {
+		if !m.sent && f(m) {
+			removed = append(removed, m)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	r.messages.m = kept
+
+	if len(kept) == 0 {
+		r.messages.m = nil
+		r.querySentAt = time.Time{}
+	}
+
+	return removed
+}
+
 func (r *resendContext) clear() {
 	r.messages.Lock()
 	defer r.messages.Unlock()
 
 	r.messages.m = nil
+	r.querySentAt = time.Time{}
+}
+
+// drop forgets all messages, returning the ones that were never sent
+func (r *resendContext) drop() []messageToResend {
+	r.messages.Lock()
+	defer r.messages.Unlock()
+
+	var dropped []messageToResend
+	for _, m := range r.messages.m {
+		if !m.sent {
+			dropped = append(dropped, m)
+		}
+	}
+	r.messages.m = nil
+	r.querySentAt = time.Time{}
+
+	return dropped
 }
 
 func (r *resendContext) shouldRetransmit() bool {
@@ -87,21 +161,73 @@ func (c *Conversation) resendMessageTransformer() func([]byte) []byte {
 	return c.resend.messageTransform
 }
 
-func (c *Conversation) lastMessage(msg MessagePlaintext, opaque ...interface{}) {
-	c.resend.later(msg, opaque...)
+// lastMessage remembers a message we have sent, in case the peer can't read it
+func (c *Conversation) lastMessage(msg MessagePlaintext) {
+	c.resend.laterWithID(msg, c.currentMessageID, true)
+}
+
+// queueMessage keeps a message that can't be sent yet, until a secure conversation has been established
+func (c *Conversation) queueMessage(msg MessagePlaintext, opaque ...interface{}) error {
+	if len(c.resend.queued()) >= c.maxQueuedMessages() {
+		c.messageEvent(MessageEventMessageDropped, opaque...)
+		c.deliveryEvent(c.currentMessageID, DeliveryDropped)
+		return errQueueFull
+	}
+
+	c.resend.add(messageToResend{m: msg, id: c.currentMessageID, queuedAt: c.now(), opaque: opaque})
+	c.deliveryEvent(c.currentMessageID, DeliveryQueued)
+	return nil
+}
+
+func (c *Conversation) dropQueuedMessages() {
+	c.signalDropped(c.resend.drop())
+	c.updateMayRetransmitTo(noRetransmit)
+}
+
+// dropExpiredQueuedMessages forgets the messages that have waited for longer than the expiry. Messages restored from
+// a saved state don't know when they were queued, so they are only dropped with the rest of the queue.
+func (c *Conversation) dropExpiredQueuedMessages() {
+	limit := c.now().Add(-c.queuedMessageExpiry())
+	c.signalDropped(c.resend.remove(func(m messageToResend) bool {
+		return !m.queuedAt.IsZero() && !m.queuedAt.After(limit)
+	}))
+}
+
+func (c *Conversation) signalDropped(msgs []messageToResend) {
+	for _, m := range msgs {
+		c.messageEvent(MessageEventMessageDropped, m.opaque...)
+		c.deliveryEvent(m.id, DeliveryDropped)
+	}
 }
 
 func (c *Conversation) updateMayRetransmitTo(f retransmitFlag) {
 	c.resend.mayRetransmit = f
 }
 
+// SetResendInterval sets for how long after the last message we sent that queued messages are still
+// resent when a secure conversation is established. A zero duration restores the default of 60 seconds.
+func (c *Conversation) SetResendInterval(d time.Duration) {
+	c.resend.interval = d
+}
+
+func (c *Conversation) resendInterval() time.Duration {
+	if c.resend.interval == 0 {
+		return defaultResendInterval
+	}
+	return c.resend.interval
+}
+
 func (c *Conversation) shouldRetransmit() bool {
 	return c.resend.shouldRetransmit() &&
-		c.heartbeat.lastSent.After(time.Now().Add(-resendInterval))
+		c.heartbeat.lastSent.After(c.now().Add(-c.resendInterval()))
 }
 
 func (c *Conversation) maybeRetransmit() ([]messageWithHeader, error) {
 	if !c.shouldRetransmit() {
+		// The queued messages have been waiting for too long
+		if c.resend.shouldRetransmit() {
+			c.dropQueuedMessages()
+		}
 		return nil, nil
 	}
 
@@ -109,38 +235,43 @@ func (c *Conversation) maybeRetransmit() ([]messageWithHeader, error) {
 }
 
 func (c *Conversation) retransmit() ([]messageWithHeader, error) {
+	c.dropExpiredQueuedMessages()
+
 	msgs := c.resend.pending()
 	c.resend.clear()
-	ret := make([]messageWithHeader, 0, len(msgs))
 
-	resending := c.resend.mayRetransmit == retransmitWithPrefix
+	return c.sendAgain(msgs, c.resend.mayRetransmit == retransmitWithPrefix)
+}
+
+func (c *Conversation) sendAgain(msgs []messageToResend, resending bool) ([]messageWithHeader, error) {
+	ret := make([]messageWithHeader, 0, len(msgs))
 
 	c.resend.startRetransmitting()
 	defer c.resend.endRetransmitting()
 
+	current := c.currentMessageID
+	defer func() { c.currentMessageID = current }()
+
 	for _, msgx := range msgs {
 		msg := msgx.m
 		if resending {
 			msg = c.resendMessageTransformer()(msg)
 		}
-		dataMsg, _, err := c.genDataMsg(msg)
+		c.currentMessageID = msgx.id
+		toSend, _, err := c.genDataMsgWithHeader(msg, messageFlagNormal)
 		if err != nil {
 			return nil, err
 		}
-
-		// It is actually safe to ignore this error, since the only possible error
-		// here is a problem with generating the instance tags for the message header,
-		// which we already do once in genDataMsg
-		toSend, _ := c.wrapMessageHeader(msgTypeData, dataMsg.serialize(c.version))
 		ret = append(ret, toSend)
 	}
 
-	ev := MessageEventMessageSent
+	ev, dev := MessageEventMessageSent, DeliverySent
 	if resending {
-		ev = MessageEventMessageResent
+		ev, dev = MessageEventMessageResent, DeliveryResent
 	}
 	for _, msgx := range msgs {
 		c.messageEvent(ev, msgx.opaque...)
+		c.deliveryEvent(msgx.id, dev)
 	}
 
 	c.updateLastSent()
@@ -234,3 +234,25 @@ func Test_maybeRetransmit_signalMessageEventWhenSendingMessageExact(t *testing.T
 		c.maybeRetransmit()
 	}, MessageEventMessageSent, nil, nil)
 }
+
+func Test_resendInterval_usesTheDefaultUnlessSet(t *testing.T) {
+	c := &Conversation{}
+	assertEquals(t, c.resendInterval(), defaultResendInterval)
+
+	c.SetResendInterval(5 * time.Second)
+	assertEquals(t, c.resendInterval(), 5*time.Second)
+}
+
+func Test_shouldRetransmit_usesTheConfiguredIntervalAndClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c := &Conversation{}
+	c.SetClock(clock)
+	c.SetResendInterval(5 * time.Minute)
+	fixtureCorrectResend(c)
+
+	clock.Advance(4 * time.Minute)
+	assertEquals(t, c.shouldRetransmit(), true)
+
+	clock.Advance(2 * time.Minute)
+	assertEquals(t, c.shouldRetransmit(), false)
+}
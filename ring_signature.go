@@ -0,0 +1,126 @@
+package otr3
+
+import "io"
+
+// ringSignature is the Schnorr-based ring signature OTRv4 uses in the DAKE. It proves that the signer knows the secret
+// of one of three public keys, without revealing which one. That is what makes the DAKE deniable: the peer can't
+// prove to anyone else that we signed, since they could have made the same signature with one of the other keys.
+type ringSignature struct {
+	c1, r1 *ed448Scalar
+	c2, r2 *ed448Scalar
+	c3, r3 *ed448Scalar
+}
+
+const ringSignatureLen = 6 * ed448ScalarLen
+
+// ringSign creates a signature of the message with the ring of keys. The secret has to belong to one of the keys.
+func ringSign(r io.Reader, secret *ed448Scalar, ring [3]*ed448Point, message []byte) (*ringSignature, error) {
+	pub := ed448BaseMul(secret)
+
+	signer := -1
+	for i, k := range ring {
+		if k.equals(pub) {
+			signer = i
+		}
+	}
+	if signer == -1 {
+		return nil, errNotInRing
+	}
+
+	var cs, rs [3]*ed448Scalar
+	var ts [3]*ed448Point
+
+	t, err := randomEd448Scalar(r)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeEd448Scalar(t)
+
+	for i := range ring {
+		if i == signer {
+			ts[i] = ed448BaseMul(t)
+			continue
+		}
+
+		if cs[i], err = randomEd448Scalar(r); err != nil {
+			return nil, err
+		}
+		if rs[i], err = randomEd448Scalar(r); err != nil {
+			return nil, err
+		}
+		ts[i] = ed448BaseMul(rs[i]).add(ring[i].mul(cs[i]))
+	}
+
+	c := ringChallenge(ring, ts, message)
+	c.Sub(c, cs[(signer+1)%3])
+	c.Sub(c, cs[(signer+2)%3])
+	cs[signer] = c
+
+	rs[signer] = &ed448Scalar{}
+	rs[signer].Mul(c, secret)
+	rs[signer].Sub(t, rs[signer])
+
+	return &ringSignature{
+		c1: cs[0], r1: rs[0],
+		c2: cs[1], r2: rs[1],
+		c3: cs[2], r3: rs[2],
+	}, nil
+}
+
+func ringChallenge(ring [3]*ed448Point, ts [3]*ed448Point, message []byte) *ed448Scalar {
+	return hashToScalar(usageRingSignatureChallenge,
+		ed448G.encode(), encodeEd448Scalar(&ed448Q),
+		ring[0].encode(), ring[1].encode(), ring[2].encode(),
+		ts[0].encode(), ts[1].encode(), ts[2].encode(),
+		message)
+}
+
+// verify returns true if the signature of the message was made with the secret of one of the keys in the ring
+func (s *ringSignature) verify(ring [3]*ed448Point, message []byte) bool {
+	for _, k := range ring {
+		if !isValidEd448Point(k) {
+			return false
+		}
+	}
+
+	cs := [3]*ed448Scalar{s.c1, s.c2, s.c3}
+	rs := [3]*ed448Scalar{s.r1, s.r2, s.r3}
+
+	var ts [3]*ed448Point
+	for i := range ring {
+		ts[i] = ed448BaseMul(rs[i]).add(ring[i].mul(cs[i]))
+	}
+
+	c := ringChallenge(ring, ts, message)
+	sum := &ed448Scalar{}
+	sum.Add(s.c1, s.c2)
+	sum.Add(sum, s.c3)
+
+	return *c == *sum
+}
+
+func (s *ringSignature) serialize() []byte {
+	var out []byte
+	for _, v := range []*ed448Scalar{s.c1, s.r1, s.c2, s.r2, s.c3, s.r3} {
+		out = append(out, encodeEd448Scalar(v)...)
+	}
+	return out
+}
+
+func (s *ringSignature) deserialize(in []byte) ([]byte, bool) {
+	if len(in) < ringSignatureLen {
+		return in, false
+	}
+
+	var vals [6]*ed448Scalar
+	for i := range vals {
+		v, ok := decodeEd448Scalar(in[i*ed448ScalarLen : (i+1)*ed448ScalarLen])
+		if !ok {
+			return in, false
+		}
+		vals[i] = v
+	}
+
+	s.c1, s.r1, s.c2, s.r2, s.c3, s.r3 = vals[0], vals[1], vals[2], vals[3], vals[4], vals[5]
+	return in[ringSignatureLen:], true
+}
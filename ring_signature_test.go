@@ -0,0 +1,80 @@
+//go:build go1.15
+// +build go1.15
+
+package otr3
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func ringSignatureFixture(t *testing.T) (secrets [3]*ed448Scalar, ring [3]*ed448Point) {
+	for i := range secrets {
+		s, err := randomEd448Secret(rand.Reader)
+		assertNil(t, err)
+		secrets[i] = s
+		ring[i] = ed448BaseMul(s)
+	}
+	return secrets, ring
+}
+
+func Test_ringSign_createsAVerifiableSignatureForAnySignerInTheRing(t *testing.T) {
+	secrets, ring := ringSignatureFixture(t)
+	msg := []byte("hello world")
+
+	for _, s := range secrets {
+		sigma, err := ringSign(rand.Reader, s, ring, msg)
+		assertNil(t, err)
+		assertTrue(t, sigma.verify(ring, msg))
+	}
+}
+
+func Test_ringSign_returnsAnErrorIfTheSecretIsNotInTheRing(t *testing.T) {
+	_, ring := ringSignatureFixture(t)
+	other, _ := randomEd448Secret(rand.Reader)
+
+	_, err := ringSign(rand.Reader, other, ring, []byte("hello"))
+	assertEquals(t, err, errNotInRing)
+}
+
+func Test_ringSignature_verify_failsForAnotherMessageOrRing(t *testing.T) {
+	secrets, ring := ringSignatureFixture(t)
+	sigma, _ := ringSign(rand.Reader, secrets[1], ring, []byte("hello"))
+
+	assertFalse(t, sigma.verify(ring, []byte("hellO")))
+
+	ring[0], ring[2] = ring[2], ring[0]
+	assertFalse(t, sigma.verify(ring, []byte("hello")))
+}
+
+func Test_ringSignature_verify_failsForAnInvalidKeyInTheRing(t *testing.T) {
+	secrets, ring := ringSignatureFixture(t)
+	sigma, _ := ringSign(rand.Reader, secrets[0], ring, []byte("hello"))
+
+	ring[2] = ed448Identity()
+	assertFalse(t, sigma.verify(ring, []byte("hello")))
+}
+
+func Test_ringSignature_serializesAndDeserializes(t *testing.T) {
+	secrets, ring := ringSignatureFixture(t)
+	sigma, _ := ringSign(rand.Reader, secrets[2], ring, []byte("hello"))
+
+	ser := sigma.serialize()
+	assertEquals(t, len(ser), ringSignatureLen)
+
+	res := &ringSignature{}
+	rest, ok := res.deserialize(append(ser, 0x01))
+	assertTrue(t, ok)
+	assertDeepEquals(t, rest, []byte{0x01})
+	assertTrue(t, res.verify(ring, []byte("hello")))
+}
+
+func Test_ringSignature_deserialize_failsForShortOrUnreducedValues(t *testing.T) {
+	_, ok := (&ringSignature{}).deserialize(make([]byte, ringSignatureLen-1))
+	assertFalse(t, ok)
+
+	in := make([]byte, ringSignatureLen)
+	copy(in, encodeEd448Scalar(&ed448Q))
+	_, ok = (&ringSignature{}).deserialize(in)
+	assertFalse(t, ok)
+}
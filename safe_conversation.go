@@ -0,0 +1,276 @@
+package otr3
+
+import (
+	"sync"
+	"time"
+)
+
+// SafeConversation wraps a Conversation and serializes all access to it, so that it can be used from several goroutines
+// at the same time - for example with one goroutine receiving from the network while another sends what the user types.
+// Event handlers of the wrapped conversation are called while the lock is held, so they must not call back into the SafeConversation.
+type SafeConversation struct {
+	c    *Conversation
+	lock sync.Mutex
+}
+
+// NewSafeConversation wraps the given conversation. The conversation should not be used directly after this.
+func NewSafeConversation(c *Conversation) *SafeConversation {
+	return &SafeConversation{c: c}
+}
+
+// Do calls the given function with the wrapped conversation while holding the lock.
+// It can be used to access functionality that is not directly exposed by SafeConversation.
+func (s *SafeConversation) Do(f func(c *Conversation)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f(s.c)
+}
+
+// Send is the same as Conversation.Send, but safe for concurrent use
+func (s *SafeConversation) Send(m ValidMessage, trace ...interface{}) ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.Send(m, trace...)
+}
+
+// SendWithID is the same as Conversation.SendWithID, but safe for concurrent use
+func (s *SafeConversation) SendWithID(m ValidMessage, trace ...interface{}) (MessageID, []ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SendWithID(m, trace...)
+}
+
+// SendAllowingPlaintext is the same as Conversation.SendAllowingPlaintext, but safe for concurrent use
+func (s *SafeConversation) SendAllowingPlaintext(m ValidMessage, trace ...interface{}) (MessageID, []ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SendAllowingPlaintext(m, trace...)
+}
+
+// SendWithTLVs is the same as Conversation.SendWithTLVs, but safe for concurrent use
+func (s *SafeConversation) SendWithTLVs(m ValidMessage, tlvs ...TLV) ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SendWithTLVs(m, tlvs...)
+}
+
+// SetStrictSending is the same as Conversation.SetStrictSending, but safe for concurrent use
+func (s *SafeConversation) SetStrictSending(strict bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.c.SetStrictSending(strict)
+}
+
+// Receive is the same as Conversation.Receive, but safe for concurrent use
+func (s *SafeConversation) Receive(m ValidMessage) (MessagePlaintext, []ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.Receive(m)
+}
+
+// ReceiveDetailed is the same as Conversation.ReceiveDetailed, but safe for concurrent use
+func (s *SafeConversation) ReceiveDetailed(m ValidMessage) (*ReceivedMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.ReceiveDetailed(m)
+}
+
+// End is the same as Conversation.End, but safe for concurrent use
+func (s *SafeConversation) End() ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.End()
+}
+
+// QueryMessage is the same as Conversation.QueryMessage, but safe for concurrent use
+func (s *SafeConversation) QueryMessage() ValidMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.QueryMessage()
+}
+
+// IsEncrypted is the same as Conversation.IsEncrypted, but safe for concurrent use
+func (s *SafeConversation) IsEncrypted() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.IsEncrypted()
+}
+
+// StartAuthenticate is the same as Conversation.StartAuthenticate, but safe for concurrent use
+func (s *SafeConversation) StartAuthenticate(question string, mutualSecret []byte) ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.StartAuthenticate(question, mutualSecret)
+}
+
+// ProvideAuthenticationSecret is the same as Conversation.ProvideAuthenticationSecret, but safe for concurrent use
+func (s *SafeConversation) ProvideAuthenticationSecret(mutualSecret []byte) ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.ProvideAuthenticationSecret(mutualSecret)
+}
+
+// AbortAuthentication is the same as Conversation.AbortAuthentication, but safe for concurrent use
+func (s *SafeConversation) AbortAuthentication() ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.AbortAuthentication()
+}
+
+// SMPQuestion is the same as Conversation.SMPQuestion, but safe for concurrent use
+func (s *SafeConversation) SMPQuestion() (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SMPQuestion()
+}
+
+// UseExtraSymmetricKey is the same as Conversation.UseExtraSymmetricKey, but safe for concurrent use
+func (s *SafeConversation) UseExtraSymmetricKey(usage uint32, usageData []byte) ([]byte, []ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.UseExtraSymmetricKey(usage, usageData)
+}
+
+// SecureSessionID is the same as Conversation.SecureSessionID, but safe for concurrent use
+func (s *SafeConversation) SecureSessionID() ([]string, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SecureSessionID()
+}
+
+// GetSSID is the same as Conversation.GetSSID, but safe for concurrent use
+func (s *SafeConversation) GetSSID() [8]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.GetSSID()
+}
+
+// GetTheirKey is the same as Conversation.GetTheirKey, but safe for concurrent use
+func (s *SafeConversation) GetTheirKey() PublicKey {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.GetTheirKey()
+}
+
+// GetOurCurrentKey is the same as Conversation.GetOurCurrentKey, but safe for concurrent use
+func (s *SafeConversation) GetOurCurrentKey() PrivateKey {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.GetOurCurrentKey()
+}
+
+// SaveState is the same as Conversation.SaveState, but safe for concurrent use
+func (s *SafeConversation) SaveState(integrityKey []byte) (ConversationState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SaveState(integrityKey)
+}
+
+// Tick is the same as Conversation.Tick, but safe for concurrent use
+func (s *SafeConversation) Tick() ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.Tick()
+}
+
+// NextDeadline is the same as Conversation.NextDeadline, but safe for concurrent use
+func (s *SafeConversation) NextDeadline() (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.NextDeadline()
+}
+
+// StartOTRv4 is the same as Conversation.StartOTRv4, but safe for concurrent use
+func (s *SafeConversation) StartOTRv4() ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.StartOTRv4()
+}
+
+// Refresh is the same as Conversation.Refresh, but safe for concurrent use
+func (s *SafeConversation) Refresh() ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.Refresh()
+}
+
+// QueuedMessages is the same as Conversation.QueuedMessages, but safe for concurrent use
+func (s *SafeConversation) QueuedMessages() []QueuedMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.QueuedMessages()
+}
+
+// CancelQueuedMessage is the same as Conversation.CancelQueuedMessage, but safe for concurrent use
+func (s *SafeConversation) CancelQueuedMessage(id MessageID) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.CancelQueuedMessage(id)
+}
+
+// FlushQueuedMessages is the same as Conversation.FlushQueuedMessages, but safe for concurrent use
+func (s *SafeConversation) FlushQueuedMessages() ([]ValidMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.FlushQueuedMessages()
+}
+
+// KeyStatistics is the same as Conversation.KeyStatistics, but safe for concurrent use
+func (s *SafeConversation) KeyStatistics() (KeyStatistics, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.KeyStatistics()
+}
+
+// ExportKeyingMaterial is the same as Conversation.ExportKeyingMaterial, but safe for concurrent use
+func (s *SafeConversation) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.ExportKeyingMaterial(label, context, length)
+}
+
+// SAS is the same as Conversation.SAS, but safe for concurrent use
+func (s *SafeConversation) SAS(f SASFormat) ([]string, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.SAS(f)
+}
+
+// ConfirmSAS is the same as Conversation.ConfirmSAS, but safe for concurrent use
+func (s *SafeConversation) ConfirmSAS(f SASFormat, parts []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.c.ConfirmSAS(f, parts)
+}
@@ -0,0 +1,202 @@
+package otr3
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const (
+	hammerGoroutines = 8
+	hammerMessages   = 25
+)
+
+func Test_SafeConversation_Do_givesAccessToTheWrappedConversation(t *testing.T) {
+	c := &Conversation{}
+	s := NewSafeConversation(c)
+
+	var seen *Conversation
+	s.Do(func(c *Conversation) { seen = c })
+	assertEquals(t, seen, c)
+}
+
+func Test_SafeConversation_delegatesToTheWrappedConversation(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	sa := NewSafeConversation(alice)
+	sb := NewSafeConversation(bob)
+
+	assertTrue(t, sa.IsEncrypted())
+	assertEquals(t, sa.GetSSID(), bob.GetSSID())
+	assertEquals(t, sa.GetOurCurrentKey(), alicePrivateKey)
+	assertDeepEquals(t, sa.GetTheirKey().Fingerprint(), bobPrivateKey.PublicKey().Fingerprint())
+	assertDeepEquals(t, sa.QueryMessage(), alice.QueryMessage())
+
+	msgs, err := sa.Send(ValidMessage("hello"))
+	assertNil(t, err)
+	plain, _, err := sb.Receive(msgs[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+
+	msgs, err = sa.End()
+	assertNil(t, err)
+	_, _, err = sb.Receive(msgs[0])
+	assertNil(t, err)
+	assertFalse(t, sa.IsEncrypted())
+	assertFalse(t, sb.IsEncrypted())
+}
+
+func Test_SafeConversation_delegatesTLVsAndDetailedReceiving(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	sa := NewSafeConversation(alice)
+	sb := NewSafeConversation(bob)
+
+	msgs, err := sa.SendWithTLVs(ValidMessage("hello"), TLV{Type: 0x42, Value: []byte{0x01}})
+	assertNil(t, err)
+	res, err := sb.ReceiveDetailed(msgs[0])
+	assertNil(t, err)
+	assertDeepEquals(t, res.Plain, MessagePlaintext("hello"))
+	assertTrue(t, res.Encrypted)
+	assertDeepEquals(t, res.TLVs, []TLV{{Type: 0x42, Value: []byte{0x01}}})
+}
+
+func Test_SafeConversation_canBeUsedForSMP(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	sa := NewSafeConversation(alice)
+	sb := NewSafeConversation(bob)
+
+	msgs, err := sa.StartAuthenticate("the question", []byte("secret"))
+	assertNil(t, err)
+	_, _, err = sb.Receive(msgs[0])
+	assertNil(t, err)
+
+	q, ok := sb.SMPQuestion()
+	assertTrue(t, ok)
+	assertEquals(t, q, "the question")
+
+	msgs, err = sb.AbortAuthentication()
+	assertNil(t, err)
+	_, _, err = sa.Receive(msgs[0])
+	assertNil(t, err)
+
+	msgs, err = sa.StartAuthenticate("", []byte("secret"))
+	assertNil(t, err)
+	_, _, err = sb.Receive(msgs[0])
+	assertNil(t, err)
+	msgs, err = sb.ProvideAuthenticationSecret([]byte("secret"))
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+}
+
+func Test_SafeConversation_canBeHammeredFromManyGoroutines(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+	sa := NewSafeConversation(alice)
+	sb := NewSafeConversation(bob)
+
+	total := hammerGoroutines * hammerMessages
+	toBob := make(chan ValidMessage, total)
+	toAlice := make(chan ValidMessage, total)
+
+	var senders sync.WaitGroup
+	sendFrom := func(s *SafeConversation, wire chan ValidMessage, name string, n int) {
+		defer senders.Done()
+		for i := 0; i < hammerMessages; i++ {
+			// The transport has to keep the order of the messages produced, so we put them on the wire
+			// while still holding the lock
+			s.Do(func(c *Conversation) {
+				msgs, err := c.Send(ValidMessage(fmt.Sprintf("%s %d %d", name, n, i)))
+				if err != nil {
+					t.Errorf("Unexpected error when sending: %v", err)
+				}
+				for _, m := range msgs {
+					wire <- m
+				}
+			})
+		}
+	}
+
+	var receivers sync.WaitGroup
+	receiveOn := func(s *SafeConversation, wire chan ValidMessage, received *int) {
+		defer receivers.Done()
+		for m := range wire {
+			plain, _, err := s.Receive(m)
+			if err != nil {
+				t.Errorf("Unexpected error when receiving: %v", err)
+			}
+			if plain != nil {
+				*received++
+			}
+		}
+	}
+
+	stop := make(chan bool)
+	var observers sync.WaitGroup
+	observe := func(s *SafeConversation) {
+		defer observers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.IsEncrypted()
+				s.SecureSessionID()
+				s.GetSSID()
+				s.SMPQuestion()
+			}
+		}
+	}
+
+	var aliceReceived, bobReceived int
+	receivers.Add(2)
+	go receiveOn(sb, toBob, &bobReceived)
+	go receiveOn(sa, toAlice, &aliceReceived)
+
+	observers.Add(2)
+	go observe(sa)
+	go observe(sb)
+
+	senders.Add(2 * hammerGoroutines)
+	for i := 0; i < hammerGoroutines; i++ {
+		go sendFrom(sa, toBob, "alice", i)
+		go sendFrom(sb, toAlice, "bob", i)
+	}
+
+	senders.Wait()
+	close(toBob)
+	close(toAlice)
+	receivers.Wait()
+	close(stop)
+	observers.Wait()
+
+	assertEquals(t, bobReceived, total)
+	assertEquals(t, aliceReceived, total)
+	assertTrue(t, sa.IsEncrypted())
+	assertTrue(t, sb.IsEncrypted())
+}
+
+func Test_SafeConversation_concurrentSendsAllSucceed(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	sa := NewSafeConversation(alice)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var produced []ValidMessage
+
+	wg.Add(hammerGoroutines)
+	for i := 0; i < hammerGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < hammerMessages; j++ {
+				msgs, err := sa.Send(ValidMessage("hello"))
+				if err != nil {
+					t.Errorf("Unexpected error when sending: %v", err)
+				}
+				lock.Lock()
+				produced = append(produced, msgs...)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertEquals(t, len(produced), hammerGoroutines*hammerMessages)
+}
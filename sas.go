@@ -0,0 +1,101 @@
+package otr3
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SASFormat decides how a short authentication string is rendered
+type SASFormat int
+
+const (
+	// SASWords renders every byte of the SSID as a word from the PGP word list
+	SASWords SASFormat = iota
+	// SASNumeric renders every half of the SSID as a ten digit number
+	SASNumeric
+	// SASEmoji renders every half of the SSID as six emoji
+	SASEmoji
+)
+
+// SAS returns the secure session ID rendered as a short authentication string, in two parts that both peers will see
+// in the same order. The parts are easier to compare out of band than the hex strings from SecureSessionID. As for
+// SecureSessionID, the index returned points to the part that should be highlighted, which is different for the two
+// peers: each of them reads their highlighted part, and listens for the other.
+func (c *Conversation) SAS(f SASFormat) (parts []string, highlightIndex int, err error) {
+	var render func([]byte) string
+	switch f {
+	case SASWords:
+		render = renderSASWords
+	case SASNumeric:
+		render = renderSASNumber
+	case SASEmoji:
+		render = renderSASEmoji
+	default:
+		return nil, 0, errUnknownSASFormat
+	}
+
+	_, ix := c.SecureSessionID()
+	return []string{render(c.ssid[0:4]), render(c.ssid[4:])}, ix, nil
+}
+
+// ConfirmSAS records that the user has compared the short authentication string with the peer and found it to be the
+// same, by marking the current fingerprint of the peer as verified in the trust store. The parts are the ones shown to
+// the user, which makes sure the confirmation is for the current session.
+func (c *Conversation) ConfirmSAS(f SASFormat, parts []string) error {
+	if c.msgState != encrypted {
+		return errCannotConfirmSASUnencrypted
+	}
+
+	if c.trust.store == nil || c.theirKey == nil {
+		return errNoTrustStore
+	}
+
+	current, _, err := c.SAS(f)
+	if err != nil {
+		return err
+	}
+
+	if len(parts) != len(current) || parts[0] != current[0] || parts[1] != current[1] {
+		return errSASChanged
+	}
+
+	l, err := c.TheirTrustLevel()
+	if err != nil {
+		return err
+	}
+
+	if l.IsVerified() {
+		return nil
+	}
+	return c.setTheirTrustLevel(TrustVerified)
+}
+
+func renderSASWords(b []byte) string {
+	s := ""
+	for i, v := range b {
+		if i > 0 {
+			s += " "
+		}
+		// The position in the whole SSID decides the word list, and every part has an even length
+		if i%2 == 0 {
+			s += pgpEvenWords[v]
+		} else {
+			s += pgpOddWords[v]
+		}
+	}
+	return s
+}
+
+func renderSASNumber(b []byte) string {
+	return fmt.Sprintf("%010d", binary.BigEndian.Uint32(b))
+}
+
+func renderSASEmoji(b []byte) string {
+	v := uint64(binary.BigEndian.Uint32(b)) << 4
+
+	s := ""
+	for i := 5; i >= 0; i-- {
+		s += sasEmoji[(v>>(uint(i)*6))&0x3F]
+	}
+	return s
+}
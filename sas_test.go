@@ -0,0 +1,125 @@
+package otr3
+
+import "testing"
+
+func sasConversation() *Conversation {
+	c := newConversation(nil, fixtureRand())
+	c.ssid = [8]byte{0xE5, 0x82, 0x94, 0xF2, 0xE9, 0xA2, 0x27, 0x48}
+	return c
+}
+
+func Test_SAS_rendersTheSSIDWithThePGPWordList(t *testing.T) {
+	parts, _, err := sasConversation().SAS(SASWords)
+
+	assertNil(t, err)
+	assertDeepEquals(t, parts, []string{"topmost Istanbul Pluto vagabond", "treadmill Pacific brackish dictator"})
+}
+
+func Test_SAS_usesTheFirstAndLastWordsOfTheLists(t *testing.T) {
+	c := sasConversation()
+	c.ssid = [8]byte{0x00, 0x00, 0xFF, 0xFF, 0x00, 0xFF, 0xFF, 0x00}
+
+	parts, _, _ := c.SAS(SASWords)
+
+	assertDeepEquals(t, parts, []string{"aardvark adroitness Zulu Yucatan", "aardvark Yucatan Zulu adroitness"})
+}
+
+func Test_SAS_rendersTheSSIDAsNumbers(t *testing.T) {
+	c := sasConversation()
+	c.ssid = [8]byte{0x00, 0x00, 0x01, 0x00, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	parts, _, err := c.SAS(SASNumeric)
+
+	assertNil(t, err)
+	assertDeepEquals(t, parts, []string{"0000000256", "4294967295"})
+}
+
+func Test_SAS_rendersTheSSIDAsEmoji(t *testing.T) {
+	c := sasConversation()
+	// 000001 000010 000011 000100 000101 11, where the last two bits are padded with zeroes
+	c.ssid = [8]byte{0x04, 0x20, 0xC4, 0x17, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	parts, _, err := c.SAS(SASEmoji)
+
+	assertNil(t, err)
+	assertEquals(t, parts[0], sasEmoji[1]+sasEmoji[2]+sasEmoji[3]+sasEmoji[4]+sasEmoji[5]+sasEmoji[48])
+	assertEquals(t, parts[1], sasEmoji[63]+sasEmoji[63]+sasEmoji[63]+sasEmoji[63]+sasEmoji[63]+sasEmoji[48])
+}
+
+func Test_SAS_highlightsTheSamePartAsSecureSessionID(t *testing.T) {
+	c := sasConversation()
+
+	c.sentRevealSig = true
+	_, ix, _ := c.SAS(SASWords)
+	assertEquals(t, ix, 0)
+
+	c.sentRevealSig = false
+	_, ix, _ = c.SAS(SASEmoji)
+	assertEquals(t, ix, 1)
+}
+
+func Test_SAS_failsForAnUnknownFormat(t *testing.T) {
+	_, _, err := sasConversation().SAS(SASFormat(42))
+
+	assertEquals(t, err, errUnknownSASFormat)
+}
+
+func Test_SAS_isTheSameForBothPeersButHighlightedDifferently(t *testing.T) {
+	alice, bob := encryptedConversationPair(t)
+
+	a, aix, _ := alice.SAS(SASWords)
+	b, bix, _ := bob.SAS(SASWords)
+
+	assertDeepEquals(t, a, b)
+	assertEquals(t, aix+bix, 1)
+}
+
+func sasTrustConversation(t *testing.T) (*Conversation, *MemoryTrustStore) {
+	alice, _ := encryptedConversationPair(t)
+	store := NewMemoryTrustStore()
+	alice.SetTrustStore(store, "alice@example.org", "xmpp", "bob@example.org")
+	return alice, store
+}
+
+func Test_ConfirmSAS_marksTheFingerprintOfThePeerAsVerified(t *testing.T) {
+	alice, _ := sasTrustConversation(t)
+	parts, _, _ := alice.SAS(SASNumeric)
+
+	err := alice.ConfirmSAS(SASNumeric, parts)
+
+	assertNil(t, err)
+	l, _ := alice.TheirTrustLevel()
+	assertEquals(t, l, TrustVerified)
+}
+
+func Test_ConfirmSAS_keepsAnSMPVerification(t *testing.T) {
+	alice, _ := sasTrustConversation(t)
+	alice.setTheirTrustLevel(TrustSMPVerified)
+	parts, _, _ := alice.SAS(SASWords)
+
+	assertNil(t, alice.ConfirmSAS(SASWords, parts))
+
+	l, _ := alice.TheirTrustLevel()
+	assertEquals(t, l, TrustSMPVerified)
+}
+
+func Test_ConfirmSAS_failsWhenTheSessionHasChanged(t *testing.T) {
+	alice, _ := sasTrustConversation(t)
+	parts, _, _ := alice.SAS(SASWords)
+	alice.ssid[0] ^= 0x01
+
+	err := alice.ConfirmSAS(SASWords, parts)
+
+	assertEquals(t, err, errSASChanged)
+	assertEquals(t, alice.ConfirmSAS(SASWords, parts[:1]), errSASChanged)
+	l, _ := alice.TheirTrustLevel()
+	assertEquals(t, l, TrustUnknown)
+}
+
+func Test_ConfirmSAS_failsWithoutATrustStoreOrEncryption(t *testing.T) {
+	alice, _ := encryptedConversationPair(t)
+	parts, _, _ := alice.SAS(SASWords)
+	assertEquals(t, alice.ConfirmSAS(SASWords, parts), errNoTrustStore)
+
+	assertEquals(t, (&Conversation{}).ConfirmSAS(SASWords, parts), errCannotConfirmSASUnencrypted)
+}
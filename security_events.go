@@ -2,7 +2,7 @@ package otr3
 
 import "fmt"
 
-// SecurityEvent define the events used to indicate changes in security status. In comparison with libotr, this library does not take trust levels into concern for security events
+// SecurityEvent define the events used to indicate changes in security status. In comparison with libotr, this library only takes trust levels into concern for security events when a TrustStore is used
 type SecurityEvent int
 
 const (
@@ -12,6 +12,10 @@ const (
 	GoneSecure
 	// StillSecure is signalled when we have refreshed the security state but is still in a secure state
 	StillSecure
+	// GoneSecureUnverified is signalled instead of GoneSecure when a TrustStore is used and the fingerprint of the peer has not been verified
+	GoneSecureUnverified
+	// GoneSecureVerified is signalled instead of GoneSecure when a TrustStore is used and the fingerprint of the peer has been verified
+	GoneSecureVerified
 )
 
 // SecurityEventHandler is an interface for events that are related to changes of security status
@@ -43,6 +47,10 @@ func (s SecurityEvent) String() string {
 		return "GoneSecure"
 	case StillSecure:
 		return "StillSecure"
+	case GoneSecureUnverified:
+		return "GoneSecureUnverified"
+	case GoneSecureVerified:
+		return "GoneSecureVerified"
 	default:
 		return "SECURITY EVENT: (THIS SHOULD NEVER HAPPEN)"
 	}
@@ -8,10 +8,56 @@ import (
 // Send takes a human readable message from the local user, possibly encrypts
 // it and returns zero or more messages to send to the peer.
 func (c *Conversation) Send(m ValidMessage, trace ...interface{}) ([]ValidMessage, error) {
+	_, toSend, err := c.SendWithID(m, trace...)
+	return toSend, err
+}
+
+// SendWithID works like Send, but also returns an ID for the message. Everything that happens to the message later -
+// being queued, sent after the AKE, resent, dropped or failing to be encrypted - is signalled with that ID to the
+// DeliveryEventHandler.
+func (c *Conversation) SendWithID(m ValidMessage, trace ...interface{}) (MessageID, []ValidMessage, error) {
+	id := c.nextMessageID()
+
+	c.currentMessageID = id
+	defer func() { c.currentMessageID = 0 }()
+
+	toSend, err := c.send(m, false, trace...)
+	return id, toSend, err
+}
+
+// SendAllowingPlaintext works like SendWithID, but allows the message to be sent unencrypted even when strict sending
+// is enabled, as long as the policy allows it. It is meant for the rare messages the user has explicitly agreed to
+// send without protection.
+func (c *Conversation) SendAllowingPlaintext(m ValidMessage, trace ...interface{}) (MessageID, []ValidMessage, error) {
+	id := c.nextMessageID()
+
+	c.currentMessageID = id
+	defer func() { c.currentMessageID = 0 }()
+
+	toSend, err := c.send(m, true, trace...)
+	return id, toSend, err
+}
+
+// SetStrictSending decides whether Send may ever return the message of the user unencrypted. With strict sending,
+// messages sent before a secure conversation has been established are always queued until the AKE has finished,
+// whatever the policy says, and dropped if that takes longer than the resend interval. Only SendAllowingPlaintext
+// can send them unencrypted.
+func (c *Conversation) SetStrictSending(strict bool) {
+	c.strictSending = strict
+}
+
+func (c *Conversation) send(m ValidMessage, allowPlaintext bool, trace ...interface{}) ([]ValidMessage, error) {
 	message := makeCopy(m)
 	defer wipeBytes(message)
 
+	mayLeak := allowPlaintext || !c.strictSending
+
 	if !c.Policies.isOTREnabled() {
+		if !mayLeak {
+			c.deliveryEvent(c.currentMessageID, DeliveryDropped)
+			return nil, errPlaintextNotAllowed
+		}
+		c.deliveryEvent(c.currentMessageID, DeliverySentInPlaintext)
 		return []ValidMessage{makeCopy(message)}, nil
 	}
 
@@ -22,26 +68,36 @@ func (c *Conversation) Send(m ValidMessage, trace ...interface{}) ([]ValidMessag
 
 	switch c.msgState {
 	case plainText:
-		return c.withInjections(c.sendMessageOnPlaintext(message, trace...))
+		return c.withInjections(c.sendMessageOnPlaintext(message, mayLeak, trace...))
 	case encrypted:
 		return c.withInjections(c.sendMessageOnEncrypted(message))
 	case finished:
 		c.messageEvent(MessageEventConnectionEnded)
+		c.deliveryEvent(c.currentMessageID, DeliveryDropped)
 		return c.withInjections(nil, newOtrError("cannot send message because secure conversation has finished"))
 	}
 
 	return c.withInjections(nil, newOtrError("cannot send message in current state"))
 }
 
-func (c *Conversation) sendMessageOnPlaintext(message ValidMessage, trace ...interface{}) ([]ValidMessage, error) {
-	if c.Policies.has(requireEncryption) {
+func (c *Conversation) sendMessageOnPlaintext(message ValidMessage, mayLeak bool, trace ...interface{}) ([]ValidMessage, error) {
+	if c.Policies.has(requireEncryption) || !mayLeak {
 		c.messageEvent(MessageEventEncryptionRequired, trace...)
+		if err := c.queueMessage(MessagePlaintext(makeCopy(message)), trace...); err != nil {
+			return nil, err
+		}
 		c.updateLastSent()
 		c.updateMayRetransmitTo(retransmitExact)
-		c.lastMessage(MessagePlaintext(makeCopy(message)), trace...)
+
+		// One query is enough to get the AKE going for all queued messages
+		if c.queryOutstanding() || c.akeInProgress() {
+			return nil, nil
+		}
+		c.resend.querySentAt = c.now()
 		return []ValidMessage{c.QueryMessage()}, nil
 	}
 
+	c.deliveryEvent(c.currentMessageID, DeliverySentInPlaintext)
 	return []ValidMessage{makeCopy(c.appendWhitespaceTag(message))}, nil
 }
 
@@ -49,10 +105,25 @@ func (c *Conversation) sendMessageOnEncrypted(message ValidMessage) ([]ValidMess
 	result, _, err := c.createSerializedDataMessage(message, messageFlagNormal, []tlv{})
 	if err != nil {
 		c.messageEvent(MessageEventEncryptionError)
+		c.deliveryEventWithError(c.currentMessageID, DeliveryEncryptionFailed, err)
 		c.generatePotentialErrorMessage(ErrorCodeEncryptionError)
+		return result, err
+	}
+
+	c.deliveryEvent(c.currentMessageID, DeliverySent)
+	c.countMessageForRefresh()
+
+	// The message has been sent at this point, so a failed refresh is only reported, and will be retried later
+	if c.refreshIsDue() {
+		ts, err := c.refresh()
+		if err != nil {
+			c.messageEventWithError(MessageEventSetupError, err)
+			return result, nil
+		}
+		result = append(result, c.encodeAndCombine(ts)...)
 	}
 
-	return result, err
+	return result, nil
 }
 
 func (c *Conversation) sendDHCommit() (toSend messageWithHeader, err error) {
@@ -70,6 +141,7 @@ func (c *Conversation) sendDHCommit() (toSend messageWithHeader, err error) {
 	}
 
 	c.ake.state = authStateAwaitingDHKey{}
+	c.ake.lastStateChange = c.now()
 
 	return
 }
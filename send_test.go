@@ -3,6 +3,7 @@ package otr3
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func Test_sendDHCommit_resetsAKEKeyContext(t *testing.T) {
@@ -18,7 +19,7 @@ func Test_Send_signalsMessageEventIfTryingToSendWithoutEncryptedChannel(t *testi
 	m := []byte("hello")
 	c := bobContextAfterAKE()
 	c.msgState = plainText
-	c.Policies = policies(allowV3 | requireEncryption)
+	c.Policies = Policy(allowV3 | requireEncryption)
 
 	c.expectMessageEvent(t, func() {
 		c.Send(m)
@@ -29,7 +30,7 @@ func Test_Send_signalsMessageEventIfTryingToSendOnAFinishedChannel(t *testing.T)
 	m := []byte("hello")
 	c := bobContextAfterAKE()
 	c.msgState = finished
-	c.Policies = policies(allowV3 | requireEncryption)
+	c.Policies = Policy(allowV3 | requireEncryption)
 
 	c.expectMessageEvent(t, func() {
 		c.Send(m)
@@ -41,7 +42,7 @@ func Test_Send_signalsEncryptionErrorMessageEventIfSomethingWentWrong(t *testing
 
 	c := bobContextAfterAKE()
 	c.msgState = encrypted
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 	c.keys.theirKeyID = 0
 
 	c.expectMessageEvent(t, func() {
@@ -54,7 +55,7 @@ func Test_Send_callsErrorMessageHandlerAndReturnsTheResultAsAnOTRErrorMessage(t
 
 	c := bobContextAfterAKE()
 	c.msgState = encrypted
-	c.Policies = policies(allowV3)
+	c.Policies = Policy(allowV3)
 	c.keys.theirKeyID = 0
 
 	c.errorMessageHandler = dynamicErrorMessageHandler{
@@ -73,13 +74,15 @@ func Test_Send_saveLastMessageWhenMsgIsPlainTextAndEncryptedIsExpected(t *testin
 	m := []byte("hello")
 	c := bobContextAfterAKE()
 	c.msgState = plainText
-	c.Policies = policies(allowV3 | requireEncryption)
+	c.Policies = Policy(allowV3 | requireEncryption)
+	clock := fixtureClock()
+	c.SetClock(clock)
 
 	c.Send(m)
 
 	assertDeepEquals(t, c.resend.pending(),
 		[]messageToResend{
-			messageToResend{MessagePlaintext(m), nil},
+			messageToResend{m: MessagePlaintext(m), id: 1, queuedAt: clock.Now()},
 		})
 }
 
@@ -88,15 +91,17 @@ func Test_Send_saveLastMessageWhenMsgIsPlainTextAndEncryptedIsExpected_AndAddsAn
 	m2 := []byte("hello again?")
 	c := bobContextAfterAKE()
 	c.msgState = plainText
-	c.Policies = policies(allowV3 | requireEncryption)
+	c.Policies = Policy(allowV3 | requireEncryption)
+	clock := fixtureClock()
+	c.SetClock(clock)
 
 	c.Send(m, 42, "hello")
 	c.Send(m2, 15, "something")
 
 	assertDeepEquals(t, c.resend.pending(),
 		[]messageToResend{
-			messageToResend{MessagePlaintext(m), []interface{}{42, "hello"}},
-			messageToResend{MessagePlaintext(m2), []interface{}{15, "something"}},
+			messageToResend{m: MessagePlaintext(m), id: 1, queuedAt: clock.Now(), opaque: []interface{}{42, "hello"}},
+			messageToResend{m: MessagePlaintext(m2), id: 2, queuedAt: clock.Now(), opaque: []interface{}{15, "something"}},
 		})
 }
 
@@ -104,7 +109,7 @@ func Test_Send_setsMayRetransmitFlagToExpectExactResending(t *testing.T) {
 	m := []byte("hello")
 	c := bobContextAfterAKE()
 	c.msgState = plainText
-	c.Policies = policies(allowV3 | requireEncryption)
+	c.Policies = Policy(allowV3 | requireEncryption)
 
 	c.Send(m)
 
@@ -158,3 +163,92 @@ func Test_Send_printsDebugStatementToStderrIfGivenMagicString(t *testing.T) {
     Received_Q: 0
 `)
 }
+
+func Test_Send_withStrictSendingQueuesTheMessageEvenIfThePolicyAllowsPlaintext(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3)}
+	c.SetStrictSending(true)
+
+	msgs, err := c.Send(ValidMessage("hello"))
+
+	assertNil(t, err)
+	assertDeepEquals(t, msgs, []ValidMessage{c.QueryMessage()})
+	assertEquals(t, len(c.resend.pending()), 1)
+}
+
+func Test_Send_withStrictSendingFailsWhenOTRIsDisabled(t *testing.T) {
+	c := &Conversation{}
+	c.SetStrictSending(true)
+	events := c.recordDeliveryEvents()
+
+	id, msgs, err := c.SendWithID(ValidMessage("hello"))
+
+	assertEquals(t, err, errPlaintextNotAllowed)
+	assertNil(t, msgs)
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliveryDropped}})
+}
+
+func Test_SendAllowingPlaintext_sendsThePlaintextEvenWithStrictSending(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3)}
+	c.SetStrictSending(true)
+
+	_, msgs, err := c.SendAllowingPlaintext(ValidMessage("hello"))
+
+	assertNil(t, err)
+	assertDeepEquals(t, msgs, []ValidMessage{ValidMessage("hello")})
+	assertEquals(t, len(c.resend.pending()), 0)
+}
+
+func Test_SendAllowingPlaintext_stillRespectsThePolicy(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3 | requireEncryption)}
+
+	_, msgs, _ := c.SendAllowingPlaintext(ValidMessage("hello"))
+
+	assertDeepEquals(t, msgs, []ValidMessage{c.QueryMessage()})
+}
+
+func Test_Send_withStrictSendingSendsTheQueuedMessageAfterTheAKE(t *testing.T) {
+	alice, bob := conversationPairWithClock(fixtureClock(), allowV3)
+	alice.SetStrictSending(true)
+
+	toBob, _ := alice.Send(ValidMessage("hello"))
+	toAlice := deliverAll(t, bob, toBob)
+	var received []MessagePlaintext
+	for len(toAlice) > 0 {
+		toBob = deliverAll(t, alice, toAlice)
+		toAlice = nil
+		for _, m := range toBob {
+			plain, ts, err := bob.Receive(m)
+			assertNil(t, err)
+			if plain != nil {
+				received = append(received, plain)
+			}
+			toAlice = append(toAlice, ts...)
+		}
+	}
+
+	assertDeepEquals(t, received, []MessagePlaintext{MessagePlaintext("hello")})
+}
+
+func Test_Send_withStrictSendingDropsMessagesQueuedForTooLongWhenTheAKEFinishes(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	alice.SetStrictSending(true)
+	events := alice.recordDeliveryEvents()
+	var dropped []interface{}
+	alice.SetMessageEventHandler(dynamicMessageEventHandler{func(event MessageEvent, message []byte, err error, trace ...interface{}) {
+		if event == MessageEventMessageDropped {
+			dropped = append(dropped, trace...)
+		}
+	}})
+
+	id, toBob, _ := alice.SendWithID(ValidMessage("hello"), "trace")
+	clock.Advance(defaultResendInterval + time.Second)
+	toAlice := deliverAll(t, bob, toBob)
+	for len(toAlice) > 0 {
+		toAlice = deliverAll(t, bob, deliverAll(t, alice, toAlice))
+	}
+
+	assertTrue(t, alice.IsEncrypted())
+	assertDeepEquals(t, dropped, []interface{}{"trace"})
+	assertDeepEquals(t, *events, []deliveryEventRecord{{id, DeliveryQueued}, {id, DeliveryDropped}})
+}
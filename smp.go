@@ -2,6 +2,7 @@ package otr3
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/coyim/gotrax"
 )
@@ -13,6 +14,8 @@ type smp struct {
 	s1       *smp1State
 	s2       *smp2State
 	s3       *smp3State
+
+	lastProgress time.Time
 }
 
 const smpVersion = 1
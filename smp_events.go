@@ -39,6 +39,10 @@ func (d dynamicSMPEventHandler) HandleSMPEvent(event SMPEvent, pp int, question
 }
 
 func (c *Conversation) smpEvent(e SMPEvent, percent int) {
+	if e == SMPEventSuccess {
+		c.markTheirFingerprintSMPVerified()
+	}
+
 	if c.smpEventHandler != nil {
 		c.smpEventHandler.HandleSMPEvent(e, percent, "")
 	}
@@ -56,11 +56,11 @@ func TestFullSMPHandshake(t *testing.T) {
 func Test_SMP_Full(t *testing.T) {
 	alice := &Conversation{Rand: rand.Reader}
 	alice.ourKeys = []PrivateKey{alicePrivateKey}
-	alice.Policies = policies(allowV3)
+	alice.Policies = Policy(allowV3)
 
 	bob := &Conversation{Rand: rand.Reader}
 	bob.ourKeys = []PrivateKey{bobPrivateKey}
-	bob.Policies = policies(allowV3)
+	bob.Policies = Policy(allowV3)
 
 	var err error
 	var aliceMessages []ValidMessage
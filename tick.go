@@ -0,0 +1,207 @@
+package otr3
+
+import "time"
+
+// How long an unfinished AKE can go without progress before it is abandoned
+const defaultAKETimeout = 60 * time.Second
+
+// How long an unfinished SMP can go without progress before it is aborted
+const defaultSMPTimeout = 10 * time.Minute
+
+// SetAKETimeout sets for how long an AKE can go without progress before Tick abandons it.
+// A zero duration restores the default of 60 seconds.
+func (c *Conversation) SetAKETimeout(d time.Duration) {
+	c.akeTimeout = d
+}
+
+func (c *Conversation) akeTimeoutInterval() time.Duration {
+	if c.akeTimeout == 0 {
+		return defaultAKETimeout
+	}
+	return c.akeTimeout
+}
+
+// SetSMPTimeout sets for how long an SMP can go without progress before Tick aborts it.
+// A zero duration restores the default of 10 minutes.
+func (c *Conversation) SetSMPTimeout(d time.Duration) {
+	c.smpTimeout = d
+}
+
+func (c *Conversation) smpTimeoutInterval() time.Duration {
+	if c.smpTimeout == 0 {
+		return defaultSMPTimeout
+	}
+	return c.smpTimeout
+}
+
+// NextDeadline returns the next time at which Tick has something to do, and not ok if there is nothing pending.
+// An event loop can use it to schedule a timer instead of calling Tick periodically.
+func (c *Conversation) NextDeadline() (deadline time.Time, ok bool) {
+	consider := func(t time.Time) {
+		if !ok || t.Before(deadline) {
+			deadline, ok = t, true
+		}
+	}
+
+	if c.heartbeatPending() {
+		consider(c.heartbeat.lastSent.Add(c.acknowledgeInterval()))
+	}
+
+	if c.resend.shouldRetransmit() {
+		if c.shouldRetransmit() && c.msgState == encrypted && c.resend.mayRetransmit == retransmitExact {
+			consider(c.now())
+		} else {
+			consider(c.heartbeat.lastSent.Add(c.resendInterval()))
+		}
+	}
+
+	for _, m := range c.resend.queued() {
+		if !m.queuedAt.IsZero() {
+			consider(m.queuedAt.Add(c.queuedMessageExpiry()))
+			break
+		}
+	}
+
+	if c.akeInProgress() {
+		consider(c.akeLastStateChange().Add(c.akeTimeoutInterval()))
+	} else if c.refreshIsDue() {
+		consider(c.now())
+	} else {
+		if deadline, due := c.refreshDeadline(); due {
+			consider(deadline)
+		}
+		if deadline, due := c.ourKeysReplacementDeadline(); due {
+			consider(deadline)
+		}
+	}
+
+	if c.smpInProgress() {
+		consider(c.smp.lastProgress.Add(c.smpTimeoutInterval()))
+	}
+
+	return
+}
+
+// Tick performs all protocol actions that are due at the current time of the conversation clock, and returns the
+// messages that should be sent to the peer because of them. It will:
+//   - send a heartbeat if we have received messages but not sent anything for longer than the heartbeat interval
+//   - send messages that were queued waiting for encryption, and forget them when they have expired or the resend
+//     interval has passed
+//   - abandon an AKE that has made no progress for the AKE timeout, asking again for an AKE if messages are queued
+//   - abort an SMP that has made no progress for the SMP timeout
+//   - refresh the session when the refresh policy says it is time for it, or when the peer hasn't let us rotate our
+//     keys for longer than the key rotation policy allows
+//
+// It is safe to call Tick at any time, but NextDeadline can be used to only call it when something is due.
+func (c *Conversation) Tick() ([]ValidMessage, error) {
+	var toSend []ValidMessage
+
+	now := c.now()
+
+	if c.smpInProgress() && !now.Before(c.smp.lastProgress.Add(c.smpTimeoutInterval())) {
+		msgs, err := c.timeoutSMP()
+		if err != nil {
+			return nil, err
+		}
+		toSend = append(toSend, msgs...)
+	}
+
+	if c.akeInProgress() && !now.Before(c.akeLastStateChange().Add(c.akeTimeoutInterval())) {
+		toSend = append(toSend, c.timeoutAKE()...)
+	}
+
+	if c.refreshIsDue() {
+		ts, err := c.refresh()
+		if err != nil {
+			return nil, err
+		}
+		toSend = append(toSend, c.encodeAndCombine(ts)...)
+	}
+
+	c.dropExpiredQueuedMessages()
+	if c.resend.shouldRetransmit() {
+		if !c.shouldRetransmit() {
+			c.dropQueuedMessages()
+		} else if c.msgState == encrypted && c.resend.mayRetransmit == retransmitExact {
+			msgs, err := c.retransmit()
+			if err != nil {
+				return nil, err
+			}
+			toSend = append(toSend, c.encodeAndCombine(msgs)...)
+		}
+	}
+
+	if c.heartbeatPending() && !now.Before(c.heartbeat.lastSent.Add(c.acknowledgeInterval())) {
+		msg, err := c.heartbeatMessage()
+		if err != nil {
+			return nil, err
+		}
+		toSend = append(toSend, c.encodeAndCombine([]messageWithHeader{msg})...)
+	}
+
+	return c.withInjections(toSend, nil)
+}
+
+// heartbeatPending returns true if we have received data from the peer after the last message we sent,
+// which means that the peer is waiting for us to acknowledge their new keys
+func (c *Conversation) heartbeatPending() bool {
+	return c.msgState == encrypted && c.heartbeat.lastReceived.After(c.heartbeat.lastSent)
+}
+
+func (c *Conversation) akeInProgress() bool {
+	return (c.ake != nil && c.ake.state != nil && c.ake.state != (authStateNone{})) ||
+		(c.dake != nil && c.dake.state != nil && c.dake.state != (dakeStateNone{}))
+}
+
+// akeLastStateChange returns when the AKE in progress last made progress, for either version of the AKE
+func (c *Conversation) akeLastStateChange() time.Time {
+	if c.dake != nil && c.dake.state != (dakeStateNone{}) {
+		return c.dake.lastStateChange
+	}
+	return c.ake.lastStateChange
+}
+
+func (c *Conversation) smpInProgress() bool {
+	return c.smp.state != nil && c.smp.state != (smpStateExpect1{})
+}
+
+func (c *Conversation) timeoutAKE() []ValidMessage {
+	c.ake.wipe(true)
+	c.ake = nil
+	c.dake.wipe()
+	c.dake = nil
+	c.messageEventWithError(MessageEventSetupError, errAKETimedOut)
+
+	if c.msgState == encrypted || !c.resend.shouldRetransmit() || !c.shouldRetransmit() {
+		return nil
+	}
+
+	c.updateLastSent()
+	c.resend.querySentAt = c.now()
+	return []ValidMessage{c.QueryMessage()}
+}
+
+// queryOutstanding returns true if we have asked the peer for an AKE so recently that it can still answer. If no
+// AKE has started once the resend interval or the AKE timeout has passed, the query is considered lost.
+func (c *Conversation) queryOutstanding() bool {
+	if c.resend.querySentAt.IsZero() {
+		return false
+	}
+
+	wait := c.resendInterval()
+	if t := c.akeTimeoutInterval(); t < wait {
+		wait = t
+	}
+	return c.now().Before(c.resend.querySentAt.Add(wait))
+}
+
+func (c *Conversation) timeoutSMP() ([]ValidMessage, error) {
+	c.smpEvent(SMPEventError, 0)
+	c.smp.wipe()
+
+	if c.msgState != encrypted {
+		return nil, nil
+	}
+
+	return c.AbortAuthentication()
+}
@@ -0,0 +1,176 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+func fixtureClock() *FakeClock {
+	return NewFakeClock(time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC))
+}
+
+func conversationPairWithClock(clock Clock, p Policy) (alice, bob *Conversation) {
+	return conversationPair(p, clock, nil, nil)
+}
+
+func Test_Tick_doesNothingForANewConversation(t *testing.T) {
+	c := &Conversation{}
+	_, ok := c.NextDeadline()
+	assertFalse(t, ok)
+
+	msgs, err := c.Tick()
+	assertNil(t, err)
+	assertNil(t, msgs)
+}
+
+func Test_Tick_sendsAHeartbeatWhenWeHaveNotAnsweredReceivedData(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+	toAlice, _ := bob.Send(ValidMessage("hi"))
+	deliverAll(t, alice, toAlice)
+
+	_, ok := bob.NextDeadline()
+	assertFalse(t, ok)
+
+	clock.Advance(30 * time.Second)
+	toBob, _ := alice.Send(ValidMessage("hello"))
+	deliverAll(t, bob, toBob)
+
+	deadline, ok := bob.NextDeadline()
+	assertTrue(t, ok)
+	assertEquals(t, deadline, bob.heartbeat.lastSent.Add(defaultHeartbeatInterval))
+
+	msgs, err := bob.Tick()
+	assertNil(t, err)
+	assertNil(t, msgs)
+
+	clock.Set(deadline)
+	msgs, err = bob.Tick()
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+
+	plain, toSend, err := alice.Receive(msgs[0])
+	assertNil(t, err)
+	assertNil(t, plain)
+	assertNil(t, toSend)
+
+	_, ok = bob.NextDeadline()
+	assertFalse(t, ok)
+}
+
+func Test_Tick_forgetsQueuedMessagesWhenTheResendIntervalHasPassed(t *testing.T) {
+	clock := fixtureClock()
+	alice, _ := conversationPairWithClock(clock, allowV3|requireEncryption)
+	alice.SetResendInterval(10 * time.Second)
+
+	msgs, _ := alice.Send(ValidMessage("secret"))
+	assertDeepEquals(t, msgs, []ValidMessage{alice.QueryMessage()})
+
+	deadline, ok := alice.NextDeadline()
+	assertTrue(t, ok)
+	assertEquals(t, deadline, clock.Now().Add(10*time.Second))
+
+	clock.Advance(11 * time.Second)
+	msgs, err := alice.Tick()
+	assertNil(t, err)
+	assertNil(t, msgs)
+	assertEquals(t, len(alice.resend.pending()), 0)
+
+	_, ok = alice.NextDeadline()
+	assertFalse(t, ok)
+}
+
+func Test_Tick_sendsMessagesQueuedForAnEncryptedConversation(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+
+	alice.updateLastSent()
+	alice.updateMayRetransmitTo(retransmitExact)
+	alice.lastMessage(MessagePlaintext("queued"))
+
+	deadline, ok := alice.NextDeadline()
+	assertTrue(t, ok)
+	assertEquals(t, deadline, clock.Now())
+
+	msgs, err := alice.Tick()
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+
+	plain, _, err := bob.Receive(msgs[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("queued"))
+}
+
+func Test_Tick_abandonsAStalledAKE(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+
+	deliverAll(t, bob, []ValidMessage{alice.QueryMessage()})
+
+	deadline, ok := bob.NextDeadline()
+	assertTrue(t, ok)
+	assertEquals(t, deadline, clock.Now().Add(defaultAKETimeout))
+
+	clock.Set(deadline)
+	var msgs []ValidMessage
+	var err error
+	bob.expectMessageEvent(t, func() {
+		msgs, err = bob.Tick()
+	}, MessageEventSetupError, nil, errAKETimedOut)
+
+	assertNil(t, err)
+	assertNil(t, msgs)
+	assertNil(t, bob.ake)
+
+	_, ok = bob.NextDeadline()
+	assertFalse(t, ok)
+}
+
+func Test_Tick_asksForANewAKEWhenAStalledAKEHasQueuedMessages(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3|requireEncryption)
+	alice.SetAKETimeout(5 * time.Second)
+
+	query, _ := alice.Send(ValidMessage("secret"))
+	dhCommit := deliverAll(t, bob, query)
+	deliverAll(t, alice, dhCommit)
+	assertTrue(t, alice.akeInProgress())
+
+	clock.Advance(5 * time.Second)
+	msgs, err := alice.Tick()
+	assertNil(t, err)
+	assertDeepEquals(t, msgs, []ValidMessage{alice.QueryMessage()})
+	assertEquals(t, len(alice.resend.pending()), 1)
+}
+
+func Test_Tick_abortsAStalledSMP(t *testing.T) {
+	clock := fixtureClock()
+	alice, bob := conversationPairWithClock(clock, allowV3)
+	runAKE(t, alice, bob)
+	alice.SetSMPTimeout(time.Minute)
+
+	toBob, _ := alice.StartAuthenticate("", []byte("secret"))
+	deliverAll(t, bob, toBob)
+
+	deadline, ok := alice.NextDeadline()
+	assertTrue(t, ok)
+	assertEquals(t, deadline, clock.Now().Add(time.Minute))
+
+	clock.Set(deadline)
+	var msgs []ValidMessage
+	var err error
+	alice.expectSMPEvent(t, func() {
+		msgs, err = alice.Tick()
+	}, SMPEventError, 0, "")
+
+	assertNil(t, err)
+	assertEquals(t, len(msgs), 1)
+	assertEquals(t, alice.smp.state, smpStateExpect1{})
+
+	bob.expectSMPEvent(t, func() {
+		deliverAll(t, bob, msgs)
+	}, SMPEventAbort, 0, "")
+	assertEquals(t, bob.smp.state, smpStateExpect1{})
+}
@@ -52,11 +52,23 @@ func initTLVHandlers() {
 	}
 }
 
-func messageHandlerForTLV(t tlv) (tlvHandler, error) {
-	if t.tlvType >= uint16(len(tlvHandlers)) {
-		return nil, newOtrError("unexpected TLV type")
+func (c *Conversation) messageHandlerForTLV(t tlv) (tlvHandler, error) {
+	switch {
+	case t.tlvType < uint16(len(tlvHandlers)):
+		return tlvHandlers[t.tlvType], nil
+	case t.tlvType == tlvTypeReceiptRequest:
+		return (*Conversation).processReceiptRequestTLV, nil
+	case t.tlvType == tlvTypeReceipt:
+		return (*Conversation).processReceiptTLV, nil
 	}
-	return tlvHandlers[t.tlvType], nil
+
+	if h, ok := c.customTLVHandler(t.tlvType); ok {
+		return func(c *Conversation, t tlv, _ dataMessageExtra) (*tlv, error) {
+			return c.processCustomTLV(h, t)
+		}, nil
+	}
+
+	return nil, newOtrError("unexpected TLV type")
 }
 
 type tlv struct {
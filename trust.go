@@ -0,0 +1,299 @@
+package otr3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TrustLevel describes how much we know about a fingerprint of a peer
+type TrustLevel int
+
+const (
+	// TrustUnknown is the level of a fingerprint that has never been seen before
+	TrustUnknown TrustLevel = iota
+	// TrustUnverified is the level of a fingerprint that has been used by the peer, but never verified
+	TrustUnverified
+	// TrustVerified is the level of a fingerprint that the user has verified manually
+	TrustVerified
+	// TrustSMPVerified is the level of a fingerprint that has been verified with the socialist millionaires protocol
+	TrustSMPVerified
+)
+
+var trustLevelNames = []string{"unknown", "unverified", "verified", "smp"}
+
+// IsVerified returns true if the fingerprint has been verified in any way
+func (l TrustLevel) IsVerified() bool {
+	return l >= TrustVerified
+}
+
+// String returns the string representation of the TrustLevel
+func (l TrustLevel) String() string {
+	if l < 0 || int(l) >= len(trustLevelNames) {
+		return "TRUST LEVEL: (THIS SHOULD NEVER HAPPEN)"
+	}
+	return trustLevelNames[l]
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (l TrustLevel) MarshalText() ([]byte, error) {
+	if l < 0 || int(l) >= len(trustLevelNames) {
+		return nil, errUnknownTrustLevel
+	}
+	return []byte(trustLevelNames[l]), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (l *TrustLevel) UnmarshalText(text []byte) error {
+	for i, n := range trustLevelNames {
+		if n == string(text) {
+			*l = TrustLevel(i)
+			return nil
+		}
+	}
+	return errUnknownTrustLevel
+}
+
+// FingerprintTrust is a fingerprint together with its trust level
+type FingerprintTrust struct {
+	Fingerprint []byte
+	Level       TrustLevel
+}
+
+// TrustStore keeps track of the fingerprints we have seen for every peer of every account, and how much we trust them.
+// Fingerprints that are not in the store have the level TrustUnknown.
+type TrustStore interface {
+	// TrustLevel returns the trust level of the fingerprint for the peer
+	TrustLevel(account, protocol, peer string, fingerprint []byte) (TrustLevel, error)
+	// SetTrustLevel sets the trust level of the fingerprint for the peer. Setting TrustUnknown removes the fingerprint.
+	SetTrustLevel(account, protocol, peer string, fingerprint []byte, level TrustLevel) error
+	// Fingerprints returns all known fingerprints for the peer, ordered by fingerprint
+	Fingerprints(account, protocol, peer string) ([]FingerprintTrust, error)
+}
+
+type trustKey struct {
+	account, protocol, peer string
+	fingerprint             string
+}
+
+// MemoryTrustStore is a TrustStore that only keeps its content in memory. It is safe for concurrent use.
+type MemoryTrustStore struct {
+	levels map[trustKey]TrustLevel
+	lock   sync.RWMutex
+}
+
+// NewMemoryTrustStore creates an empty MemoryTrustStore
+func NewMemoryTrustStore() *MemoryTrustStore {
+	return &MemoryTrustStore{levels: make(map[trustKey]TrustLevel)}
+}
+
+// TrustLevel implements TrustStore
+func (s *MemoryTrustStore) TrustLevel(account, protocol, peer string, fingerprint []byte) (TrustLevel, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.levels[trustKey{account, protocol, peer, string(fingerprint)}], nil
+}
+
+// SetTrustLevel implements TrustStore
+func (s *MemoryTrustStore) SetTrustLevel(account, protocol, peer string, fingerprint []byte, level TrustLevel) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.set(trustKey{account, protocol, peer, string(fingerprint)}, level)
+	return nil
+}
+
+func (s *MemoryTrustStore) set(k trustKey, level TrustLevel) {
+	if level == TrustUnknown {
+		delete(s.levels, k)
+		return
+	}
+	s.levels[k] = level
+}
+
+// Fingerprints implements TrustStore
+func (s *MemoryTrustStore) Fingerprints(account, protocol, peer string) ([]FingerprintTrust, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var ret []FingerprintTrust
+	for k, l := range s.levels {
+		if k.account == account && k.protocol == protocol && k.peer == peer {
+			ret = append(ret, FingerprintTrust{[]byte(k.fingerprint), l})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return bytes.Compare(ret[i].Fingerprint, ret[j].Fingerprint) < 0 })
+
+	return ret, nil
+}
+
+// FileTrustStore is a TrustStore that keeps its content in a JSON file, which is rewritten every time a trust level
+// changes. It is safe for concurrent use within one process.
+type FileTrustStore struct {
+	MemoryTrustStore
+	fname string
+}
+
+type fileTrustEntry struct {
+	Account     string     `json:"account"`
+	Protocol    string     `json:"protocol"`
+	Peer        string     `json:"peer"`
+	Fingerprint string     `json:"fingerprint"`
+	Level       TrustLevel `json:"level"`
+}
+
+// OpenFileTrustStore reads the trust store in the given file. If the file doesn't exist, the store starts out empty
+// and the file will be created the first time a trust level is set.
+func OpenFileTrustStore(fname string) (*FileTrustStore, error) {
+	s := &FileTrustStore{MemoryTrustStore: MemoryTrustStore{levels: make(map[trustKey]TrustLevel)}, fname: fname}
+
+	content, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fileTrustEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, newOtrErrorf("couldn't read trust store: %v", err)
+	}
+
+	for _, e := range entries {
+		fpr, err := hex.DecodeString(e.Fingerprint)
+		if err != nil {
+			return nil, newOtrErrorf("couldn't read trust store: invalid fingerprint %q", e.Fingerprint)
+		}
+		s.set(trustKey{e.Account, e.Protocol, e.Peer, string(fpr)}, e.Level)
+	}
+
+	return s, nil
+}
+
+// SetTrustLevel implements TrustStore, saving the store to its file
+func (s *FileTrustStore) SetTrustLevel(account, protocol, peer string, fingerprint []byte, level TrustLevel) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	k := trustKey{account, protocol, peer, string(fingerprint)}
+	old, existed := s.levels[k]
+	s.set(k, level)
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.levels[k] = old
+		} else {
+			delete(s.levels, k)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileTrustStore) save() error {
+	entries := make([]fileTrustEntry, 0, len(s.levels))
+	for k, l := range s.levels {
+		entries = append(entries, fileTrustEntry{k.account, k.protocol, k.peer, hex.EncodeToString([]byte(k.fingerprint)), l})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		return strings.Join([]string{a.Account, a.Protocol, a.Peer, a.Fingerprint}, "\x00") <
+			strings.Join([]string{b.Account, b.Protocol, b.Peer, b.Fingerprint}, "\x00")
+	})
+
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Writing to a temporary file first means that a crash can never leave a half written store behind
+	tmp, err := ioutil.TempFile(filepath.Dir(s.fname), filepath.Base(s.fname)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.fname)
+}
+
+type trustContext struct {
+	store                   TrustStore
+	account, protocol, peer string
+}
+
+// SetTrustStore makes the conversation use the given trust store for the peer. New fingerprints of the peer will be
+// recorded when the AKE finishes, a successful SMP marks the fingerprint as verified, and GoneSecureVerified or
+// GoneSecureUnverified will be signalled instead of GoneSecure.
+func (c *Conversation) SetTrustStore(store TrustStore, account, protocol, peer string) {
+	c.trust = trustContext{store, account, protocol, peer}
+}
+
+// TheirTrustLevel returns the trust level of the current key of the peer. It will return TrustUnknown if there is no
+// key for the peer or no trust store.
+func (c *Conversation) TheirTrustLevel() (TrustLevel, error) {
+	if c.trust.store == nil || c.theirKey == nil {
+		return TrustUnknown, nil
+	}
+	return c.trust.store.TrustLevel(c.trust.account, c.trust.protocol, c.trust.peer, c.theirKey.Fingerprint())
+}
+
+func (c *Conversation) setTheirTrustLevel(l TrustLevel) error {
+	return c.trust.store.SetTrustLevel(c.trust.account, c.trust.protocol, c.trust.peer, c.theirKey.Fingerprint(), l)
+}
+
+// recordTheirFingerprint makes sure the fingerprint of the peer is in the trust store and returns the security event
+// to signal for a new secure session. Problems with the trust store are reported as MessageEventTrustStoreError, and
+// make the session count as unverified.
+func (c *Conversation) recordTheirFingerprint() SecurityEvent {
+	if c.trust.store == nil || c.theirKey == nil {
+		return GoneSecure
+	}
+
+	l, err := c.TheirTrustLevel()
+	if err != nil {
+		c.messageEventWithError(MessageEventTrustStoreError, err)
+		return GoneSecureUnverified
+	}
+
+	if l == TrustUnknown {
+		if err := c.setTheirTrustLevel(TrustUnverified); err != nil {
+			c.messageEventWithError(MessageEventTrustStoreError, err)
+		}
+	}
+
+	if l.IsVerified() {
+		return GoneSecureVerified
+	}
+	return GoneSecureUnverified
+}
+
+func (c *Conversation) markTheirFingerprintSMPVerified() {
+	if c.trust.store == nil || c.theirKey == nil {
+		return
+	}
+
+	l, err := c.TheirTrustLevel()
+	if err == nil && l < TrustSMPVerified {
+		err = c.setTheirTrustLevel(TrustSMPVerified)
+	}
+	if err != nil {
+		c.messageEventWithError(MessageEventTrustStoreError, err)
+	}
+}
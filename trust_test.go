@@ -0,0 +1,241 @@
+package otr3
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func conversationPairWithTrustStores(aliceStore, bobStore TrustStore) (alice, bob *Conversation) {
+	alice, bob = conversationPairWithClock(fixtureClock(), allowV2|allowV3)
+	alice.SetTrustStore(aliceStore, "alice@example.org", "xmpp", "bob@example.org")
+	bob.SetTrustStore(bobStore, "bob@example.org", "xmpp", "alice@example.org")
+	return alice, bob
+}
+
+func collectSecurityEvents(c *Conversation) *[]SecurityEvent {
+	var events []SecurityEvent
+	c.securityEventHandler = dynamicSecurityEventHandler{func(e SecurityEvent) {
+		events = append(events, e)
+	}}
+	return &events
+}
+
+// countingTrustStore counts the trust levels set, and fails to set them if err is set
+type countingTrustStore struct {
+	*MemoryTrustStore
+	sets int
+	err  error
+}
+
+func (s *countingTrustStore) SetTrustLevel(account, protocol, peer string, fingerprint []byte, level TrustLevel) error {
+	s.sets++
+	if s.err != nil {
+		return s.err
+	}
+	return s.MemoryTrustStore.SetTrustLevel(account, protocol, peer, fingerprint, level)
+}
+
+func Test_TrustLevel_canBeMarshaledAsText(t *testing.T) {
+	for _, l := range []TrustLevel{TrustUnknown, TrustUnverified, TrustVerified, TrustSMPVerified} {
+		text, err := l.MarshalText()
+		assertNil(t, err)
+
+		var back TrustLevel
+		assertNil(t, back.UnmarshalText(text))
+		assertEquals(t, back, l)
+	}
+
+	var l TrustLevel
+	assertEquals(t, l.UnmarshalText([]byte("very much")), errUnknownTrustLevel)
+	_, err := TrustLevel(42).MarshalText()
+	assertEquals(t, err, errUnknownTrustLevel)
+}
+
+func Test_TrustLevel_IsVerified(t *testing.T) {
+	assertFalse(t, TrustUnknown.IsVerified())
+	assertFalse(t, TrustUnverified.IsVerified())
+	assertTrue(t, TrustVerified.IsVerified())
+	assertTrue(t, TrustSMPVerified.IsVerified())
+}
+
+func Test_MemoryTrustStore_keepsTrustLevelsPerPeerAndFingerprint(t *testing.T) {
+	s := NewMemoryTrustStore()
+	assertNil(t, s.SetTrustLevel("alice", "xmpp", "bob", []byte{0x02}, TrustVerified))
+	assertNil(t, s.SetTrustLevel("alice", "xmpp", "bob", []byte{0x01}, TrustUnverified))
+	assertNil(t, s.SetTrustLevel("alice", "irc", "bob", []byte{0x03}, TrustSMPVerified))
+
+	l, err := s.TrustLevel("alice", "xmpp", "bob", []byte{0x02})
+	assertNil(t, err)
+	assertEquals(t, l, TrustVerified)
+
+	l, _ = s.TrustLevel("alice", "xmpp", "carol", []byte{0x02})
+	assertEquals(t, l, TrustUnknown)
+
+	fprs, err := s.Fingerprints("alice", "xmpp", "bob")
+	assertNil(t, err)
+	assertDeepEquals(t, fprs, []FingerprintTrust{{[]byte{0x01}, TrustUnverified}, {[]byte{0x02}, TrustVerified}})
+
+	assertNil(t, s.SetTrustLevel("alice", "xmpp", "bob", []byte{0x01}, TrustUnknown))
+	fprs, _ = s.Fingerprints("alice", "xmpp", "bob")
+	assertDeepEquals(t, fprs, []FingerprintTrust{{[]byte{0x02}, TrustVerified}})
+}
+
+func Test_FileTrustStore_persistsTrustLevels(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "trust.json")
+
+	s, err := OpenFileTrustStore(fname)
+	assertNil(t, err)
+	assertNil(t, s.SetTrustLevel("alice", "xmpp", "bob", []byte{0xAB, 0xCD}, TrustSMPVerified))
+	assertNil(t, s.SetTrustLevel("alice", "xmpp", "carol", []byte{0x01}, TrustUnverified))
+
+	reopened, err := OpenFileTrustStore(fname)
+	assertNil(t, err)
+	l, _ := reopened.TrustLevel("alice", "xmpp", "bob", []byte{0xAB, 0xCD})
+	assertEquals(t, l, TrustSMPVerified)
+	fprs, _ := reopened.Fingerprints("alice", "xmpp", "carol")
+	assertDeepEquals(t, fprs, []FingerprintTrust{{[]byte{0x01}, TrustUnverified}})
+}
+
+func Test_FileTrustStore_returnsErrorForCorruptFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "broken.json")
+	ioutil.WriteFile(fname, []byte("{not json"), 0600)
+	_, err := OpenFileTrustStore(fname)
+	assertNotNil(t, err)
+
+	fname = filepath.Join(dir, "badfpr.json")
+	ioutil.WriteFile(fname, []byte(`[{"account":"a","protocol":"p","peer":"b","fingerprint":"XYZ","level":"smp"}]`), 0600)
+	_, err = OpenFileTrustStore(fname)
+	assertNotNil(t, err)
+}
+
+func Test_FileTrustStore_keepsTheOldLevelWhenSavingFails(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "missing", "trust.json")
+	s, err := OpenFileTrustStore(fname)
+	assertNil(t, err)
+
+	assertNotNil(t, s.SetTrustLevel("alice", "xmpp", "bob", []byte{0x01}, TrustVerified))
+	l, _ := s.TrustLevel("alice", "xmpp", "bob", []byte{0x01})
+	assertEquals(t, l, TrustUnknown)
+}
+
+func Test_Conversation_recordsNewFingerprintsAndSignalsUnverified(t *testing.T) {
+	aliceStore, bobStore := NewMemoryTrustStore(), NewMemoryTrustStore()
+	alice, bob := conversationPairWithTrustStores(aliceStore, bobStore)
+	aliceEvents := collectSecurityEvents(alice)
+
+	runAKE(t, alice, bob)
+
+	assertDeepEquals(t, *aliceEvents, []SecurityEvent{GoneSecureUnverified})
+	l, _ := aliceStore.TrustLevel("alice@example.org", "xmpp", "bob@example.org", bobPrivateKey.PublicKey().Fingerprint())
+	assertEquals(t, l, TrustUnverified)
+	l, _ = bob.TheirTrustLevel()
+	assertEquals(t, l, TrustUnverified)
+}
+
+func Test_Conversation_signalsVerifiedForVerifiedFingerprints(t *testing.T) {
+	aliceStore := NewMemoryTrustStore()
+	aliceStore.SetTrustLevel("alice@example.org", "xmpp", "bob@example.org", bobPrivateKey.PublicKey().Fingerprint(), TrustVerified)
+	alice, bob := conversationPairWithTrustStores(aliceStore, NewMemoryTrustStore())
+	aliceEvents := collectSecurityEvents(alice)
+
+	runAKE(t, alice, bob)
+
+	assertDeepEquals(t, *aliceEvents, []SecurityEvent{GoneSecureVerified})
+	l, _ := alice.TheirTrustLevel()
+	assertEquals(t, l, TrustVerified)
+}
+
+func Test_Conversation_signalsGoneSecureWithoutTrustStore(t *testing.T) {
+	alice, bob := conversationPairWithClock(fixtureClock(), allowV3)
+	aliceEvents := collectSecurityEvents(alice)
+
+	runAKE(t, alice, bob)
+
+	assertDeepEquals(t, *aliceEvents, []SecurityEvent{GoneSecure})
+	l, err := alice.TheirTrustLevel()
+	assertNil(t, err)
+	assertEquals(t, l, TrustUnknown)
+}
+
+func Test_Conversation_doesNotTouchTheTrustStoreWhenRefreshing(t *testing.T) {
+	store := &countingTrustStore{MemoryTrustStore: NewMemoryTrustStore()}
+	c := bobContextAfterAKE()
+	c.ourCurrentKey = bobPrivateKey
+	c.theirKey = alicePrivateKey.PublicKey()
+	c.msgState = encrypted
+	c.SetTrustStore(store, "bob@example.org", "xmpp", "alice@example.org")
+
+	c.expectSecurityEvent(t, func() {
+		c.akeHasFinished()
+	}, StillSecure)
+	assertEquals(t, store.sets, 0)
+}
+
+func Test_Conversation_reportsTrustStoreErrors(t *testing.T) {
+	storeErr := newOtrError("disk full")
+	aliceStore := &countingTrustStore{MemoryTrustStore: NewMemoryTrustStore(), err: storeErr}
+	alice, bob := conversationPairWithTrustStores(aliceStore, NewMemoryTrustStore())
+	aliceEvents := collectSecurityEvents(alice)
+	var reported []error
+	alice.messageEventHandler = dynamicMessageEventHandler{func(e MessageEvent, _ []byte, err error, _ ...interface{}) {
+		if e == MessageEventTrustStoreError {
+			reported = append(reported, err)
+		}
+	}}
+
+	runAKE(t, alice, bob)
+
+	assertDeepEquals(t, reported, []error{storeErr})
+	assertDeepEquals(t, *aliceEvents, []SecurityEvent{GoneSecureUnverified})
+}
+
+func Test_Conversation_successfulSMPMarksTheFingerprintAsSMPVerified(t *testing.T) {
+	aliceStore, bobStore := NewMemoryTrustStore(), NewMemoryTrustStore()
+	alice, bob := conversationPairWithTrustStores(aliceStore, bobStore)
+	runAKE(t, alice, bob)
+
+	toBob, _ := alice.StartAuthenticate("", []byte("secret"))
+	deliverAll(t, bob, toBob)
+	toAlice, _ := bob.ProvideAuthenticationSecret([]byte("secret"))
+	toBob = deliverAll(t, alice, toAlice)
+	deliverAll(t, alice, deliverAll(t, bob, toBob))
+
+	l, _ := alice.TheirTrustLevel()
+	assertEquals(t, l, TrustSMPVerified)
+	l, _ = bob.TheirTrustLevel()
+	assertEquals(t, l, TrustSMPVerified)
+}
+
+func Test_Conversation_failedSMPDoesNotChangeTheTrustLevel(t *testing.T) {
+	aliceStore, bobStore := NewMemoryTrustStore(), NewMemoryTrustStore()
+	alice, bob := conversationPairWithTrustStores(aliceStore, bobStore)
+	runAKE(t, alice, bob)
+
+	toBob, _ := alice.StartAuthenticate("", []byte("secret"))
+	deliverAll(t, bob, toBob)
+	toAlice, _ := bob.ProvideAuthenticationSecret([]byte("another secret"))
+	toBob = deliverAll(t, alice, toAlice)
+	deliverAll(t, alice, deliverAll(t, bob, toBob))
+
+	l, _ := alice.TheirTrustLevel()
+	assertEquals(t, l, TrustUnverified)
+	l, _ = bob.TheirTrustLevel()
+	assertEquals(t, l, TrustUnverified)
+}
+
+func Test_UserState_givesNewConversationsItsTrustStore(t *testing.T) {
+	u := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	u.TrustStore = NewMemoryTrustStore()
+
+	c, _ := u.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	assertEquals(t, c.trust, trustContext{u.TrustStore, "alice", "xmpp", "bob"})
+}
@@ -0,0 +1,420 @@
+package otr3
+
+import (
+	"crypto/rand"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/coyim/gotrax"
+)
+
+// These special instance tags can be given to UserState.Send and UserState.Conversation to select an instance.
+// They mirror the OTRL_INSTAG_* values in libotr and can never collide with real instance tags.
+const (
+	// InstanceTagMaster selects the master conversation, which is used before we know about any instances of the peer
+	InstanceTagMaster = uint32(0)
+	// InstanceTagBest selects the instance with the best security state, preferring the most recently active one
+	InstanceTagBest = uint32(1)
+	// InstanceTagRecent selects the instance we have most recently sent to or received from
+	InstanceTagRecent = uint32(2)
+	// InstanceTagRecentReceived selects the instance we have most recently received from
+	InstanceTagRecentReceived = uint32(3)
+	// InstanceTagRecentSent selects the instance we have most recently sent to
+	InstanceTagRecentSent = uint32(4)
+)
+
+type accountID struct {
+	name, protocol string
+}
+
+type peerID struct {
+	account accountID
+	peer    string
+}
+
+type instanceContext struct {
+	conversation           *Conversation
+	lastReceived, lastSent time.Time
+}
+
+// peerContext is the equivalent of a libotr master context with its children - one child per instance of the peer
+type peerContext struct {
+	master   *instanceContext
+	children map[uint32]*instanceContext
+}
+
+// UserState keeps track of all accounts of a user, their instance tags and the conversations with every peer.
+// It is the equivalent of OtrlUserState in libotr, and it routes incoming messages to the conversation for the
+// instance of the peer that sent them.
+// A UserState is not safe for concurrent use.
+type UserState struct {
+	// Rand is used to generate instance tags. If it is nil, crypto/rand will be used
+	Rand io.Reader
+
+	// Policy is the policy given to every new conversation. It can be changed by ConversationSetup, and is
+	// replaced by the override for the peer if there is one
+	Policy Policy
+
+	// TrustStore is given to every new conversation, so that fingerprints of peers are recorded and verified
+	TrustStore TrustStore
+
+	// ConversationSetup will be called every time a new conversation is created, before it is used.
+	// It is the right place to set policies and event handlers for the conversation.
+	ConversationSetup func(c *Conversation, account *Account, peer string)
+
+	accounts       []*Account
+	instanceTags   map[accountID]uint32
+	peers          map[peerID]*peerContext
+	policyOverride map[peerID]Policy
+}
+
+// NewUserState creates a new UserState holding the given accounts, for example the ones returned from ImportKeys
+func NewUserState(accounts []*Account) *UserState {
+	u := &UserState{
+		instanceTags:   make(map[accountID]uint32),
+		peers:          make(map[peerID]*peerContext),
+		policyOverride: make(map[peerID]Policy),
+	}
+
+	for _, a := range accounts {
+		u.AddAccount(a)
+	}
+
+	return u
+}
+
+// AddAccount adds an account to this user state, replacing any existing account with the same name and protocol
+func (u *UserState) AddAccount(a *Account) {
+	for i, existing := range u.accounts {
+		if existing.Name == a.Name && existing.Protocol == a.Protocol {
+			u.accounts[i] = a
+			return
+		}
+	}
+	u.accounts = append(u.accounts, a)
+}
+
+// Accounts returns all accounts of this user state
+func (u *UserState) Accounts() []*Account {
+	return u.accounts
+}
+
+// Account returns the account with the given name and protocol
+func (u *UserState) Account(name, protocol string) (*Account, bool) {
+	for _, a := range u.accounts {
+		if a.Name == name && a.Protocol == protocol {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// SetInstanceTag sets our instance tag for an account. It should be called with the persisted instance tag
+// before any conversation for the account is created.
+func (u *UserState) SetInstanceTag(account, protocol string, tag uint32) {
+	u.instanceTags[accountID{account, protocol}] = tag
+}
+
+// InstanceTag returns our instance tag for an account, generating a new one if there is none
+func (u *UserState) InstanceTag(account, protocol string) (uint32, error) {
+	id := accountID{account, protocol}
+	if tag, ok := u.instanceTags[id]; ok {
+		return tag, nil
+	}
+
+	tag, err := randomInstanceTag(u.rand())
+	if err != nil {
+		return 0, err
+	}
+
+	u.instanceTags[id] = tag
+	return tag, nil
+}
+
+// SetPeerPolicy sets a policy for conversations with the peer that overrides both the Policy of the user state and
+// the one set by ConversationSetup. It only affects conversations created after it is called.
+func (u *UserState) SetPeerPolicy(account, protocol, peer string, p Policy) {
+	u.policyOverride[peerID{accountID{account, protocol}, peer}] = p
+}
+
+// RemovePeerPolicy removes the policy override for the peer
+func (u *UserState) RemovePeerPolicy(account, protocol, peer string) {
+	delete(u.policyOverride, peerID{accountID{account, protocol}, peer})
+}
+
+// PeerPolicy returns the policy override for the peer, and not ok if there is none
+func (u *UserState) PeerPolicy(account, protocol, peer string) (p Policy, ok bool) {
+	p, ok = u.policyOverride[peerID{accountID{account, protocol}, peer}]
+	return
+}
+
+// Instances returns the instance tags of all known instances of the peer, in ascending order
+func (u *UserState) Instances(account, protocol, peer string) []uint32 {
+	pc, ok := u.peers[peerID{accountID{account, protocol}, peer}]
+	if !ok {
+		return nil
+	}
+
+	var ret []uint32
+	for tag := range pc.children {
+		ret = append(ret, tag)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// Conversation returns the conversation with the peer for the given instance. The instance can be either a
+// real instance tag or one of the InstanceTag* selectors. The master conversation is created if needed.
+func (u *UserState) Conversation(account, protocol, peer string, instance uint32) (*Conversation, error) {
+	ic, err := u.selectInstance(account, protocol, peer, instance)
+	if err != nil {
+		return nil, err
+	}
+	return ic.conversation, nil
+}
+
+// Send sends a message to the peer, using the conversation for the given instance. The instance can be either a
+// real instance tag or one of the InstanceTag* selectors.
+func (u *UserState) Send(account, protocol, peer string, instance uint32, m ValidMessage, trace ...interface{}) ([]ValidMessage, error) {
+	ic, err := u.selectInstance(account, protocol, peer, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	ic.lastSent = ic.conversation.now()
+	return ic.conversation.Send(m, trace...)
+}
+
+// Receive handles a message from the peer, routing it to the conversation for the instance that sent it.
+// Messages without instance information, such as plaintext, query messages and OTRv2 messages, are handled by the
+// master conversation. The conversation that handled the message is returned together with the result of receiving
+// it. Messages addressed to another of our instances are ignored - the master conversation is returned for them, with
+// nothing to show or send.
+func (u *UserState) Receive(account, protocol, peer string, m ValidMessage) (plain MessagePlaintext, toSend []ValidMessage, c *Conversation, err error) {
+	pc, err := u.peerContextFor(account, protocol, peer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ic := pc.master
+	if sender, receiver, ok := instanceTagsOf(m); ok {
+		ourTag, err := u.InstanceTag(account, protocol)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if receiver != 0 && receiver != ourTag {
+			return nil, nil, ic.conversation, nil
+		}
+
+		if sender >= minValidInstanceTag {
+			if ic, err = u.childFor(pc, account, protocol, peer, sender, guessMessageType(m)); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	ic.lastReceived = ic.conversation.now()
+	plain, toSend, err = ic.conversation.Receive(m)
+	return plain, toSend, ic.conversation, err
+}
+
+func (u *UserState) rand() io.Reader {
+	if u.Rand != nil {
+		return u.Rand
+	}
+	return rand.Reader
+}
+
+func (u *UserState) newConversation(account, protocol, peer string) (*Conversation, error) {
+	a, ok := u.Account(account, protocol)
+	if !ok {
+		return nil, errUnknownAccount
+	}
+
+	tag, err := u.InstanceTag(account, protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conversation{Policies: u.Policy}
+	if u.TrustStore != nil {
+		c.SetTrustStore(u.TrustStore, account, protocol, peer)
+	}
+	if u.ConversationSetup != nil {
+		u.ConversationSetup(c, a, peer)
+	}
+	if p, ok := u.PeerPolicy(account, protocol, peer); ok {
+		c.Policies = p
+	}
+	c.SetOurKeys([]PrivateKey{a.Key})
+	c.InitializeInstanceTag(tag)
+
+	return c, nil
+}
+
+func (u *UserState) peerContextFor(account, protocol, peer string) (*peerContext, error) {
+	id := peerID{accountID{account, protocol}, peer}
+	if pc, ok := u.peers[id]; ok {
+		return pc, nil
+	}
+
+	c, err := u.newConversation(account, protocol, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &peerContext{
+		master:   &instanceContext{conversation: c},
+		children: make(map[uint32]*instanceContext),
+	}
+	u.peers[id] = pc
+
+	return pc, nil
+}
+
+func (u *UserState) childFor(pc *peerContext, account, protocol, peer string, theirTag uint32, msgType messageTypeGuess) (*instanceContext, error) {
+	if ic, ok := pc.children[theirTag]; ok {
+		return ic, nil
+	}
+
+	c, err := u.newConversation(account, protocol, peer)
+	if err != nil {
+		return nil, err
+	}
+	c.theirInstanceTag = theirTag
+
+	// As in libotr, a DH-Key message from a new instance is an answer to a DH-Commit sent by the master
+	// conversation, so the child has to continue the AKE from where the master left it
+	master := pc.master.conversation
+	if msgType == msgGuessDHKey && master.ake != nil && master.ake.state == (authStateAwaitingDHKey{}) {
+		c.version = master.version
+		c.ourCurrentKey = master.ourCurrentKey
+		c.ake = master.ake.copy()
+	}
+
+	// The same goes for an auth-r message answering an identity message sent by the master conversation
+	if msgType == msgGuessAuthR && master.dake != nil && master.dake.state == (dakeStateAwaitingAuthR{}) {
+		c.version = master.version
+		c.ourCurrentKey = master.ourCurrentKey
+		c.clientProfile = master.clientProfile
+		c.dake = master.dake.copy()
+	}
+
+	ic := &instanceContext{conversation: c}
+	pc.children[theirTag] = ic
+
+	return ic, nil
+}
+
+func (u *UserState) selectInstance(account, protocol, peer string, instance uint32) (*instanceContext, error) {
+	pc, err := u.peerContextFor(account, protocol, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	switch instance {
+	case InstanceTagMaster:
+		return pc.master, nil
+	case InstanceTagBest:
+		return pc.best(), nil
+	case InstanceTagRecent:
+		return pc.mostRecent(func(ic *instanceContext) time.Time {
+			if ic.lastSent.After(ic.lastReceived) {
+				return ic.lastSent
+			}
+			return ic.lastReceived
+		}), nil
+	case InstanceTagRecentReceived:
+		return pc.mostRecent(func(ic *instanceContext) time.Time { return ic.lastReceived }), nil
+	case InstanceTagRecentSent:
+		return pc.mostRecent(func(ic *instanceContext) time.Time { return ic.lastSent }), nil
+	}
+
+	if ic, ok := pc.children[instance]; ok {
+		return ic, nil
+	}
+	return nil, errUnknownInstance
+}
+
+func (pc *peerContext) mostRecent(when func(*instanceContext) time.Time) *instanceContext {
+	var ret *instanceContext
+	for _, ic := range pc.children {
+		if ret == nil || when(ic).After(when(ret)) {
+			ret = ic
+		}
+	}
+
+	if ret == nil {
+		return pc.master
+	}
+	return ret
+}
+
+func msgStateRank(s msgState) int {
+	switch s {
+	case encrypted:
+		return 2
+	case finished:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// best follows the libotr rules: an encrypted instance is better than a finished one, which is better
+// than a plaintext one. Ties are broken by the most recently received message.
+func (pc *peerContext) best() *instanceContext {
+	var ret *instanceContext
+	for _, ic := range pc.children {
+		if ret == nil {
+			ret = ic
+			continue
+		}
+
+		r, rr := msgStateRank(ic.conversation.msgState), msgStateRank(ret.conversation.msgState)
+		if r > rr || (r == rr && ic.lastReceived.After(ret.lastReceived)) {
+			ret = ic
+		}
+	}
+
+	if ret == nil {
+		return pc.master
+	}
+	return ret
+}
+
+// instanceTagsOf returns the sender and receiver instance tags of an OTRv3 or OTRv4 encoded message or fragment.
+// It returns not ok for all other messages.
+func instanceTagsOf(m ValidMessage) (sender, receiver uint32, ok bool) {
+	switch guessMessageType(m) {
+	case msgGuessFragment:
+		switch versionFromFragment(m) {
+		case 3:
+			return parseFragmentInstanceTags(m)
+		case 4:
+			return parseOTRv4FragmentInstanceTags(m)
+		}
+		return 0, 0, false
+	case msgGuessDHCommit, msgGuessDHKey, msgGuessRevealSig, msgGuessSignature, msgGuessData,
+		msgGuessIdentity, msgGuessAuthR, msgGuessAuthI:
+		if len(m) <= len(msgMarker) {
+			return 0, 0, false
+		}
+
+		decoded, err := b64decode(removeOTRMsgEnvelope(encodedMessage(m)))
+		if err != nil {
+			return 0, 0, false
+		}
+
+		if _, v, ok := gotrax.ExtractShort(decoded); !ok || v < 3 {
+			return 0, 0, false
+		}
+
+		_, sender, receiver, ok = extractInstanceTags(decoded)
+		return sender, receiver, ok
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,298 @@
+package otr3
+
+import (
+	"testing"
+	"time"
+)
+
+func fixtureUserState(name string, key PrivateKey, tag uint32) *UserState {
+	u := NewUserState([]*Account{&Account{Name: name, Protocol: "xmpp", Key: key}})
+	u.SetInstanceTag(name, "xmpp", tag)
+	u.ConversationSetup = func(c *Conversation, a *Account, peer string) {
+		c.Policies = Policy(allowV2 | allowV3)
+	}
+	return u
+}
+
+// exchange delivers messages between two user states until there is nothing more to deliver
+func exchange(t *testing.T, from *UserState, fromName string, to *UserState, toName string, msgs []ValidMessage) {
+	for i := 0; i < 10 && len(msgs) > 0; i++ {
+		var replies []ValidMessage
+		for _, m := range msgs {
+			_, ts, _, err := to.Receive(toName, "xmpp", fromName, m)
+			if err != nil {
+				t.Fatalf("Unexpected error when receiving: %v", err)
+			}
+			replies = append(replies, ts...)
+		}
+		msgs = replies
+		from, to = to, from
+		fromName, toName = toName, fromName
+	}
+}
+
+func Test_NewUserState_holdsTheGivenAccounts(t *testing.T) {
+	a := &Account{Name: "alice@example.org", Protocol: "xmpp", Key: alicePrivateKey}
+	u := NewUserState([]*Account{a})
+
+	assertDeepEquals(t, u.Accounts(), []*Account{a})
+	found, ok := u.Account("alice@example.org", "xmpp")
+	assertTrue(t, ok)
+	assertEquals(t, found, a)
+
+	_, ok = u.Account("alice@example.org", "irc")
+	assertFalse(t, ok)
+}
+
+func Test_UserState_AddAccount_replacesAccountsWithTheSameNameAndProtocol(t *testing.T) {
+	u := NewUserState([]*Account{&Account{Name: "alice", Protocol: "xmpp", Key: alicePrivateKey}})
+	replacement := &Account{Name: "alice", Protocol: "xmpp", Key: bobPrivateKey}
+	u.AddAccount(replacement)
+
+	assertDeepEquals(t, u.Accounts(), []*Account{replacement})
+}
+
+func Test_UserState_InstanceTag_generatesAValidInstanceTagOnlyOnce(t *testing.T) {
+	u := NewUserState(nil)
+	tag, err := u.InstanceTag("alice", "xmpp")
+	assertNil(t, err)
+	assertTrue(t, tag >= minValidInstanceTag)
+
+	again, _ := u.InstanceTag("alice", "xmpp")
+	assertEquals(t, again, tag)
+}
+
+func Test_UserState_InstanceTag_returnsErrorOnBrokenRandomness(t *testing.T) {
+	u := NewUserState(nil)
+	u.Rand = fixedRand([]string{"00"})
+	_, err := u.InstanceTag("alice", "xmpp")
+	assertEquals(t, err, errShortRandomRead)
+}
+
+func Test_UserState_Receive_returnsErrorForUnknownAccount(t *testing.T) {
+	u := NewUserState(nil)
+	_, _, _, err := u.Receive("alice", "xmpp", "bob", ValidMessage("hello"))
+	assertEquals(t, err, errUnknownAccount)
+}
+
+func Test_UserState_Receive_handsPlaintextToTheMasterConversation(t *testing.T) {
+	u := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	plain, _, c, err := u.Receive("alice", "xmpp", "bob", ValidMessage("hello"))
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("hello"))
+
+	master, _ := u.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	assertEquals(t, c, master)
+	assertEquals(t, c.ourInstanceTag, uint32(0x1000))
+	assertNil(t, u.Instances("alice", "xmpp", "bob"))
+}
+
+func Test_UserState_routesTheAKEToAChildConversationForTheInstance(t *testing.T) {
+	alice := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	bob := fixtureUserState("bob", bobPrivateKey, 0x2000)
+
+	query, _ := alice.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	exchange(t, alice, "alice", bob, "bob", []ValidMessage{query.QueryMessage()})
+
+	assertDeepEquals(t, alice.Instances("alice", "xmpp", "bob"), []uint32{0x2000})
+	assertDeepEquals(t, bob.Instances("bob", "xmpp", "alice"), []uint32{0x1000})
+
+	ac, _ := alice.Conversation("alice", "xmpp", "bob", 0x2000)
+	bc, _ := bob.Conversation("bob", "xmpp", "alice", 0x1000)
+	assertTrue(t, ac.IsEncrypted())
+	assertTrue(t, bc.IsEncrypted())
+
+	toBob, err := alice.Send("alice", "xmpp", "bob", InstanceTagBest, ValidMessage("hi bob"))
+	assertNil(t, err)
+	plain, _, c, err := bob.Receive("bob", "xmpp", "alice", toBob[0])
+	assertNil(t, err)
+	assertEquals(t, c, bc)
+	assertDeepEquals(t, plain, MessagePlaintext("hi bob"))
+}
+
+func Test_UserState_keepsOneChildPerInstanceOfThePeer(t *testing.T) {
+	alice := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	bobPhone := fixtureUserState("bob", bobPrivateKey, 0x2000)
+	bobLaptop := fixtureUserState("bob", bobPrivateKey, 0x3000)
+
+	query, _ := alice.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	exchange(t, alice, "alice", bobPhone, "bob", []ValidMessage{query.QueryMessage()})
+	exchange(t, alice, "alice", bobLaptop, "bob", []ValidMessage{query.QueryMessage()})
+
+	assertDeepEquals(t, alice.Instances("alice", "xmpp", "bob"), []uint32{0x2000, 0x3000})
+
+	toLaptop, _ := alice.Send("alice", "xmpp", "bob", 0x3000, ValidMessage("to the laptop"))
+
+	plain, ts, c, err := bobPhone.Receive("bob", "xmpp", "alice", toLaptop[0])
+	assertNil(t, err)
+	assertNil(t, plain)
+	assertNil(t, ts)
+	master, _ := bobPhone.Conversation("bob", "xmpp", "alice", InstanceTagMaster)
+	assertEquals(t, c, master)
+
+	plain, _, _, err = bobLaptop.Receive("bob", "xmpp", "alice", toLaptop[0])
+	assertNil(t, err)
+	assertDeepEquals(t, plain, MessagePlaintext("to the laptop"))
+}
+
+func Test_UserState_Receive_returnsTheMasterConversationForMessagesToAnotherInstance(t *testing.T) {
+	alice := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	bobPhone := fixtureUserState("bob", bobPrivateKey, 0x2000)
+	bobLaptop := fixtureUserState("bob", bobPrivateKey, 0x3000)
+
+	query, _ := alice.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	exchange(t, alice, "alice", bobPhone, "bob", []ValidMessage{query.QueryMessage()})
+	exchange(t, alice, "alice", bobLaptop, "bob", []ValidMessage{query.QueryMessage()})
+
+	toLaptop, _ := alice.Send("alice", "xmpp", "bob", 0x3000, ValidMessage("to the laptop"))
+
+	_, _, c, err := bobPhone.Receive("bob", "xmpp", "alice", toLaptop[0])
+	assertNil(t, err)
+	master, _ := bobPhone.Conversation("bob", "xmpp", "alice", InstanceTagMaster)
+	assertEquals(t, c, master)
+	assertFalse(t, c.IsEncrypted())
+}
+
+func Test_UserState_continuesTheAKEOfTheMasterInANewChild(t *testing.T) {
+	alice := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	bob := fixtureUserState("bob", bobPrivateKey, 0x2000)
+
+	// Bob receives the query in the master conversation and answers with a DH-Commit
+	query, _ := alice.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	_, dhCommit, c, _ := bob.Receive("bob", "xmpp", "alice", query.QueryMessage())
+	master, _ := bob.Conversation("bob", "xmpp", "alice", InstanceTagMaster)
+	assertEquals(t, c, master)
+
+	exchange(t, bob, "bob", alice, "alice", dhCommit)
+
+	child, err := bob.Conversation("bob", "xmpp", "alice", 0x1000)
+	assertNil(t, err)
+	assertTrue(t, child.IsEncrypted())
+	assertFalse(t, master.IsEncrypted())
+}
+
+func Test_UserState_continuesTheDAKEOfTheMasterInANewChild(t *testing.T) {
+	alice := fixtureUserState("alice", generateEd448Key(t), 0x1000)
+	bob := fixtureUserState("bob", generateEd448Key(t), 0x2000)
+	for _, u := range []*UserState{alice, bob} {
+		u.ConversationSetup = func(c *Conversation, a *Account, peer string) {
+			c.Policies = Policy(allowV3 | allowV4)
+		}
+	}
+
+	// Bob starts the DAKE in the master conversation with an identity message
+	master, _ := bob.Conversation("bob", "xmpp", "alice", InstanceTagMaster)
+	identity, err := master.StartOTRv4()
+	assertNil(t, err)
+
+	exchange(t, bob, "bob", alice, "alice", identity)
+
+	child, err := bob.Conversation("bob", "xmpp", "alice", 0x1000)
+	assertNil(t, err)
+	assertTrue(t, child.IsEncrypted())
+	assertEquals(t, child.version, otrV4{})
+	assertFalse(t, master.IsEncrypted())
+}
+
+func Test_UserState_Conversation_returnsErrorForUnknownInstance(t *testing.T) {
+	u := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	_, err := u.Conversation("alice", "xmpp", "bob", 0x4242)
+	assertEquals(t, err, errUnknownInstance)
+}
+
+func Test_UserState_selectorsFallBackToTheMasterWithoutChildren(t *testing.T) {
+	u := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	master, _ := u.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+
+	for _, sel := range []uint32{InstanceTagBest, InstanceTagRecent, InstanceTagRecentReceived, InstanceTagRecentSent} {
+		c, err := u.Conversation("alice", "xmpp", "bob", sel)
+		assertNil(t, err)
+		assertEquals(t, c, master)
+	}
+}
+
+func Test_peerContext_best_prefersEncryptedThenMostRecentlyReceived(t *testing.T) {
+	now := time.Now()
+	plain := &instanceContext{conversation: &Conversation{msgState: plainText}, lastReceived: now}
+	fin := &instanceContext{conversation: &Conversation{msgState: finished}, lastReceived: now.Add(-time.Minute)}
+	oldEnc := &instanceContext{conversation: &Conversation{msgState: encrypted}, lastReceived: now.Add(-2 * time.Minute)}
+	newEnc := &instanceContext{conversation: &Conversation{msgState: encrypted}, lastReceived: now.Add(-time.Minute)}
+
+	pc := &peerContext{children: map[uint32]*instanceContext{0x100: plain, 0x200: fin}}
+	assertEquals(t, pc.best(), fin)
+
+	pc.children[0x300] = oldEnc
+	pc.children[0x400] = newEnc
+	assertEquals(t, pc.best(), newEnc)
+}
+
+func Test_peerContext_mostRecent_usesTheGivenTime(t *testing.T) {
+	now := time.Now()
+	one := &instanceContext{lastSent: now, lastReceived: now.Add(-time.Hour)}
+	two := &instanceContext{lastSent: now.Add(-time.Hour), lastReceived: now}
+
+	pc := &peerContext{children: map[uint32]*instanceContext{0x100: one, 0x200: two}}
+	assertEquals(t, pc.mostRecent(func(ic *instanceContext) time.Time { return ic.lastSent }), one)
+	assertEquals(t, pc.mostRecent(func(ic *instanceContext) time.Time { return ic.lastReceived }), two)
+}
+
+func Test_instanceTagsOf_readsTagsFromEncodedMessagesAndFragments(t *testing.T) {
+	c := newConversation(otrV3{}, fixtureRand())
+	c.ourInstanceTag = 0x1234
+	c.theirInstanceTag = 0x5678
+	msg, _ := c.wrapMessageHeader(msgTypeDHKey, []byte{0x01, 0x02})
+	encoded := c.encode(msg)
+
+	sender, receiver, ok := instanceTagsOf(ValidMessage(encoded))
+	assertTrue(t, ok)
+	assertEquals(t, sender, uint32(0x1234))
+	assertEquals(t, receiver, uint32(0x5678))
+
+	frags := c.fragment(encoded, 30)
+	sender, receiver, ok = instanceTagsOf(frags[0])
+	assertTrue(t, ok)
+	assertEquals(t, sender, uint32(0x1234))
+	assertEquals(t, receiver, uint32(0x5678))
+}
+
+func Test_instanceTagsOf_isNotOkForMessagesWithoutTags(t *testing.T) {
+	c := newConversation(otrV2{}, fixtureRand())
+	msg, _ := c.wrapMessageHeader(msgTypeDHKey, []byte{0x01, 0x02})
+
+	for _, m := range []ValidMessage{
+		ValidMessage("hello"),
+		ValidMessage("?OTRv3?"),
+		ValidMessage(c.encode(msg)),
+		ValidMessage("?OTR,00001,00002,abc,"),
+	} {
+		_, _, ok := instanceTagsOf(m)
+		assertFalse(t, ok)
+	}
+}
+
+func Test_UserState_givesNewConversationsItsPolicy(t *testing.T) {
+	u := NewUserState([]*Account{&Account{Name: "alice", Protocol: "xmpp", Key: alicePrivateKey}})
+	u.Policy = PolicyOpportunistic
+
+	c, _ := u.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	assertEquals(t, c.Policies, PolicyOpportunistic)
+}
+
+func Test_UserState_peerPolicyOverridesTheConversationSetup(t *testing.T) {
+	u := fixtureUserState("alice", alicePrivateKey, 0x1000)
+	u.SetPeerPolicy("alice", "xmpp", "bob", PolicyAlways)
+
+	p, ok := u.PeerPolicy("alice", "xmpp", "bob")
+	assertTrue(t, ok)
+	assertEquals(t, p, PolicyAlways)
+
+	bob, _ := u.Conversation("alice", "xmpp", "bob", InstanceTagMaster)
+	assertEquals(t, bob.Policies, PolicyAlways)
+
+	carol, _ := u.Conversation("alice", "xmpp", "carol", InstanceTagMaster)
+	assertEquals(t, carol.Policies, Policy(allowV2|allowV3))
+
+	u.RemovePeerPolicy("alice", "xmpp", "bob")
+	_, ok = u.PeerPolicy("alice", "xmpp", "bob")
+	assertFalse(t, ok)
+}
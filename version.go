@@ -15,7 +15,7 @@ type otrVersion interface {
 	isGroupElement(n *big.Int) bool
 	isFragmented(data []byte) bool
 	parseFragmentPrefix(c *Conversation, data []byte) (rest []byte, ignore bool, ok bool)
-	fragmentPrefix(n, total int, itags uint32, itagr uint32) []byte
+	fragmentPrefix(n, total int, id, itags, itagr uint32) []byte
 	whitespaceTag() []byte
 	messageHeader(c *Conversation, msgType byte) ([]byte, error)
 	parseMessageHeader(c *Conversation, msg []byte) ([]byte, []byte, error)
@@ -29,8 +29,8 @@ type otrVersion interface {
 	keyLength() int
 }
 
-func newOtrVersion(v uint16, p policies) (version otrVersion, err error) {
-	toCheck := policy(0)
+func newOtrVersion(v uint16, p Policy) (version otrVersion, err error) {
+	toCheck := Policy(0)
 	switch v {
 	case 2:
 		version = otrV2{}
@@ -38,6 +38,9 @@ func newOtrVersion(v uint16, p policies) (version otrVersion, err error) {
 	case 3:
 		version = otrV3{}
 		toCheck = allowV3
+	case 4:
+		version = otrV4{}
+		toCheck = allowV4
 	default:
 		return nil, errUnsupportedOTRVersion
 	}
@@ -51,6 +54,8 @@ func versionFromFragment(fragment []byte) uint16 {
 	var messageVersion uint16
 
 	switch {
+	case isOTRv4Fragment(fragment):
+		messageVersion = 4
 	case bytes.HasPrefix(fragment, otrv3FragmentationPrefix):
 		messageVersion = 3
 	case bytes.HasPrefix(fragment, otrv2FragmentationPrefix):
@@ -87,6 +92,8 @@ func (c *Conversation) commitToVersionFrom(versions int) error {
 	var version otrVersion
 
 	switch {
+	case c.Policies.has(allowV4) && versions&(1<<4) > 0 && c.hasKeyForVersion(4):
+		version = otrV4{}
 	case c.Policies.has(allowV3) && versions&(1<<3) > 0:
 		version = otrV3{}
 	case c.Policies.has(allowV2) && versions&(1<<2) > 0:
@@ -100,7 +107,34 @@ func (c *Conversation) commitToVersionFrom(versions int) error {
 	return c.setKeyMatchingVersion()
 }
 
+func (c *Conversation) hasKeyForVersion(v uint16) bool {
+	if c.signerIsAvailableForVersion(v) {
+		return true
+	}
+	for _, k := range c.ourKeys {
+		if k.IsAvailableForVersion(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// signerIsAvailableForVersion returns true if we have a signer that can be used with the given version. Only OTRv2
+// and OTRv3 can use signers, since the DAKE of OTRv4 needs our secret key for its ring signatures.
+func (c *Conversation) signerIsAvailableForVersion(v uint16) bool {
+	if c.ourSigner == nil {
+		return false
+	}
+	pub, ok := c.ourSigner.PublicKey().(*DSAPublicKey)
+	return ok && pub.IsAvailableForVersion(v)
+}
+
 func (c *Conversation) setKeyMatchingVersion() error {
+	if c.signerIsAvailableForVersion(c.version.protocolVersion()) {
+		c.ourCurrentKey = c.ourSigner
+		return nil
+	}
+
 	for _, k := range c.ourKeys {
 		if k.IsAvailableForVersion(c.version.protocolVersion()) {
 			c.ourCurrentKey = k
@@ -3,18 +3,18 @@ package otr3
 import "testing"
 
 func Test_newOtrVersion_returnsTheCorrectOTRVersionForAValidVersionNumber(t *testing.T) {
-	v, _ := newOtrVersion(3, policies(allowV3))
+	v, _ := newOtrVersion(3, Policy(allowV3))
 	_, ok := v.(otrV3)
 	assertEquals(t, ok, true)
 }
 
 func Test_newOtrVersion_returnsUnsupportedVersionErrorIfGivenAWrongVersion(t *testing.T) {
-	_, err := newOtrVersion(4, policies(allowV3))
+	_, err := newOtrVersion(5, Policy(allowV3))
 	assertEquals(t, err, errUnsupportedOTRVersion)
 }
 
 func Test_newOtrVersion_returnsAnErrorIfGivenAVersionThatIsntAllowedByPolicy(t *testing.T) {
-	_, err := newOtrVersion(3, policies(allowV2))
+	_, err := newOtrVersion(3, Policy(allowV2))
 	assertEquals(t, err, errInvalidVersion)
 }
 
@@ -25,7 +25,7 @@ func Test_checkVersion_returnsErrorIfTheMessageIsCorrupt(t *testing.T) {
 }
 
 func Test_checkVersion_setsTheConversationVersionIfWeHaveNoExistingVersion(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV3)}
+	c := &Conversation{Policies: Policy(allowV3)}
 	c.ourKeys = []PrivateKey{alicePrivateKey}
 	e := c.checkVersion([]byte{0x00, 0x03})
 	assertEquals(t, e, nil)
@@ -33,7 +33,7 @@ func Test_checkVersion_setsTheConversationVersionIfWeHaveNoExistingVersion(t *te
 }
 
 func Test_checkVersion_setsTheConversationVersionIfWeHaveTheCorrectPolicy(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV2)}
+	c := &Conversation{Policies: Policy(allowV2)}
 	c.ourKeys = []PrivateKey{alicePrivateKey}
 	e := c.checkVersion([]byte{0x00, 0x02})
 	assertEquals(t, e, nil)
@@ -41,21 +41,21 @@ func Test_checkVersion_setsTheConversationVersionIfWeHaveTheCorrectPolicy(t *tes
 }
 
 func Test_checkVersion_returnsTheErrorFromNewOtrVersion(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV2)}
+	c := &Conversation{Policies: Policy(allowV2)}
 	c.ourKeys = []PrivateKey{alicePrivateKey}
 	e := c.checkVersion([]byte{0x00, 0x03})
 	assertEquals(t, e, errUnsupportedOTRVersion)
 }
 
 func Test_checkVersion_doesNotSetConversationVersionIfOneIsAlreadySet(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV2 | allowV3), version: otrV3{}}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3), version: otrV3{}}
 	c.ourKeys = []PrivateKey{alicePrivateKey}
 	c.checkVersion([]byte{0x00, 0x02})
 	assertEquals(t, otrV3{}, c.version)
 }
 
 func Test_checkVersion_returnsErrorIfCurrentVersionIsDifferentFromMessageVersion(t *testing.T) {
-	c := &Conversation{Policies: policies(allowV2 | allowV3), version: otrV3{}}
+	c := &Conversation{Policies: Policy(allowV2 | allowV3), version: otrV3{}}
 	c.ourKeys = []PrivateKey{alicePrivateKey}
 	e := c.checkVersion([]byte{0x00, 0x02})
 	assertEquals(t, e, errWrongProtocolVersion)
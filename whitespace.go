@@ -30,7 +30,7 @@ var (
 	whitespaceTagHeader = convertToWhitespace("OT")
 )
 
-func genWhitespaceTag(p policies) []byte {
+func genWhitespaceTag(p Policy) []byte {
 	ret := whitespaceTagHeader
 
 	if p.has(allowV2) {
@@ -105,7 +105,7 @@ func (c *Conversation) startAKEFromWhitespaceTag(versions int) (toSend []message
 		return
 	}
 
-	ts, e := c.sendDHCommit()
+	ts, e := c.sendAKEStart()
 	toSend, err = c.potentialAuthError(compactMessagesWithHeader(ts), e)
 	return
 }
@@ -6,7 +6,7 @@ import (
 )
 
 func Test_extractWhitespaceTag_removesTagFromMessage(t *testing.T) {
-	p := policies(allowV2)
+	p := Policy(allowV2)
 	expectedTag := genWhitespaceTag(p)
 
 	messages := []ValidMessage{
@@ -26,7 +26,7 @@ func Test_extractWhitespaceTag_removesTagFromMessage(t *testing.T) {
 func Test_processWhitespaceTag_shouldNotStartAKEIfPolicyDoesNotAllow(t *testing.T) {
 	c := &Conversation{}
 	// the policy explicitly is missing whitespaceStartAKE
-	c.Policies = policies(allowV2)
+	c.Policies = Policy(allowV2)
 	c.ensureAKE()
 	assertEquals(t, c.ake.state, authStateNone{})
 
@@ -43,7 +43,7 @@ func Test_processWhitespaceTag_shouldNotStartAKEIfPolicyDoesNotAllow(t *testing.
 
 func Test_genWhitespace_forV2(t *testing.T) {
 	hLen := len(whitespaceTagHeader)
-	p := policies(allowV2)
+	p := Policy(allowV2)
 	tag := genWhitespaceTag(p)
 
 	assertDeepEquals(t, tag[:hLen], whitespaceTagHeader)
@@ -52,7 +52,7 @@ func Test_genWhitespace_forV2(t *testing.T) {
 
 func Test_genWhitespace_forV3(t *testing.T) {
 	hLen := len(whitespaceTagHeader)
-	p := policies(allowV3)
+	p := Policy(allowV3)
 	tag := genWhitespaceTag(p)
 
 	assertDeepEquals(t, tag[:hLen], whitespaceTagHeader)
@@ -63,7 +63,7 @@ func Test_genWhitespace_forV2AndV3(t *testing.T) {
 	hLen := len(whitespaceTagHeader)
 	tLen := 8
 
-	p := policies(allowV2 | allowV3)
+	p := Policy(allowV2 | allowV3)
 	tag := genWhitespaceTag(p)
 
 	assertDeepEquals(t, tag[:hLen], whitespaceTagHeader)
@@ -71,12 +71,27 @@ func Test_genWhitespace_forV2AndV3(t *testing.T) {
 	assertDeepEquals(t, tag[hLen+tLen:], otrV3{}.whitespaceTag())
 }
 
+func Test_genWhitespace_neverAdvertisesV4(t *testing.T) {
+	tag := genWhitespaceTag(Policy(allowV3 | allowV4))
+
+	assertDeepEquals(t, tag, genWhitespaceTag(Policy(allowV3)))
+}
+
+func Test_appendWhitespaceTag_doesntAdvertiseV4(t *testing.T) {
+	c := &Conversation{Policies: Policy(allowV3 | allowV4 | sendWhitespaceTag)}
+	c.SetOurKeys([]PrivateKey{alicePrivateKey, &Ed448PrivateKey{}})
+
+	msg := c.appendWhitespaceTag([]byte("hi"))
+
+	assertDeepEquals(t, msg, append([]byte("hi"), genWhitespaceTag(Policy(allowV3))...))
+}
+
 func Test_receive_acceptsV2WhitespaceTagAndStartsAKE(t *testing.T) {
 	c := newConversation(nil, fixtureRand())
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV2 | whitespaceStartAKE)
+	c.Policies = Policy(allowV2 | whitespaceStartAKE)
 
-	msg := genWhitespaceTag(policies(allowV2))
+	msg := genWhitespaceTag(Policy(allowV2))
 
 	_, enc, err := c.Receive(msg)
 	toSend, _ := c.decode(encodedMessage(enc[0]))
@@ -89,9 +104,9 @@ func Test_receive_acceptsV2WhitespaceTagAndStartsAKE(t *testing.T) {
 func Test_receive_ignoresV2WhitespaceTagIfThePolicyDoesNotHaveWhitespaceStartAKE(t *testing.T) {
 	c := newConversation(nil, fixtureRand())
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV2)
+	c.Policies = Policy(allowV2)
 
-	msg := genWhitespaceTag(policies(allowV2))
+	msg := genWhitespaceTag(Policy(allowV2))
 	_, enc, err := c.Receive(msg)
 
 	assertNil(t, err)
@@ -101,9 +116,9 @@ func Test_receive_ignoresV2WhitespaceTagIfThePolicyDoesNotHaveWhitespaceStartAKE
 func Test_receive_failsWhenReceivesV2WhitespaceTagIfV2IsNotInThePolicy(t *testing.T) {
 	c := newConversation(nil, fixtureRand())
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV3 | whitespaceStartAKE)
+	c.Policies = Policy(allowV3 | whitespaceStartAKE)
 
-	msg := genWhitespaceTag(policies(allowV2))
+	msg := genWhitespaceTag(Policy(allowV2))
 
 	_, toSend, err := c.Receive(msg)
 
@@ -114,9 +129,9 @@ func Test_receive_failsWhenReceivesV2WhitespaceTagIfV2IsNotInThePolicy(t *testin
 func Test_receive_acceptsV3WhitespaceTagAndStartsAKE(t *testing.T) {
 	c := newConversation(nil, fixtureRand())
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV2 | allowV3 | whitespaceStartAKE)
+	c.Policies = Policy(allowV2 | allowV3 | whitespaceStartAKE)
 
-	msg := genWhitespaceTag(policies(allowV2 | allowV3))
+	msg := genWhitespaceTag(Policy(allowV2 | allowV3))
 
 	_, enc, err := c.Receive(msg)
 	toSend, _ := c.decode(encodedMessage(enc[0]))
@@ -129,8 +144,8 @@ func Test_receive_acceptsV3WhitespaceTagAndStartsAKE(t *testing.T) {
 func Test_receive_whiteSpaceTagWillSignalSetupErrorIfSomethingFails(t *testing.T) {
 	c := newConversation(nil, fixedRand([]string{"ABCD"}))
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV2 | allowV3 | whitespaceStartAKE)
-	msg := genWhitespaceTag(policies(allowV2 | allowV3))
+	c.Policies = Policy(allowV2 | allowV3 | whitespaceStartAKE)
+	msg := genWhitespaceTag(Policy(allowV2 | allowV3))
 
 	c.expectMessageEvent(t, func() {
 		c.Receive(msg)
@@ -140,9 +155,9 @@ func Test_receive_whiteSpaceTagWillSignalSetupErrorIfSomethingFails(t *testing.T
 func Test_receive_ignoresV3WhitespaceTagIfThePolicyDoesNotHaveWhitespaceStartAKE(t *testing.T) {
 	c := newConversation(nil, fixtureRand())
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV2 | allowV3)
+	c.Policies = Policy(allowV2 | allowV3)
 
-	msg := genWhitespaceTag(policies(allowV3))
+	msg := genWhitespaceTag(Policy(allowV3))
 
 	_, toSend, err := c.Receive(msg)
 
@@ -153,9 +168,9 @@ func Test_receive_ignoresV3WhitespaceTagIfThePolicyDoesNotHaveWhitespaceStartAKE
 func Test_receive_failsWhenReceivesV3WhitespaceTagIfV3IsNotInThePolicy(t *testing.T) {
 	c := newConversation(nil, fixtureRand())
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV2 | whitespaceStartAKE)
+	c.Policies = Policy(allowV2 | whitespaceStartAKE)
 
-	msg := genWhitespaceTag(policies(allowV3))
+	msg := genWhitespaceTag(Policy(allowV3))
 	_, toSend, err := c.Receive(msg)
 
 	assertEquals(t, err, errUnsupportedOTRVersion)
@@ -165,7 +180,7 @@ func Test_receive_failsWhenReceivesV3WhitespaceTagIfV3IsNotInThePolicy(t *testin
 func Test_stopAppendingWhitespaceTagsAfterReceivingAPlainMessage(t *testing.T) {
 	c := &Conversation{}
 	c.ourKeys = []PrivateKey{alicePrivateKey}
-	c.Policies = policies(allowV3 | sendWhitespaceTag)
+	c.Policies = Policy(allowV3 | sendWhitespaceTag)
 
 	toSend, err := c.Send([]byte("hi"))
 	assertEquals(t, err, nil)
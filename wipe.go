@@ -45,6 +45,9 @@ func (a *ake) wipe(wipeKeys bool) {
 	a.revealKey.wipe()
 	a.sigKey.wipe()
 
+	wipeBytes(a.exporterSecret)
+	a.exporterSecret = nil
+
 	if wipeKeys {
 		a.keys.wipe()
 	} else {
@@ -184,3 +187,13 @@ func setBigInt(dst *big.Int, src *big.Int) *big.Int {
 	ret.Set(src)
 	return ret
 }
+
+// Wipe overwrites the private key of the account, if it is a DSA or Ed448 key. The account can not be used after this.
+func (a *Account) Wipe() {
+	switch k := a.Key.(type) {
+	case *DSAPrivateKey:
+		wipeBigInt(k.X)
+	case *Ed448PrivateKey:
+		k.wipe()
+	}
+}